@@ -0,0 +1,595 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/plugin/autosubscribefeed"
+	"github.com/worksinmagic/ytfeed/plugin/backfill"
+	"github.com/worksinmagic/ytfeed/plugin/channelresolver"
+	"github.com/worksinmagic/ytfeed/plugin/savevideo"
+	"github.com/worksinmagic/ytfeed/plugin/streamschedule"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionimport"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionstore"
+	"github.com/worksinmagic/ytfeed/rss"
+)
+
+const (
+	PathPrefix            = "/admin/"
+	YoutubeVideoURLPrefix = "https://www.youtube.com/watch?v="
+)
+
+var (
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrMethodNotAllowed   = errors.New("method not allowed")
+	ErrMissingVideo       = errors.New("video_id or url query parameter is required")
+	ErrInvalidVideoURL    = errors.New("url is not a youtube video url")
+	ErrSchedulerDisabled  = errors.New("stream scheduler is not enabled")
+	ErrDownloaderDisabled = errors.New("video saving is not enabled")
+	ErrSubscriberDisabled = errors.New("subscriber is not enabled")
+	ErrStoreDisabled      = errors.New("subscription store is not enabled")
+	ErrMissingTopic       = errors.New("topic query parameter is required")
+	ErrBackfillDisabled   = errors.New("backfill is not enabled")
+	ErrMissingChannel     = errors.New("channel is required")
+)
+
+type Scheduler interface {
+	List() ([]streamschedule.Schedule, error)
+	Cancel(linkURL string) error
+	DeadLetters() ([]streamschedule.DeadLetter, error)
+}
+
+type Downloader interface {
+	Status() savevideo.DownloadStatus
+	DataHandler(ctx context.Context, d *ytfeed.Data)
+}
+
+type Subscriber interface {
+	Topics() []autosubscribefeed.TopicStatus
+	Resubscribe() error
+	Refresh()
+}
+
+type SubscriptionStore interface {
+	List() ([]subscriptionstore.Subscription, error)
+	Get(topic string) (*subscriptionstore.Subscription, error)
+	Put(sub subscriptionstore.Subscription) (subscriptionstore.Subscription, error)
+	Delete(topic string) error
+}
+
+// TopicResolver turns handles, channel, video and playlist URLs into subscription topics
+type TopicResolver interface {
+	ResolveTopic(ctx context.Context, input string) (topic string, err error)
+}
+
+type Backfiller interface {
+	Start(channelID string, publishedAfter, publishedBefore time.Time) (backfill.Job, error)
+	Cancel(channelID string) (backfill.Job, error)
+	Jobs() ([]backfill.Job, error)
+}
+
+type Schedules struct {
+	Schedules   []streamschedule.Schedule   `json:"schedules"`
+	DeadLetters []streamschedule.DeadLetter `json:"dead_letters"`
+}
+
+type Subscriptions struct {
+	Topics        []autosubscribefeed.TopicStatus  `json:"topics"`
+	Subscriptions []subscriptionstore.Subscription `json:"subscriptions,omitempty"`
+}
+
+// SubscriptionRequest adds or updates a subscription, it is enabled unless Enabled is false
+type SubscriptionRequest struct {
+	Topic       string `json:"topic"`
+	Label       string `json:"label"`
+	StoragePath string `json:"storage_path"`
+	Enabled     *bool  `json:"enabled"`
+}
+
+type Backfills struct {
+	Jobs []backfill.Job `json:"jobs"`
+}
+
+// BackfillRequest starts a backfill of the uploads of the channel, zero times leave that end of the range open
+type BackfillRequest struct {
+	Channel         string    `json:"channel"`
+	PublishedAfter  time.Time `json:"published_after"`
+	PublishedBefore time.Time `json:"published_before"`
+}
+
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Admin is the JSON API to inspect and operate the running service, every request needs the token as bearer token
+type Admin struct {
+	ctx        context.Context
+	logger     ytfeed.Logger
+	token      string
+	scheduler  Scheduler
+	downloader Downloader
+	subscriber Subscriber
+	store      SubscriptionStore
+	resolver   TopicResolver
+	backfiller Backfiller
+	mux        *http.ServeMux
+}
+
+// SetScheduler because stream scheduler is optional, it doesn't have to be present at constructor function
+func (a *Admin) SetScheduler(s Scheduler) {
+	a.scheduler = s
+}
+
+// SetDownloader because saving video is optional, it doesn't have to be present at constructor function
+func (a *Admin) SetDownloader(d Downloader) {
+	a.downloader = d
+}
+
+// SetSubscriptionStore because managing subscriptions at runtime is optional, it doesn't have to be present at constructor function
+func (a *Admin) SetSubscriptionStore(s SubscriptionStore) {
+	a.store = s
+}
+
+// SetTopicResolver because topics can be given as subscription topics, it doesn't have to be present at constructor function
+func (a *Admin) SetTopicResolver(r TopicResolver) {
+	a.resolver = r
+}
+
+// SetBackfiller because backfill is optional, it doesn't have to be present at constructor function
+func (a *Admin) SetBackfiller(b Backfiller) {
+	a.backfiller = b
+}
+
+// SetSubscriber because subscriber is optional, it doesn't have to be present at constructor function
+func (a *Admin) SetSubscriber(s Subscriber) {
+	a.subscriber = s
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	if !a.authorized(req) {
+		a.writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+
+	a.mux.ServeHTTP(w, req)
+}
+
+// authorized compares the bearer token in constant time, an empty token never authorizes
+func (a *Admin) authorized(req *http.Request) bool {
+	header := req.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if a.token == "" || token == "" || token == header {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *Admin) schedulesHandler(w http.ResponseWriter, req *http.Request) {
+	if a.scheduler == nil {
+		a.writeError(w, http.StatusNotFound, ErrSchedulerDisabled)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		var err error
+		schedules := Schedules{}
+		schedules.Schedules, err = a.scheduler.List()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		schedules.DeadLetters, err = a.scheduler.DeadLetters()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.writeJSON(w, http.StatusOK, schedules)
+	case http.MethodDelete:
+		_, linkURL, err := videoLink(req.URL.Query())
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err = a.scheduler.Cancel(linkURL)
+		if err == streamschedule.ErrScheduleNotFound {
+			a.writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.logger.Infof("Cancelled schedule of video %s through admin API", linkURL)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+	}
+}
+
+func (a *Admin) downloadsHandler(w http.ResponseWriter, req *http.Request) {
+	if a.downloader == nil {
+		a.writeError(w, http.StatusNotFound, ErrDownloaderDisabled)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		a.writeJSON(w, http.StatusOK, a.downloader.Status())
+	case http.MethodPost:
+		videoID, linkURL, err := videoLink(req.URL.Query())
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		d := &ytfeed.Data{}
+		d.Feed.Entry.VideoID = videoID
+		d.Feed.Entry.Link.Rel = "alternate"
+		d.Feed.Entry.Link.Href = linkURL
+
+		// downloading outlives the request
+		a.logger.Infof("Downloading video %s through admin API", linkURL)
+		go a.downloader.DataHandler(a.ctx, d)
+
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+	}
+}
+
+func (a *Admin) subscriptionsHandler(w http.ResponseWriter, req *http.Request) {
+	if a.subscriber == nil {
+		a.writeError(w, http.StatusNotFound, ErrSubscriberDisabled)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		subscriptions := Subscriptions{}
+		subscriptions.Topics = a.subscriber.Topics()
+		if a.store != nil {
+			var err error
+			subscriptions.Subscriptions, err = a.store.List()
+			if err != nil {
+				a.writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		a.writeJSON(w, http.StatusOK, subscriptions)
+	case http.MethodPost:
+		if a.store == nil {
+			a.writeError(w, http.StatusNotFound, ErrStoreDisabled)
+			return
+		}
+
+		sr := SubscriptionRequest{}
+		err := json.NewDecoder(req.Body).Decode(&sr)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid subscription"))
+			return
+		}
+
+		sub := subscriptionstore.Subscription{}
+		sub.Topic, err = a.resolveTopic(req.Context(), sr.Topic)
+		if err != nil {
+			a.writeResolveError(w, err)
+			return
+		}
+		sub.Label = sr.Label
+		sub.StoragePath = sr.StoragePath
+		sub.Enabled = sr.Enabled == nil || *sr.Enabled
+		sub, err = a.store.Put(sub)
+		if err == subscriptionstore.ErrInvalidTopic || err == subscriptionstore.ErrInvalidStoragePath {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.logger.Infof("Saved subscription of topic %s through admin API", sub.Topic)
+		a.subscriber.Refresh()
+		a.writeJSON(w, http.StatusOK, sub)
+	case http.MethodDelete:
+		if a.store == nil {
+			a.writeError(w, http.StatusNotFound, ErrStoreDisabled)
+			return
+		}
+
+		topic := req.URL.Query().Get("topic")
+		if topic == "" {
+			a.writeError(w, http.StatusBadRequest, ErrMissingTopic)
+			return
+		}
+		topic, err := a.resolveTopic(req.Context(), topic)
+		if err != nil {
+			a.writeResolveError(w, err)
+			return
+		}
+
+		err = a.store.Delete(topic)
+		if err == subscriptionstore.ErrSubscriptionNotFound {
+			a.writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.logger.Infof("Deleted subscription of topic %s through admin API", topic)
+		a.subscriber.Refresh()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+	}
+}
+
+func (a *Admin) resubscribeHandler(w http.ResponseWriter, req *http.Request) {
+	if a.subscriber == nil {
+		a.writeError(w, http.StatusNotFound, ErrSubscriberDisabled)
+		return
+	}
+	if req.Method != http.MethodPost {
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+
+	err := a.subscriber.Resubscribe()
+	if err != nil {
+		a.writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, Subscriptions{Topics: a.subscriber.Topics()})
+}
+
+// importHandler adds the channels of an OPML or takeout subscriptions CSV body to the subscription store
+func (a *Admin) importHandler(w http.ResponseWriter, req *http.Request) {
+	if a.subscriber == nil {
+		a.writeError(w, http.StatusNotFound, ErrSubscriberDisabled)
+		return
+	}
+	if a.store == nil {
+		a.writeError(w, http.StatusNotFound, ErrStoreDisabled)
+		return
+	}
+	if req.Method != http.MethodPost {
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+
+	entries, err := subscriptionimport.Parse(req.Body)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := subscriptionimport.New(a.store, a.resolveTopic).Import(req.Context(), entries)
+	a.logger.Infof("Imported %d subscriptions through admin API, %d existed and %d failed", len(result.Imported), len(result.Existing), len(result.Failed))
+	a.subscriber.Refresh()
+	a.writeJSON(w, http.StatusOK, result)
+}
+
+// exportHandler writes the subscribed topics as OPML
+func (a *Admin) exportHandler(w http.ResponseWriter, req *http.Request) {
+	if a.subscriber == nil {
+		a.writeError(w, http.StatusNotFound, ErrSubscriberDisabled)
+		return
+	}
+	if req.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+
+	topics := make([]string, 0, 16)
+	for _, status := range a.subscriber.Topics() {
+		if status.Mode != autosubscribefeed.HubModeUnsubscribe {
+			topics = append(topics, status.Topic)
+		}
+	}
+	var stored []subscriptionstore.Subscription
+	if a.store != nil {
+		var err error
+		stored, err = a.store.List()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscriptions.opml"`)
+	err := subscriptionimport.WriteOPML(w, subscriptionimport.SubscriptionSet(topics, stored))
+	if err != nil {
+		a.logger.Errorf("Failed to write admin API response: %v", err)
+	}
+}
+
+func (a *Admin) backfillHandler(w http.ResponseWriter, req *http.Request) {
+	if a.backfiller == nil {
+		a.writeError(w, http.StatusNotFound, ErrBackfillDisabled)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		jobs, err := a.backfiller.Jobs()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.writeJSON(w, http.StatusOK, Backfills{Jobs: jobs})
+	case http.MethodPost:
+		br := BackfillRequest{}
+		err := json.NewDecoder(req.Body).Decode(&br)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid backfill"))
+			return
+		}
+		channelID, err := a.resolveChannelID(req.Context(), br.Channel)
+		if err == ErrMissingChannel {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			a.writeResolveError(w, err)
+			return
+		}
+
+		job, err := a.backfiller.Start(channelID, br.PublishedAfter, br.PublishedBefore)
+		switch err {
+		case nil:
+		case backfill.ErrInvalidRange:
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		case backfill.ErrJobRunning:
+			a.writeError(w, http.StatusConflict, err)
+			return
+		default:
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.logger.Infof("Started backfill of channel %s through admin API", channelID)
+		a.writeJSON(w, http.StatusAccepted, job)
+	case http.MethodDelete:
+		channelID, err := a.resolveChannelID(req.Context(), req.URL.Query().Get("channel"))
+		if err == ErrMissingChannel {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			a.writeResolveError(w, err)
+			return
+		}
+
+		job, err := a.backfiller.Cancel(channelID)
+		if err == backfill.ErrJobNotFound {
+			a.writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.logger.Infof("Cancelled backfill of channel %s through admin API", channelID)
+		a.writeJSON(w, http.StatusOK, job)
+	default:
+		a.writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+	}
+}
+
+// resolveChannelID returns the ID of the channel input refers to, it accepts what resolveTopic accepts
+func (a *Admin) resolveChannelID(ctx context.Context, input string) (channelID string, err error) {
+	if input == "" {
+		err = ErrMissingChannel
+		return
+	}
+	topic, err := a.resolveTopic(ctx, input)
+	if err != nil {
+		return
+	}
+	channelID = strings.TrimPrefix(topic, rss.YoutubeSubscriptionTopicPrefix)
+
+	return
+}
+
+// resolveTopic returns the subscription topic of the channel input refers to, input is used as is without a resolver
+func (a *Admin) resolveTopic(ctx context.Context, input string) (topic string, err error) {
+	if a.resolver == nil {
+		return input, nil
+	}
+
+	return a.resolver.ResolveTopic(ctx, input)
+}
+
+// writeResolveError blames the request for inputs that don't refer to a channel and the YouTube API for the rest
+func (a *Admin) writeResolveError(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case channelresolver.ErrUnsupportedInput, channelresolver.ErrChannelNotFound:
+		a.writeError(w, http.StatusBadRequest, err)
+	default:
+		a.writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+// videoLink returns the video ID and its link URL from the video_id or url query parameter
+func videoLink(query url.Values) (videoID, linkURL string, err error) {
+	videoID = query.Get("video_id")
+	if videoID == "" && query.Get("url") != "" {
+		var u *url.URL
+		u, err = url.Parse(query.Get("url"))
+		if err != nil {
+			err = errors.Wrap(ErrInvalidVideoURL, err.Error())
+			return
+		}
+
+		switch strings.TrimPrefix(u.Hostname(), "www.") {
+		case "youtube.com", "m.youtube.com":
+			videoID = u.Query().Get("v")
+		case "youtu.be":
+			videoID = strings.Trim(u.Path, "/")
+		}
+		if videoID == "" {
+			err = ErrInvalidVideoURL
+			return
+		}
+	}
+	if videoID == "" {
+		err = ErrMissingVideo
+		return
+	}
+	linkURL = YoutubeVideoURLPrefix + videoID
+
+	return
+}
+
+func (a *Admin) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		a.logger.Errorf("Failed to write admin API response: %v", err)
+	}
+}
+
+func (a *Admin) writeError(w http.ResponseWriter, status int, err error) {
+	a.writeJSON(w, status, Error{Error: err.Error()})
+}
+
+// New creates the admin API, ctx is used by the work it starts since that outlives the request
+func New(ctx context.Context, logger ytfeed.Logger, token string) (a *Admin) {
+	a = &Admin{}
+	a.ctx = ctx
+	a.logger = logger
+	a.token = token
+	a.mux = http.NewServeMux()
+	a.mux.HandleFunc(PathPrefix+"schedules", a.schedulesHandler)
+	a.mux.HandleFunc(PathPrefix+"downloads", a.downloadsHandler)
+	a.mux.HandleFunc(PathPrefix+"subscriptions", a.subscriptionsHandler)
+	a.mux.HandleFunc(PathPrefix+"subscriptions/resubscribe", a.resubscribeHandler)
+	a.mux.HandleFunc(PathPrefix+"subscriptions/import", a.importHandler)
+	a.mux.HandleFunc(PathPrefix+"subscriptions/export", a.exportHandler)
+	a.mux.HandleFunc(PathPrefix+"backfill", a.backfillHandler)
+
+	return
+}
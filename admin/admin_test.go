@@ -0,0 +1,472 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+	"github.com/worksinmagic/ytfeed/plugin/autosubscribefeed"
+	"github.com/worksinmagic/ytfeed/plugin/backfill"
+	"github.com/worksinmagic/ytfeed/plugin/channelresolver"
+	"github.com/worksinmagic/ytfeed/plugin/savevideo"
+	"github.com/worksinmagic/ytfeed/plugin/streamschedule"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionimport"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionstore"
+)
+
+const (
+	token    = "admintoken"
+	videoID  = "dQw4w9WgXcQ"
+	videoURL = YoutubeVideoURLPrefix + videoID
+)
+
+type fakeScheduler struct {
+	schedules []streamschedule.Schedule
+	cancelled []string
+}
+
+func (f *fakeScheduler) List() ([]streamschedule.Schedule, error) {
+	return f.schedules, nil
+}
+
+func (f *fakeScheduler) Cancel(linkURL string) error {
+	if linkURL != videoURL {
+		return streamschedule.ErrScheduleNotFound
+	}
+	f.cancelled = append(f.cancelled, linkURL)
+	return nil
+}
+
+func (f *fakeScheduler) DeadLetters() ([]streamschedule.DeadLetter, error) {
+	return []streamschedule.DeadLetter{}, nil
+}
+
+type fakeDownloader struct {
+	status     savevideo.DownloadStatus
+	downloaded chan *ytfeed.Data
+}
+
+func (f *fakeDownloader) Status() savevideo.DownloadStatus {
+	return f.status
+}
+
+func (f *fakeDownloader) DataHandler(ctx context.Context, d *ytfeed.Data) {
+	f.downloaded <- d
+}
+
+type fakeSubscriber struct {
+	err       error
+	refreshed int
+}
+
+func (f *fakeSubscriber) Topics() []autosubscribefeed.TopicStatus {
+	return []autosubscribefeed.TopicStatus{{Topic: "mytopic"}}
+}
+
+func (f *fakeSubscriber) Resubscribe() error {
+	return f.err
+}
+
+func (f *fakeSubscriber) Refresh() {
+	f.refreshed++
+}
+
+type fakeStore struct {
+	subs map[string]subscriptionstore.Subscription
+}
+
+func (f *fakeStore) List() ([]subscriptionstore.Subscription, error) {
+	subs := make([]subscriptionstore.Subscription, 0, len(f.subs))
+	for _, sub := range f.subs {
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (f *fakeStore) Get(topic string) (*subscriptionstore.Subscription, error) {
+	sub, ok := f.subs[topic]
+	if !ok {
+		return nil, subscriptionstore.ErrSubscriptionNotFound
+	}
+	return &sub, nil
+}
+
+func (f *fakeStore) Put(sub subscriptionstore.Subscription) (subscriptionstore.Subscription, error) {
+	if sub.Topic == "invalid" {
+		return sub, subscriptionstore.ErrInvalidTopic
+	}
+	if sub.StoragePath == "../invalid" {
+		return sub, subscriptionstore.ErrInvalidStoragePath
+	}
+	f.subs[sub.Topic] = sub
+	return sub, nil
+}
+
+func (f *fakeStore) Delete(topic string) error {
+	if _, ok := f.subs[topic]; !ok {
+		return subscriptionstore.ErrSubscriptionNotFound
+	}
+	delete(f.subs, topic)
+	return nil
+}
+
+type fakeBackfiller struct {
+	jobs map[string]backfill.Job
+}
+
+func (f *fakeBackfiller) Start(channelID string, publishedAfter, publishedBefore time.Time) (backfill.Job, error) {
+	if f.jobs[channelID].State == backfill.JobStateRunning {
+		return backfill.Job{}, backfill.ErrJobRunning
+	}
+	if !publishedAfter.IsZero() && !publishedBefore.IsZero() && !publishedAfter.Before(publishedBefore) {
+		return backfill.Job{}, backfill.ErrInvalidRange
+	}
+	job := backfill.Job{ChannelID: channelID, PublishedAfter: publishedAfter, PublishedBefore: publishedBefore, State: backfill.JobStateRunning}
+	f.jobs[channelID] = job
+	return job, nil
+}
+
+func (f *fakeBackfiller) Cancel(channelID string) (backfill.Job, error) {
+	job, ok := f.jobs[channelID]
+	if !ok {
+		return backfill.Job{}, backfill.ErrJobNotFound
+	}
+	job.State = backfill.JobStateCancelled
+	f.jobs[channelID] = job
+	return job, nil
+}
+
+func (f *fakeBackfiller) Jobs() ([]backfill.Job, error) {
+	jobs := make([]backfill.Job, 0, len(f.jobs))
+	for _, job := range f.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+type fakeResolver struct{}
+
+func (f *fakeResolver) ResolveTopic(ctx context.Context, input string) (string, error) {
+	switch input {
+	case "@myhandle", "https://www.youtube.com/@myhandle", "mytopic":
+		return "mytopic", nil
+	case "@unknown":
+		return "", channelresolver.ErrChannelNotFound
+	case "@broken":
+		return "", errors.New("expected error")
+	}
+	return "", channelresolver.ErrUnsupportedInput
+}
+
+func TestAdmin(t *testing.T) {
+	do := func(a *Admin, method, target string, body ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(strings.Join(body, "")))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := httptest.NewRecorder()
+		a.ServeHTTP(res, req)
+
+		return res
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		a := New(context.TODO(), mock.NewMockLogger(ctrl), token)
+
+		for _, header := range []string{"", "Bearer wrong", token} {
+			req := httptest.NewRequest(http.MethodGet, "/admin/downloads", nil)
+			req.Header.Set("Authorization", header)
+			res := httptest.NewRecorder()
+			a.ServeHTTP(res, req)
+			require.Equal(t, http.StatusUnauthorized, res.Code)
+		}
+
+		// empty token never authorizes
+		a = New(context.TODO(), mock.NewMockLogger(ctrl), "")
+		req := httptest.NewRequest(http.MethodGet, "/admin/downloads", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		res := httptest.NewRecorder()
+		a.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("Disabled components", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		a := New(context.TODO(), mock.NewMockLogger(ctrl), token)
+		for _, target := range []string{"/admin/schedules", "/admin/downloads", "/admin/subscriptions", "/admin/subscriptions/resubscribe", "/admin/backfill"} {
+			res := do(a, http.MethodGet, target)
+			require.Equal(t, http.StatusNotFound, res.Code, target)
+		}
+	})
+
+	t.Run("Schedules", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		scheduler := &fakeScheduler{schedules: []streamschedule.Schedule{{State: streamschedule.ScheduleStateScheduled}}}
+		a := New(context.TODO(), logger, token)
+		a.SetScheduler(scheduler)
+
+		res := do(a, http.MethodGet, "/admin/schedules")
+		require.Equal(t, http.StatusOK, res.Code)
+		schedules := Schedules{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&schedules))
+		require.Len(t, schedules.Schedules, 1)
+		require.Empty(t, schedules.DeadLetters)
+
+		logger.EXPECT().Infof(gomock.Any(), videoURL)
+		res = do(a, http.MethodDelete, "/admin/schedules?url="+url.QueryEscape(videoURL))
+		require.Equal(t, http.StatusNoContent, res.Code)
+		require.Equal(t, []string{videoURL}, scheduler.cancelled)
+
+		res = do(a, http.MethodDelete, "/admin/schedules?video_id=unknown")
+		require.Equal(t, http.StatusNotFound, res.Code)
+
+		res = do(a, http.MethodDelete, "/admin/schedules")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+
+		res = do(a, http.MethodPost, "/admin/schedules")
+		require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+	})
+
+	t.Run("Downloads", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		downloader := &fakeDownloader{downloaded: make(chan *ytfeed.Data, 1)}
+		downloader.status.Recent = []ytfeed.DownloadProgress{{VideoName: "video.mp4", Status: ytfeed.DownloadStatusFailed, Error: "expected error"}}
+		a := New(context.TODO(), logger, token)
+		a.SetDownloader(downloader)
+
+		res := do(a, http.MethodGet, "/admin/downloads")
+		require.Equal(t, http.StatusOK, res.Code)
+		status := savevideo.DownloadStatus{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+		require.Equal(t, "expected error", status.Recent[0].Error)
+
+		logger.EXPECT().Infof(gomock.Any(), videoURL)
+		res = do(a, http.MethodPost, "/admin/downloads?url="+url.QueryEscape("https://youtu.be/"+videoID))
+		require.Equal(t, http.StatusAccepted, res.Code)
+		d := <-downloader.downloaded
+		require.Equal(t, videoID, d.Feed.Entry.VideoID)
+		require.Equal(t, videoURL, d.Feed.Entry.Link.Href)
+
+		res = do(a, http.MethodPost, "/admin/downloads?url="+url.QueryEscape("https://example.com/watch?v="+videoID))
+		require.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Subscriptions", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		subscriber := &fakeSubscriber{}
+		a := New(context.TODO(), mock.NewMockLogger(ctrl), token)
+		a.SetSubscriber(subscriber)
+
+		res := do(a, http.MethodGet, "/admin/subscriptions")
+		require.Equal(t, http.StatusOK, res.Code)
+		subscriptions := Subscriptions{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&subscriptions))
+		require.Equal(t, "mytopic", subscriptions.Topics[0].Topic)
+
+		res = do(a, http.MethodGet, "/admin/subscriptions/resubscribe")
+		require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+
+		res = do(a, http.MethodPost, "/admin/subscriptions/resubscribe")
+		require.Equal(t, http.StatusOK, res.Code)
+
+		subscriber.err = errors.New("expected error")
+		res = do(a, http.MethodPost, "/admin/subscriptions/resubscribe")
+		require.Equal(t, http.StatusBadGateway, res.Code)
+		apiErr := Error{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&apiErr))
+		require.Equal(t, "expected error", apiErr.Error)
+	})
+
+	t.Run("Subscription store", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		subscriber := &fakeSubscriber{}
+		store := &fakeStore{subs: map[string]subscriptionstore.Subscription{}}
+		a := New(context.TODO(), logger, token)
+		a.SetSubscriber(subscriber)
+
+		res := do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "mytopic"}`)
+		require.Equal(t, http.StatusNotFound, res.Code)
+
+		a.SetSubscriptionStore(store)
+		logger.EXPECT().Infof(gomock.Any(), "mytopic")
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "mytopic", "label": "label", "storage_path": "channel"}`)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.True(t, store.subs["mytopic"].Enabled)
+		require.Equal(t, "channel", store.subs["mytopic"].StoragePath)
+		require.Equal(t, 1, subscriber.refreshed)
+
+		logger.EXPECT().Infof(gomock.Any(), "mytopic")
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "mytopic", "enabled": false}`)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.False(t, store.subs["mytopic"].Enabled)
+
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "invalid"}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "mytopic", "storage_path": "../invalid"}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPost, "/admin/subscriptions", `not json`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+
+		res = do(a, http.MethodGet, "/admin/subscriptions")
+		require.Equal(t, http.StatusOK, res.Code)
+		subscriptions := Subscriptions{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&subscriptions))
+		require.Len(t, subscriptions.Subscriptions, 1)
+
+		logger.EXPECT().Infof(gomock.Any(), "mytopic")
+		res = do(a, http.MethodDelete, "/admin/subscriptions?topic=mytopic")
+		require.Equal(t, http.StatusNoContent, res.Code)
+		require.Empty(t, store.subs)
+		require.Equal(t, 3, subscriber.refreshed)
+
+		res = do(a, http.MethodDelete, "/admin/subscriptions?topic=mytopic")
+		require.Equal(t, http.StatusNotFound, res.Code)
+		res = do(a, http.MethodDelete, "/admin/subscriptions")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+	})
+	t.Run("Topic resolver", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		subscriber := &fakeSubscriber{}
+		store := &fakeStore{subs: map[string]subscriptionstore.Subscription{}}
+		a := New(context.TODO(), logger, token)
+		a.SetSubscriber(subscriber)
+		a.SetSubscriptionStore(store)
+		a.SetTopicResolver(&fakeResolver{})
+
+		logger.EXPECT().Infof(gomock.Any(), "mytopic")
+		res := do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "@myhandle"}`)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Contains(t, store.subs, "mytopic")
+
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "@unknown"}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "nonsense"}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPost, "/admin/subscriptions", `{"topic": "@broken"}`)
+		require.Equal(t, http.StatusBadGateway, res.Code)
+
+		logger.EXPECT().Infof(gomock.Any(), "mytopic")
+		res = do(a, http.MethodDelete, "/admin/subscriptions?topic=%40myhandle")
+		require.Equal(t, http.StatusNoContent, res.Code)
+		require.Empty(t, store.subs)
+	})
+	t.Run("Import and export", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		subscriber := &fakeSubscriber{}
+		store := &fakeStore{subs: map[string]subscriptionstore.Subscription{
+			"mytopic": {Topic: "mytopic", Label: "My Topic", Enabled: true},
+		}}
+		a := New(context.TODO(), logger, token)
+		a.SetSubscriber(subscriber)
+
+		res := do(a, http.MethodPost, "/admin/subscriptions/import", "")
+		require.Equal(t, http.StatusNotFound, res.Code)
+
+		a.SetSubscriptionStore(store)
+		a.SetTopicResolver(&fakeResolver{})
+		res = do(a, http.MethodGet, "/admin/subscriptions/import")
+		require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+		res = do(a, http.MethodPost, "/admin/subscriptions/import", "  ")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+
+		logger.EXPECT().Infof(gomock.Any(), 0, 1, 1)
+		res = do(a, http.MethodPost, "/admin/subscriptions/import", `<opml version="2.0"><body>
+<outline text="Handle" htmlUrl="https://www.youtube.com/@myhandle"/>
+<outline text="Unknown" htmlUrl="https://www.youtube.com/@unknown"/>
+</body></opml>`)
+		require.Equal(t, http.StatusOK, res.Code)
+		result := subscriptionimport.Result{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+		require.Equal(t, []string{"mytopic"}, result.Existing)
+		require.Len(t, result.Failed, 1)
+		require.Equal(t, 1, subscriber.refreshed)
+
+		res = do(a, http.MethodGet, "/admin/subscriptions/export")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, "text/x-opml", res.Header().Get("Content-Type"))
+		require.Contains(t, res.Body.String(), `text="My Topic"`)
+	})
+
+	t.Run("Backfill", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		backfiller := &fakeBackfiller{jobs: map[string]backfill.Job{}}
+		a := New(context.TODO(), logger, token)
+		a.SetBackfiller(backfiller)
+
+		logger.EXPECT().Infof(gomock.Any(), "mychannel")
+		res := do(a, http.MethodPost, "/admin/backfill", `{"channel": "https://www.youtube.com/xml/feeds/videos.xml?channel_id=mychannel", "published_after": "2020-01-01T00:00:00Z"}`)
+		require.Equal(t, http.StatusAccepted, res.Code)
+		job := backfill.Job{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&job))
+		require.Equal(t, "mychannel", job.ChannelID)
+		require.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), job.PublishedAfter)
+		require.True(t, job.PublishedBefore.IsZero())
+
+		res = do(a, http.MethodPost, "/admin/backfill", `{"channel": "mychannel"}`)
+		require.Equal(t, http.StatusConflict, res.Code)
+		res = do(a, http.MethodPost, "/admin/backfill", `{"channel": "otherchannel", "published_after": "2020-01-01T00:00:00Z", "published_before": "2019-01-01T00:00:00Z"}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPost, "/admin/backfill", `{}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPost, "/admin/backfill", `{"published_after": "yesterday"}`)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+
+		res = do(a, http.MethodGet, "/admin/backfill")
+		require.Equal(t, http.StatusOK, res.Code)
+		backfills := Backfills{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&backfills))
+		require.Len(t, backfills.Jobs, 1)
+
+		logger.EXPECT().Infof(gomock.Any(), "mychannel")
+		res = do(a, http.MethodDelete, "/admin/backfill?channel=mychannel")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, backfill.JobStateCancelled, backfiller.jobs["mychannel"].State)
+		res = do(a, http.MethodDelete, "/admin/backfill?channel=otherchannel")
+		require.Equal(t, http.StatusNotFound, res.Code)
+		res = do(a, http.MethodDelete, "/admin/backfill")
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = do(a, http.MethodPut, "/admin/backfill")
+		require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+
+		// channels are resolved like subscription topics
+		a.SetTopicResolver(&fakeResolver{})
+		logger.EXPECT().Infof(gomock.Any(), "mytopic")
+		res = do(a, http.MethodPost, "/admin/backfill", `{"channel": "@myhandle"}`)
+		require.Equal(t, http.StatusAccepted, res.Code)
+		res = do(a, http.MethodPost, "/admin/backfill", `{"channel": "@broken"}`)
+		require.Equal(t, http.StatusBadGateway, res.Code)
+	})
+}
@@ -0,0 +1,117 @@
+package ytfeed
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	mainytfeed "github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/config"
+	"github.com/worksinmagic/ytfeed/plugin/channelresolver"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionimport"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionstore"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+var (
+	ErrSubscriptionStoreRequired = errors.New("YTFEED_SUBSCRIPTION_BOLTDB_PATH is required to import or export subscriptions")
+)
+
+// Import adds the channels of the OPML or takeout subscriptions CSV file to the subscription store, path - is stdin.
+// The store is locked while the server runs, use the admin API then.
+func Import(ctx context.Context, logger mainytfeed.Logger, path string) (err error) {
+	cfg, channelResolver, store, err := openSubscriptions(ctx)
+	if err != nil {
+		return
+	}
+	defer closeSubscriptionStore(logger, store)
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			err = errors.Wrap(err, "failed to open import file")
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+
+	entries, err := subscriptionimport.Parse(r)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse import file %s", path)
+		return
+	}
+
+	result := subscriptionimport.New(store, channelResolver.ResolveTopic).Import(ctx, entries)
+	for _, topic := range result.Imported {
+		logger.Infof("Imported subscription of topic %s", topic)
+	}
+	for _, f := range result.Failed {
+		logger.Errorf("Failed to import %s: %s", f.Input, f.Error)
+	}
+	logger.Infof("Imported %d subscriptions into %s, %d existed and %d failed", len(result.Imported), cfg.SubscriptionBoltDBPath, len(result.Existing), len(result.Failed))
+
+	return
+}
+
+// Export writes the static and stored topics that are subscribed as OPML.
+// The store is locked while the server runs, use the admin API then.
+func Export(ctx context.Context, logger mainytfeed.Logger, w io.Writer) (err error) {
+	cfg, channelResolver, store, err := openSubscriptions(ctx)
+	if err != nil {
+		return
+	}
+	defer closeSubscriptionStore(logger, store)
+
+	static, failedTopics := channelResolver.ResolveTopics(ctx, cfg.ResubTopics)
+	for input, resolveErr := range failedTopics {
+		logger.Errorf("Failed to resolve resubscription topic %s, it is not exported: %v", input, resolveErr)
+	}
+	stored, err := store.List()
+	if err != nil {
+		return
+	}
+
+	err = subscriptionimport.WriteOPML(w, subscriptionimport.SubscriptionSet(static, stored))
+
+	return
+}
+
+func openSubscriptions(ctx context.Context) (cfg *config.Configuration, channelResolver *channelresolver.Resolver, store *subscriptionstore.SubscriptionStore, err error) {
+	cfg = config.New()
+	err = cfg.Validate()
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate configuration")
+		return
+	}
+	if cfg.SubscriptionBoltDBPath == "" {
+		err = ErrSubscriptionStoreRequired
+		return
+	}
+
+	yts, err := youtube.NewService(ctx, option.WithAPIKey(cfg.YoutubeAPIKey))
+	if err != nil {
+		err = errors.Wrap(err, "failed to create new YouTube service")
+		return
+	}
+	channelResolver = channelresolver.New(yts.Channels, yts.Videos, yts.Playlists, yts.Search)
+
+	store, err = subscriptionstore.New(cfg.SubscriptionBoltDBPath)
+	if err != nil {
+		err = errors.Wrap(err, "failed to open subscription store, use the admin API while the server is running")
+		return
+	}
+
+	return
+}
+
+func closeSubscriptionStore(logger mainytfeed.Logger, store *subscriptionstore.SubscriptionStore) {
+	err := store.CloseDatabase()
+	if err != nil {
+		logger.Errorf("Failed to close subscription store database: %v", err)
+	}
+}
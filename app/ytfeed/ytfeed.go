@@ -2,25 +2,34 @@ package ytfeed
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
 	mainytfeed "github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/admin"
 	"github.com/worksinmagic/ytfeed/config"
 	"github.com/worksinmagic/ytfeed/health"
+	"github.com/worksinmagic/ytfeed/plugin/archivesql"
 	"github.com/worksinmagic/ytfeed/plugin/autosubscribefeed"
+	"github.com/worksinmagic/ytfeed/plugin/backfill"
+	"github.com/worksinmagic/ytfeed/plugin/channelresolver"
 	"github.com/worksinmagic/ytfeed/plugin/disk"
+	"github.com/worksinmagic/ytfeed/plugin/downloadlease"
 	"github.com/worksinmagic/ytfeed/plugin/gcs"
+	"github.com/worksinmagic/ytfeed/plugin/inboundqueue"
 	"github.com/worksinmagic/ytfeed/plugin/publishamqp"
 	"github.com/worksinmagic/ytfeed/plugin/publishredis"
 	"github.com/worksinmagic/ytfeed/plugin/s3"
 	"github.com/worksinmagic/ytfeed/plugin/savevideo"
 	"github.com/worksinmagic/ytfeed/plugin/streamschedule"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionstore"
 	"github.com/worksinmagic/ytfeed/rss"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
@@ -41,8 +50,16 @@ func Run(ctx context.Context, logger mainytfeed.Logger) (err error) {
 		return
 	}
 
-	// declare data handlers
+	// declare data handlers, the name identifies the handler in the inbound queue so it must not change between releases,
+	// the stream scheduler retries handlers that return an error, everything else relies on handlers logging their own failures
 	dataHandlers := make([]mainytfeed.DataHandlerFunc, 0, 3)
+	errDataHandlers := make([]mainytfeed.DataHandlerErrFunc, 0, 3)
+	dataHandlerNames := make([]string, 0, 3)
+	addDataHandler := func(name string, d mainytfeed.DataHandlerErrFunc) {
+		dataHandlerNames = append(dataHandlerNames, name)
+		dataHandlers = append(dataHandlers, d.WithoutError())
+		errDataHandlers = append(errDataHandlers, d)
+	}
 
 	var streamScheduler *streamschedule.StreamSchedule
 	if cfg.BoltDBPath != "" {
@@ -57,6 +74,22 @@ func Run(ctx context.Context, logger mainytfeed.Logger) (err error) {
 				logger.Errorf("Failed to close stream scheduler database: %v", err)
 			}
 		}(streamScheduler)
+		streamScheduler.SetRetryPolicy(cfg.StreamSchedulerMaxAttempts, cfg.StreamSchedulerRetryBackoff, cfg.StreamSchedulerMaxBackoff)
+	}
+
+	var subscriptionStore *subscriptionstore.SubscriptionStore
+	if cfg.SubscriptionBoltDBPath != "" {
+		subscriptionStore, err = subscriptionstore.New(cfg.SubscriptionBoltDBPath)
+		if err != nil {
+			err = errors.Wrap(err, "failed to create subscription store")
+			return
+		}
+		defer func(subscriptionStore *subscriptionstore.SubscriptionStore) {
+			err := subscriptionStore.CloseDatabase()
+			if err != nil {
+				logger.Errorf("Failed to close subscription store database: %v", err)
+			}
+		}(subscriptionStore)
 	}
 
 	var dataSaver savevideo.DataSaver
@@ -97,35 +130,100 @@ func Run(ctx context.Context, logger mainytfeed.Logger) (err error) {
 			return
 		}
 	}
+	if saveVideo != nil {
+		var downloader savevideo.Downloader
+		downloader, err = savevideo.NewDownloader(cfg.VideoDownloader, cfg.VideoDownloaderBinary, cfg.VideoDownloaderCommandTemplate)
+		if err != nil {
+			err = errors.Wrap(err, "failed to initialize video downloader")
+			return
+		}
+		saveVideo.SetDownloader(downloader)
+
+		var formatPolicy *savevideo.FormatPolicy
+		formatPolicy, err = savevideo.NewFormatPolicy(cfg.VideoFormatMode, cfg.VideoFormatMaxFPS, cfg.VideoFormatCodecs, cfg.VideoFormatFallback, cfg.VideoFormatAudioOnly, cfg.VideoFormatAudioFormat)
+		if err != nil {
+			err = errors.Wrap(err, "failed to initialize video format policy")
+			return
+		}
+		saveVideo.SetFormatPolicy(formatPolicy)
+		if formatPolicy.AudioOnly && formatPolicy.AudioFormat != "" {
+			saveVideo.SetAudioTagger(savevideo.NewFFmpegTagger(logger, cfg.FFmpegBinary, http.DefaultClient))
+		}
+		saveVideo.SetStreaming(cfg.VideoDownloadStreaming)
+		saveVideo.SetSidecars(savevideo.SidecarOptions{
+			JSON:        cfg.VideoSidecarJSON,
+			Thumbnails:  cfg.VideoSidecarThumbnails,
+			Description: cfg.VideoSidecarDescription,
+		}, http.DefaultClient)
+		saveVideo.SetSubtitles(savevideo.SubtitleOptions{
+			Languages: cfg.VideoSubtitleLanguages,
+			Auto:      cfg.VideoSubtitleAuto,
+			Mode:      cfg.VideoSubtitleMode,
+		})
+		saveVideo.SetVODArchive(cfg.VideoVODMode, cfg.VideoVODRearchiveDelay)
+		saveVideo.SetLiveWaitPolicy(savevideo.LiveWaitPolicy{
+			Window:          cfg.VideoLiveWaitWindow,
+			PollInterval:    cfg.VideoLiveWaitPollInterval,
+			MaxPollInterval: cfg.VideoLiveWaitMaxPollInterval,
+			GiveUpAfter:     cfg.VideoLiveWaitGiveUpAfter,
+		})
+		switch cfg.VideoLiveChatRecorder {
+		case savevideo.LiveChatRecorderAPI:
+			saveVideo.SetLiveChatRecorder(savevideo.NewAPILiveChatRecorder(yts.LiveChatMessages, 0), cfg.VideoLiveChatGracePeriod)
+		case savevideo.LiveChatRecorderDownloader:
+			saveVideo.SetLiveChatRecorder(savevideo.NewDownloaderLiveChatRecorder(cfg.VideoLiveChatRecorderBinary, cfg.TemporaryFileDir), cfg.VideoLiveChatGracePeriod)
+		}
+	}
 	if saveVideo != nil && streamScheduler != nil {
 		saveVideo.SetStreamScheduler(streamScheduler)
 	}
+	if saveVideo != nil && subscriptionStore != nil {
+		saveVideo.SetStoragePather(subscriptionStore)
+	}
 	if saveVideo != nil && cfg.VideoDownloadRetryDelay > 0 && cfg.VideoDownloadMaxRetries > 0 {
 		saveVideo.SetRetries(cfg.VideoDownloadRetryDelay, cfg.VideoDownloadMaxRetries)
 	}
+	if saveVideo != nil && (cfg.VideoDownloadMaxConcurrent > 0 || cfg.VideoDownloadMaxPerChannel > 0) {
+		saveVideo.SetDownloadLimits(cfg.VideoDownloadMaxConcurrent, cfg.VideoDownloadMaxPerChannel)
+	}
+	if saveVideo != nil {
+		switch cfg.DownloadLeaseBackend {
+		case config.DownloadLeaseBackendRedis:
+			// the lease can have its own redis so it doesn't turn on publishing to redis
+			leaseRedisOptions := newRedisOptions(cfg)
+			if cfg.DownloadLeaseRedisAddr != "" {
+				leaseRedisOptions.Addr = cfg.DownloadLeaseRedisAddr
+			}
+			leaseRedisClient := redis.NewClient(leaseRedisOptions)
+			defer func(leaseRedisClient *redis.Client) {
+				err := leaseRedisClient.Close()
+				if err != nil {
+					logger.Errorf("Failed to close download lease redis client: %v", err)
+				}
+			}(leaseRedisClient)
+			saveVideo.SetDownloadLeaser(downloadlease.NewRedis(leaseRedisClient, downloadlease.DefaultRedisKeyPrefix, downloadlease.DefaultOwner()), cfg.DownloadLeaseTTL)
+		case config.DownloadLeaseBackendBolt:
+			saveVideo.SetDownloadLeaser(downloadlease.NewBolt(cfg.DownloadLeaseBoltDBPath, downloadlease.DefaultOwner()), cfg.DownloadLeaseTTL)
+		}
+	}
 	if saveVideo != nil {
-		dataHandlers = append(dataHandlers, saveVideo.DataHandler)
+		addDataHandler("savevideo", saveVideo.HandleData)
+	}
+	if streamScheduler != nil {
+		addDataHandler("streamschedule", streamScheduler.HandleData)
 	}
 
+	progressHandlers := make([]mainytfeed.DownloadProgressHandlerFunc, 0, 2)
+
 	if cfg.RedisAddr != "" {
-		opts := &redis.Options{}
-		opts.Addr = cfg.RedisAddr
-		opts.DB = cfg.RedisDB
-		opts.DialTimeout = cfg.RedisDialTimeout
-		opts.IdleCheckFrequency = cfg.RedisIdleCheckFrequency
-		opts.IdleTimeout = cfg.RedisIdleTimeout
-		opts.MaxConnAge = cfg.RedisMaxConnAge
-		opts.MaxRetries = cfg.RedisMaxRetries
-		opts.MinIdleConns = cfg.RedisMinIdleConns
-		opts.Password = cfg.RedisPassword
-		opts.PoolSize = cfg.RedisPoolSize
-		opts.PoolTimeout = cfg.RedisPoolTimeout
-		opts.ReadTimeout = cfg.RedisReadTimeout
-		opts.Username = cfg.RedisUsername
-		opts.WriteTimeout = cfg.RedisWriteTimeout
-		redisClient := publishredis.New(logger, cfg.RedisChannel, opts)
-
-		dataHandlers = append(dataHandlers, redisClient.DataHandler)
+		redisClient := publishredis.New(logger, cfg.RedisChannel, newRedisOptions(cfg))
+
+		addDataHandler("publishredis", redisClient.HandleData)
+
+		if cfg.RedisProgressChannel != "" {
+			redisClient.SetProgressChannel(cfg.RedisProgressChannel)
+			progressHandlers = append(progressHandlers, redisClient.ProgressHandler)
+		}
 	}
 
 	if cfg.AMQPDSN != "" {
@@ -174,23 +272,52 @@ func Run(ctx context.Context, logger mainytfeed.Logger) (err error) {
 			cfg.AMQPPublishImmediate,
 		)
 
-		dataHandlers = append(dataHandlers, pa.DataHandler)
+		addDataHandler("publishamqp", pa.HandleData)
+
+		if cfg.AMQPProgressKey != "" {
+			pa.SetProgressKey(cfg.AMQPProgressKey)
+			progressHandlers = append(progressHandlers, pa.ProgressHandler)
+		}
 	}
 
-	// run workers
-	subscriber := autosubscribefeed.New(logger, cfg.VerificationToken, cfg.VerificationSecret, cfg.ResubTargetAddr, cfg.ResubCallbackAddr, cfg.ResubTopics, cfg.ResubInterval)
-	go func(ctx context.Context, subscriber *autosubscribefeed.Subscriber) {
-		err := subscriber.Subscribe(ctx)
+	if saveVideo != nil && len(progressHandlers) > 0 {
+		saveVideo.SetProgressHandlers(cfg.VideoDownloadProgressInterval, progressHandlers...)
+	}
+
+	if cfg.ArchiveSQLDSN != "" {
+		var archive *archivesql.ArchiveSQL
+		archive, err = archivesql.New(ctx, logger, cfg.ArchiveSQLDriver, cfg.ArchiveSQLDSN)
 		if err != nil {
-			err = errors.Wrap(err, "resubscriber worker exited with error")
-			logger.Errorln(err)
+			err = errors.Wrap(err, "failed to initialize archivesql")
 			return
 		}
-	}(ctx, subscriber)
+		defer func(archive *archivesql.ArchiveSQL) {
+			err := archive.CloseDatabase()
+			if err != nil {
+				logger.Errorf("Failed to close archive database: %v", err)
+			}
+		}(archive)
+
+		addDataHandler("archivesql", archive.HandleData)
+	}
+
+	// run workers
+	channelResolver := channelresolver.New(yts.Channels, yts.Videos, yts.Playlists, yts.Search)
+	subscriber := autosubscribefeed.New(logger, cfg.VerificationToken, cfg.VerificationSecret, cfg.ResubTargetAddr, cfg.ResubCallbackAddr, cfg.ResubTopics, cfg.ResubInterval)
+	subscriber.SetHubVerify(cfg.ResubVerify)
+	// a channel that fails to resolve doesn't keep the others from being subscribed, it is resolved again later
+	subscriber.SetTopicResolver(channelResolver)
+	if subscriptionStore != nil {
+		subscriber.SetTopicSource(subscriptionStore)
+		subscriber.SetSubscribedTopicStore(subscriptionStore)
+	}
 
 	if streamScheduler != nil {
+		for i, d := range errDataHandlers {
+			streamScheduler.RegisterDataHandler(dataHandlerNames[i], d)
+		}
+
 		go func(ctx context.Context, streamScheduler *streamschedule.StreamSchedule) {
-			streamScheduler.RegisterDataHandler(dataHandlers...)
 			err := streamScheduler.RunWorker(ctx)
 			if err != nil {
 				err = errors.Wrap(err, "stream scheduler worker exited with error")
@@ -200,17 +327,128 @@ func Run(ctx context.Context, logger mainytfeed.Logger) (err error) {
 		}(ctx, streamScheduler)
 	}
 
+	feedHandler := rss.Handler(ctx, logger, cfg.VerificationToken, cfg.VerificationSecret, subscriber, dataHandlers...)
+	// backfilled videos take the same path as hub notifications, without the queue the next one is only
+	// dispatched once the data handlers are done with this one
+	dispatch := func(ctx context.Context, data *mainytfeed.Data) error {
+		var wg sync.WaitGroup
+		for _, d := range dataHandlers {
+			wg.Add(1)
+			go func(d mainytfeed.DataHandlerFunc) {
+				defer wg.Done()
+				d(ctx, data)
+			}(d)
+		}
+		wg.Wait()
+		return nil
+	}
+	if cfg.QueueBoltDBPath != "" {
+		var inboundQueue *inboundqueue.InboundQueue
+		inboundQueue, err = inboundqueue.New(logger, cfg.QueueBoltDBPath, cfg.QueueWorkers)
+		if err != nil {
+			err = errors.Wrap(err, "failed to create inbound queue")
+			return
+		}
+		defer func(inboundQueue *inboundqueue.InboundQueue) {
+			err := inboundQueue.CloseDatabase()
+			if err != nil {
+				logger.Errorf("Failed to close inbound queue database: %v", err)
+			}
+		}(inboundQueue)
+		inboundQueue.SetMaxPending(cfg.QueueMaxPending)
+		for i, d := range dataHandlers {
+			inboundQueue.RegisterDataHandler(dataHandlerNames[i], d)
+		}
+
+		go func(ctx context.Context, inboundQueue *inboundqueue.InboundQueue) {
+			err := inboundQueue.RunWorker(ctx)
+			if err != nil {
+				err = errors.Wrap(err, "inbound queue worker exited with error")
+				logger.Errorln(err)
+				return
+			}
+		}(ctx, inboundQueue)
+
+		feedHandler = rss.QueueHandler(ctx, logger, cfg.VerificationToken, cfg.VerificationSecret, subscriber, inboundQueue)
+		// the backfill waits while the queue is full instead of failing
+		dispatch = func(ctx context.Context, data *mainytfeed.Data) error {
+			err := inboundQueue.Enqueue(ctx, data)
+			if err == inboundqueue.ErrQueueFull {
+				return backfill.ErrDispatchBusy
+			}
+			return err
+		}
+	}
+
+	var backfiller *backfill.Backfill
+	if cfg.BackfillBoltDBPath != "" {
+		backfiller, err = backfill.New(logger, cfg.BackfillBoltDBPath, yts.Channels, yts.PlaylistItems, dispatch)
+		if err != nil {
+			err = errors.Wrap(err, "failed to create backfill")
+			return
+		}
+		defer func(backfiller *backfill.Backfill) {
+			err := backfiller.CloseDatabase()
+			if err != nil {
+				logger.Errorf("Failed to close backfill database: %v", err)
+			}
+		}(backfiller)
+		backfiller.SetInterval(cfg.BackfillInterval)
+
+		go func(ctx context.Context, backfiller *backfill.Backfill) {
+			err := backfiller.Run(ctx)
+			if err != nil {
+				err = errors.Wrap(err, "backfill worker exited with error")
+				logger.Errorln(err)
+				return
+			}
+		}(ctx, backfiller)
+	}
+
 	// declare handler functions
 	http.HandleFunc("/health", health.Handler)
-	http.HandleFunc("/", rss.Handler(ctx, logger, cfg.VerificationToken, cfg.VerificationSecret, dataHandlers...))
+	if cfg.AdminToken != "" {
+		adminAPI := admin.New(ctx, logger, cfg.AdminToken)
+		if streamScheduler != nil {
+			adminAPI.SetScheduler(streamScheduler)
+		}
+		if saveVideo != nil {
+			adminAPI.SetDownloader(saveVideo)
+		}
+		adminAPI.SetSubscriber(subscriber)
+		if subscriptionStore != nil {
+			adminAPI.SetSubscriptionStore(subscriptionStore)
+		}
+		adminAPI.SetTopicResolver(channelResolver)
+		if backfiller != nil {
+			adminAPI.SetBackfiller(backfiller)
+		}
+		http.Handle(admin.PathPrefix, adminAPI)
+	}
+	http.HandleFunc("/", feedHandler)
 
-	// listen
+	// listen before subscribing so the hub can verify the subscriptions
+	listener, err := net.Listen("tcp", cfg.Host)
+	if err != nil {
+		err = errors.Wrap(err, "failed to listen")
+		return
+	}
 	errCh := make(chan error, 1)
 	go func(errCh chan<- error) {
 		logger.Infof("Server is listening at %s", cfg.Host)
-		errCh <- http.ListenAndServe(cfg.Host, nil)
+		errCh <- http.Serve(listener, nil)
 	}(errCh)
 
+	// subscribe on startup and renew every subscription before its lease expires
+	go func(ctx context.Context, subscriber *autosubscribefeed.Subscriber) {
+		err := subscriber.Subscribe(ctx)
+		if err != nil {
+			err = errors.Wrap(err, "resubscriber worker exited with error")
+			logger.Errorln(err)
+			return
+		}
+	}(ctx, subscriber)
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -224,3 +462,23 @@ func Run(ctx context.Context, logger mainytfeed.Logger) (err error) {
 
 	return
 }
+
+func newRedisOptions(cfg *config.Configuration) (opts *redis.Options) {
+	opts = &redis.Options{}
+	opts.Addr = cfg.RedisAddr
+	opts.DB = cfg.RedisDB
+	opts.DialTimeout = cfg.RedisDialTimeout
+	opts.IdleCheckFrequency = cfg.RedisIdleCheckFrequency
+	opts.IdleTimeout = cfg.RedisIdleTimeout
+	opts.MaxConnAge = cfg.RedisMaxConnAge
+	opts.MaxRetries = cfg.RedisMaxRetries
+	opts.MinIdleConns = cfg.RedisMinIdleConns
+	opts.Password = cfg.RedisPassword
+	opts.PoolSize = cfg.RedisPoolSize
+	opts.PoolTimeout = cfg.RedisPoolTimeout
+	opts.ReadTimeout = cfg.RedisReadTimeout
+	opts.Username = cfg.RedisUsername
+	opts.WriteTimeout = cfg.RedisWriteTimeout
+
+	return
+}
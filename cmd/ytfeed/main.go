@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -10,11 +11,18 @@ import (
 
 const (
 	cleanupDelay = 3 * time.Second
+
+	usage = "Usage: ytfeed [import <file.opml|subscriptions.csv|-> | export [file.opml]]"
 )
 
 func main() {
 	logger := log.New()
 
+	if len(os.Args) > 1 {
+		runCommand(logger, os.Args[1], os.Args[2:])
+		return
+	}
+
 	logger.Infoln("Starting server")
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -33,3 +41,32 @@ func main() {
 
 	logger.Infoln("Server shut down")
 }
+
+// runCommand runs the import and export commands that work on the subscription store while the server is stopped
+func runCommand(logger *log.Logger, command string, args []string) {
+	ctx := context.Background()
+
+	var err error
+	switch {
+	case command == "import" && len(args) == 1:
+		err = ytfeed.Import(ctx, logger, args[0])
+	case command == "export" && len(args) == 0:
+		err = ytfeed.Export(ctx, logger, os.Stdout)
+	case command == "export" && len(args) == 1:
+		var f *os.File
+		f, err = os.Create(args[0])
+		if err != nil {
+			break
+		}
+		err = ytfeed.Export(ctx, logger, f)
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	default:
+		logger.Fatalln(usage)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to %s subscriptions: %v", command, err)
+	}
+}
@@ -14,11 +14,25 @@ const (
 	DefaultHost                          = ":8123"
 	DefaultResubTargetAddr               = "https://pubsubhubbub.appspot.com/subscribe"
 	DefaultResubInterval                 = 24 * 3 * time.Hour
+	DefaultResubVerify                   = "sync"
 	DefaultFileNameTemplate              = "{{.ChannelID}}/{{.PublishedYear}}/{{.PublishedMonth}}/{{.PublishedDay}}/{{.PublishedTimeZone}}/{{.VideoID}}.{{.VideoExtension}}"
 	DefaultFormatQuality                 = "720"
 	DefaultFormatExtension               = "webm"
+	DefaultFormatMode                    = "exact"
+	DefaultVideoSubtitleMode             = "files"
+	DefaultVideoLiveChatRecorder         = "none"
+	DefaultVideoVODMode                  = "skip"
+	DefaultVideoLiveWaitWindow           = 10 * time.Minute
+	DefaultVideoLiveWaitPollInterval     = 30 * time.Second
+	DefaultVideoLiveWaitMaxPollInterval  = 5 * time.Minute
+	DefaultVideoLiveWaitGiveUpAfter      = 12 * time.Hour
+	DefaultVideoDownloader               = "youtube-dl"
+	DefaultVideoDownloadProgressInterval = 10 * time.Second
 	DefaultRedisChannel                  = "ytfeed"
 	DefaultStreamSchedulerWorkerInterval = 1 * time.Minute
+	DefaultStreamSchedulerMaxAttempts    = 5
+	DefaultStreamSchedulerRetryBackoff   = 1 * time.Minute
+	DefaultStreamSchedulerMaxBackoff     = 1 * time.Hour
 	DefaultVideoDownloadMaxRetries       = 5
 	DefaultTemporaryFileDir              = "./"
 	DefaultAMQPExchange                  = "ytfeed"
@@ -30,11 +44,22 @@ const (
 	DefaultAMQPExchangeInternal          = false
 	DefaultAMQPExchangeAutoDelete        = false
 	DefaultAMQPExchangeNoWait            = false
+	DefaultArchiveSQLDriver              = "sqlite3"
+	DefaultQueueWorkers                  = 4
+	DefaultDownloadLeaseBackend          = "none"
+	DefaultDownloadLeaseTTL              = 1 * time.Minute
+	DefaultBackfillInterval              = 30 * time.Second
 
 	StorageBackendS3   = "s3"
 	StorageBackendGCS  = "gcs"
 	StorageBackendDisk = "disk"
 	StorageBackendNone = "none"
+
+	DownloadLeaseBackendRedis = "redis"
+	DownloadLeaseBackendBolt  = "bolt"
+	DownloadLeaseBackendNone  = "none"
+
+	VideoDownloaderTemplate = "template"
 )
 
 var (
@@ -43,6 +68,9 @@ var (
 	ErrInvalidDiskConfig = errors.New("invalid or incomplete disk config")
 	ErrInvalidGCSConfig  = errors.New("invalid or incomplete gcs config")
 	ErrInvalidS3Config   = errors.New("invalid or incomplete s3 config")
+
+	ErrInvalidDownloadLeaseConfig   = errors.New("invalid or incomplete download lease config")
+	ErrInvalidVideoDownloaderConfig = errors.New("invalid or incomplete video downloader config")
 )
 
 func init() {
@@ -56,8 +84,10 @@ func init() {
 	handleError(viper.BindEnv("verification_secret"))
 
 	handleError(viper.BindEnv("resub_interval"))
+	handleError(viper.BindEnv("resub_verify"))
 	handleError(viper.BindEnv("resub_target_addr"))
 	handleError(viper.BindEnv("resub_topic"))
+	handleError(viper.BindEnv("subscription_boltdb_path"))
 	handleError(viper.BindEnv("resub_callback_addr"))
 
 	handleError(viper.BindEnv("s3_endpoint"))
@@ -76,15 +106,49 @@ func init() {
 
 	handleError(viper.BindEnv("video_format_quality"))
 	handleError(viper.BindEnv("video_format_extension"))
+	handleError(viper.BindEnv("video_format_mode"))
+	handleError(viper.BindEnv("video_format_max_fps"))
+	handleError(viper.BindEnv("video_format_codecs"))
+	handleError(viper.BindEnv("video_format_fallback"))
+	handleError(viper.BindEnv("video_format_audio_only"))
+	handleError(viper.BindEnv("video_format_audio_format"))
+	handleError(viper.BindEnv("ffmpeg_binary"))
+	handleError(viper.BindEnv("video_sidecar_json"))
+	handleError(viper.BindEnv("video_sidecar_thumbnails"))
+	handleError(viper.BindEnv("video_sidecar_description"))
+	handleError(viper.BindEnv("video_subtitle_languages"))
+	handleError(viper.BindEnv("video_subtitle_auto"))
+	handleError(viper.BindEnv("video_subtitle_mode"))
+	handleError(viper.BindEnv("video_live_chat_recorder"))
+	handleError(viper.BindEnv("video_live_chat_recorder_binary"))
+	handleError(viper.BindEnv("video_live_chat_grace_period"))
+	handleError(viper.BindEnv("video_vod_mode"))
+	handleError(viper.BindEnv("video_vod_rearchive_delay"))
+	handleError(viper.BindEnv("video_live_wait_window"))
+	handleError(viper.BindEnv("video_live_wait_poll_interval"))
+	handleError(viper.BindEnv("video_live_wait_max_poll_interval"))
+	handleError(viper.BindEnv("video_live_wait_give_up_after"))
+	handleError(viper.BindEnv("video_downloader"))
+	handleError(viper.BindEnv("video_downloader_binary"))
+	handleError(viper.BindEnv("video_downloader_command_template"))
+	handleError(viper.BindEnv("video_download_streaming"))
+	handleError(viper.BindEnv("video_download_progress_interval"))
 	handleError(viper.BindEnv("video_handling_delay"))
 	handleError(viper.BindEnv("temporary_file_dir"))
 	handleError(viper.BindEnv("video_download_max_retries"))
 	handleError(viper.BindEnv("video_download_retry_delay"))
+	handleError(viper.BindEnv("video_download_max_concurrent"))
+	handleError(viper.BindEnv("video_download_max_per_channel"))
+	handleError(viper.BindEnv("download_lease_backend"))
+	handleError(viper.BindEnv("download_lease_ttl"))
+	handleError(viper.BindEnv("download_lease_boltdb_path"))
+	handleError(viper.BindEnv("download_lease_redis_addr"))
 
 	handleError(viper.BindEnv("redis_addr"))
 	handleError(viper.BindEnv("redis_username"))
 	handleError(viper.BindEnv("redis_password"))
 	handleError(viper.BindEnv("redis_channel"))
+	handleError(viper.BindEnv("redis_progress_channel"))
 	handleError(viper.BindEnv("redis_db"))
 	handleError(viper.BindEnv("redis_max_retries"))
 	handleError(viper.BindEnv("redis_dial_timeout"))
@@ -99,10 +163,14 @@ func init() {
 
 	handleError(viper.BindEnv("boltdb_path"))
 	handleError(viper.BindEnv("stream_scheduler_worker_interval"))
+	handleError(viper.BindEnv("stream_scheduler_max_attempts"))
+	handleError(viper.BindEnv("stream_scheduler_retry_backoff"))
+	handleError(viper.BindEnv("stream_scheduler_max_backoff"))
 
 	handleError(viper.BindEnv("amqp_dsn"))
 	handleError(viper.BindEnv("amqp_exchange"))
 	handleError(viper.BindEnv("amqp_key"))
+	handleError(viper.BindEnv("amqp_progress_key"))
 	handleError(viper.BindEnv("amqp_publish_mandatory"))
 	handleError(viper.BindEnv("amqp_publish_immediate"))
 	handleError(viper.BindEnv("amqp_exchange_kind"))
@@ -111,15 +179,41 @@ func init() {
 	handleError(viper.BindEnv("amqp_exchange_auto_delete"))
 	handleError(viper.BindEnv("amqp_exchange_no_wait"))
 
+	handleError(viper.BindEnv("archive_sql_driver"))
+	handleError(viper.BindEnv("archive_sql_dsn"))
+
+	handleError(viper.BindEnv("queue_boltdb_path"))
+	handleError(viper.BindEnv("queue_workers"))
+	handleError(viper.BindEnv("queue_max_pending"))
+
+	handleError(viper.BindEnv("backfill_boltdb_path"))
+	handleError(viper.BindEnv("backfill_interval"))
+
+	handleError(viper.BindEnv("admin_token"))
+
 	viper.SetDefault("version", DefaultVersion)
 	viper.SetDefault("host", DefaultHost)
 	viper.SetDefault("resub_target_addr", DefaultResubTargetAddr)
 	viper.SetDefault("resub_interval", DefaultResubInterval)
+	viper.SetDefault("resub_verify", DefaultResubVerify)
 	viper.SetDefault("filename_template", DefaultFileNameTemplate)
 	viper.SetDefault("video_format_quality", DefaultFormatQuality)
 	viper.SetDefault("video_format_extension", DefaultFormatExtension)
+	viper.SetDefault("video_format_mode", DefaultFormatMode)
+	viper.SetDefault("video_subtitle_mode", DefaultVideoSubtitleMode)
+	viper.SetDefault("video_live_chat_recorder", DefaultVideoLiveChatRecorder)
+	viper.SetDefault("video_vod_mode", DefaultVideoVODMode)
+	viper.SetDefault("video_live_wait_window", DefaultVideoLiveWaitWindow)
+	viper.SetDefault("video_live_wait_poll_interval", DefaultVideoLiveWaitPollInterval)
+	viper.SetDefault("video_live_wait_max_poll_interval", DefaultVideoLiveWaitMaxPollInterval)
+	viper.SetDefault("video_live_wait_give_up_after", DefaultVideoLiveWaitGiveUpAfter)
+	viper.SetDefault("video_downloader", DefaultVideoDownloader)
+	viper.SetDefault("video_download_progress_interval", DefaultVideoDownloadProgressInterval)
 	viper.SetDefault("redis_channel", DefaultRedisChannel)
 	viper.SetDefault("stream_scheduler_worker_interval", DefaultStreamSchedulerWorkerInterval)
+	viper.SetDefault("stream_scheduler_max_attempts", DefaultStreamSchedulerMaxAttempts)
+	viper.SetDefault("stream_scheduler_retry_backoff", DefaultStreamSchedulerRetryBackoff)
+	viper.SetDefault("stream_scheduler_max_backoff", DefaultStreamSchedulerMaxBackoff)
 	viper.SetDefault("video_download_max_retries", DefaultVideoDownloadMaxRetries)
 	viper.SetDefault("temporary_file_dir", DefaultTemporaryFileDir)
 	viper.SetDefault("amqp_exchange", DefaultAMQPExchange)
@@ -131,6 +225,11 @@ func init() {
 	viper.SetDefault("amqp_exchange_internal", DefaultAMQPExchangeInternal)
 	viper.SetDefault("amqp_exchange_auto_delete", DefaultAMQPExchangeAutoDelete)
 	viper.SetDefault("amqp_exchange_no_wait", DefaultAMQPExchangeNoWait)
+	viper.SetDefault("archive_sql_driver", DefaultArchiveSQLDriver)
+	viper.SetDefault("queue_workers", DefaultQueueWorkers)
+	viper.SetDefault("backfill_interval", DefaultBackfillInterval)
+	viper.SetDefault("download_lease_backend", DefaultDownloadLeaseBackend)
+	viper.SetDefault("download_lease_ttl", DefaultDownloadLeaseTTL)
 }
 
 func handleError(err error) {
@@ -151,9 +250,12 @@ type Configuration struct {
 	VerificationSecret string `validate:"required"`
 
 	ResubTargetAddr   string        `validate:"required"`
-	ResubTopics       []string      `validate:"required"`
+	ResubTopics       []string      `validate:"required_without=SubscriptionBoltDBPath"`
 	ResubCallbackAddr string        `validate:"required"`
 	ResubInterval     time.Duration `validate:"required"`
+	ResubVerify       string        `validate:"required,oneof=sync async"`
+
+	SubscriptionBoltDBPath string `validate:""`
 
 	S3Endpoint        string `validate:""`
 	S3AccessKeyID     string `validate:""`
@@ -170,16 +272,58 @@ type Configuration struct {
 	Host             string `validate:"required"`
 	Version          string `validate:"required"`
 
-	VideoFormatQuality      string        `validate:"required,oneof=1080 720 640 480 360 240 144"`
-	VideoFormatExtension    string        `validate:"required,oneof=mp4 webm mkv"`
-	VideoDownloadMaxRetries int           `validate:"required,min=0"`
-	VideoDownloadRetryDelay time.Duration `validate:"omitempty,min=1"`
-	TemporaryFileDir        string        `validate:"required,dir"`
+	VideoFormatQuality         string        `validate:"required,oneof=2160 1440 1080 720 640 480 360 240 144"`
+	VideoFormatExtension       string        `validate:"required,oneof=mp4 webm mkv"`
+	VideoDownloadMaxRetries    int           `validate:"required,min=0"`
+	VideoDownloadRetryDelay    time.Duration `validate:"omitempty,min=1"`
+	VideoDownloadMaxConcurrent int           `validate:"omitempty,min=0"`
+	VideoDownloadMaxPerChannel int           `validate:"omitempty,min=0"`
+	TemporaryFileDir           string        `validate:"required,dir"`
+
+	VideoFormatMode        string   `validate:"required,oneof=exact best"`
+	VideoFormatMaxFPS      int      `validate:"omitempty,min=1"`
+	VideoFormatCodecs      []string `validate:"omitempty,dive,oneof=av1 vp9 avc"`
+	VideoFormatFallback    bool     `validate:""`
+	VideoFormatAudioOnly   bool     `validate:""`
+	VideoFormatAudioFormat string   `validate:"omitempty,oneof=m4a opus mp3"`
+	FFmpegBinary           string   `validate:""`
+
+	VideoSidecarJSON        bool `validate:""`
+	VideoSidecarThumbnails  bool `validate:""`
+	VideoSidecarDescription bool `validate:""`
+
+	VideoSubtitleLanguages []string `validate:"omitempty,dive,required"`
+	VideoSubtitleAuto      bool     `validate:""`
+	VideoSubtitleMode      string   `validate:"required,oneof=files embed"`
+
+	VideoLiveChatRecorder       string        `validate:"required,oneof=none api downloader"`
+	VideoLiveChatRecorderBinary string        `validate:""`
+	VideoLiveChatGracePeriod    time.Duration `validate:"omitempty,min=0"`
+
+	VideoVODMode           string        `validate:"required,oneof=skip replace keep"`
+	VideoVODRearchiveDelay time.Duration `validate:"omitempty,min=0"`
+
+	VideoLiveWaitWindow          time.Duration `validate:"required,min=1"`
+	VideoLiveWaitPollInterval    time.Duration `validate:"required,min=1"`
+	VideoLiveWaitMaxPollInterval time.Duration `validate:"required,min=1"`
+	VideoLiveWaitGiveUpAfter     time.Duration `validate:"required,min=1"`
+
+	VideoDownloader                string        `validate:"required,oneof=youtube-dl yt-dlp template"`
+	VideoDownloaderBinary          string        `validate:""`
+	VideoDownloaderCommandTemplate string        `validate:""`
+	VideoDownloadStreaming         bool          `validate:""`
+	VideoDownloadProgressInterval  time.Duration `validate:"required,min=1"`
+
+	DownloadLeaseBackend    string        `validate:"required,oneof=redis bolt none"`
+	DownloadLeaseTTL        time.Duration `validate:"required,min=1000000000"`
+	DownloadLeaseBoltDBPath string        `validate:""`
+	DownloadLeaseRedisAddr  string        `validate:"omitempty,hostname_port"`
 
 	RedisAddr               string        `validate:"omitempty,hostname_port"`
 	RedisUsername           string        `validate:""`
 	RedisPassword           string        `validate:""`
 	RedisChannel            string        `validate:"required"`
+	RedisProgressChannel    string        `validate:""`
 	RedisDB                 int           `validate:"omitempty,min=0"`
 	RedisMaxRetries         int           `validate:"omitempty,min=0"`
 	RedisDialTimeout        time.Duration `validate:""`
@@ -194,10 +338,14 @@ type Configuration struct {
 
 	BoltDBPath                    string        `validate:"omitempty,file"`
 	StreamSchedulerWorkerInterval time.Duration `validate:"required,min=1000000000"`
+	StreamSchedulerMaxAttempts    int           `validate:"required,min=1"`
+	StreamSchedulerRetryBackoff   time.Duration `validate:"required,min=1000000000"`
+	StreamSchedulerMaxBackoff     time.Duration `validate:"required,min=1000000000"`
 
 	AMQPDSN                string `validate:""`
 	AMQPExchange           string `validate:"required"`
 	AMQPKey                string `validate:"required"`
+	AMQPProgressKey        string `validate:""`
 	AMQPPublishMandatory   bool   `validate:""`
 	AMQPPublishImmediate   bool   `validate:""`
 	AMQPExchangeKind       string `validate:"required,oneof=direct fanout topic headers"`
@@ -205,6 +353,18 @@ type Configuration struct {
 	AMQPExchangeAutoDelete bool   `validate:""`
 	AMQPExchangeInternal   bool   `validate:""`
 	AMQPExchangeNoWait     bool   `validate:""`
+
+	ArchiveSQLDriver string `validate:"required,oneof=sqlite3 postgres"`
+	ArchiveSQLDSN    string `validate:""`
+
+	QueueBoltDBPath string `validate:""`
+	QueueWorkers    int    `validate:"required,min=1"`
+	QueueMaxPending int    `validate:"omitempty,min=0"`
+
+	BackfillBoltDBPath string        `validate:""`
+	BackfillInterval   time.Duration `validate:"omitempty,min=0"`
+
+	AdminToken string `validate:""`
 }
 
 func New() (c *Configuration) {
@@ -219,6 +379,9 @@ func New() (c *Configuration) {
 	c.ResubTargetAddr = viper.GetString("resub_target_addr")
 	c.ResubTopics = viper.GetStringSlice("resub_topic")
 	c.ResubInterval = viper.GetDuration("resub_interval")
+	c.ResubVerify = viper.GetString("resub_verify")
+
+	c.SubscriptionBoltDBPath = viper.GetString("subscription_boltdb_path")
 
 	c.S3Endpoint = viper.GetString("s3_endpoint")
 	c.S3AccessKeyID = viper.GetString("s3_access_key_id")
@@ -237,14 +400,49 @@ func New() (c *Configuration) {
 
 	c.VideoFormatQuality = viper.GetString("video_format_quality")
 	c.VideoFormatExtension = viper.GetString("video_format_extension")
+	c.VideoFormatMode = viper.GetString("video_format_mode")
+	c.VideoFormatMaxFPS = viper.GetInt("video_format_max_fps")
+	c.VideoFormatCodecs = viper.GetStringSlice("video_format_codecs")
+	c.VideoFormatFallback = viper.GetBool("video_format_fallback")
+	c.VideoFormatAudioOnly = viper.GetBool("video_format_audio_only")
+	c.VideoFormatAudioFormat = viper.GetString("video_format_audio_format")
+	c.FFmpegBinary = viper.GetString("ffmpeg_binary")
+	c.VideoSidecarJSON = viper.GetBool("video_sidecar_json")
+	c.VideoSidecarThumbnails = viper.GetBool("video_sidecar_thumbnails")
+	c.VideoSidecarDescription = viper.GetBool("video_sidecar_description")
+	c.VideoSubtitleLanguages = viper.GetStringSlice("video_subtitle_languages")
+	c.VideoSubtitleAuto = viper.GetBool("video_subtitle_auto")
+	c.VideoSubtitleMode = viper.GetString("video_subtitle_mode")
+	c.VideoLiveChatRecorder = viper.GetString("video_live_chat_recorder")
+	c.VideoLiveChatRecorderBinary = viper.GetString("video_live_chat_recorder_binary")
+	c.VideoLiveChatGracePeriod = viper.GetDuration("video_live_chat_grace_period")
+	c.VideoVODMode = viper.GetString("video_vod_mode")
+	c.VideoVODRearchiveDelay = viper.GetDuration("video_vod_rearchive_delay")
+	c.VideoLiveWaitWindow = viper.GetDuration("video_live_wait_window")
+	c.VideoLiveWaitPollInterval = viper.GetDuration("video_live_wait_poll_interval")
+	c.VideoLiveWaitMaxPollInterval = viper.GetDuration("video_live_wait_max_poll_interval")
+	c.VideoLiveWaitGiveUpAfter = viper.GetDuration("video_live_wait_give_up_after")
+	c.VideoDownloader = viper.GetString("video_downloader")
+	c.VideoDownloaderBinary = viper.GetString("video_downloader_binary")
+	c.VideoDownloaderCommandTemplate = viper.GetString("video_downloader_command_template")
+	c.VideoDownloadStreaming = viper.GetBool("video_download_streaming")
+	c.VideoDownloadProgressInterval = viper.GetDuration("video_download_progress_interval")
 	c.VideoDownloadMaxRetries = viper.GetInt("video_download_max_retries")
 	c.VideoDownloadRetryDelay = viper.GetDuration("video_download_retry_delay")
+	c.VideoDownloadMaxConcurrent = viper.GetInt("video_download_max_concurrent")
+	c.VideoDownloadMaxPerChannel = viper.GetInt("video_download_max_per_channel")
 	c.TemporaryFileDir = viper.GetString("temporary_file_dir")
 
+	c.DownloadLeaseBackend = viper.GetString("download_lease_backend")
+	c.DownloadLeaseTTL = viper.GetDuration("download_lease_ttl")
+	c.DownloadLeaseBoltDBPath = viper.GetString("download_lease_boltdb_path")
+	c.DownloadLeaseRedisAddr = viper.GetString("download_lease_redis_addr")
+
 	c.RedisAddr = viper.GetString("redis_addr")
 	c.RedisUsername = viper.GetString("redis_username")
 	c.RedisPassword = viper.GetString("redis_password")
 	c.RedisChannel = viper.GetString("redis_channel")
+	c.RedisProgressChannel = viper.GetString("redis_progress_channel")
 	c.RedisDB = viper.GetInt("redis_db")
 	c.RedisMaxRetries = viper.GetInt("redis_max_retries")
 	c.RedisDialTimeout = viper.GetDuration("redis_dial_timeout")
@@ -259,10 +457,14 @@ func New() (c *Configuration) {
 
 	c.BoltDBPath = viper.GetString("boltdb_path")
 	c.StreamSchedulerWorkerInterval = viper.GetDuration("stream_scheduler_worker_interval")
+	c.StreamSchedulerMaxAttempts = viper.GetInt("stream_scheduler_max_attempts")
+	c.StreamSchedulerRetryBackoff = viper.GetDuration("stream_scheduler_retry_backoff")
+	c.StreamSchedulerMaxBackoff = viper.GetDuration("stream_scheduler_max_backoff")
 
 	c.AMQPDSN = viper.GetString("amqp_dsn")
 	c.AMQPExchange = viper.GetString("amqp_exchange")
 	c.AMQPKey = viper.GetString("amqp_key")
+	c.AMQPProgressKey = viper.GetString("amqp_progress_key")
 	c.AMQPPublishMandatory = viper.GetBool("amqp_publish_mandatory")
 	c.AMQPPublishImmediate = viper.GetBool("amqp_publish_immediate")
 	c.AMQPExchangeKind = viper.GetString("amqp_exchange_kind")
@@ -271,6 +473,18 @@ func New() (c *Configuration) {
 	c.AMQPExchangeInternal = viper.GetBool("amqp_exchange_internal")
 	c.AMQPExchangeNoWait = viper.GetBool("amqp_exchange_no_wait")
 
+	c.ArchiveSQLDriver = viper.GetString("archive_sql_driver")
+	c.ArchiveSQLDSN = viper.GetString("archive_sql_dsn")
+
+	c.QueueBoltDBPath = viper.GetString("queue_boltdb_path")
+	c.QueueWorkers = viper.GetInt("queue_workers")
+	c.QueueMaxPending = viper.GetInt("queue_max_pending")
+
+	c.BackfillBoltDBPath = viper.GetString("backfill_boltdb_path")
+	c.BackfillInterval = viper.GetDuration("backfill_interval")
+
+	c.AdminToken = viper.GetString("admin_token")
+
 	return
 }
 
@@ -293,6 +507,21 @@ func (c *Configuration) Validate() (err error) {
 		return ErrInvalidStorageBackend
 	}
 
+	switch c.DownloadLeaseBackend {
+	case DownloadLeaseBackendRedis:
+		if c.DownloadLeaseRedisAddr == "" && c.RedisAddr == "" {
+			return ErrInvalidDownloadLeaseConfig
+		}
+	case DownloadLeaseBackendBolt:
+		if c.DownloadLeaseBoltDBPath == "" {
+			return ErrInvalidDownloadLeaseConfig
+		}
+	}
+
+	if c.VideoDownloader == VideoDownloaderTemplate && c.VideoDownloaderCommandTemplate == "" {
+		return ErrInvalidVideoDownloaderConfig
+	}
+
 	err = c.validator.Struct(c)
 
 	return
@@ -83,4 +83,23 @@ func TestConfig(t *testing.T) {
 		err := cfg.Validate()
 		require.Error(t, err)
 	})
+
+	t.Run("Validate download lease redis address", func(t *testing.T) {
+		cfg = New()
+		require.NotNil(t, cfg)
+		cfg.StorageBackend = StorageBackendDisk
+		cfg.DiskDirectory = "/"
+		cfg.DownloadLeaseBackend = DownloadLeaseBackendRedis
+
+		err := cfg.Validate()
+		require.Equal(t, ErrInvalidDownloadLeaseConfig, err)
+
+		cfg.DownloadLeaseRedisAddr = "localhost:6379"
+		cfg.validator = mockValidator
+		mockValidator.EXPECT().Struct(gomock.AssignableToTypeOf(cfg)).Return(nil)
+
+		err = cfg.Validate()
+		require.NoError(t, err)
+		require.Empty(t, cfg.RedisAddr)
+	})
 }
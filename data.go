@@ -3,10 +3,46 @@ package ytfeed
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"time"
 )
 
 type DataHandlerFunc func(ctx context.Context, d *Data)
 
+// DataHandlerErrFunc is a data handler that reports whether it handled the data, callers that retry failed data use it
+type DataHandlerErrFunc func(ctx context.Context, d *Data) error
+
+// WithoutError adapts the handler for callers that don't retry, the handler is expected to log its own failures
+func (f DataHandlerErrFunc) WithoutError() DataHandlerFunc {
+	return func(ctx context.Context, d *Data) {
+		_ = f(ctx, d)
+	}
+}
+
+// RetryLaterError is returned by a data handler that isn't done with the data yet and wants it again at RetryAt,
+// callers that retry later don't count it as a failed attempt
+type RetryLaterError struct {
+	RetryAt time.Time
+	Reason  string
+}
+
+func (e *RetryLaterError) Error() string {
+	return fmt.Sprintf("%s, retrying at %s", e.Reason, e.RetryAt)
+}
+
+type retryLaterKey struct{}
+
+// WithRetryLater marks the context of a caller that gives the data again to data handlers that returned a RetryLaterError
+func WithRetryLater(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryLaterKey{}, true)
+}
+
+// RetriesLater reports whether the caller gives the data again to data handlers that returned a RetryLaterError
+func RetriesLater(ctx context.Context) bool {
+	retries, _ := ctx.Value(retryLaterKey{}).(bool)
+	return retries
+}
+
 type Data struct {
 	Feed               Feed   `json:"feed"`
 	OriginalXMLMessage string `json:"original_xml_message,omitempty"`
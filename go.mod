@@ -4,13 +4,13 @@ go 1.15
 
 require (
 	cloud.google.com/go/storage v1.10.0
-	github.com/basgys/goxml2json v1.1.0
-	github.com/bitly/go-simplejson v0.5.0 // indirect
-	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
 	github.com/fsouza/fake-gcs-server v1.20.0
 	github.com/go-playground/validator/v10 v10.3.0
 	github.com/go-redis/redis/v8 v8.0.0-beta.12
 	github.com/golang/mock v1.4.4
+	github.com/jmoiron/sqlx v1.2.0
+	github.com/lib/pq v1.8.0
+	github.com/mattn/go-sqlite3 v1.14.4
 	github.com/minio/minio-go/v7 v7.0.5
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.6.0
@@ -0,0 +1,78 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: plugin/savevideo/dedup.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+	time "time"
+)
+
+// MockDownloadLeaser is a mock of DownloadLeaser interface
+type MockDownloadLeaser struct {
+	ctrl     *gomock.Controller
+	recorder *MockDownloadLeaserMockRecorder
+}
+
+// MockDownloadLeaserMockRecorder is the mock recorder for MockDownloadLeaser
+type MockDownloadLeaserMockRecorder struct {
+	mock *MockDownloadLeaser
+}
+
+// NewMockDownloadLeaser creates a new mock instance
+func NewMockDownloadLeaser(ctrl *gomock.Controller) *MockDownloadLeaser {
+	mock := &MockDownloadLeaser{ctrl: ctrl}
+	mock.recorder = &MockDownloadLeaserMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockDownloadLeaser) EXPECT() *MockDownloadLeaserMockRecorder {
+	return m.recorder
+}
+
+// Acquire mocks base method
+func (m *MockDownloadLeaser) Acquire(ctx context.Context, videoID string, ttl time.Duration) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Acquire", ctx, videoID, ttl)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Acquire indicates an expected call of Acquire
+func (mr *MockDownloadLeaserMockRecorder) Acquire(ctx, videoID, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Acquire", reflect.TypeOf((*MockDownloadLeaser)(nil).Acquire), ctx, videoID, ttl)
+}
+
+// Renew mocks base method
+func (m *MockDownloadLeaser) Renew(ctx context.Context, videoID string, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Renew", ctx, videoID, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Renew indicates an expected call of Renew
+func (mr *MockDownloadLeaserMockRecorder) Renew(ctx, videoID, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Renew", reflect.TypeOf((*MockDownloadLeaser)(nil).Renew), ctx, videoID, ttl)
+}
+
+// Release mocks base method
+func (m *MockDownloadLeaser) Release(ctx context.Context, videoID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Release", ctx, videoID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Release indicates an expected call of Release
+func (mr *MockDownloadLeaserMockRecorder) Release(ctx, videoID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Release", reflect.TypeOf((*MockDownloadLeaser)(nil).Release), ctx, videoID)
+}
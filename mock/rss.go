@@ -0,0 +1,100 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: rss/rss.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	ytfeed "github.com/worksinmagic/ytfeed"
+	reflect "reflect"
+)
+
+// MockEnqueuer is a mock of Enqueuer interface
+type MockEnqueuer struct {
+	ctrl     *gomock.Controller
+	recorder *MockEnqueuerMockRecorder
+}
+
+// MockEnqueuerMockRecorder is the mock recorder for MockEnqueuer
+type MockEnqueuerMockRecorder struct {
+	mock *MockEnqueuer
+}
+
+// NewMockEnqueuer creates a new mock instance
+func NewMockEnqueuer(ctrl *gomock.Controller) *MockEnqueuer {
+	mock := &MockEnqueuer{ctrl: ctrl}
+	mock.recorder = &MockEnqueuerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockEnqueuer) EXPECT() *MockEnqueuerMockRecorder {
+	return m.recorder
+}
+
+// EnqueueBatch mocks base method
+func (m *MockEnqueuer) EnqueueBatch(ctx context.Context, data []*ytfeed.Data) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueBatch", ctx, data)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnqueueBatch indicates an expected call of EnqueueBatch
+func (mr *MockEnqueuerMockRecorder) EnqueueBatch(ctx, data interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueBatch", reflect.TypeOf((*MockEnqueuer)(nil).EnqueueBatch), ctx, data)
+}
+
+// MockVerifier is a mock of Verifier interface
+type MockVerifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockVerifierMockRecorder
+}
+
+// MockVerifierMockRecorder is the mock recorder for MockVerifier
+type MockVerifierMockRecorder struct {
+	mock *MockVerifier
+}
+
+// NewMockVerifier creates a new mock instance
+func NewMockVerifier(ctrl *gomock.Controller) *MockVerifier {
+	mock := &MockVerifier{ctrl: ctrl}
+	mock.recorder = &MockVerifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockVerifier) EXPECT() *MockVerifierMockRecorder {
+	return m.recorder
+}
+
+// Verify mocks base method
+func (m *MockVerifier) Verify(mode, topic string, leaseSeconds int) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", mode, topic, leaseSeconds)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Verify indicates an expected call of Verify
+func (mr *MockVerifierMockRecorder) Verify(mode, topic, leaseSeconds interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockVerifier)(nil).Verify), mode, topic, leaseSeconds)
+}
+
+// Denied mocks base method
+func (m *MockVerifier) Denied(topic, reason string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Denied", topic, reason)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Denied indicates an expected call of Denied
+func (mr *MockVerifierMockRecorder) Denied(topic, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Denied", reflect.TypeOf((*MockVerifier)(nil).Denied), topic, reason)
+}
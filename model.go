@@ -11,3 +11,12 @@ type YTFeedModel struct {
 	Title       string    `db:"title"`
 	URL         string    `db:"url"`
 }
+
+type YTFeedDeletedEntryModel struct {
+	Ref       string    `db:"ref"`
+	DeletedAt time.Time `db:"deleted_at"`
+	VideoID   string    `db:"video_id"`
+	URL       string    `db:"url"`
+	ByName    string    `db:"by_name"`
+	ByURI     string    `db:"by_uri"`
+}
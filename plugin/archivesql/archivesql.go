@@ -0,0 +1,162 @@
+package archivesql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"           // postgres driver
+	_ "github.com/mattn/go-sqlite3" // sqlite3 driver
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+)
+
+const (
+	DriverSQLite3  = "sqlite3"
+	DriverPostgres = "postgres"
+
+	VideoIDPrefix = "yt:video:"
+
+	ErrUnsupportedDriverFormat = "unsupported archive sql driver: %s"
+
+	upsertVideoQuery = `INSERT INTO ytfeed_videos (id, published_at, updated_at, video_id, channel_id, title, url)
+VALUES (:id, :published_at, :updated_at, :video_id, :channel_id, :title, :url)
+ON CONFLICT (id) DO UPDATE SET
+	published_at = excluded.published_at,
+	updated_at = excluded.updated_at,
+	video_id = excluded.video_id,
+	channel_id = excluded.channel_id,
+	title = excluded.title,
+	url = excluded.url`
+	upsertDeletedEntryQuery = `INSERT INTO ytfeed_deleted_entries (ref, deleted_at, video_id, url, by_name, by_uri)
+VALUES (:ref, :deleted_at, :video_id, :url, :by_name, :by_uri)
+ON CONFLICT (ref) DO UPDATE SET
+	deleted_at = excluded.deleted_at,
+	video_id = excluded.video_id,
+	url = excluded.url,
+	by_name = excluded.by_name,
+	by_uri = excluded.by_uri`
+)
+
+type Databaser interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+	Close() error
+}
+
+type ArchiveSQL struct {
+	logger ytfeed.Logger
+	db     Databaser
+}
+
+func (a *ArchiveSQL) DataHandler(ctx context.Context, d *ytfeed.Data) {
+	_ = a.HandleData(ctx, d)
+}
+
+// HandleData archives the data like DataHandler and returns why it failed
+func (a *ArchiveSQL) HandleData(ctx context.Context, d *ytfeed.Data) (err error) {
+	if d.Feed.DeletedEntry.Ref != "" {
+		err = a.SaveDeletedEntry(ctx, d.Feed.DeletedEntry)
+		if err != nil {
+			a.logger.Errorf("Failed to archive deleted entry %s: %v", d.Feed.DeletedEntry.Ref, err)
+			return
+		}
+
+		a.logger.Infof("Archived deleted entry %s", d.Feed.DeletedEntry.Ref)
+		return
+	}
+
+	err = a.SaveEntry(ctx, d.Feed.Entry)
+	if err != nil {
+		a.logger.Errorf("Failed to archive video %s: %v", d.Feed.Entry.Link.Href, err)
+		return
+	}
+
+	a.logger.Infof("Archived video %s", d.Feed.Entry.Link.Href)
+
+	return
+}
+
+func (a *ArchiveSQL) SaveEntry(ctx context.Context, e ytfeed.Entry) (err error) {
+	m := ytfeed.YTFeedModel{}
+	m.ID = e.ID
+	m.VideoID = e.VideoID
+	m.ChannelID = e.ChannelID
+	m.Title = e.Title
+	m.URL = e.Link.Href
+	m.PublishedAt, err = time.Parse(time.RFC3339Nano, e.Published)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid published date %s", e.Published)
+		return
+	}
+	m.UpdatedAt, err = time.Parse(time.RFC3339Nano, e.Updated)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid updated date %s", e.Updated)
+		return
+	}
+	m.PublishedAt = m.PublishedAt.UTC()
+	m.UpdatedAt = m.UpdatedAt.UTC()
+	if m.ID == "" {
+		m.ID = VideoIDPrefix + m.VideoID
+	}
+
+	_, err = a.db.NamedExecContext(ctx, upsertVideoQuery, m)
+
+	return
+}
+
+func (a *ArchiveSQL) SaveDeletedEntry(ctx context.Context, e ytfeed.DeletedEntry) (err error) {
+	m := ytfeed.YTFeedDeletedEntryModel{}
+	m.Ref = e.Ref
+	m.VideoID = strings.TrimPrefix(e.Ref, VideoIDPrefix)
+	m.URL = e.Link.Href
+	m.ByName = e.By.Name
+	m.ByURI = e.By.URI
+	m.DeletedAt, err = time.Parse(time.RFC3339Nano, e.When)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid deletion date %s", e.When)
+		return
+	}
+	m.DeletedAt = m.DeletedAt.UTC()
+
+	_, err = a.db.NamedExecContext(ctx, upsertDeletedEntryQuery, m)
+
+	return
+}
+
+func (a *ArchiveSQL) CloseDatabase() (err error) {
+	return a.db.Close()
+}
+
+func New(ctx context.Context, logger ytfeed.Logger, driverName, dsn string) (a *ArchiveSQL, err error) {
+	switch driverName {
+	case DriverSQLite3:
+	case DriverPostgres:
+	default:
+		err = fmt.Errorf(ErrUnsupportedDriverFormat, driverName)
+		return
+	}
+
+	a = &ArchiveSQL{}
+	a.logger = logger
+	a.db, err = sqlx.ConnectContext(ctx, driverName, dsn)
+	if err != nil {
+		a = nil
+		err = errors.Wrap(err, "failed to connect to archive database")
+		return
+	}
+
+	err = a.Migrate(ctx)
+	if err != nil {
+		// the caller only closes the database of an archive it got back
+		a.db.Close()
+		a = nil
+	}
+
+	return
+}
@@ -0,0 +1,158 @@
+package archivesql
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+const (
+	videoID   = "nivpuSG09_E"
+	videoURL  = "https://www.youtube.com/watch?v=nivpuSG09_E"
+	channelID = "UCAzsiozXvl0GfoAodNpDSQw"
+)
+
+func TestArchiveSQL(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dirName)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := mock.NewMockLogger(ctrl)
+
+	a, err := New(context.TODO(), logger, DriverSQLite3, filepath.Join(dirName, "archive.db"))
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	defer a.CloseDatabase()
+
+	db := a.db.(interface {
+		SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	})
+
+	t.Run("Migrate is idempotent", func(t *testing.T) {
+		err := a.Migrate(context.TODO())
+		require.NoError(t, err)
+
+		var version int
+		err = a.db.GetContext(context.TODO(), &version, selectMigrationVersionQuery)
+		require.NoError(t, err)
+		require.Equal(t, len(migrations), version)
+	})
+
+	t.Run("DataHandler success upsert entry", func(t *testing.T) {
+		data := &ytfeed.Data{}
+		data.Feed.Entry.ID = "yt:video:" + videoID
+		data.Feed.Entry.VideoID = videoID
+		data.Feed.Entry.ChannelID = channelID
+		data.Feed.Entry.Title = "Up- Shania Twain"
+		data.Feed.Entry.Link.Href = videoURL
+		data.Feed.Entry.Published = "2012-08-26T01:51:49+00:00"
+		data.Feed.Entry.Updated = "2020-03-22T11:53:58.790881024+00:00"
+
+		logger.EXPECT().Infof(
+			gomock.AssignableToTypeOf("archived"),
+			gomock.AssignableToTypeOf(videoURL),
+		).Times(2)
+
+		a.DataHandler(context.TODO(), data)
+
+		data.Feed.Entry.Title = "Up! Shania Twain"
+		a.DataHandler(context.TODO(), data)
+
+		videos := []ytfeed.YTFeedModel{}
+		err := db.SelectContext(context.TODO(), &videos, "SELECT * FROM ytfeed_videos")
+		require.NoError(t, err)
+		require.Len(t, videos, 1)
+		require.Equal(t, videoID, videos[0].VideoID)
+		require.Equal(t, channelID, videos[0].ChannelID)
+		require.Equal(t, "Up! Shania Twain", videos[0].Title)
+		require.Equal(t, 2012, videos[0].PublishedAt.Year())
+	})
+
+	t.Run("HandleData failed invalid published date", func(t *testing.T) {
+		data := &ytfeed.Data{}
+		data.Feed.Entry.VideoID = videoID
+		data.Feed.Entry.Link.Href = videoURL
+		data.Feed.Entry.Published = "invalid"
+
+		logger.EXPECT().Errorf(
+			gomock.AssignableToTypeOf("failed"),
+			gomock.AssignableToTypeOf(videoURL),
+			gomock.Any(),
+		)
+
+		err := a.HandleData(context.TODO(), data)
+		require.Error(t, err)
+	})
+
+	t.Run("DataHandler success deleted entry", func(t *testing.T) {
+		data := &ytfeed.Data{}
+		data.Feed.DeletedEntry.Ref = "yt:video:" + videoID
+		data.Feed.DeletedEntry.When = "2020-07-29T16:46:32+00:00"
+		data.Feed.DeletedEntry.Link.Href = videoURL
+		data.Feed.DeletedEntry.By.Name = "Komari PackoftheFallen"
+		data.Feed.DeletedEntry.By.URI = "https://www.youtube.com/channel/" + channelID
+
+		logger.EXPECT().Infof(
+			gomock.AssignableToTypeOf("archived"),
+			gomock.AssignableToTypeOf("ref"),
+		)
+
+		a.DataHandler(context.TODO(), data)
+
+		entries := []ytfeed.YTFeedDeletedEntryModel{}
+		err := db.SelectContext(context.TODO(), &entries, "SELECT * FROM ytfeed_deleted_entries")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, videoID, entries[0].VideoID)
+		require.Equal(t, videoURL, entries[0].URL)
+	})
+
+	t.Run("HandleData failed invalid deletion date", func(t *testing.T) {
+		data := &ytfeed.Data{}
+		data.Feed.DeletedEntry.Ref = "yt:video:" + videoID
+		data.Feed.DeletedEntry.When = "invalid"
+
+		logger.EXPECT().Errorf(
+			gomock.AssignableToTypeOf("failed"),
+			gomock.AssignableToTypeOf("ref"),
+			gomock.Any(),
+		)
+
+		err := a.HandleData(context.TODO(), data)
+		require.Error(t, err)
+	})
+
+	t.Run("New failed unsupported driver", func(t *testing.T) {
+		a, err := New(context.TODO(), logger, "mysql", "dsn")
+		require.Error(t, err)
+		require.Nil(t, a)
+	})
+
+	t.Run("New failed migration", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "broken.db")
+		db, err := sqlx.Connect(DriverSQLite3, databasePath)
+		require.NoError(t, err)
+		_, err = db.Exec(`CREATE TABLE ytfeed_schema_migrations (version TEXT)`)
+		require.NoError(t, err)
+		_, err = db.Exec(`INSERT INTO ytfeed_schema_migrations (version) VALUES ('not a version')`)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		a, err := New(context.TODO(), logger, DriverSQLite3, databasePath)
+		require.Error(t, err)
+		require.Nil(t, a)
+	})
+}
@@ -0,0 +1,91 @@
+package archivesql
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	createMigrationTableQuery = `CREATE TABLE IF NOT EXISTS ytfeed_schema_migrations (
+	version INTEGER PRIMARY KEY
+)`
+	selectMigrationVersionQuery = `SELECT COALESCE(MAX(version), 0) FROM ytfeed_schema_migrations`
+	insertMigrationVersionQuery = `INSERT INTO ytfeed_schema_migrations (version) VALUES (?)`
+)
+
+// migrations are applied in order, never edit or reorder an existing one, append a new one instead.
+// Timestamps are stored in UTC because TIMESTAMP is the only type both sqlite3 and postgres drivers scan into time.Time.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS ytfeed_videos (
+	id TEXT PRIMARY KEY,
+	published_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	url TEXT NOT NULL
+)`,
+	`CREATE INDEX IF NOT EXISTS ytfeed_videos_channel_id_idx ON ytfeed_videos (channel_id)`,
+	`CREATE TABLE IF NOT EXISTS ytfeed_deleted_entries (
+	ref TEXT PRIMARY KEY,
+	deleted_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	by_name TEXT NOT NULL,
+	by_uri TEXT NOT NULL
+)`,
+}
+
+func (a *ArchiveSQL) Migrate(ctx context.Context) (err error) {
+	_, err = a.db.ExecContext(ctx, createMigrationTableQuery)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create migration table")
+		return
+	}
+
+	var version int
+	err = a.db.GetContext(ctx, &version, selectMigrationVersionQuery)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get current migration version")
+		return
+	}
+
+	for i := version; i < len(migrations); i++ {
+		err = a.migrate(ctx, i+1, migrations[i])
+		if err != nil {
+			err = errors.Wrapf(err, "failed to apply migration version %d", i+1)
+			return
+		}
+	}
+
+	return
+}
+
+func (a *ArchiveSQL) migrate(ctx context.Context, version int, query string) (err error) {
+	var tx *sqlx.Tx
+	tx, err = a.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(insertMigrationVersionQuery), version)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+
+	return
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,8 +16,16 @@ import (
 
 const (
 	DefaultTimeout   = 30 * time.Second
-	DefaultHubMode   = "subscribe"
-	DefaultHubVerify = "sync"
+	DefaultHubMode   = HubModeSubscribe
+	DefaultHubVerify = HubVerifySync
+
+	// DefaultRetryInterval is how long a failed subscription waits before it is retried
+	DefaultRetryInterval = 5 * time.Minute
+	// DefaultResolveRetryInterval is how long a static topic that failed to resolve waits before it is resolved again,
+	// resolving a handle costs a search so it is retried less often than a subscription
+	DefaultResolveRetryInterval = time.Hour
+	// DefaultLeaseRenewalDivisor renews a subscription when a tenth of its lease is left
+	DefaultLeaseRenewalDivisor = 10
 
 	HubTopic             = "hub.topic"
 	HubCallback          = "hub.callback"
@@ -24,13 +34,95 @@ const (
 	HubVerificationToken = "hub.verify_token"
 	HubSecret            = "hub.secret"
 
+	HubModeSubscribe   = "subscribe"
+	HubModeUnsubscribe = "unsubscribe"
+
+	// HubVerifySync makes the hub verify the intent before it answers the request
+	HubVerifySync = "sync"
+	// HubVerifyAsync makes the hub answer the request right away and verify the intent later
+	HubVerifyAsync = "async"
+
+	// TopicStatePending is a request that the hub has not verified yet
+	TopicStatePending = "pending"
+	// TopicStateVerified is a request that the hub verified
+	TopicStateVerified = "verified"
+	// TopicStateDenied is a subscription that the hub or the publisher refused
+	TopicStateDenied = "denied"
+	// TopicStateFailed is a request that the hub refused with a non 2xx response, a request whose response never arrived stays pending
+	TopicStateFailed = "failed"
+
 	ErrResubscribeFormat = "failed to resubscribe for topic %s with error '%v'"
+	ErrUnsubscribeFormat = "failed to unsubscribe from topic %s with error '%v'"
 )
 
 var (
-	ErrFailedToSubscribeFeed = errors.New("failed to subscribe to feed")
+	ErrFailedToSubscribeFeed   = errors.New("failed to subscribe to feed")
+	ErrFailedToUnsubscribeFeed = errors.New("failed to unsubscribe from feed")
+	ErrSubscriptionDenied      = errors.New("subscription denied by hub")
 )
 
+// TopicStatus is the outcome of the last subscription or unsubscription of the topic
+type TopicStatus struct {
+	Topic string `json:"topic"`
+	// Mode is the hub.mode of the last request, topics being unsubscribed are kept until the hub verified it
+	Mode          string    `json:"mode"`
+	SubscribedAt  time.Time `json:"subscribed_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	// State is one of pending, verified, denied or failed
+	State           string    `json:"state,omitempty"`
+	VerifiedAt      time.Time `json:"verified_at"`
+	LeaseSeconds    int       `json:"lease_seconds,omitempty"`
+	LeaseExpiresAt  time.Time `json:"lease_expires_at"`
+	NextSubscribeAt time.Time `json:"next_subscribe_at"`
+}
+
+// nextSubscribeAt returns when the desired topic has to be subscribed again,
+// interval is used for subscriptions without a known lease
+func (ts *TopicStatus) nextSubscribeAt(interval time.Duration) (t time.Time) {
+	switch {
+	case ts.LastAttemptAt.IsZero() || ts.Mode != HubModeSubscribe:
+		return
+	case ts.State == TopicStateFailed || ts.State == TopicStatePending:
+		// a pending request is retried too in case the verification never arrives
+		return ts.LastAttemptAt.Add(DefaultRetryInterval)
+	case ts.State == TopicStateDenied:
+		return ts.LastAttemptAt.Add(interval)
+	case ts.State == TopicStateVerified && ts.LeaseSeconds > 0:
+		lease := time.Duration(ts.LeaseSeconds) * time.Second
+		return ts.LeaseExpiresAt.Add(-lease / DefaultLeaseRenewalDivisor)
+	default:
+		return ts.SubscribedAt.Add(interval)
+	}
+}
+
+// nextUnsubscribeAt returns when the topic that is no longer desired has to be unsubscribed,
+// it is retried until the hub verified it
+func (ts *TopicStatus) nextUnsubscribeAt() (t time.Time) {
+	if ts.LastAttemptAt.IsZero() || ts.Mode != HubModeUnsubscribe {
+		return
+	}
+
+	return ts.LastAttemptAt.Add(DefaultRetryInterval)
+}
+
+// TopicSource is where topics are added or disabled at runtime
+type TopicSource interface {
+	Topics() (enabled, disabled []string, err error)
+}
+
+// TopicResolver turns what a static topic refers to, like a channel URL or handle, into its subscription topic
+type TopicResolver interface {
+	ResolveTopic(ctx context.Context, input string) (topic string, err error)
+}
+
+// SubscribedTopicStore persists the topics the hub was asked to push to us so they can be unsubscribed after a restart
+type SubscribedTopicStore interface {
+	SubscribedTopics() (topics map[string]time.Time, err error)
+	PutSubscribedTopic(topic string, subscribedAt time.Time) (err error)
+	DeleteSubscribedTopic(topic string) (err error)
+}
+
 type Subscriber struct {
 	resubInterval     time.Duration
 	targetAddr        string
@@ -38,9 +130,22 @@ type Subscriber struct {
 	callbackAddr      string
 	verificationToken string
 	hmacSecret        string
+	hubVerify         string
+
+	logger               ytfeed.Logger
+	client               *http.Client
+	topicSource          TopicSource
+	subscribedTopicStore SubscribedTopicStore
+	topicResolver        TopicResolver
+	refresh              chan struct{}
 
-	logger ytfeed.Logger
-	client *http.Client
+	resolvedTopics     map[string]string
+	resolveAttemptAt   time.Time
+	resolvedTopicsLock sync.RWMutex
+
+	status        map[string]*TopicStatus
+	statusLock    sync.RWMutex
+	subscribeLock sync.Mutex
 }
 
 func New(logger ytfeed.Logger, verificationToken, hmacSecret, targetAddr, callbackAddr string, topics []string, resubInterval time.Duration) (s *Subscriber) {
@@ -50,29 +155,465 @@ func New(logger ytfeed.Logger, verificationToken, hmacSecret, targetAddr, callba
 	s.callbackAddr = callbackAddr
 	s.verificationToken = verificationToken
 	s.hmacSecret = hmacSecret
+	s.hubVerify = DefaultHubVerify
 	s.topics = topics
 	s.client = &http.Client{}
 	s.client.Timeout = DefaultTimeout
 	s.logger = logger
+	s.status = make(map[string]*TopicStatus, len(topics))
+	s.resolvedTopics = make(map[string]string, len(topics))
+	s.refresh = make(chan struct{}, 1)
 
 	return
 }
 
+// SetTopicSource because topics can be static, it doesn't have to be present at constructor function.
+// Enabled topics of the source are subscribed next to the static topics, disabled ones are not even if they are static.
+func (s *Subscriber) SetTopicSource(ts TopicSource) {
+	s.topicSource = ts
+}
+
+// SetSubscribedTopicStore because SubscribedTopicStore is optional, it doesn't have to be present at constructor function.
+// Without it topics removed while the process was stopped are left to expire at the hub.
+func (s *Subscriber) SetSubscribedTopicStore(sts SubscribedTopicStore) {
+	s.subscribedTopicStore = sts
+}
+
+// SetTopicResolver because static topics can be subscription topics already, it doesn't have to be present at constructor function.
+// Static topics are resolved when Subscribe starts, the ones that fail are skipped and resolved again every DefaultResolveRetryInterval
+// or resubscribe interval if it is shorter. Nothing is unsubscribed until every static topic resolved.
+func (s *Subscriber) SetTopicResolver(tr TopicResolver) {
+	s.topicResolver = tr
+}
+
+// SetHubVerify because the hub verifies synchronously by default, it doesn't have to be present at constructor function.
+// verify is either HubVerifySync or HubVerifyAsync.
+func (s *Subscriber) SetHubVerify(verify string) {
+	s.hubVerify = verify
+}
+
 func (s *Subscriber) SetHTTPClient(c *http.Client) {
 	s.client = c
 }
 
+// Subscribe subscribes to every topic right away, renews each topic before its hub lease expires
+// and unsubscribes from the topics that are no longer desired
 func (s *Subscriber) Subscribe(ctx context.Context) (err error) {
+	s.loadSubscribedTopics()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
 		select {
-		case <-time.After(s.resubInterval):
-			err = s.subscribe()
-			if err != nil {
-				s.logger.Errorf("Failed to resubscribe feed: %v", err)
+		case <-timer.C:
+			s.resolveStaticTopics(ctx)
+			reconcileErr := s.reconcile()
+			if reconcileErr != nil {
+				err = reconcileErr
+				s.logger.Errorf("Failed to reconcile feed subscriptions: %v", err)
+			}
+		case <-s.refresh:
+			if !timer.Stop() {
+				<-timer.C
 			}
 		case <-ctx.Done():
 			return
 		}
+
+		_, _, next := s.plan(time.Now())
+		timer.Reset(time.Until(next))
+	}
+}
+
+// Refresh makes the running Subscribe pick up topics added to or removed from the topic source and renewal times
+// changed by verifications without waiting for the next renewal
+func (s *Subscriber) Refresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Verify records the verification of intent the hub made for the topic, it returns false if the mode of the topic
+// was not requested. A subscription can be verified again while it is active.
+// The topic is forgotten once the hub verified its unsubscription. A subscription whose request failed on our side
+// is saved as subscribed once the hub verified it.
+func (s *Subscriber) Verify(mode, topic string, leaseSeconds int) (expected bool) {
+	now := time.Now()
+
+	s.statusLock.Lock()
+	status, ok := s.status[topic]
+	expected = ok && status.Mode == mode &&
+		(status.State == TopicStatePending || (mode == HubModeSubscribe && status.State == TopicStateVerified))
+	if !expected {
+		s.statusLock.Unlock()
+		return
+	}
+
+	status.State = TopicStateVerified
+	status.LastError = ""
+	status.VerifiedAt = now
+	status.LeaseSeconds = leaseSeconds
+	status.LeaseExpiresAt = time.Time{}
+	if leaseSeconds > 0 {
+		status.LeaseExpiresAt = now.Add(time.Duration(leaseSeconds) * time.Second)
+	}
+	subscribedAt := time.Time{}
+	if mode == HubModeSubscribe && status.SubscribedAt.IsZero() {
+		status.SubscribedAt = now
+		subscribedAt = now
+	}
+	if mode == HubModeUnsubscribe {
+		delete(s.status, topic)
+	}
+	s.statusLock.Unlock()
+
+	if mode == HubModeUnsubscribe {
+		s.forgetSubscribedTopic(topic)
+	}
+	if !subscribedAt.IsZero() {
+		s.saveSubscribedTopic(topic, subscribedAt)
+	}
+	s.Refresh()
+
+	return
+}
+
+// Denied records that the hub or the publisher refused the subscription of the topic, it returns false unless
+// a subscription of the topic is pending or verified. Only subscriptions are denied so a topic being unsubscribed
+// is kept until the hub verified its unsubscription.
+func (s *Subscriber) Denied(topic, reason string) (expected bool) {
+	s.statusLock.Lock()
+	status, ok := s.status[topic]
+	expected = ok && status.Mode == HubModeSubscribe &&
+		(status.State == TopicStatePending || status.State == TopicStateVerified)
+	if !expected {
+		s.statusLock.Unlock()
+		return
+	}
+
+	status.State = TopicStateDenied
+	status.LastError = errors.Wrapf(ErrSubscriptionDenied, "reason '%s'", reason).Error()
+	status.LeaseSeconds = 0
+	status.LeaseExpiresAt = time.Time{}
+	s.statusLock.Unlock()
+
+	s.Refresh()
+
+	return
+}
+
+func (s *Subscriber) saveSubscribedTopic(topic string, subscribedAt time.Time) {
+	if s.subscribedTopicStore == nil {
+		return
+	}
+
+	err := s.subscribedTopicStore.PutSubscribedTopic(topic, subscribedAt)
+	if err != nil {
+		s.logger.Errorf("Failed to save subscribed topic %s: %v", topic, err)
+	}
+}
+
+func (s *Subscriber) forgetSubscribedTopic(topic string) {
+	if s.subscribedTopicStore == nil {
+		return
+	}
+
+	err := s.subscribedTopicStore.DeleteSubscribedTopic(topic)
+	if err != nil {
+		s.logger.Errorf("Failed to delete subscribed topic %s: %v", topic, err)
+	}
+}
+
+// loadSubscribedTopics adds the persisted topics to the status so the ones that are no longer desired get unsubscribed
+func (s *Subscriber) loadSubscribedTopics() {
+	if s.subscribedTopicStore == nil {
+		return
+	}
+
+	topics, err := s.subscribedTopicStore.SubscribedTopics()
+	if err != nil {
+		s.logger.Errorf("Failed to load subscribed topics: %v", err)
+		return
+	}
+
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	for topic, subscribedAt := range topics {
+		if _, ok := s.status[topic]; ok {
+			continue
+		}
+		s.status[topic] = &TopicStatus{Topic: topic, Mode: HubModeSubscribe, SubscribedAt: subscribedAt}
+	}
+}
+
+// resolveStaticTopics resolves the static topics that didn't resolve yet, failed ones are only attempted again
+// once the resolve retry interval passed
+func (s *Subscriber) resolveStaticTopics(ctx context.Context) {
+	if s.topicResolver == nil {
+		return
+	}
+
+	retryInterval := DefaultResolveRetryInterval
+	if s.resubInterval < retryInterval {
+		retryInterval = s.resubInterval
+	}
+	s.resolvedTopicsLock.RLock()
+	attemptedAt := s.resolveAttemptAt
+	s.resolvedTopicsLock.RUnlock()
+	if !attemptedAt.IsZero() && time.Since(attemptedAt) < retryInterval {
+		return
+	}
+
+	for _, input := range s.topics {
+		s.resolvedTopicsLock.RLock()
+		_, ok := s.resolvedTopics[input]
+		s.resolvedTopicsLock.RUnlock()
+		if ok {
+			continue
+		}
+
+		topic, err := s.topicResolver.ResolveTopic(ctx, input)
+		if err != nil {
+			s.logger.Errorf("Failed to resolve static topic %s, skipping it until it resolves: %v", input, err)
+			continue
+		}
+		s.resolvedTopicsLock.Lock()
+		s.resolvedTopics[input] = topic
+		s.resolvedTopicsLock.Unlock()
+	}
+
+	s.resolvedTopicsLock.Lock()
+	s.resolveAttemptAt = time.Now()
+	s.resolvedTopicsLock.Unlock()
+}
+
+// staticTopics returns the static topics, resolved ones only if there is a topic resolver.
+// complete is false if any of them didn't resolve yet.
+func (s *Subscriber) staticTopics() (topics []string, complete bool) {
+	if s.topicResolver == nil {
+		return s.topics, true
+	}
+
+	s.resolvedTopicsLock.RLock()
+	defer s.resolvedTopicsLock.RUnlock()
+
+	complete = true
+	topics = make([]string, 0, len(s.topics))
+	for _, input := range s.topics {
+		topic, ok := s.resolvedTopics[input]
+		if !ok {
+			complete = false
+			continue
+		}
+		topics = append(topics, topic)
+	}
+
+	return
+}
+
+// desiredTopics returns the static topics and the enabled topics of the topic source without the disabled ones,
+// the static topics are used alone if the topic source fails. complete is false then or if any static topic didn't resolve,
+// the topics they refer to may be subscribed already.
+func (s *Subscriber) desiredTopics() (topics []string, complete bool) {
+	static, complete := s.staticTopics()
+	topics = static
+	if s.topicSource == nil {
+		return
+	}
+
+	enabled, disabled, err := s.topicSource.Topics()
+	if err != nil {
+		s.logger.Errorf("Failed to get topics from topic source, using static topics only: %v", err)
+		complete = false
+		return
+	}
+
+	skip := make(map[string]bool, len(disabled)+len(static))
+	for _, topic := range disabled {
+		skip[topic] = true
+	}
+	topics = make([]string, 0, len(static)+len(enabled))
+	for _, topic := range append(append([]string{}, static...), enabled...) {
+		if skip[topic] {
+			continue
+		}
+		skip[topic] = true
+		topics = append(topics, topic)
+	}
+
+	return
+}
+
+// plan returns the desired topics that have to be subscribed at now, the subscribed topics that are no longer desired
+// and when the next request is due, it is never later than the resubscribe interval so topic source changes are picked up.
+// Nothing is unsubscribed while the desired topics are incomplete.
+func (s *Subscriber) plan(now time.Time) (subscribe, unsubscribe []string, next time.Time) {
+	desired, complete := s.desiredTopics()
+
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	next = now.Add(s.resubInterval)
+	due := func(at time.Time) bool {
+		if at.After(now) {
+			if at.Before(next) {
+				next = at
+			}
+			return false
+		}
+		next = now
+		return true
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	subscribe = make([]string, 0, len(desired))
+	for _, topic := range desired {
+		wanted[topic] = true
+		var at time.Time
+		if st, ok := s.status[topic]; ok {
+			at = st.nextSubscribeAt(s.resubInterval)
+		}
+		if due(at) {
+			subscribe = append(subscribe, topic)
+		}
+	}
+
+	unsubscribe = make([]string, 0, len(s.status))
+	if !complete {
+		// static topics that didn't resolve are attempted again once the resolve retry interval passed
+		if s.topicResolver != nil && now.Add(DefaultResolveRetryInterval).Before(next) {
+			next = now.Add(DefaultResolveRetryInterval)
+		}
+		return
+	}
+	for topic, st := range s.status {
+		if wanted[topic] {
+			continue
+		}
+		// there is nothing to unsubscribe if the hub never accepted the subscription
+		if st.Mode == HubModeSubscribe && st.SubscribedAt.IsZero() {
+			delete(s.status, topic)
+			continue
+		}
+		if due(st.nextUnsubscribeAt()) {
+			unsubscribe = append(unsubscribe, topic)
+		}
+	}
+	sort.Strings(unsubscribe)
+
+	return
+}
+
+// reconcile subscribes and unsubscribes the topics that are due
+func (s *Subscriber) reconcile() (err error) {
+	subscribe, unsubscribe, _ := s.plan(time.Now())
+
+	subErr := s.requestTopics(HubModeSubscribe, subscribe)
+	unsubErr := s.requestTopics(HubModeUnsubscribe, unsubscribe)
+	switch {
+	case subErr != nil && unsubErr != nil:
+		err = errors.New(subErr.Error() + "," + unsubErr.Error())
+	case subErr != nil:
+		err = subErr
+	default:
+		err = unsubErr
+	}
+
+	return
+}
+
+// Resubscribe subscribes to every topic right away instead of waiting for the next renewal
+func (s *Subscriber) Resubscribe() (err error) {
+	return s.subscribe()
+}
+
+// Topics returns the subscription status of every desired topic followed by the topics being unsubscribed
+func (s *Subscriber) Topics() (topics []TopicStatus) {
+	desired, _ := s.desiredTopics()
+
+	s.statusLock.RLock()
+	defer s.statusLock.RUnlock()
+
+	wanted := make(map[string]bool, len(desired))
+	topics = make([]TopicStatus, 0, len(desired))
+	for _, topic := range desired {
+		wanted[topic] = true
+		status := TopicStatus{Topic: topic}
+		if st, ok := s.status[topic]; ok {
+			status = *st
+		}
+		status.NextSubscribeAt = status.nextSubscribeAt(s.resubInterval)
+		topics = append(topics, status)
+	}
+
+	unsubscribing := make([]TopicStatus, 0, len(s.status))
+	for topic, st := range s.status {
+		if wanted[topic] || st.Mode != HubModeUnsubscribe {
+			continue
+		}
+		status := *st
+		status.NextSubscribeAt = time.Time{}
+		unsubscribing = append(unsubscribing, status)
+	}
+	sort.Slice(unsubscribing, func(i, j int) bool {
+		return unsubscribing[i].Topic < unsubscribing[j].Topic
+	})
+	topics = append(topics, unsubscribing...)
+
+	return
+}
+
+// startAttempt marks that a request of the mode is pending for the topic, it must be called before sending
+// because the hub verifies synchronously while the request is in flight
+func (s *Subscriber) startAttempt(topic, mode string) (attemptedAt time.Time) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	status, ok := s.status[topic]
+	if !ok {
+		status = &TopicStatus{Topic: topic}
+		s.status[topic] = status
+	}
+	attemptedAt = time.Now()
+	status.Mode = mode
+	status.LastAttemptAt = attemptedAt
+	status.State = TopicStatePending
+	status.LastError = ""
+
+	return
+}
+
+// setStatus records the outcome of the request of the mode for the topic that was attempted at attemptedAt.
+// Only a request the hub refused fails, the hub may still verify a request whose response never arrived
+// so it stays pending until it is retried.
+func (s *Subscriber) setStatus(topic, mode string, attemptedAt time.Time, err error) {
+	s.statusLock.Lock()
+	status, ok := s.status[topic]
+	// the topic is gone if the hub verified its unsubscription already
+	if !ok || status.Mode != mode || !status.LastAttemptAt.Equal(attemptedAt) {
+		s.statusLock.Unlock()
+		return
+	}
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause == ErrFailedToSubscribeFeed || cause == ErrFailedToUnsubscribeFeed {
+			status.State = TopicStateFailed
+		}
+		status.LastError = err.Error()
+		s.statusLock.Unlock()
+		return
+	}
+	if mode == HubModeSubscribe {
+		status.SubscribedAt = time.Now()
+	}
+	subscribedAt := status.SubscribedAt
+	s.statusLock.Unlock()
+
+	if mode == HubModeSubscribe {
+		s.saveSubscribedTopic(topic, subscribedAt)
 	}
 }
 
@@ -82,17 +623,33 @@ type ErrorSub struct {
 }
 
 func (s *Subscriber) subscribe() (err error) {
+	topics, _ := s.desiredTopics()
+	return s.requestTopics(HubModeSubscribe, topics)
+}
+
+// requestTopics sends a request of the mode to the hub for every topic
+func (s *Subscriber) requestTopics(mode string, topics []string) (err error) {
+	s.subscribeLock.Lock()
+	defer s.subscribeLock.Unlock()
+
 	failedReqs := make([]ErrorSub, 0, 8)
+	failedErr := ErrFailedToSubscribeFeed
+	errFormat := ErrResubscribeFormat
+	if mode == HubModeUnsubscribe {
+		failedErr = ErrFailedToUnsubscribeFeed
+		errFormat = ErrUnsubscribeFormat
+	}
 
-	for _, topic := range s.topics {
+	for _, topic := range topics {
 		data := url.Values{}
 		data.Set(HubTopic, topic)
 		data.Set(HubCallback, s.callbackAddr)
-		data.Set(HubMode, DefaultHubMode)
-		data.Set(HubVerify, DefaultHubVerify)
+		data.Set(HubMode, mode)
+		data.Set(HubVerify, s.hubVerify)
 		data.Set(HubVerificationToken, s.verificationToken)
 		data.Set(HubSecret, s.hmacSecret)
 
+		attemptedAt := s.startAttempt(topic, mode)
 		var resp *http.Response
 		resp, err = s.client.PostForm(s.targetAddr, data)
 		if err != nil {
@@ -100,24 +657,33 @@ func (s *Subscriber) subscribe() (err error) {
 				Topic: topic,
 				Err:   err,
 			})
+			s.setStatus(topic, mode, attemptedAt, err)
 			continue
 		}
-		if resp.StatusCode >= http.StatusBadRequest {
-			err = errors.Wrapf(ErrFailedToSubscribeFeed, "HTTP status %d", resp.StatusCode)
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			err = errors.Wrapf(failedErr, "HTTP status %d", resp.StatusCode)
 			failedReqs = append(failedReqs, ErrorSub{
 				Topic: topic,
 				Err:   err,
 			})
+			s.setStatus(topic, mode, attemptedAt, err)
 			continue
 		}
+		s.setStatus(topic, mode, attemptedAt, nil)
 
+		if mode == HubModeUnsubscribe {
+			s.logger.Infof("Unsubscribed from topic %s with callback address %s", topic, s.callbackAddr)
+			continue
+		}
 		s.logger.Infof("Resubscribed to topic %s with callback address %s", topic, s.callbackAddr)
 	}
+	err = nil
 
 	if len(failedReqs) > 0 {
 		errMessages := make([]string, 0, len(failedReqs))
 		for _, f := range failedReqs {
-			errMessages = append(errMessages, fmt.Sprintf(ErrResubscribeFormat, f.Topic, f.Err))
+			errMessages = append(errMessages, fmt.Sprintf(errFormat, f.Topic, f.Err))
 		}
 
 		err = errors.New(strings.Join(errMessages, ","))
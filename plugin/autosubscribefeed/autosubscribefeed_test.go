@@ -2,9 +2,12 @@ package autosubscribefeed
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +16,86 @@ import (
 	"github.com/worksinmagic/ytfeed/mock"
 )
 
+type fakeTopicSource struct {
+	enabled  []string
+	disabled []string
+	err      error
+	lock     sync.Mutex
+}
+
+func (f *fakeTopicSource) Topics() (enabled, disabled []string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.enabled, f.disabled, f.err
+}
+
+type fakeSubscribedTopicStore struct {
+	topics map[string]time.Time
+	lock   sync.Mutex
+}
+
+func (f *fakeSubscribedTopicStore) SubscribedTopics() (topics map[string]time.Time, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	topics = make(map[string]time.Time, len(f.topics))
+	for topic, subscribedAt := range f.topics {
+		topics[topic] = subscribedAt
+	}
+
+	return
+}
+
+func (f *fakeSubscribedTopicStore) PutSubscribedTopic(topic string, subscribedAt time.Time) (err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.topics[topic] = subscribedAt
+
+	return
+}
+
+func (f *fakeSubscribedTopicStore) DeleteSubscribedTopic(topic string) (err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.topics, topic)
+
+	return
+}
+
+func (f *fakeSubscribedTopicStore) list() (topics []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for topic := range f.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	return
+}
+
+type fakeTopicResolver struct {
+	topics map[string]string
+	calls  int
+	lock   sync.Mutex
+}
+
+func (f *fakeTopicResolver) ResolveTopic(ctx context.Context, input string) (topic string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls++
+	topic, ok := f.topics[input]
+	if !ok {
+		err = errors.New("channel not found")
+	}
+
+	return
+}
+
 func TestSubscriber(t *testing.T) {
 	wrongTopic := "wrongtopic"
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -47,10 +130,10 @@ func TestSubscriber(t *testing.T) {
 		customHTTPClient := &http.Client{}
 		s.SetHTTPClient(customHTTPClient)
 
-		ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
 		defer cancel()
 
-		// do this twice because we have two topics
+		// do this twice because we have two topics, both are subscribed on startup
 		logger.EXPECT().Infof(
 			gomock.AssignableToTypeOf("resubscribed"),
 			gomock.AssignableToTypeOf("topic"),
@@ -77,6 +160,7 @@ func TestSubscriber(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.TODO(), 150*time.Millisecond)
 		defer cancel()
 
+		// the failed topic is not retried before DefaultRetryInterval even though resubInterval passed
 		logger.EXPECT().Errorf(
 			gomock.AssignableToTypeOf("failed"),
 			gomock.Any(),
@@ -85,4 +169,308 @@ func TestSubscriber(t *testing.T) {
 		err := s.Subscribe(ctx)
 		require.Error(t, err)
 	})
+
+	t.Run("Resubscribe and Topics", func(t *testing.T) {
+		topics := []string{"mytopic", wrongTopic}
+		s := New(logger, verificationToken, secret, targetAddr, callbackAddr, topics, resubInterval)
+		require.NotNil(t, s)
+
+		status := s.Topics()
+		require.Len(t, status, 2)
+		require.Equal(t, "mytopic", status[0].Topic)
+		require.True(t, status[0].SubscribedAt.IsZero())
+
+		logger.EXPECT().Infof(gomock.Any(), "mytopic", callbackAddr)
+
+		err := s.Resubscribe()
+		require.Error(t, err)
+
+		status = s.Topics()
+		require.Equal(t, "mytopic", status[0].Topic)
+		require.False(t, status[0].SubscribedAt.IsZero())
+		require.Empty(t, status[0].LastError)
+		require.Equal(t, wrongTopic, status[1].Topic)
+		require.True(t, status[1].SubscribedAt.IsZero())
+		require.False(t, status[1].LastAttemptAt.IsZero())
+		require.Contains(t, status[1].LastError, "HTTP status 400")
+	})
+
+	t.Run("Topic source", func(t *testing.T) {
+		s := New(logger, verificationToken, secret, targetAddr, callbackAddr, topics, time.Hour)
+		require.NotNil(t, s)
+
+		source := &fakeTopicSource{enabled: []string{"newtopic", "mytopic"}, disabled: []string{"yourtopic"}}
+		s.SetTopicSource(source)
+		desired, complete := s.desiredTopics()
+		require.Equal(t, []string{"mytopic", "newtopic"}, desired)
+		require.True(t, complete)
+
+		// the static topics are used if the source fails
+		source.err = errors.New("expected error")
+		logger.EXPECT().Errorf(gomock.Any(), source.err)
+		desired, complete = s.desiredTopics()
+		require.Equal(t, topics, desired)
+		require.False(t, complete)
+		source.err = nil
+
+		// the desired topics are subscribed on startup
+		subscribed := make(chan string, 2)
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), callbackAddr).Do(func(format string, args ...interface{}) {
+			subscribed <- args[0].(string)
+		}).Times(2)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			require.NoError(t, s.Subscribe(ctx))
+		}()
+
+		require.Equal(t, "mytopic", <-subscribed)
+		require.Equal(t, "newtopic", <-subscribed)
+
+		// refreshing only subscribes the topics that were never subscribed
+		source.lock.Lock()
+		source.enabled = append(source.enabled, "latertopic")
+		source.lock.Unlock()
+		logger.EXPECT().Infof(gomock.Any(), "latertopic", callbackAddr).Do(func(format string, args ...interface{}) {
+			subscribed <- args[0].(string)
+		})
+		s.Refresh()
+		require.Equal(t, "latertopic", <-subscribed)
+
+		cancel()
+		<-done
+	})
+
+	t.Run("Lease", func(t *testing.T) {
+		s := New(logger, verificationToken, secret, targetAddr, callbackAddr, topics, time.Hour)
+		require.NotNil(t, s)
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), callbackAddr).Times(2)
+		require.NoError(t, s.Resubscribe())
+
+		require.True(t, s.Verify("subscribe", "mytopic", 100))
+		// verifications of requests that were not made are rejected
+		require.False(t, s.Verify("unsubscribe", "yourtopic", 100))
+		require.False(t, s.Verify("subscribe", "othertopic", 100))
+
+		status := s.Topics()
+		require.Equal(t, 100, status[0].LeaseSeconds)
+		require.Equal(t, status[0].VerifiedAt.Add(100*time.Second), status[0].LeaseExpiresAt)
+		require.Equal(t, status[0].LeaseExpiresAt.Add(-10*time.Second), status[0].NextSubscribeAt)
+		require.Equal(t, TopicStateVerified, status[0].State)
+		// the pending subscription is retried if the verification never arrives
+		require.Equal(t, TopicStatePending, status[1].State)
+		require.Zero(t, status[1].LeaseSeconds)
+		require.Equal(t, status[1].LastAttemptAt.Add(DefaultRetryInterval), status[1].NextSubscribeAt)
+
+		now := time.Now()
+		subscribe, unsubscribe, next := s.plan(now)
+		require.Empty(t, subscribe)
+		require.Empty(t, unsubscribe)
+		require.Equal(t, status[0].NextSubscribeAt, next)
+		subscribe, _, _ = s.plan(now.Add(95 * time.Second))
+		require.Equal(t, []string{"mytopic"}, subscribe)
+		subscribe, _, _ = s.plan(now.Add(2 * time.Hour))
+		require.Equal(t, topics, subscribe)
+	})
+
+	t.Run("Renew before lease expires", func(t *testing.T) {
+		var s *Subscriber
+		hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := r.ParseForm()
+			if err != nil {
+				panic(err)
+			}
+
+			// verify synchronously like the hub does with a lease of one second
+			require.True(t, s.Verify(r.Form.Get(HubMode), r.Form.Get(HubTopic), 1))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer hub.Close()
+
+		s = New(logger, verificationToken, secret, hub.URL, callbackAddr, []string{"mytopic"}, time.Hour)
+		require.NotNil(t, s)
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 1300*time.Millisecond)
+		defer cancel()
+
+		// on startup and once more before the lease expires instead of after resubInterval
+		logger.EXPECT().Infof(gomock.Any(), "mytopic", callbackAddr).Times(2)
+
+		err := s.Subscribe(ctx)
+		require.NoError(t, err)
+	})
+	t.Run("Reconcile", func(t *testing.T) {
+		var s *Subscriber
+		requests := make(chan string, 8)
+		hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := r.ParseForm()
+			if err != nil {
+				panic(err)
+			}
+
+			requests <- r.Form.Get(HubMode) + " " + r.Form.Get(HubTopic)
+			require.True(t, s.Verify(r.Form.Get(HubMode), r.Form.Get(HubTopic), 0))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer hub.Close()
+
+		s = New(logger, verificationToken, secret, hub.URL, callbackAddr, []string{"mytopic"}, time.Hour)
+		require.NotNil(t, s)
+		source := &fakeTopicSource{enabled: []string{"newtopic"}}
+		s.SetTopicSource(source)
+		// removedtopic was subscribed before a restart and is no longer desired
+		store := &fakeSubscribedTopicStore{topics: map[string]time.Time{"removedtopic": time.Now()}}
+		s.SetSubscribedTopicStore(store)
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), callbackAddr).Times(4)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			require.NoError(t, s.Subscribe(ctx))
+		}()
+
+		require.Equal(t, "subscribe mytopic", <-requests)
+		require.Equal(t, "subscribe newtopic", <-requests)
+		require.Equal(t, "unsubscribe removedtopic", <-requests)
+
+		// disabling a topic unsubscribes it
+		source.lock.Lock()
+		source.enabled = nil
+		source.disabled = []string{"newtopic"}
+		source.lock.Unlock()
+		s.Refresh()
+		require.Equal(t, "unsubscribe newtopic", <-requests)
+
+		cancel()
+		<-done
+
+		require.Equal(t, []string{"mytopic"}, store.list())
+		status := s.Topics()
+		require.Len(t, status, 1)
+		require.Equal(t, HubModeSubscribe, status[0].Mode)
+		require.Equal(t, TopicStateVerified, status[0].State)
+	})
+	t.Run("Request that timed out stays pending until the hub verifies it", func(t *testing.T) {
+		release := make(chan struct{})
+		hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer hub.Close()
+		defer close(release)
+
+		s := New(logger, verificationToken, secret, hub.URL, callbackAddr, []string{"mytopic"}, time.Hour)
+		require.NotNil(t, s)
+		s.SetHTTPClient(&http.Client{Timeout: 10 * time.Millisecond})
+		store := &fakeSubscribedTopicStore{topics: map[string]time.Time{}}
+		s.SetSubscribedTopicStore(store)
+
+		require.Error(t, s.Resubscribe())
+		status := s.Topics()
+		require.Equal(t, TopicStatePending, status[0].State)
+		require.NotEmpty(t, status[0].LastError)
+		require.True(t, status[0].SubscribedAt.IsZero())
+		require.Equal(t, status[0].LastAttemptAt.Add(DefaultRetryInterval), status[0].NextSubscribeAt)
+
+		// the hub verifies after the request timed out
+		require.True(t, s.Verify(HubModeSubscribe, "mytopic", 100))
+		status = s.Topics()
+		require.Equal(t, TopicStateVerified, status[0].State)
+		require.Empty(t, status[0].LastError)
+		require.False(t, status[0].SubscribedAt.IsZero())
+		require.Equal(t, []string{"mytopic"}, store.list())
+	})
+
+	t.Run("Static topics that fail to resolve are retried and keep subscribed topics", func(t *testing.T) {
+		s := New(logger, verificationToken, secret, targetAddr, callbackAddr, []string{"@myhandle", "@otherhandle"}, 10*time.Minute)
+		require.NotNil(t, s)
+		resolver := &fakeTopicResolver{topics: map[string]string{"@myhandle": "mytopic"}}
+		s.SetTopicResolver(resolver)
+		// othertopic was subscribed before a restart, it may be what @otherhandle refers to
+		store := &fakeSubscribedTopicStore{topics: map[string]time.Time{"othertopic": time.Now()}}
+		s.SetSubscribedTopicStore(store)
+		s.loadSubscribedTopics()
+
+		logger.EXPECT().Errorf(gomock.Any(), "@otherhandle", gomock.Any())
+		s.resolveStaticTopics(context.TODO())
+		require.Equal(t, 2, resolver.calls)
+
+		now := time.Now()
+		subscribe, unsubscribe, next := s.plan(now)
+		require.Equal(t, []string{"mytopic"}, subscribe)
+		require.Empty(t, unsubscribe)
+		require.Equal(t, now, next)
+
+		// failed ones are not attempted again before the retry interval
+		s.resolveStaticTopics(context.TODO())
+		require.Equal(t, 2, resolver.calls)
+
+		resolver.lock.Lock()
+		resolver.topics["@otherhandle"] = "othertopic"
+		resolver.lock.Unlock()
+		s.resolveAttemptAt = time.Now().Add(-10 * time.Minute)
+		s.resolveStaticTopics(context.TODO())
+		require.Equal(t, 3, resolver.calls)
+
+		subscribe, unsubscribe, _ = s.plan(time.Now())
+		require.Equal(t, []string{"mytopic", "othertopic"}, subscribe)
+		require.Empty(t, unsubscribe)
+	})
+
+	t.Run("Async verification", func(t *testing.T) {
+		verify := make(chan string, 2)
+		hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := r.ParseForm()
+			if err != nil {
+				panic(err)
+			}
+
+			verify <- r.Form.Get(HubVerify)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer hub.Close()
+
+		s := New(logger, verificationToken, secret, hub.URL, callbackAddr, topics, time.Hour)
+		require.NotNil(t, s)
+		s.SetHubVerify(HubVerifyAsync)
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), callbackAddr).Times(2)
+		require.NoError(t, s.Resubscribe())
+		require.Equal(t, HubVerifyAsync, <-verify)
+
+		status := s.Topics()
+		require.Equal(t, TopicStatePending, status[0].State)
+		require.Equal(t, TopicStatePending, status[1].State)
+
+		// the hub verifies later
+		require.True(t, s.Verify("subscribe", "mytopic", 100))
+		require.False(t, s.Verify("unsubscribe", "mytopic", 0))
+		require.True(t, s.Denied("yourtopic", "publisher refused"))
+		require.False(t, s.Denied("othertopic", "unknown"))
+
+		status = s.Topics()
+		require.Len(t, status, 2)
+		require.Equal(t, TopicStateVerified, status[0].State)
+		require.Equal(t, TopicStateDenied, status[1].State)
+		require.Contains(t, status[1].LastError, "publisher refused")
+		require.Equal(t, status[1].LastAttemptAt.Add(time.Hour), status[1].NextSubscribeAt)
+
+		// a denied topic is only denied again once it was subscribed again
+		require.False(t, s.Denied("yourtopic", "publisher refused"))
+
+		// a topic being unsubscribed is kept until the hub verified the unsubscription
+		s.status["yourtopic"].Mode = HubModeUnsubscribe
+		s.status["yourtopic"].State = TopicStatePending
+		require.False(t, s.Denied("yourtopic", "publisher refused"))
+		status = s.Topics()
+		require.Len(t, status, 2)
+		require.Equal(t, HubModeUnsubscribe, status[1].Mode)
+		require.Equal(t, TopicStatePending, status[1].State)
+	})
 }
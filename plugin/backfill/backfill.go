@@ -0,0 +1,517 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/rss"
+	"go.etcd.io/bbolt"
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	DefaultFilePermission      = 0666
+	DefaultDatabaseOpenTimeout = time.Second
+	DefaultBucketName          = "ytfeed-backfill"
+	// DefaultInterval is the least time between two videos pushed to the data handlers
+	DefaultInterval    = 30 * time.Second
+	DefaultRetryDelay  = time.Minute
+	DefaultMaxAttempts = 5
+	DefaultPageSize    = 50
+
+	JobStateRunning   = "running"
+	JobStateDone      = "done"
+	JobStateFailed    = "failed"
+	JobStateCancelled = "cancelled"
+
+	// PublishedLayout is how the hub formats the published time of entries
+	PublishedLayout = "2006-01-02T15:04:05-07:00"
+	// UpdatedLayout is how the hub formats the updated time of feeds and entries
+	UpdatedLayout = "2006-01-02T15:04:05.999999999-07:00"
+
+	YoutubeWatchURLPrefix   = "https://www.youtube.com/watch?v="
+	YoutubeChannelURLPrefix = "https://www.youtube.com/channel/"
+)
+
+var (
+	ErrJobNotFound     = errors.New("backfill job not found")
+	ErrJobRunning      = errors.New("backfill of the channel is already running")
+	ErrChannelNotFound = errors.New("youtube channel not found")
+	ErrInvalidRange    = errors.New("published after must be before published before")
+	// ErrDispatchBusy is returned by a DispatchFunc whose data handlers can't take more data right now
+	ErrDispatchBusy = errors.New("data handlers are busy")
+
+	errJobStopped = errors.New("backfill job is no longer running")
+)
+
+type Databaser interface {
+	Close() error
+	Update(func(tx *bbolt.Tx) error) error
+	View(func(tx *bbolt.Tx) error) error
+}
+
+type YoutubeChannelLister interface {
+	List(part []string) *youtube.ChannelsListCall
+}
+
+type YoutubePlaylistItemLister interface {
+	List(part []string) *youtube.PlaylistItemsListCall
+}
+
+// DispatchFunc pushes the data to the data handlers the way a hub notification would be,
+// it returns ErrDispatchBusy to have the data dispatched again later without failing an attempt
+type DispatchFunc func(ctx context.Context, data *ytfeed.Data) error
+
+// Job is the backfill of the uploads of a channel, PageToken and ItemIndex are where it resumes after a restart.
+// Zero PublishedAfter or PublishedBefore leave that end of the range open.
+type Job struct {
+	ChannelID         string    `json:"channel_id"`
+	ChannelTitle      string    `json:"channel_title,omitempty"`
+	UploadsPlaylistID string    `json:"uploads_playlist_id,omitempty"`
+	PublishedAfter    time.Time `json:"published_after,omitempty"`
+	PublishedBefore   time.Time `json:"published_before,omitempty"`
+	PageToken         string    `json:"page_token,omitempty"`
+	ItemIndex         int       `json:"item_index"`
+	Dispatched        int       `json:"dispatched"`
+	Skipped           int       `json:"skipped"`
+	State             string    `json:"state"`
+	Attempts          int       `json:"attempts"`
+	LastError         string    `json:"last_error,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Backfill pushes the existing uploads of channels through the data handlers, one video at a time
+type Backfill struct {
+	logger       ytfeed.Logger
+	database     Databaser
+	cs           YoutubeChannelLister
+	pis          YoutubePlaylistItemLister
+	dispatch     DispatchFunc
+	interval     time.Duration
+	retryDelay   time.Duration
+	maxAttempts  int
+	lastDispatch time.Time
+	wakeup       chan struct{}
+}
+
+// SetInterval because rate limiting is optional, it doesn't have to be present at constructor function
+func (b *Backfill) SetInterval(interval time.Duration) {
+	b.interval = interval
+}
+
+// SetRetryPolicy because retrying is optional, it doesn't have to be present at constructor function
+func (b *Backfill) SetRetryPolicy(maxAttempts int, retryDelay time.Duration) {
+	b.maxAttempts = maxAttempts
+	b.retryDelay = retryDelay
+}
+
+// Start adds a backfill job of the channel, a finished job of the same channel is replaced
+func (b *Backfill) Start(channelID string, publishedAfter, publishedBefore time.Time) (job Job, err error) {
+	if !publishedAfter.IsZero() && !publishedBefore.IsZero() && !publishedAfter.Before(publishedBefore) {
+		err = ErrInvalidRange
+		return
+	}
+
+	now := time.Now()
+	job = Job{
+		ChannelID:       channelID,
+		PublishedAfter:  publishedAfter,
+		PublishedBefore: publishedBefore,
+		State:           JobStateRunning,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	err = b.database.Update(func(tx *bbolt.Tx) (err error) {
+		existing, err := getJob(tx, channelID)
+		if err == nil && existing.State == JobStateRunning {
+			return ErrJobRunning
+		}
+		if err != nil && err != ErrJobNotFound {
+			return
+		}
+
+		return putJob(tx, job)
+	})
+	if err != nil {
+		return
+	}
+
+	select {
+	case b.wakeup <- struct{}{}:
+	default:
+	}
+
+	return
+}
+
+// Cancel stops the running backfill of the channel after the video being dispatched
+func (b *Backfill) Cancel(channelID string) (job Job, err error) {
+	err = b.database.Update(func(tx *bbolt.Tx) (err error) {
+		job, err = getJob(tx, channelID)
+		if err != nil || job.State != JobStateRunning {
+			return
+		}
+		job.State = JobStateCancelled
+		job.UpdatedAt = time.Now()
+
+		return putJob(tx, job)
+	})
+
+	return
+}
+
+// Jobs returns every backfill job ordered by channel ID
+func (b *Backfill) Jobs() (jobs []Job, err error) {
+	jobs = make([]Job, 0, 4)
+	err = b.database.View(func(tx *bbolt.Tx) (err error) {
+		return tx.Bucket([]byte(DefaultBucketName)).ForEach(func(k, v []byte) (err error) {
+			job := Job{}
+			err = json.Unmarshal(v, &job)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal backfill job json with key %s", string(k))
+				return
+			}
+			jobs = append(jobs, job)
+
+			return
+		})
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to list backfill jobs")
+		return
+	}
+
+	return
+}
+
+// Run works on the running jobs one after another, including the ones left from a previous run
+func (b *Backfill) Run(ctx context.Context) (err error) {
+	for {
+		var jobs []Job
+		jobs, err = b.Jobs()
+		if err != nil {
+			b.logger.Errorf("Failed to load backfill jobs: %v", err)
+		}
+
+		for _, job := range jobs {
+			if job.State != JobStateRunning {
+				continue
+			}
+
+			err = b.run(ctx, job)
+			if ctx.Err() != nil {
+				err = nil
+				return
+			}
+			if err == errJobStopped {
+				b.logger.Infof("Backfill of channel %s stopped", job.ChannelID)
+			} else if err != nil {
+				b.logger.Errorf("Backfill of channel %s failed: %v", job.ChannelID, err)
+			}
+		}
+
+		select {
+		case <-b.wakeup:
+		case <-ctx.Done():
+			err = nil
+			return
+		}
+	}
+}
+
+// run pages through the uploads of the channel, the progress is saved after every video so a restart resumes after the last one dispatched
+func (b *Backfill) run(ctx context.Context, job Job) (err error) {
+	if job.UploadsPlaylistID == "" {
+		err = b.retry(ctx, &job, func() error { return b.lookupUploads(ctx, &job) })
+		if err != nil {
+			return
+		}
+		err = b.save(&job)
+		if err != nil {
+			return
+		}
+	}
+
+	for {
+		var resp *youtube.PlaylistItemListResponse
+		err = b.retry(ctx, &job, func() (err error) {
+			call := b.pis.List([]string{"snippet", "contentDetails"}).PlaylistId(job.UploadsPlaylistID).MaxResults(DefaultPageSize).Context(ctx)
+			if job.PageToken != "" {
+				call = call.PageToken(job.PageToken)
+			}
+			resp, err = call.Do()
+			if err != nil {
+				err = errors.Wrapf(err, "failed to list uploads of channel %s", job.ChannelID)
+			}
+
+			return
+		})
+		if err != nil {
+			return
+		}
+
+		for job.ItemIndex < len(resp.Items) {
+			var data *ytfeed.Data
+			data, err = b.newData(job, resp.Items[job.ItemIndex])
+			if err != nil {
+				return b.fail(&job, err)
+			}
+			if data == nil {
+				job.Skipped++
+				job.ItemIndex++
+				continue
+			}
+
+			err = b.retry(ctx, &job, func() error { return b.dispatchWhenReady(ctx, &job, data) })
+			if err != nil {
+				return
+			}
+			job.Dispatched++
+			job.ItemIndex++
+			err = b.save(&job)
+			if err != nil {
+				return
+			}
+		}
+
+		job.PageToken = resp.NextPageToken
+		job.ItemIndex = 0
+		if job.PageToken == "" {
+			job.State = JobStateDone
+		}
+		err = b.save(&job)
+		if err != nil || job.State == JobStateDone {
+			return
+		}
+	}
+}
+
+func (b *Backfill) lookupUploads(ctx context.Context, job *Job) (err error) {
+	resp, err := b.cs.List([]string{"snippet", "contentDetails"}).Id(job.ChannelID).Context(ctx).Do()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get uploads playlist of channel %s", job.ChannelID)
+		return
+	}
+	if len(resp.Items) == 0 || resp.Items[0].ContentDetails == nil || resp.Items[0].ContentDetails.RelatedPlaylists == nil {
+		err = b.fail(job, ErrChannelNotFound)
+		return
+	}
+
+	job.UploadsPlaylistID = resp.Items[0].ContentDetails.RelatedPlaylists.Uploads
+	if resp.Items[0].Snippet != nil {
+		job.ChannelTitle = resp.Items[0].Snippet.Title
+	}
+
+	return
+}
+
+// newData returns the data of the video as the hub would notify it, data is nil if the video is skipped.
+// Videos outside of the range are skipped one by one because the uploads are not strictly listed newest first.
+func (b *Backfill) newData(job Job, item *youtube.PlaylistItem) (data *ytfeed.Data, err error) {
+	// private and deleted videos have no publish time
+	if item.ContentDetails == nil || item.ContentDetails.VideoPublishedAt == "" {
+		return
+	}
+	published, err := time.Parse(time.RFC3339, item.ContentDetails.VideoPublishedAt)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse publish time of video %s", item.ContentDetails.VideoId)
+		return
+	}
+	if !job.PublishedAfter.IsZero() && published.Before(job.PublishedAfter) {
+		return
+	}
+	if !job.PublishedBefore.IsZero() && !published.Before(job.PublishedBefore) {
+		return
+	}
+
+	entry := ytfeed.Entry{
+		ID:        "yt:video:" + item.ContentDetails.VideoId,
+		VideoID:   item.ContentDetails.VideoId,
+		ChannelID: job.ChannelID,
+		Link:      ytfeed.Link{Rel: "alternate", Href: YoutubeWatchURLPrefix + item.ContentDetails.VideoId},
+		Author:    ytfeed.Author{Name: job.ChannelTitle, URI: YoutubeChannelURLPrefix + job.ChannelID},
+		Published: published.UTC().Format(PublishedLayout),
+		Updated:   time.Now().UTC().Format(UpdatedLayout),
+	}
+	if item.Snippet != nil {
+		entry.Title = item.Snippet.Title
+	}
+
+	data, err = rss.NewEntryData(entry)
+
+	return
+}
+
+// retry calls f until it succeeds, the job fails after max attempts
+func (b *Backfill) retry(ctx context.Context, job *Job, f func() error) (err error) {
+	for {
+		err = f()
+		if err == nil {
+			job.Attempts = 0
+			return
+		}
+		if err == errJobStopped || job.State == JobStateFailed || ctx.Err() != nil {
+			return
+		}
+
+		job.Attempts++
+		job.LastError = err.Error()
+		if job.Attempts >= b.maxAttempts {
+			return b.fail(job, err)
+		}
+		b.logger.Warnf("Backfill of channel %s failed attempt %d of %d, retrying in %v: %v", job.ChannelID, job.Attempts, b.maxAttempts, b.retryDelay, err)
+
+		err = b.save(job)
+		if err != nil {
+			return
+		}
+		select {
+		case <-time.After(b.retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// dispatchWhenReady dispatches the data once the interval passed, busy data handlers are waited for
+// retry delay at a time without failing an attempt. The job is saved before the data is dispatched again
+// so it stops with errJobStopped once it was cancelled meanwhile.
+func (b *Backfill) dispatchWhenReady(ctx context.Context, job *Job, data *ytfeed.Data) (err error) {
+	for {
+		err = b.wait(ctx)
+		if err != nil {
+			return
+		}
+		b.lastDispatch = time.Now()
+		err = b.dispatch(ctx, data)
+		if err != ErrDispatchBusy {
+			break
+		}
+
+		select {
+		case <-time.After(b.retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err = b.save(job)
+		if err != nil {
+			return
+		}
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to dispatch video %s", data.Feed.Entry.VideoID)
+	}
+
+	return
+}
+
+// wait blocks until the interval since the last dispatched video passed
+func (b *Backfill) wait(ctx context.Context) (err error) {
+	delay := time.Until(b.lastDispatch.Add(b.interval))
+	if delay <= 0 {
+		return
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+func (b *Backfill) fail(job *Job, cause error) (err error) {
+	job.State = JobStateFailed
+	job.LastError = cause.Error()
+	err = b.save(job)
+	if err != nil {
+		return
+	}
+
+	return cause
+}
+
+// save stores the progress of the job, it returns errJobStopped if the job was cancelled or replaced meanwhile
+func (b *Backfill) save(job *Job) (err error) {
+	job.UpdatedAt = time.Now()
+	err = b.database.Update(func(tx *bbolt.Tx) (err error) {
+		stored, err := getJob(tx, job.ChannelID)
+		if err != nil {
+			return
+		}
+		if stored.State != JobStateRunning || !stored.CreatedAt.Equal(job.CreatedAt) {
+			return errJobStopped
+		}
+
+		return putJob(tx, *job)
+	})
+	if err == ErrJobNotFound {
+		err = errJobStopped
+	}
+
+	return
+}
+
+func getJob(tx *bbolt.Tx, channelID string) (job Job, err error) {
+	v := tx.Bucket([]byte(DefaultBucketName)).Get([]byte(channelID))
+	if v == nil {
+		err = ErrJobNotFound
+		return
+	}
+
+	err = json.Unmarshal(v, &job)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to unmarshal backfill job json with key %s", channelID)
+	}
+
+	return
+}
+
+func putJob(tx *bbolt.Tx, job Job) (err error) {
+	rawData, err := json.Marshal(job)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to json marshal backfill job of channel %s", job.ChannelID)
+		return
+	}
+
+	return tx.Bucket([]byte(DefaultBucketName)).Put([]byte(job.ChannelID), rawData)
+}
+
+func (b *Backfill) CloseDatabase() (err error) {
+	return b.database.Close()
+}
+
+func New(logger ytfeed.Logger, databasePath string, cs YoutubeChannelLister, pis YoutubePlaylistItemLister, dispatch DispatchFunc) (b *Backfill, err error) {
+	b = &Backfill{}
+	b.logger = logger
+	b.cs = cs
+	b.pis = pis
+	b.dispatch = dispatch
+	b.interval = DefaultInterval
+	b.retryDelay = DefaultRetryDelay
+	b.maxAttempts = DefaultMaxAttempts
+	b.wakeup = make(chan struct{}, 1)
+	b.database, err = bbolt.Open(databasePath, DefaultFilePermission, &bbolt.Options{Timeout: DefaultDatabaseOpenTimeout})
+	if err != nil {
+		return
+	}
+
+	err = b.database.Update(func(tx *bbolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists([]byte(DefaultBucketName))
+		return
+	})
+	if err != nil {
+		_ = b.database.Close()
+		b = nil
+		err = errors.Wrap(err, "failed to load backfill jobs")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,319 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+	"go.etcd.io/bbolt"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	channelID       = "UCuAXFkgsw1L7xaCfnd5JJOw"
+	brokenChannelID = "UCbroken"
+	uploadsID       = "UUuAXFkgsw1L7xaCfnd5JJOw"
+)
+
+type recorder struct {
+	data      []*ytfeed.Data
+	times     []time.Time
+	busy      int
+	busyCalls int
+	lock      sync.Mutex
+}
+
+func (r *recorder) dispatch(ctx context.Context, data *ytfeed.Data) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.busy > 0 {
+		r.busy--
+		r.busyCalls++
+		return ErrDispatchBusy
+	}
+	r.data = append(r.data, data)
+	r.times = append(r.times, time.Now())
+
+	return nil
+}
+
+func (r *recorder) videoIDs() (ids []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ids = make([]string, 0, len(r.data))
+	for _, d := range r.data {
+		ids = append(ids, d.Feed.Entry.VideoID)
+	}
+
+	return
+}
+
+func TestBackfill(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/channels") && query.Get("id") == channelID:
+			fmt.Fprintf(w, `{"items":[{"id":"%s","snippet":{"title":"My Channel"},"contentDetails":{"relatedPlaylists":{"uploads":"%s"}}}]}`, channelID, uploadsID)
+		case strings.HasSuffix(req.URL.Path, "/channels") && query.Get("id") == brokenChannelID:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":{"code":500,"message":"broken"}}`)
+		case strings.HasSuffix(req.URL.Path, "/playlistItems") && query.Get("pageToken") == "":
+			fmt.Fprint(w, `{"nextPageToken":"p2","items":[
+				{"snippet":{"title":"Video 3"},"contentDetails":{"videoId":"v3","videoPublishedAt":"2020-03-01T00:00:00Z"}},
+				{"snippet":{"title":"Private video"},"contentDetails":{"videoId":"private"}},
+				{"snippet":{"title":"Video 2 & more"},"contentDetails":{"videoId":"v2","videoPublishedAt":"2020-02-01T10:12:08Z"}}]}`)
+		case strings.HasSuffix(req.URL.Path, "/playlistItems") && query.Get("pageToken") == "p2":
+			fmt.Fprint(w, `{"items":[
+				{"snippet":{"title":"Video 1"},"contentDetails":{"videoId":"v1","videoPublishedAt":"2020-01-01T00:00:00Z"}},
+				{"snippet":{"title":"Video 0"},"contentDetails":{"videoId":"v0","videoPublishedAt":"2019-12-01T00:00:00Z"}},
+				{"snippet":{"title":"Video 1 listed late"},"contentDetails":{"videoId":"v1late","videoPublishedAt":"2020-01-15T00:00:00Z"}}]}`)
+		default:
+			fmt.Fprint(w, `{"items":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	yts, err := youtube.NewService(context.TODO(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "ytfeed-backfill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	newBackfill := func(t *testing.T, name string, r *recorder) *Backfill {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		logger := mock.NewMockLogger(ctrl)
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Warnf(gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Errorf(gomock.Any(), gomock.Any()).AnyTimes()
+
+		b, err := New(logger, filepath.Join(dir, name), yts.Channels, yts.PlaylistItems, r.dispatch)
+		require.NoError(t, err)
+		require.NotNil(t, b)
+		b.SetInterval(0)
+		b.SetRetryPolicy(2, time.Millisecond)
+
+		return b
+	}
+
+	run := func(b *Backfill) (stop func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = b.Run(ctx)
+		}()
+
+		return func() {
+			cancel()
+			<-done
+		}
+	}
+
+	jobState := func(b *Backfill, channelID string) func() bool {
+		return func() bool {
+			jobs, err := b.Jobs()
+			if err != nil {
+				return false
+			}
+			for _, job := range jobs {
+				if job.ChannelID == channelID {
+					return job.State != JobStateRunning
+				}
+			}
+			return false
+		}
+	}
+
+	t.Run("Run dispatches uploads in range", func(t *testing.T) {
+		r := &recorder{}
+		b := newBackfill(t, "range.db", r)
+		defer b.CloseDatabase()
+		b.SetInterval(20 * time.Millisecond)
+
+		after := time.Date(2019, 12, 15, 0, 0, 0, 0, time.UTC)
+		before := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+		_, err := b.Start(channelID, after, before)
+		require.NoError(t, err)
+
+		stop := run(b)
+		defer stop()
+		require.Eventually(t, jobState(b, channelID), 5*time.Second, 10*time.Millisecond)
+
+		// older videos don't end the backfill because uploads are not strictly listed newest first
+		require.Equal(t, []string{"v2", "v1", "v1late"}, r.videoIDs())
+		require.True(t, r.times[1].Sub(r.times[0]) >= 20*time.Millisecond)
+
+		entry := r.data[0].Feed.Entry
+		require.Equal(t, "yt:video:v2", entry.ID)
+		require.Equal(t, channelID, entry.ChannelID)
+		require.Equal(t, "Video 2 & more", entry.Title)
+		require.Equal(t, "2020-02-01T10:12:08+00:00", entry.Published)
+		require.Equal(t, ytfeed.Author{Name: "My Channel", URI: YoutubeChannelURLPrefix + channelID}, entry.Author)
+		require.Equal(t, ytfeed.Link{Rel: "alternate", Href: YoutubeWatchURLPrefix + "v2"}, entry.Link)
+		require.Contains(t, r.data[0].OriginalXMLMessage, "<yt:videoId>v2</yt:videoId>")
+
+		jobs, err := b.Jobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, JobStateDone, jobs[0].State)
+		require.Equal(t, 3, jobs[0].Dispatched)
+		require.Equal(t, 3, jobs[0].Skipped)
+		require.Equal(t, uploadsID, jobs[0].UploadsPlaylistID)
+	})
+
+	t.Run("Run resumes saved progress", func(t *testing.T) {
+		r := &recorder{}
+		b := newBackfill(t, "resume.db", r)
+		defer b.CloseDatabase()
+
+		// a previous run dispatched the first video of the second page
+		err := b.database.Update(func(tx *bbolt.Tx) error {
+			return putJob(tx, Job{
+				ChannelID:         channelID,
+				ChannelTitle:      "My Channel",
+				UploadsPlaylistID: uploadsID,
+				PageToken:         "p2",
+				ItemIndex:         1,
+				Dispatched:        3,
+				State:             JobStateRunning,
+			})
+		})
+		require.NoError(t, err)
+
+		stop := run(b)
+		defer stop()
+		require.Eventually(t, jobState(b, channelID), 5*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, []string{"v0", "v1late"}, r.videoIDs())
+		jobs, err := b.Jobs()
+		require.NoError(t, err)
+		require.Equal(t, JobStateDone, jobs[0].State)
+		require.Equal(t, 5, jobs[0].Dispatched)
+	})
+
+	t.Run("Run waits for busy data handlers without failing", func(t *testing.T) {
+		// busy more often than the max attempts
+		r := &recorder{busy: 3}
+		b := newBackfill(t, "busy.db", r)
+		defer b.CloseDatabase()
+
+		_, err := b.Start(channelID, time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC), time.Time{})
+		require.NoError(t, err)
+
+		stop := run(b)
+		defer stop()
+		require.Eventually(t, jobState(b, channelID), 5*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, []string{"v3", "v2", "v1late"}, r.videoIDs())
+		jobs, err := b.Jobs()
+		require.NoError(t, err)
+		require.Equal(t, JobStateDone, jobs[0].State)
+		require.Equal(t, 0, jobs[0].Attempts)
+		require.Empty(t, jobs[0].LastError)
+	})
+
+	t.Run("Cancel stops a job waiting for busy data handlers", func(t *testing.T) {
+		r := &recorder{busy: 1 << 30}
+		b := newBackfill(t, "busycancel.db", r)
+		defer b.CloseDatabase()
+
+		_, err := b.Start(channelID, time.Time{}, time.Time{})
+		require.NoError(t, err)
+
+		stop := run(b)
+		defer stop()
+		require.Eventually(t, func() bool {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+
+			return r.busyCalls > 0
+		}, 5*time.Second, time.Millisecond)
+
+		job, err := b.Cancel(channelID)
+		require.NoError(t, err)
+		require.Equal(t, JobStateCancelled, job.State)
+
+		// the data handlers are free again but the cancelled job dispatches nothing
+		r.lock.Lock()
+		r.busy = 0
+		r.lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		require.Empty(t, r.videoIDs())
+	})
+
+	t.Run("Run fails after max attempts", func(t *testing.T) {
+		r := &recorder{}
+		b := newBackfill(t, "failed.db", r)
+		defer b.CloseDatabase()
+
+		_, err := b.Start(brokenChannelID, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		_, err = b.Start("UCunknown", time.Time{}, time.Time{})
+		require.NoError(t, err)
+
+		stop := run(b)
+		defer stop()
+		require.Eventually(t, jobState(b, brokenChannelID), 5*time.Second, 10*time.Millisecond)
+		require.Eventually(t, jobState(b, "UCunknown"), 5*time.Second, 10*time.Millisecond)
+
+		jobs, err := b.Jobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 2)
+		require.Equal(t, JobStateFailed, jobs[0].State)
+		require.Equal(t, 2, jobs[0].Attempts)
+		require.Contains(t, jobs[0].LastError, "broken")
+		require.Equal(t, JobStateFailed, jobs[1].State)
+		require.Equal(t, ErrChannelNotFound.Error(), jobs[1].LastError)
+		require.Empty(t, r.videoIDs())
+	})
+
+	t.Run("Start and Cancel", func(t *testing.T) {
+		r := &recorder{}
+		b := newBackfill(t, "cancel.db", r)
+		defer b.CloseDatabase()
+
+		_, err := b.Start(channelID, time.Now(), time.Now().Add(-time.Hour))
+		require.Equal(t, ErrInvalidRange, err)
+
+		job, err := b.Start(channelID, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Equal(t, JobStateRunning, job.State)
+
+		_, err = b.Start(channelID, time.Time{}, time.Time{})
+		require.Equal(t, ErrJobRunning, err)
+
+		job, err = b.Cancel(channelID)
+		require.NoError(t, err)
+		require.Equal(t, JobStateCancelled, job.State)
+
+		_, err = b.Cancel("UCunknown")
+		require.Equal(t, ErrJobNotFound, err)
+
+		// a cancelled job is not run and can be started again
+		stop := run(b)
+		stop()
+		require.Empty(t, r.videoIDs())
+
+		job, err = b.Start(channelID, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Equal(t, JobStateRunning, job.State)
+	})
+}
@@ -0,0 +1,261 @@
+package channelresolver
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed/rss"
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	// DefaultSearchResults is how many channels a handle or custom name search compares against
+	DefaultSearchResults = 10
+
+	kindChannelID  = "channel"
+	kindHandle     = "handle"
+	kindUsername   = "username"
+	kindCustomName = "custom name"
+	kindVideo      = "video"
+	kindPlaylist   = "playlist"
+)
+
+var (
+	ErrUnsupportedInput = errors.New("input is not a youtube channel, handle, username, video or playlist")
+	ErrChannelNotFound  = errors.New("youtube channel not found")
+
+	channelIDPattern = regexp.MustCompile(`^UC[0-9A-Za-z_-]{22}$`)
+)
+
+type YoutubeChannelLister interface {
+	List(part []string) *youtube.ChannelsListCall
+}
+
+type YoutubeVideoLister interface {
+	List(part []string) *youtube.VideosListCall
+}
+
+type YoutubePlaylistLister interface {
+	List(part []string) *youtube.PlaylistsListCall
+}
+
+type YoutubeSearcher interface {
+	List(part []string) *youtube.SearchListCall
+}
+
+// Resolver turns the ways people refer to a channel into its channel ID
+type Resolver struct {
+	cs YoutubeChannelLister
+	vs YoutubeVideoLister
+	ps YoutubePlaylistLister
+	ss YoutubeSearcher
+}
+
+// ResolveTopic returns the subscription topic of the channel input refers to
+func (r *Resolver) ResolveTopic(ctx context.Context, input string) (topic string, err error) {
+	channelID, err := r.Resolve(ctx, input)
+	if err != nil {
+		return
+	}
+	topic = rss.YoutubeSubscriptionTopicPrefix + channelID
+
+	return
+}
+
+// ResolveTopics returns the subscription topics of the channels inputs refer to in the same order,
+// inputs that failed to resolve are skipped and returned in failed with their error
+func (r *Resolver) ResolveTopics(ctx context.Context, inputs []string) (topics []string, failed map[string]error) {
+	topics = make([]string, 0, len(inputs))
+	failed = make(map[string]error)
+	for _, input := range inputs {
+		topic, err := r.ResolveTopic(ctx, input)
+		if err != nil {
+			failed[input] = err
+			continue
+		}
+		topics = append(topics, topic)
+	}
+
+	return
+}
+
+// Resolve returns the channel ID input refers to. input can be a subscription topic, a channel ID, an @handle,
+// a channel, /user/, /c/ or @handle URL, a video URL or a playlist URL, the owner of the video or playlist is resolved then.
+func (r *Resolver) Resolve(ctx context.Context, input string) (channelID string, err error) {
+	kind, value, err := parse(input)
+	if err != nil {
+		return
+	}
+
+	switch kind {
+	case kindChannelID:
+		channelID = value
+	case kindUsername:
+		channelID, err = r.byUsername(ctx, value)
+	case kindHandle, kindCustomName:
+		channelID, err = r.byCustomURL(ctx, value)
+	case kindVideo:
+		channelID, err = r.byVideo(ctx, value)
+	case kindPlaylist:
+		channelID, err = r.byPlaylist(ctx, value)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to resolve %s %s", kind, value)
+		return
+	}
+
+	return
+}
+
+func (r *Resolver) byUsername(ctx context.Context, username string) (channelID string, err error) {
+	clresp, err := r.cs.List([]string{"id"}).ForUsername(username).Context(ctx).Do()
+	if err != nil {
+		return
+	}
+	if len(clresp.Items) < 1 {
+		err = ErrChannelNotFound
+		return
+	}
+	channelID = clresp.Items[0].Id
+
+	return
+}
+
+// byCustomURL searches channels by the handle or custom name and returns the one whose custom URL is exactly it.
+// The search costs 100 quota units and only the top DefaultSearchResults channels are compared, legacy custom names
+// that differ from the handle are never found.
+func (r *Resolver) byCustomURL(ctx context.Context, name string) (channelID string, err error) {
+	slresp, err := r.ss.List([]string{"snippet"}).Q(name).Type("channel").MaxResults(DefaultSearchResults).Context(ctx).Do()
+	if err != nil {
+		return
+	}
+
+	ids := make([]string, 0, len(slresp.Items))
+	for _, item := range slresp.Items {
+		if item.Snippet != nil && item.Snippet.ChannelId != "" {
+			ids = append(ids, item.Snippet.ChannelId)
+		}
+	}
+	if len(ids) < 1 {
+		err = errors.Wrapf(ErrChannelNotFound, "search for %s returned no channels", name)
+		return
+	}
+
+	clresp, err := r.cs.List([]string{"snippet"}).Id(ids...).Context(ctx).Do()
+	if err != nil {
+		return
+	}
+	name = strings.TrimPrefix(name, "@")
+	for _, item := range clresp.Items {
+		if item.Snippet != nil && strings.EqualFold(strings.TrimPrefix(item.Snippet.CustomUrl, "@"), name) {
+			channelID = item.Id
+			return
+		}
+	}
+	err = errors.Wrapf(ErrChannelNotFound, "no channel with custom URL %s among the top %d search results, use the channel ID or a video URL instead", name, DefaultSearchResults)
+
+	return
+}
+
+func (r *Resolver) byVideo(ctx context.Context, videoID string) (channelID string, err error) {
+	vlresp, err := r.vs.List([]string{"snippet"}).Id(videoID).Context(ctx).Do()
+	if err != nil {
+		return
+	}
+	if len(vlresp.Items) < 1 || vlresp.Items[0].Snippet == nil {
+		err = ErrChannelNotFound
+		return
+	}
+	channelID = vlresp.Items[0].Snippet.ChannelId
+
+	return
+}
+
+func (r *Resolver) byPlaylist(ctx context.Context, playlistID string) (channelID string, err error) {
+	plresp, err := r.ps.List([]string{"snippet"}).Id(playlistID).Context(ctx).Do()
+	if err != nil {
+		return
+	}
+	if len(plresp.Items) < 1 || plresp.Items[0].Snippet == nil {
+		err = ErrChannelNotFound
+		return
+	}
+	channelID = plresp.Items[0].Snippet.ChannelId
+
+	return
+}
+
+// parse returns what kind of reference input is and the value to resolve
+func parse(input string) (kind, value string, err error) {
+	input = strings.TrimSpace(input)
+	switch {
+	case rss.IsYoutubeSubscriptionTopic(input):
+		return kindChannelID, strings.TrimPrefix(input, rss.YoutubeSubscriptionTopicPrefix), nil
+	case channelIDPattern.MatchString(input):
+		return kindChannelID, input, nil
+	case strings.HasPrefix(input, "@") && len(input) > 1 && !strings.Contains(input, "/"):
+		return kindHandle, input, nil
+	}
+
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		err = errors.Wrap(ErrUnsupportedInput, err.Error())
+		return
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	second := ""
+	if len(segments) > 1 {
+		second = segments[1]
+	}
+	switch strings.TrimPrefix(u.Hostname(), "www.") {
+	case "youtu.be":
+		kind, value = kindVideo, segments[0]
+	case "youtube.com", "m.youtube.com", "music.youtube.com":
+		switch first := segments[0]; {
+		case strings.HasPrefix(first, "@"):
+			kind, value = kindHandle, first
+		case first == "channel":
+			if !channelIDPattern.MatchString(second) {
+				err = errors.Wrapf(ErrUnsupportedInput, "invalid channel ID %s", second)
+				return
+			}
+			kind, value = kindChannelID, second
+		case first == "user":
+			kind, value = kindUsername, second
+		case first == "c":
+			kind, value = kindCustomName, second
+		case first == "watch" && u.Query().Get("v") != "":
+			kind, value = kindVideo, u.Query().Get("v")
+		case first == "watch" || first == "playlist":
+			kind, value = kindPlaylist, u.Query().Get("list")
+		case first == "shorts" || first == "live" || first == "embed":
+			kind, value = kindVideo, second
+		case len(segments) == 1 && first != "" && !strings.Contains(first, "."):
+			// legacy custom URL like youtube.com/name
+			kind, value = kindCustomName, first
+		}
+	}
+	if value == "" {
+		err = ErrUnsupportedInput
+		return
+	}
+
+	return
+}
+
+func New(cs YoutubeChannelLister, vs YoutubeVideoLister, ps YoutubePlaylistLister, ss YoutubeSearcher) (r *Resolver) {
+	r = &Resolver{}
+	r.cs = cs
+	r.vs = vs
+	r.ps = ps
+	r.ss = ss
+
+	return
+}
@@ -0,0 +1,111 @@
+package channelresolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/rss"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	channelID      = "UCuAXFkgsw1L7xaCfnd5JJOw"
+	otherChannelID = "UC38IQsAvIsxxjztdMZQtwHA"
+)
+
+func TestResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/search"):
+			// the first result only looks like the handle
+			fmt.Fprintf(w, `{"items":[{"snippet":{"channelId":"%s"}},{"snippet":{"channelId":"%s"}}]}`, otherChannelID, channelID)
+		case strings.HasSuffix(req.URL.Path, "/channels") && query.Get("forUsername") == "myuser":
+			fmt.Fprintf(w, `{"items":[{"id":"%s"}]}`, channelID)
+		case strings.HasSuffix(req.URL.Path, "/channels") && query.Get("id") != "":
+			fmt.Fprintf(w, `{"items":[{"id":"%s","snippet":{"customUrl":"@myhandlefan"}},{"id":"%s","snippet":{"customUrl":"@MyHandle"}}]}`, otherChannelID, channelID)
+		case strings.HasSuffix(req.URL.Path, "/videos") && query.Get("id") == "dQw4w9WgXcQ":
+			fmt.Fprintf(w, `{"items":[{"id":"dQw4w9WgXcQ","snippet":{"channelId":"%s"}}]}`, channelID)
+		case strings.HasSuffix(req.URL.Path, "/playlists") && query.Get("id") == "PLmyplaylist":
+			fmt.Fprintf(w, `{"items":[{"id":"PLmyplaylist","snippet":{"channelId":"%s"}}]}`, channelID)
+		case strings.HasSuffix(req.URL.Path, "/playlists") && query.Get("id") == "PLbroken":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":{"code":500,"message":"broken"}}`)
+		default:
+			fmt.Fprint(w, `{"items":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	yts, err := youtube.NewService(context.TODO(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	r := New(yts.Channels, yts.Videos, yts.Playlists, yts.Search)
+	require.NotNil(t, r)
+
+	t.Run("Resolve", func(t *testing.T) {
+		inputs := []string{
+			rss.YoutubeSubscriptionTopicPrefix + channelID,
+			channelID,
+			"https://www.youtube.com/channel/" + channelID,
+			"@myhandle",
+			"https://www.youtube.com/@MyHandle/videos",
+			"youtube.com/c/myhandle",
+			"https://m.youtube.com/user/myuser",
+			"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PLmyplaylist",
+			"https://youtu.be/dQw4w9WgXcQ",
+			"https://www.youtube.com/shorts/dQw4w9WgXcQ",
+			"https://www.youtube.com/playlist?list=PLmyplaylist",
+		}
+		for _, input := range inputs {
+			resolved, err := r.Resolve(context.TODO(), input)
+			require.NoError(t, err, input)
+			require.Equal(t, channelID, resolved, input)
+		}
+	})
+
+	t.Run("Resolve failed", func(t *testing.T) {
+		_, err := r.Resolve(context.TODO(), "https://example.com/@myhandle")
+		require.Equal(t, ErrUnsupportedInput, errors.Cause(err))
+
+		_, err = r.Resolve(context.TODO(), "https://www.youtube.com/watch")
+		require.Equal(t, ErrUnsupportedInput, errors.Cause(err))
+
+		_, err = r.Resolve(context.TODO(), "https://www.youtube.com/channel/notachannelid")
+		require.Equal(t, ErrUnsupportedInput, errors.Cause(err))
+
+		_, err = r.Resolve(context.TODO(), "https://www.youtube.com/channel/"+channelID+"x")
+		require.Equal(t, ErrUnsupportedInput, errors.Cause(err))
+
+		_, err = r.Resolve(context.TODO(), "@unknownhandle")
+		require.Equal(t, ErrChannelNotFound, errors.Cause(err))
+		require.Contains(t, err.Error(), "search results")
+
+		_, err = r.Resolve(context.TODO(), "https://www.youtube.com/user/unknown")
+		require.Equal(t, ErrChannelNotFound, errors.Cause(err))
+
+		_, err = r.Resolve(context.TODO(), "https://www.youtube.com/playlist?list=PLbroken")
+		require.Error(t, err)
+		require.NotEqual(t, ErrChannelNotFound, errors.Cause(err))
+	})
+
+	t.Run("ResolveTopics", func(t *testing.T) {
+		topics, failed := r.ResolveTopics(context.TODO(), []string{"@myhandle", rss.YoutubeSubscriptionTopicPrefix + otherChannelID})
+		require.Empty(t, failed)
+		require.Equal(t, []string{rss.YoutubeSubscriptionTopicPrefix + channelID, rss.YoutubeSubscriptionTopicPrefix + otherChannelID}, topics)
+
+		// failed inputs don't keep the others from being resolved
+		topics, failed = r.ResolveTopics(context.TODO(), []string{"@myhandle", "nonsense input", "@unknownhandle"})
+		require.Equal(t, []string{rss.YoutubeSubscriptionTopicPrefix + channelID}, topics)
+		require.Len(t, failed, 2)
+		require.Equal(t, ErrUnsupportedInput, errors.Cause(failed["nonsense input"]))
+		require.Equal(t, ErrChannelNotFound, errors.Cause(failed["@unknownhandle"]))
+	})
+}
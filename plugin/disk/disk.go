@@ -49,6 +49,18 @@ func (d *Disk) SaveAs(ctx context.Context, name string, r io.Reader) (written in
 	return
 }
 
+// Rename moves the file from to to, a file already at to is replaced
+func (d *Disk) Rename(ctx context.Context, from, to string) (err error) {
+	err = os.Rename(filepath.Join(d.dirPath, from), filepath.Join(d.dirPath, to))
+
+	return
+}
+
+// StreamingSupported because files are written as the data comes
+func (d *Disk) StreamingSupported() bool {
+	return true
+}
+
 func New(dirPath string) (d *Disk, err error) {
 	d = &Disk{}
 	d.dirPath = dirPath
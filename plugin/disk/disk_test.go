@@ -58,6 +58,24 @@ func TestDisk(t *testing.T) {
 		require.False(t, exists)
 	})
 
+	t.Run("Rename success replaces the existing file", func(t *testing.T) {
+		_, err := d.SaveAs(context.TODO(), "old.txt", bytes.NewBufferString("old"))
+		require.NoError(t, err)
+		_, err = d.SaveAs(context.TODO(), "new.txt", bytes.NewBufferString("new"))
+		require.NoError(t, err)
+
+		err = d.Rename(context.TODO(), "new.txt", "old.txt")
+		require.NoError(t, err)
+
+		writtenData, err := ioutil.ReadFile(filepath.Join(dirName, "old.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "new", string(writtenData))
+
+		exists, err := d.Exists(context.TODO(), "new.txt")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
 	t.Run("Delete failed", func(t *testing.T) {
 		err = d.Delete(context.TODO(), "test.txt")
 		require.Error(t, err)
@@ -0,0 +1,141 @@
+package downloadlease
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	DefaultFilePermission      = 0666
+	DefaultDatabaseOpenTimeout = 5 * time.Second
+	DefaultBucketName          = "ytfeed-download-lease"
+)
+
+type lease struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BoltLease opens the database for every operation instead of holding it open,
+// bbolt only allows one process at a time so this is how replicas on the same host or volume share it
+type BoltLease struct {
+	databasePath string
+	owner        string
+}
+
+func (b *BoltLease) Acquire(ctx context.Context, videoID string, ttl time.Duration) (acquired bool, err error) {
+	err = b.update(func(bk *bbolt.Bucket) (err error) {
+		l := lease{}
+		v := bk.Get([]byte(videoID))
+		if v != nil {
+			err = json.Unmarshal(v, &l)
+			if err != nil {
+				return
+			}
+			if l.Owner != b.owner && time.Now().Before(l.ExpiresAt) {
+				return
+			}
+		}
+
+		acquired = true
+		err = b.put(bk, videoID, ttl)
+		return
+	})
+
+	return
+}
+
+func (b *BoltLease) Renew(ctx context.Context, videoID string, ttl time.Duration) (err error) {
+	err = b.update(func(bk *bbolt.Bucket) (err error) {
+		l := lease{}
+		v := bk.Get([]byte(videoID))
+		if v == nil {
+			return ErrLeaseLost
+		}
+		err = json.Unmarshal(v, &l)
+		if err != nil {
+			return
+		}
+		if l.Owner != b.owner {
+			return ErrLeaseLost
+		}
+
+		err = b.put(bk, videoID, ttl)
+		return
+	})
+
+	return
+}
+
+func (b *BoltLease) Release(ctx context.Context, videoID string) (err error) {
+	err = b.update(func(bk *bbolt.Bucket) (err error) {
+		l := lease{}
+		v := bk.Get([]byte(videoID))
+		if v == nil {
+			return
+		}
+		err = json.Unmarshal(v, &l)
+		if err != nil {
+			return
+		}
+		if l.Owner != b.owner {
+			return
+		}
+
+		err = bk.Delete([]byte(videoID))
+		return
+	})
+
+	return
+}
+
+func (b *BoltLease) put(bk *bbolt.Bucket, videoID string, ttl time.Duration) (err error) {
+	l := lease{}
+	l.Owner = b.owner
+	l.ExpiresAt = time.Now().Add(ttl)
+
+	var rawData []byte
+	rawData, err = json.Marshal(l)
+	if err != nil {
+		return
+	}
+
+	err = bk.Put([]byte(videoID), rawData)
+
+	return
+}
+
+func (b *BoltLease) update(fn func(bk *bbolt.Bucket) error) (err error) {
+	var db *bbolt.DB
+	db, err = bbolt.Open(b.databasePath, DefaultFilePermission, &bbolt.Options{Timeout: DefaultDatabaseOpenTimeout})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open lease database %s", b.databasePath)
+		return
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bbolt.Tx) (err error) {
+		var bk *bbolt.Bucket
+		bk, err = tx.CreateBucketIfNotExists([]byte(DefaultBucketName))
+		if err != nil {
+			return
+		}
+
+		err = fn(bk)
+		return
+	})
+
+	return
+}
+
+func NewBolt(databasePath, owner string) (b *BoltLease) {
+	b = &BoltLease{}
+	b.databasePath = databasePath
+	b.owner = owner
+
+	return
+}
@@ -0,0 +1,22 @@
+package downloadlease
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrLeaseLost = errors.New("download lease is held by another owner")
+)
+
+// DefaultOwner identifies this process among the replicas sharing the lease backend
+func DefaultOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,125 @@
+package downloadlease
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+const (
+	videoID = "dQw4w9WgXcQ"
+)
+
+func TestBoltLease(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dirName)
+
+	databasePath := filepath.Join(dirName, "lease.db")
+	replicaA := NewBolt(databasePath, "a")
+	replicaB := NewBolt(databasePath, "b")
+
+	t.Run("Acquire success then denied for other owner", func(t *testing.T) {
+		acquired, err := replicaA.Acquire(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		acquired, err = replicaB.Acquire(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+		require.False(t, acquired)
+	})
+
+	t.Run("Renew success for owner and failed for other owner", func(t *testing.T) {
+		err := replicaA.Renew(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+
+		err = replicaB.Renew(context.TODO(), videoID, time.Minute)
+		require.Equal(t, ErrLeaseLost, err)
+	})
+
+	t.Run("Release by other owner is ignored", func(t *testing.T) {
+		err := replicaB.Release(context.TODO(), videoID)
+		require.NoError(t, err)
+
+		acquired, err := replicaB.Acquire(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+		require.False(t, acquired)
+	})
+
+	t.Run("Release then Acquire by other owner", func(t *testing.T) {
+		err := replicaA.Release(context.TODO(), videoID)
+		require.NoError(t, err)
+
+		acquired, err := replicaB.Acquire(context.TODO(), videoID, time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, acquired)
+	})
+
+	t.Run("Acquire expired lease", func(t *testing.T) {
+		time.Sleep(5 * time.Millisecond)
+
+		acquired, err := replicaA.Acquire(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		err = replicaB.Renew(context.TODO(), videoID, time.Minute)
+		require.Equal(t, ErrLeaseLost, err)
+	})
+}
+
+func TestRedisLease(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := mock.NewMockRedisClient(ctrl)
+	r := NewRedis(client, DefaultRedisKeyPrefix, "a")
+	key := DefaultRedisKeyPrefix + videoID
+
+	t.Run("Acquire success", func(t *testing.T) {
+		client.EXPECT().SetNX(gomock.Any(), key, "a", time.Minute).Return(redis.NewBoolResult(true, nil))
+
+		acquired, err := r.Acquire(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+	})
+
+	t.Run("Acquire held by other owner", func(t *testing.T) {
+		client.EXPECT().SetNX(gomock.Any(), key, "a", time.Minute).Return(redis.NewBoolResult(false, nil))
+
+		acquired, err := r.Acquire(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+		require.False(t, acquired)
+	})
+
+	t.Run("Renew success", func(t *testing.T) {
+		client.EXPECT().Eval(gomock.Any(), renewScript, []string{key}, "a", int64(60000)).Return(redis.NewCmdResult(int64(1), nil))
+
+		err := r.Renew(context.TODO(), videoID, time.Minute)
+		require.NoError(t, err)
+	})
+
+	t.Run("Renew lost", func(t *testing.T) {
+		client.EXPECT().Eval(gomock.Any(), renewScript, []string{key}, "a", int64(60000)).Return(redis.NewCmdResult(int64(0), nil))
+
+		err := r.Renew(context.TODO(), videoID, time.Minute)
+		require.Equal(t, ErrLeaseLost, err)
+	})
+
+	t.Run("Release failed", func(t *testing.T) {
+		client.EXPECT().Eval(gomock.Any(), releaseScript, []string{key}, "a").Return(redis.NewCmdResult(nil, fmt.Errorf("error")))
+
+		err := r.Release(context.TODO(), videoID)
+		require.Error(t, err)
+	})
+}
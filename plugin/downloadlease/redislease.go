@@ -0,0 +1,61 @@
+package downloadlease
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	DefaultRedisKeyPrefix = "ytfeed:download-lease:"
+
+	// only touch the key if we still own it, otherwise another replica took over after our lease expired
+	renewScript   = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+)
+
+type RedisClient interface {
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+}
+
+type RedisLease struct {
+	client    RedisClient
+	keyPrefix string
+	owner     string
+}
+
+func (r *RedisLease) Acquire(ctx context.Context, videoID string, ttl time.Duration) (acquired bool, err error) {
+	acquired, err = r.client.SetNX(ctx, r.keyPrefix+videoID, r.owner, ttl).Result()
+
+	return
+}
+
+func (r *RedisLease) Renew(ctx context.Context, videoID string, ttl time.Duration) (err error) {
+	var renewed int64
+	renewed, err = r.client.Eval(ctx, renewScript, []string{r.keyPrefix + videoID}, r.owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return
+	}
+	if renewed == 0 {
+		err = ErrLeaseLost
+	}
+
+	return
+}
+
+func (r *RedisLease) Release(ctx context.Context, videoID string) (err error) {
+	err = r.client.Eval(ctx, releaseScript, []string{r.keyPrefix + videoID}, r.owner).Err()
+
+	return
+}
+
+func NewRedis(client RedisClient, keyPrefix, owner string) (r *RedisLease) {
+	r = &RedisLease{}
+	r.client = client
+	r.keyPrefix = keyPrefix
+	r.owner = owner
+
+	return
+}
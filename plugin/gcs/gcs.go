@@ -42,14 +42,42 @@ func (g *GCS) Delete(ctx context.Context, name string) (err error) {
 }
 
 func (g *GCS) SaveAs(ctx context.Context, name string, r io.Reader) (written int64, err error) {
+	// cancelling the writer context aborts the upload instead of committing a truncated object
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	w := g.cli.Bucket(g.bucketName).Object(name).NewWriter(ctx)
-	defer w.Close()
 
 	written, err = io.Copy(w, r)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return
+	}
+
+	err = w.Close()
 
 	return
 }
 
+// Rename copies the object from to to on the server and deletes from afterward, an object already at to is replaced
+func (g *GCS) Rename(ctx context.Context, from, to string) (err error) {
+	bucket := g.cli.Bucket(g.bucketName)
+	_, err = bucket.Object(to).CopierFrom(bucket.Object(from)).Run(ctx)
+	if err != nil {
+		return
+	}
+
+	err = g.Delete(ctx, from)
+
+	return
+}
+
+// StreamingSupported because the writer uploads in chunks, the size doesn't have to be known
+func (g *GCS) StreamingSupported() bool {
+	return true
+}
+
 func New(bucketName, credentialJSONFilePath string, httpClient *http.Client) (g *GCS, err error) {
 	g = &GCS{}
 	g.bucketName = bucketName
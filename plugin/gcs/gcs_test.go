@@ -63,6 +63,25 @@ func TestGCS(t *testing.T) {
 		require.False(t, exists)
 	})
 
+	t.Run("Rename success", func(t *testing.T) {
+		_, err := gcsClient.SaveAs(context.Background(), "new.txt", bytes.NewBufferString("new"))
+		require.NoError(t, err)
+
+		err = gcsClient.Rename(context.TODO(), "new.txt", fileName)
+		require.NoError(t, err)
+
+		obj, err := svr.GetObject(bucketName, fileName)
+		require.NoError(t, err)
+		require.Equal(t, "new", string(obj.Content))
+
+		exists, err := gcsClient.Exists(context.TODO(), "new.txt")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		err = gcsClient.Delete(context.TODO(), fileName)
+		require.NoError(t, err)
+	})
+
 	t.Run("Delete failed", func(t *testing.T) {
 		err = gcsClient.Delete(context.TODO(), fileName)
 		require.Error(t, err)
@@ -0,0 +1,440 @@
+package inboundqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	DefaultFilePermission      = 0666
+	DefaultDatabaseOpenTimeout = time.Second
+	DefaultBucketName          = "ytfeed-inbound"
+	DefaultDispatchInterval    = 30 * time.Second
+	DefaultWorkers             = 4
+)
+
+var (
+	ErrQueueFull = errors.New("inbound queue is full")
+)
+
+type Databaser interface {
+	Close() error
+	Update(func(tx *bbolt.Tx) error) error
+	View(func(tx *bbolt.Tx) error) error
+}
+
+// Notification is an accepted hub notification waiting for the handlers in PendingHandlers to complete
+type Notification struct {
+	AcceptedAt      time.Time    `json:"accepted_at"`
+	PendingHandlers []string     `json:"pending_handlers"`
+	Data            *ytfeed.Data `json:"data"`
+}
+
+type job struct {
+	key         []byte
+	handlerName string
+	data        *ytfeed.Data
+}
+
+type InboundQueue struct {
+	logger           ytfeed.Logger
+	database         Databaser
+	workers          int
+	maxPending       int
+	dispatchInterval time.Duration
+	handlerNames     []string
+	dataHandlers     map[string]ytfeed.DataHandlerFunc
+	// jobs has one channel per data handler so a slow handler only holds its own workers
+	jobs   map[string]chan job
+	wakeup chan struct{}
+	// backlog has the keys of the notifications every data handler has yet to be given in order, so dispatching
+	// doesn't rescan the bucket. retries has the keys whose data handler panicked, they are given again on the next tick.
+	backlog     map[string][][]byte
+	retries     map[string][][]byte
+	backlogLock sync.Mutex
+	// pending counts the notifications in the bucket, the lock is held across the write transactions that change it
+	pending     int
+	pendingLock sync.Mutex
+}
+
+// RegisterDataHandler must be called before RunWorker, the name is what gets persisted
+// so it must stay the same across restarts for unfinished work to be replayed.
+// Every data handler gets its own pool of workers.
+func (q *InboundQueue) RegisterDataHandler(name string, d ytfeed.DataHandlerFunc) {
+	if _, ok := q.dataHandlers[name]; !ok {
+		q.handlerNames = append(q.handlerNames, name)
+		q.jobs[name] = make(chan job, q.workers)
+	}
+	q.dataHandlers[name] = d
+}
+
+// SetMaxPending because limiting the queue is optional, it doesn't have to be present at constructor function
+func (q *InboundQueue) SetMaxPending(maxPending int) {
+	q.maxPending = maxPending
+}
+
+// Enqueue durably records the notification, it returns ErrQueueFull if there are already max pending notifications
+func (q *InboundQueue) Enqueue(ctx context.Context, data *ytfeed.Data) (err error) {
+	return q.EnqueueBatch(ctx, []*ytfeed.Data{data})
+}
+
+// EnqueueBatch durably records the notifications in one transaction so either all or none of them are recorded,
+// it returns ErrQueueFull if they don't fit under max pending notifications
+func (q *InboundQueue) EnqueueBatch(ctx context.Context, data []*ytfeed.Data) (err error) {
+	rawData := make([][]byte, 0, len(data))
+	for _, d := range data {
+		n := Notification{}
+		n.AcceptedAt = time.Now()
+		n.PendingHandlers = append(make([]string, 0, len(q.handlerNames)), q.handlerNames...)
+		n.Data = d
+
+		var rawNotification []byte
+		rawNotification, err = json.Marshal(n)
+		if err != nil {
+			err = errors.Wrap(err, "failed to json marshal notification")
+			return
+		}
+		rawData = append(rawData, rawNotification)
+	}
+
+	q.pendingLock.Lock()
+	defer q.pendingLock.Unlock()
+
+	keys := make([][]byte, 0, len(rawData))
+	err = q.database.Update(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+
+		if q.maxPending > 0 && q.pending+len(rawData) > q.maxPending {
+			return ErrQueueFull
+		}
+
+		for _, rawNotification := range rawData {
+			var seq uint64
+			seq, err = b.NextSequence()
+			if err != nil {
+				return
+			}
+
+			key := sequenceKey(seq)
+			err = b.Put(key, rawNotification)
+			if err != nil {
+				return
+			}
+			keys = append(keys, key)
+		}
+
+		return
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to enqueue notification")
+		return
+	}
+	q.pending += len(rawData)
+	q.addBacklog(q.handlerNames, keys...)
+
+	q.wake()
+
+	return
+}
+
+func (q *InboundQueue) wake() {
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// addBacklog appends the keys to the backlog of every handler
+func (q *InboundQueue) addBacklog(handlerNames []string, keys ...[]byte) {
+	q.backlogLock.Lock()
+	defer q.backlogLock.Unlock()
+
+	for _, name := range handlerNames {
+		q.backlog[name] = append(q.backlog[name], keys...)
+	}
+}
+
+// Pending returns the number of notifications that still have unfinished handlers
+func (q *InboundQueue) Pending() (pending int, err error) {
+	q.pendingLock.Lock()
+	defer q.pendingLock.Unlock()
+
+	pending = q.pending
+
+	return
+}
+
+// RunWorker starts the worker pool of every data handler and dispatches every unfinished notification,
+// including the ones left from a previous run
+func (q *InboundQueue) RunWorker(ctx context.Context) (err error) {
+	var wg sync.WaitGroup
+	for _, name := range q.handlerNames {
+		for i := 0; i < q.workers; i++ {
+			wg.Add(1)
+			go func(jobs <-chan job) {
+				defer wg.Done()
+				q.work(ctx, jobs)
+			}(q.jobs[name])
+		}
+	}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(q.dispatchInterval)
+	defer ticker.Stop()
+
+	retry := false
+	for {
+		err = q.dispatch(ctx, retry)
+		if err != nil {
+			q.logger.Errorf("Failed to dispatch inbound notifications: %v", err)
+		}
+
+		retry = false
+		select {
+		case <-q.wakeup:
+		case <-ticker.C:
+			retry = true
+		case <-ctx.Done():
+			err = nil
+			return
+		}
+	}
+}
+
+// dispatch gives every data handler as many jobs from its backlog as it has idle workers,
+// retry puts the jobs whose data handler panicked back in front of the backlog first
+func (q *InboundQueue) dispatch(ctx context.Context, retry bool) (err error) {
+	unregistered := make(map[string][][]byte)
+
+	q.backlogLock.Lock()
+	if retry {
+		for name, keys := range q.retries {
+			q.backlog[name] = append(keys, q.backlog[name]...)
+		}
+		q.retries = make(map[string][][]byte)
+	}
+	err = q.database.View(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+
+		for name, keys := range q.backlog {
+			handlerJobs, ok := q.jobs[name]
+			if !ok {
+				unregistered[name] = keys
+				delete(q.backlog, name)
+				continue
+			}
+
+			// only the dispatcher sends jobs so there is room for every job sent while the channel isn't full,
+			// a handler whose workers are all busy gets the rest on a later dispatch
+			for len(keys) > 0 && len(handlerJobs) < cap(handlerJobs) && ctx.Err() == nil {
+				v := b.Get(keys[0])
+				if v == nil {
+					keys = keys[1:]
+					continue
+				}
+
+				n := Notification{}
+				unmarshalErr := json.Unmarshal(v, &n)
+				if unmarshalErr != nil {
+					q.logger.Errorf("Failed to unmarshal notification json with key %d, skipping it: %v", binary.BigEndian.Uint64(keys[0]), unmarshalErr)
+					keys = keys[1:]
+					continue
+				}
+				handlerJobs <- job{key: keys[0], handlerName: name, data: n.Data}
+				keys = keys[1:]
+			}
+			q.backlog[name] = keys
+		}
+
+		return
+	})
+	q.backlogLock.Unlock()
+
+	// acked outside of the backlog lock because enqueue takes the locks the other way around
+	for name, keys := range unregistered {
+		for _, key := range keys {
+			q.logger.Warnf("Data handler %s is no longer registered, dropping its pending notification %d", name, binary.BigEndian.Uint64(key))
+			ackErr := q.ack(key, name)
+			if ackErr != nil {
+				q.logger.Errorf("Failed to acknowledge notification %d for data handler %s: %v", binary.BigEndian.Uint64(key), name, ackErr)
+			}
+		}
+	}
+
+	return
+}
+
+func (q *InboundQueue) work(ctx context.Context, jobs <-chan job) {
+	for {
+		select {
+		case j := <-jobs:
+			q.handle(ctx, j)
+			q.wake()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *InboundQueue) handle(ctx context.Context, j job) {
+	err := runDataHandler(ctx, q.dataHandlers[j.handlerName], j.data)
+	if err != nil {
+		q.logger.Errorf("Data handler %s failed on notification %d, it is given the notification again later: %v", j.handlerName, binary.BigEndian.Uint64(j.key), err)
+		q.retry(j)
+		return
+	}
+
+	// unfinished because of shutdown, leave it to be replayed on the next start
+	if ctx.Err() != nil {
+		return
+	}
+
+	err = q.ack(j.key, j.handlerName)
+	if err != nil {
+		q.logger.Errorf("Failed to acknowledge notification %d for data handler %s: %v", binary.BigEndian.Uint64(j.key), j.handlerName, err)
+		q.retry(j)
+	}
+}
+
+// runDataHandler reports a panicking data handler as failed instead of letting it kill the worker
+func runDataHandler(ctx context.Context, d ytfeed.DataHandlerFunc, data *ytfeed.Data) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("data handler panicked: %v", r)
+		}
+	}()
+	d(ctx, data)
+
+	return
+}
+
+// retry gives the job to its data handler again on the next dispatch tick
+func (q *InboundQueue) retry(j job) {
+	q.backlogLock.Lock()
+	defer q.backlogLock.Unlock()
+
+	q.retries[j.handlerName] = append(q.retries[j.handlerName], j.key)
+}
+
+// ack removes the handler from the notification and deletes the notification once no handler is pending
+func (q *InboundQueue) ack(key []byte, handlerName string) (err error) {
+	q.pendingLock.Lock()
+	defer q.pendingLock.Unlock()
+
+	deleted := false
+	err = q.database.Update(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+
+		v := b.Get(key)
+		if v == nil {
+			return
+		}
+
+		n := Notification{}
+		err = json.Unmarshal(v, &n)
+		if err != nil {
+			return
+		}
+
+		pending := n.PendingHandlers[:0]
+		for _, name := range n.PendingHandlers {
+			if name != handlerName {
+				pending = append(pending, name)
+			}
+		}
+		n.PendingHandlers = pending
+
+		if len(n.PendingHandlers) == 0 {
+			deleted = true
+			err = b.Delete(key)
+			return
+		}
+
+		var rawData []byte
+		rawData, err = json.Marshal(n)
+		if err != nil {
+			return
+		}
+
+		err = b.Put(key, rawData)
+		return
+	})
+	if err == nil && deleted {
+		q.pending--
+	}
+
+	return
+}
+
+func sequenceKey(seq uint64) (k []byte) {
+	k = make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+
+	return
+}
+
+func (q *InboundQueue) CloseDatabase() (err error) {
+	return q.database.Close()
+}
+
+func New(logger ytfeed.Logger, databasePath string, workers int) (q *InboundQueue, err error) {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	q = &InboundQueue{}
+	q.logger = logger
+	q.workers = workers
+	q.dispatchInterval = DefaultDispatchInterval
+	q.handlerNames = make([]string, 0, 4)
+	q.dataHandlers = make(map[string]ytfeed.DataHandlerFunc, 4)
+	q.jobs = make(map[string]chan job, 4)
+	q.wakeup = make(chan struct{}, 1)
+	q.backlog = make(map[string][][]byte, 4)
+	q.retries = make(map[string][][]byte, 4)
+	q.database, err = bbolt.Open(databasePath, DefaultFilePermission, &bbolt.Options{Timeout: DefaultDatabaseOpenTimeout})
+	if err != nil {
+		q = nil
+		return
+	}
+
+	err = q.database.Update(func(tx *bbolt.Tx) (err error) {
+		b, err := tx.CreateBucketIfNotExists([]byte(DefaultBucketName))
+		if err != nil {
+			return
+		}
+
+		// scanned once here, enqueue, dispatch and ack keep the count and the backlog from then on
+		return b.ForEach(func(k, v []byte) (err error) {
+			q.pending++
+
+			n := Notification{}
+			unmarshalErr := json.Unmarshal(v, &n)
+			if unmarshalErr != nil {
+				logger.Errorf("Failed to unmarshal notification json with key %d, skipping it: %v", binary.BigEndian.Uint64(k), unmarshalErr)
+				return
+			}
+			for _, name := range n.PendingHandlers {
+				q.backlog[name] = append(q.backlog[name], append([]byte(nil), k...))
+			}
+
+			return
+		})
+	})
+	if err != nil {
+		_ = q.database.Close()
+		q = nil
+		err = errors.Wrap(err, "failed to load inbound queue")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,364 @@
+package inboundqueue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+const (
+	videoURL  = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	videoURL2 = "https://www.youtube.com/watch?v=989-7xsRLR4"
+)
+
+func TestInboundQueue(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dirName)
+
+	t.Run("RunWorker success every handler runs once per notification", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "success.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 2)
+		require.NoError(t, err)
+		require.NotNil(t, q)
+		defer q.CloseDatabase()
+
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		handled := make(map[string]int, 4)
+		handler := func(name string) ytfeed.DataHandlerFunc {
+			return func(ctx context.Context, d *ytfeed.Data) {
+				defer wg.Done()
+
+				lock.Lock()
+				defer lock.Unlock()
+				handled[name+" "+d.Feed.Entry.Link.Href]++
+			}
+		}
+		q.RegisterDataHandler("first", handler("first"))
+		q.RegisterDataHandler("second", handler("second"))
+
+		wg.Add(4)
+		for _, u := range []string{videoURL, videoURL2} {
+			data := &ytfeed.Data{}
+			data.Feed.Entry.Link.Href = u
+			err = q.Enqueue(context.TODO(), data)
+			require.NoError(t, err)
+		}
+
+		pending, err := q.Pending()
+		require.NoError(t, err)
+		require.Equal(t, 2, pending)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- q.RunWorker(ctx)
+		}()
+
+		wg.Wait()
+		require.Eventually(t, func() bool {
+			pending, err := q.Pending()
+			return err == nil && pending == 0
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+
+		require.Equal(t, map[string]int{
+			"first " + videoURL:   1,
+			"second " + videoURL:  1,
+			"first " + videoURL2:  1,
+			"second " + videoURL2: 1,
+		}, handled)
+	})
+
+	t.Run("RunWorker slow handler does not hold the workers of other handlers", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "slow.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 1)
+		require.NoError(t, err)
+		defer q.CloseDatabase()
+
+		unblock := make(chan struct{})
+		q.RegisterDataHandler("slow", func(ctx context.Context, d *ytfeed.Data) {
+			select {
+			case <-unblock:
+			case <-ctx.Done():
+			}
+		})
+		handled := make(chan string, 2)
+		q.RegisterDataHandler("fast", func(ctx context.Context, d *ytfeed.Data) {
+			handled <- d.Feed.Entry.Link.Href
+		})
+
+		for _, u := range []string{videoURL, videoURL2} {
+			data := &ytfeed.Data{}
+			data.Feed.Entry.Link.Href = u
+			err = q.Enqueue(context.TODO(), data)
+			require.NoError(t, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- q.RunWorker(ctx)
+		}()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("fast handler was starved by the slow one")
+			}
+		}
+
+		close(unblock)
+		require.Eventually(t, func() bool {
+			pending, err := q.Pending()
+			return err == nil && pending == 0
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("RunWorker replays unfinished notifications after restart", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "replay.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 1)
+		require.NoError(t, err)
+		q.RegisterDataHandler("first", func(ctx context.Context, d *ytfeed.Data) {})
+
+		data := &ytfeed.Data{}
+		data.Feed.Entry.Link.Href = videoURL
+		err = q.Enqueue(context.TODO(), data)
+		require.NoError(t, err)
+
+		// simulate a crash before any worker ran
+		err = q.CloseDatabase()
+		require.NoError(t, err)
+
+		q, err = New(logger, databasePath, 1)
+		require.NoError(t, err)
+		defer q.CloseDatabase()
+
+		handled := make(chan *ytfeed.Data, 1)
+		q.RegisterDataHandler("first", func(ctx context.Context, d *ytfeed.Data) {
+			handled <- d
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- q.RunWorker(ctx)
+		}()
+
+		select {
+		case d := <-handled:
+			require.Equal(t, videoURL, d.Feed.Entry.Link.Href)
+		case <-time.After(time.Second):
+			t.Fatal("notification was not replayed")
+		}
+
+		require.Eventually(t, func() bool {
+			pending, err := q.Pending()
+			return err == nil && pending == 0
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("RunWorker drops handlers that are no longer registered", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "unregistered.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 1)
+		require.NoError(t, err)
+		q.RegisterDataHandler("removed", func(ctx context.Context, d *ytfeed.Data) {})
+
+		data := &ytfeed.Data{}
+		data.Feed.Entry.Link.Href = videoURL
+		err = q.Enqueue(context.TODO(), data)
+		require.NoError(t, err)
+
+		err = q.CloseDatabase()
+		require.NoError(t, err)
+
+		q, err = New(logger, databasePath, 1)
+		require.NoError(t, err)
+		defer q.CloseDatabase()
+
+		logger.EXPECT().Warnf(
+			gomock.AssignableToTypeOf("no longer registered"),
+			gomock.AssignableToTypeOf("removed"),
+			gomock.AssignableToTypeOf(uint64(1)),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- q.RunWorker(ctx)
+		}()
+
+		require.Eventually(t, func() bool {
+			pending, err := q.Pending()
+			return err == nil && pending == 0
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("RunWorker retries notifications whose handler panicked", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "panic.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 1)
+		require.NoError(t, err)
+		defer q.CloseDatabase()
+		q.dispatchInterval = 10 * time.Millisecond
+
+		var lock sync.Mutex
+		calls := 0
+		handled := make(chan struct{})
+		q.RegisterDataHandler("first", func(ctx context.Context, d *ytfeed.Data) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			calls++
+			if calls == 1 {
+				panic("expected panic")
+			}
+			close(handled)
+		})
+
+		logger.EXPECT().Errorf(
+			gomock.AssignableToTypeOf("failed"),
+			"first",
+			gomock.AssignableToTypeOf(uint64(1)),
+			gomock.Any(),
+		)
+
+		err = q.Enqueue(context.TODO(), &ytfeed.Data{})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- q.RunWorker(ctx)
+		}()
+
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("notification was not retried after the handler panicked")
+		}
+		require.Eventually(t, func() bool {
+			pending, err := q.Pending()
+			return err == nil && pending == 0
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("Enqueue failed queue is full", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "full.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 1)
+		require.NoError(t, err)
+		defer q.CloseDatabase()
+		q.SetMaxPending(1)
+		q.RegisterDataHandler("first", func(ctx context.Context, d *ytfeed.Data) {})
+
+		err = q.Enqueue(context.TODO(), &ytfeed.Data{})
+		require.NoError(t, err)
+
+		err = q.Enqueue(context.TODO(), &ytfeed.Data{})
+		require.Error(t, err)
+		require.Equal(t, ErrQueueFull, errors.Cause(err))
+	})
+
+	t.Run("EnqueueBatch failed records none of the notifications", func(t *testing.T) {
+		databasePath := filepath.Join(dirName, "batch.db")
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+
+		q, err := New(logger, databasePath, 1)
+		require.NoError(t, err)
+		q.SetMaxPending(2)
+		q.RegisterDataHandler("first", func(ctx context.Context, d *ytfeed.Data) {})
+
+		err = q.Enqueue(context.TODO(), &ytfeed.Data{})
+		require.NoError(t, err)
+
+		err = q.EnqueueBatch(context.TODO(), []*ytfeed.Data{{}, {}})
+		require.Error(t, err)
+		require.Equal(t, ErrQueueFull, errors.Cause(err))
+
+		pending, err := q.Pending()
+		require.NoError(t, err)
+		require.Equal(t, 1, pending)
+
+		err = q.EnqueueBatch(context.TODO(), []*ytfeed.Data{{}})
+		require.NoError(t, err)
+
+		// the pending count survives a restart
+		err = q.CloseDatabase()
+		require.NoError(t, err)
+
+		q, err = New(logger, databasePath, 1)
+		require.NoError(t, err)
+		defer q.CloseDatabase()
+
+		pending, err = q.Pending()
+		require.NoError(t, err)
+		require.Equal(t, 2, pending)
+	})
+}
@@ -20,16 +20,22 @@ type AMQPPublisher interface {
 }
 
 type PublishAMQP struct {
-	logger    ytfeed.Logger
-	channel   AMQPPublisher
-	exchange  string
-	key       string
-	mandatory bool
-	immediate bool
-	returnCh  chan amqp.Return
+	logger      ytfeed.Logger
+	channel     AMQPPublisher
+	exchange    string
+	key         string
+	progressKey string
+	mandatory   bool
+	immediate   bool
+	returnCh    chan amqp.Return
 }
 
 func (p *PublishAMQP) DataHandler(ctx context.Context, d *ytfeed.Data) {
+	_ = p.HandleData(ctx, d)
+}
+
+// HandleData publishes the data like DataHandler and returns why it failed
+func (p *PublishAMQP) HandleData(ctx context.Context, d *ytfeed.Data) (err error) {
 	rawJSON, err := json.Marshal(d)
 	if err != nil {
 		p.logger.Errorf("Failed to marshal JSON: %v", err)
@@ -50,6 +56,42 @@ func (p *PublishAMQP) DataHandler(ctx context.Context, d *ytfeed.Data) {
 	}
 
 	p.logger.Infof("Publish data `%s` to AMQP at exchange %s and key %s", string(rawJSON), p.exchange, p.key)
+
+	return
+}
+
+// ProgressHandler publishes download progress with the progress key, it does nothing if the progress key is not set
+func (p *PublishAMQP) ProgressHandler(ctx context.Context, progress *ytfeed.DownloadProgress) {
+	if p.progressKey == "" {
+		return
+	}
+
+	rawJSON, err := json.Marshal(progress)
+	if err != nil {
+		p.logger.Errorf("Failed to marshal JSON: %v", err)
+		return
+	}
+
+	msg := amqp.Publishing{}
+	msg.Body = rawJSON
+	msg.ContentType = DefaultContentType
+	// progress is stale soon, no need to persist it
+	msg.DeliveryMode = amqp.Transient
+	msg.AppId = DefaultAppID
+	msg.Timestamp = time.Now()
+
+	err = p.channel.Publish(p.exchange, p.progressKey, p.mandatory, p.immediate, msg)
+	if err != nil {
+		p.logger.Errorf("Failed to publish download progress `%s` to AMQP at exchange %s and key %s: %v", string(rawJSON), p.exchange, p.progressKey, err)
+		return
+	}
+
+	p.logger.Debugf("Publish download progress `%s` to AMQP at exchange %s and key %s", string(rawJSON), p.exchange, p.progressKey)
+}
+
+// SetProgressKey because publishing download progress is optional, it doesn't have to be present at constructor function
+func (p *PublishAMQP) SetProgressKey(key string) {
+	p.progressKey = key
 }
 
 func New(logger ytfeed.Logger, channel AMQPPublisher, exchange, key string, mandatory, immediate bool) (pr *PublishAMQP) {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
 	"github.com/worksinmagic/ytfeed"
 	"github.com/worksinmagic/ytfeed/mock"
 )
@@ -64,6 +65,37 @@ func TestPublishAMQP(t *testing.T) {
 		).Return(fmt.Errorf("error"))
 
 		d := &ytfeed.Data{}
-		pr.DataHandler(context.TODO(), d)
+		err := pr.HandleData(context.TODO(), d)
+		require.Error(t, err)
+	})
+
+	t.Run("ProgressHandler without progress key does nothing", func(t *testing.T) {
+		pr.ProgressHandler(context.TODO(), &ytfeed.DownloadProgress{})
+	})
+
+	t.Run("ProgressHandler success", func(t *testing.T) {
+		pr.SetProgressKey("progress")
+		defer pr.SetProgressKey("")
+
+		logger.EXPECT().Debugf(
+			gomock.AssignableToTypeOf("success"),
+			gomock.AssignableToTypeOf("json string"),
+			exchange,
+			"progress",
+		)
+
+		pub.EXPECT().Publish(
+			exchange,
+			"progress",
+			mandatory,
+			immediate,
+			gomock.AssignableToTypeOf(amqp.Publishing{}),
+		).DoAndReturn(func(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			require.Equal(t, amqp.Transient, msg.DeliveryMode)
+			require.Contains(t, string(msg.Body), `"status":"downloading"`)
+			return nil
+		})
+
+		pr.ProgressHandler(context.TODO(), &ytfeed.DownloadProgress{Status: ytfeed.DownloadStatusDownloading})
 	})
 }
@@ -13,13 +13,19 @@ type RedisPublisher interface {
 }
 
 type PublishRedis struct {
-	logger  ytfeed.Logger
-	client  RedisPublisher
-	channel string
-	addr    string
+	logger          ytfeed.Logger
+	client          RedisPublisher
+	channel         string
+	progressChannel string
+	addr            string
 }
 
 func (p *PublishRedis) DataHandler(ctx context.Context, d *ytfeed.Data) {
+	_ = p.HandleData(ctx, d)
+}
+
+// HandleData publishes the data like DataHandler and returns why it failed
+func (p *PublishRedis) HandleData(ctx context.Context, d *ytfeed.Data) (err error) {
 	rawJSON, err := json.Marshal(d)
 	if err != nil {
 		p.logger.Errorf("Failed to marshal JSON: %v", err)
@@ -33,6 +39,34 @@ func (p *PublishRedis) DataHandler(ctx context.Context, d *ytfeed.Data) {
 	}
 
 	p.logger.Infof("Publish data `%s` to Redis at channel %s and address %s", string(rawJSON), p.channel, p.addr)
+
+	return
+}
+
+// ProgressHandler publishes download progress to the progress channel, it does nothing if the progress channel is not set
+func (p *PublishRedis) ProgressHandler(ctx context.Context, progress *ytfeed.DownloadProgress) {
+	if p.progressChannel == "" {
+		return
+	}
+
+	rawJSON, err := json.Marshal(progress)
+	if err != nil {
+		p.logger.Errorf("Failed to marshal JSON: %v", err)
+		return
+	}
+
+	err = p.client.Publish(ctx, p.progressChannel, string(rawJSON)).Err()
+	if err != nil {
+		p.logger.Errorf("Failed to publish download progress `%s` to Redis at channel %s and address %s: %v", string(rawJSON), p.progressChannel, p.addr, err)
+		return
+	}
+
+	p.logger.Debugf("Publish download progress `%s` to Redis at channel %s and address %s", string(rawJSON), p.progressChannel, p.addr)
+}
+
+// SetProgressChannel because publishing download progress is optional, it doesn't have to be present at constructor function
+func (p *PublishRedis) SetProgressChannel(channel string) {
+	p.progressChannel = channel
 }
 
 func New(logger ytfeed.Logger, channel string, opts *redis.Options) (pr *PublishRedis) {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
 	"github.com/worksinmagic/ytfeed"
 	"github.com/worksinmagic/ytfeed/mock"
 )
@@ -58,6 +59,32 @@ func TestPublishRedis(t *testing.T) {
 		)
 
 		d := &ytfeed.Data{}
-		pr.DataHandler(context.TODO(), d)
+		err := pr.HandleData(context.TODO(), d)
+		require.Error(t, err)
+	})
+
+	t.Run("ProgressHandler without progress channel does nothing", func(t *testing.T) {
+		pr.ProgressHandler(context.TODO(), &ytfeed.DownloadProgress{})
+	})
+
+	t.Run("ProgressHandler failed", func(t *testing.T) {
+		pr.SetProgressChannel("progress")
+		defer pr.SetProgressChannel("")
+
+		pub.EXPECT().Publish(
+			gomock.Any(),
+			"progress",
+			gomock.AssignableToTypeOf("data"),
+		).Return(redis.NewIntResult(0, fmt.Errorf("error")))
+
+		logger.EXPECT().Errorf(
+			gomock.AssignableToTypeOf("published"),
+			gomock.AssignableToTypeOf("data"),
+			"progress",
+			gomock.AssignableToTypeOf("addr"),
+			gomock.AssignableToTypeOf(fmt.Errorf("error")),
+		)
+
+		pr.ProgressHandler(context.TODO(), &ytfeed.DownloadProgress{})
 	})
 }
@@ -66,6 +66,30 @@ func (s *S3) SaveAs(ctx context.Context, name string, r io.Reader) (written int6
 	return
 }
 
+// Rename copies the object from to to on the server and deletes from afterward, an object already at to is replaced.
+// The copy is composed so objects larger than the single copy limit can be renamed too.
+func (s *S3) Rename(ctx context.Context, from, to string) (err error) {
+	dst := minio.CopyDestOptions{}
+	dst.Bucket = s.bucketName
+	dst.Object = to
+	src := minio.CopySrcOptions{}
+	src.Bucket = s.bucketName
+	src.Object = from
+	_, err = s.cli.ComposeObject(ctx, dst, src)
+	if err != nil {
+		return
+	}
+
+	err = s.Delete(ctx, from)
+
+	return
+}
+
+// StreamingSupported because objects of unknown size are uploaded with multipart upload
+func (s *S3) StreamingSupported() bool {
+	return true
+}
+
 func New(endpoint, accessKeyID, secretAccessKey, bucketName string, useSSL bool) (s *S3, err error) {
 	s = &S3{}
 	s.bucketName = bucketName
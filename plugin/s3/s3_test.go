@@ -55,6 +55,25 @@ func TestS3(t *testing.T) {
 		require.False(t, exists)
 	})
 
+	t.Run("Rename success", func(t *testing.T) {
+		_, err := s.SaveAs(context.Background(), "new.txt", bytes.NewBufferString("new"))
+		require.NoError(t, err)
+
+		err = s.Rename(context.TODO(), "new.txt", fileName)
+		require.NoError(t, err)
+
+		exists, err := s.Exists(context.TODO(), fileName)
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		exists, err = s.Exists(context.TODO(), "new.txt")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		err = s.Delete(context.TODO(), fileName)
+		require.NoError(t, err)
+	})
+
 	// S3 doesn't return deletion failed on non-exist file
 	// t.Run("Delete failed", func(t *testing.T) {
 	// 	err = s.Delete(context.TODO(), "test.txt")
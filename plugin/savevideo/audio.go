@@ -0,0 +1,210 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+const (
+	AudioFormatM4A  = "m4a"
+	AudioFormatOpus = "opus"
+	AudioFormatMP3  = "mp3"
+
+	FFmpegCommand = "ffmpeg"
+
+	ErrInvalidAudioFormat = "invalid audio format: %s"
+
+	AudioTagDateLayout = "2006-01-02"
+
+	coverFileSuffix  = ".cover.jpg"
+	taggedFileSuffix = ".tagged."
+)
+
+// AudioTags are embedded into audio files
+type AudioTags struct {
+	Title    string
+	Artist   string
+	Date     string
+	Comment  string
+	CoverURL string
+}
+
+// AudioTagger converts the downloaded audio at inputPath into audioFormat with tags embedded, returning the path of the new file
+type AudioTagger interface {
+	Tag(ctx context.Context, inputPath, audioFormat string, tags *AudioTags) (outputPath string, err error)
+}
+
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// FFmpegTagger tags audio with ffmpeg, the cover is fetched from CoverURL
+type FFmpegTagger struct {
+	logger     ytfeed.Logger
+	binary     string
+	httpClient HTTPDoer
+}
+
+func (f *FFmpegTagger) Tag(ctx context.Context, inputPath, audioFormat string, tags *AudioTags) (outputPath string, err error) {
+	args := make([]string, 0, 24)
+	args = append(args, "-y", "-i", inputPath)
+
+	// ffmpeg can't attach pictures to ogg, opus stays without cover
+	coverPath := ""
+	if tags.CoverURL != "" && audioFormat != AudioFormatOpus {
+		coverPath = inputPath + coverFileSuffix
+		err = f.fetchCover(ctx, tags.CoverURL, coverPath)
+		if err != nil {
+			f.logger.Warnf("Failed to fetch cover %s, tagging without cover: %v", tags.CoverURL, err)
+			coverPath = ""
+			err = nil
+		} else {
+			defer os.Remove(coverPath)
+			args = append(args, "-i", coverPath)
+		}
+	}
+
+	args = append(args, "-map", "0:a")
+	if coverPath != "" {
+		args = append(args, "-map", "1:v", "-c:v", "copy", "-disposition:v", "attached_pic")
+	}
+
+	switch audioFormat {
+	case AudioFormatM4A:
+		args = append(args, "-c:a", "copy", "-f", "mp4")
+	case AudioFormatOpus:
+		args = append(args, "-c:a", "copy", "-f", "opus")
+	case AudioFormatMP3:
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "0", "-id3v2_version", "3", "-f", "mp3")
+	default:
+		err = fmt.Errorf(ErrInvalidAudioFormat, audioFormat)
+		return
+	}
+
+	args = appendMetadata(args, "title", tags.Title)
+	args = appendMetadata(args, "artist", tags.Artist)
+	args = appendMetadata(args, "date", tags.Date)
+	args = appendMetadata(args, "comment", tags.Comment)
+
+	outputPath = inputPath + taggedFileSuffix + audioFormat
+	args = append(args, outputPath)
+
+	stdErrCollector := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, f.binary, args...)
+	cmd.Stderr = stdErrCollector
+	err = cmd.Run()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to run ffmpeg command args %v and stderr: %s", cmd.Args, stdErrCollector.String())
+		os.Remove(outputPath)
+		outputPath = ""
+		return
+	}
+
+	return
+}
+
+func (f *FFmpegTagger) fetchCover(ctx context.Context, url, coverPath string) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+
+	res, err := f.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+		return
+	}
+
+	cover, err := os.Create(coverPath)
+	if err != nil {
+		return
+	}
+	defer cover.Close()
+
+	_, err = io.Copy(cover, res.Body)
+
+	return
+}
+
+func appendMetadata(args []string, key, value string) []string {
+	if value == "" {
+		return args
+	}
+
+	return append(args, "-metadata", key+"="+value)
+}
+
+// NewFFmpegTagger uses ffmpeg at binary, FFmpegCommand is used if binary is empty
+func NewFFmpegTagger(logger ytfeed.Logger, binary string, httpClient HTTPDoer) *FFmpegTagger {
+	if binary == "" {
+		binary = FFmpegCommand
+	}
+
+	return &FFmpegTagger{logger: logger, binary: binary, httpClient: httpClient}
+}
+
+// getAudioTags builds the tags from the entry, the cover is the largest thumbnail of the video
+func getAudioTags(entry Entry, publishedDate string, thumbnails *youtube.ThumbnailDetails) (tags *AudioTags) {
+	tags = &AudioTags{}
+	tags.Title = entry.Title
+	tags.Artist = entry.Author
+	tags.Date = publishedDate
+	tags.Comment = entry.LinkURL
+
+	if thumbnails == nil {
+		return
+	}
+	for _, thumbnail := range []*youtube.Thumbnail{thumbnails.Maxres, thumbnails.Standard, thumbnails.High, thumbnails.Medium, thumbnails.Default} {
+		if thumbnail != nil && thumbnail.Url != "" {
+			tags.CoverURL = thumbnail.Url
+			return
+		}
+	}
+
+	return
+}
+
+// tagAudio runs the tagger on the downloaded file, it returns tmpFilePath unchanged if there is nothing to tag
+func (s *SaveVideo) tagAudio(ctx context.Context, tmpFilePath string, format Format, tags *AudioTags) (outputPath string, err error) {
+	outputPath = tmpFilePath
+	if tags == nil || s.audioTagger == nil || format.AudioFormat == "" {
+		return
+	}
+
+	outputPath, err = s.audioTagger.Tag(ctx, tmpFilePath, format.AudioFormat, tags)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to tag audio %s", filepath.Base(tmpFilePath))
+		return
+	}
+
+	return
+}
+
+// audioExtension is the file extension of the saved audio, it keeps the video extension if not extracting audio
+func (s *SaveVideo) audioExtension() string {
+	if s.formatPolicy.AudioOnly && s.formatPolicy.AudioFormat != "" {
+		return s.formatPolicy.AudioFormat
+	}
+
+	return s.videoFormatExtension
+}
+
+// SetAudioTagger because tagging audio is optional, it doesn't have to be present at constructor function
+func (s *SaveVideo) SetAudioTagger(tagger AudioTagger) {
+	s.audioTagger = tagger
+}
@@ -0,0 +1,173 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/mock"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+// fakeFFmpegScript writes its arguments, one per line, into the output file which is the last argument
+const fakeFFmpegScript = `#!/bin/sh
+for last; do :; done
+printf '%s\n' "$@" > "$last"
+`
+
+func TestAudio(t *testing.T) {
+	dirName, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	ffmpeg := filepath.Join(dirName, "fake-ffmpeg")
+	err = ioutil.WriteFile(ffmpeg, []byte(fakeFFmpegScript), 0755)
+	require.NoError(t, err)
+
+	coverServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/maxresdefault.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "jpeg")
+	}))
+	defer coverServer.Close()
+
+	tags := &AudioTags{}
+	tags.Title = "Never Gonna Give You Up"
+	tags.Artist = "Rick Astley"
+	tags.Date = "2009-10-25"
+	tags.Comment = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	tags.CoverURL = coverServer.URL + "/maxresdefault.jpg"
+
+	t.Run("getAudioTags uses the largest thumbnail", func(t *testing.T) {
+		entry := Entry{Title: "title", Author: "author", LinkURL: "url"}
+		thumbnails := &youtube.ThumbnailDetails{
+			High:    &youtube.Thumbnail{Url: "high"},
+			Default: &youtube.Thumbnail{Url: "default"},
+		}
+
+		tags := getAudioTags(entry, "2009-10-25", thumbnails)
+		require.Equal(t, &AudioTags{Title: "title", Artist: "author", Date: "2009-10-25", Comment: "url", CoverURL: "high"}, tags)
+
+		tags = getAudioTags(entry, "2009-10-25", nil)
+		require.Empty(t, tags.CoverURL)
+	})
+
+	t.Run("Tag mp3 with cover", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		input := filepath.Join(dirName, "mp3")
+		tagger := NewFFmpegTagger(mock.NewMockLogger(ctrl), ffmpeg, http.DefaultClient)
+
+		output, err := tagger.Tag(context.TODO(), input, AudioFormatMP3, tags)
+		require.NoError(t, err)
+		require.Equal(t, input+".tagged.mp3", output)
+
+		args, err := ioutil.ReadFile(output)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"-y", "-i", input, "-i", input + ".cover.jpg",
+			"-map", "0:a", "-map", "1:v", "-c:v", "copy", "-disposition:v", "attached_pic",
+			"-c:a", "libmp3lame", "-q:a", "0", "-id3v2_version", "3", "-f", "mp3",
+			"-metadata", "title=Never Gonna Give You Up",
+			"-metadata", "artist=Rick Astley",
+			"-metadata", "date=2009-10-25",
+			"-metadata", "comment=https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+			output,
+		}, strings.Split(strings.TrimSpace(string(args)), "\n"))
+
+		// the cover is removed afterward
+		_, err = os.Stat(input + ".cover.jpg")
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Tag opus without cover", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		input := filepath.Join(dirName, "opus")
+		tagger := NewFFmpegTagger(mock.NewMockLogger(ctrl), ffmpeg, http.DefaultClient)
+
+		output, err := tagger.Tag(context.TODO(), input, AudioFormatOpus, &AudioTags{Title: "title", CoverURL: tags.CoverURL})
+		require.NoError(t, err)
+
+		args, err := ioutil.ReadFile(output)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"-y", "-i", input, "-map", "0:a", "-c:a", "copy", "-f", "opus", "-metadata", "title=title", output,
+		}, strings.Split(strings.TrimSpace(string(args)), "\n"))
+	})
+
+	t.Run("Tag m4a without cover if it can't be fetched", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		input := filepath.Join(dirName, "m4a")
+		tagger := NewFFmpegTagger(logger, ffmpeg, http.DefaultClient)
+
+		logger.EXPECT().Warnf(gomock.Any(), coverServer.URL+"/missing.jpg", gomock.Any())
+
+		output, err := tagger.Tag(context.TODO(), input, AudioFormatM4A, &AudioTags{CoverURL: coverServer.URL + "/missing.jpg"})
+		require.NoError(t, err)
+
+		args, err := ioutil.ReadFile(output)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"-y", "-i", input, "-map", "0:a", "-c:a", "copy", "-f", "mp4", output,
+		}, strings.Split(strings.TrimSpace(string(args)), "\n"))
+	})
+
+	t.Run("Tag failed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		tagger := NewFFmpegTagger(mock.NewMockLogger(ctrl), filepath.Join(dirName, "does-not-exist"), http.DefaultClient)
+		output, err := tagger.Tag(context.TODO(), filepath.Join(dirName, "input"), AudioFormatM4A, &AudioTags{})
+		require.Error(t, err)
+		require.Empty(t, output)
+
+		output, err = tagger.Tag(context.TODO(), filepath.Join(dirName, "input"), "flac", &AudioTags{})
+		require.Error(t, err)
+		require.Empty(t, output)
+	})
+
+	t.Run("downloadVideo saves the tagged audio", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := &streamingDataSaver{mock.NewMockDataSaver(ctrl)}
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+		sv.SetFormatPolicy(&FormatPolicy{Mode: FormatModeBest, AudioOnly: true, AudioFormat: AudioFormatOpus})
+		sv.SetAudioTagger(NewFFmpegTagger(logger, ffmpeg, http.DefaultClient))
+		// converted audio is never streamed
+		sv.SetStreaming(true)
+		require.Equal(t, AudioFormatOpus, sv.audioExtension())
+
+		var saved []byte
+		dataSaver.EXPECT().Exists(gomock.Any(), "audio.opus").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "audio.opus", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			saved, err = ioutil.ReadAll(r)
+			return int64(len(saved)), err
+		})
+
+		err = sv.downloadVideo(context.TODO(), "audio.opus", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "1080", "mp4", false, dataSaver, &AudioTags{Title: "title"}, false)
+		require.NoError(t, err)
+		require.Contains(t, string(saved), "-f\nopus\n-metadata\ntitle=title\n")
+	})
+}
@@ -0,0 +1,102 @@
+package savevideo
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultLeaseTTL = time.Minute
+)
+
+// DownloadLeaser coordinates which replica downloads a video, Acquire returns false if another owner holds the lease
+type DownloadLeaser interface {
+	Acquire(ctx context.Context, videoID string, ttl time.Duration) (bool, error)
+	Renew(ctx context.Context, videoID string, ttl time.Duration) error
+	Release(ctx context.Context, videoID string) error
+}
+
+// claimVideo atomically marks the video as downloading by this instance and, if a leaser is set, by this replica.
+// The video must be handled under claimCtx, it is cancelled once the lease can't be renewed so another replica
+// that takes over doesn't download the video at the same time.
+// The returned release must be called once the video is handled, it is nil if the video was not claimed.
+func (s *SaveVideo) claimVideo(ctx context.Context, videoID string) (claimCtx context.Context, release func(), claimed bool, err error) {
+	s.downloadingVideoLock.Lock()
+	if _, ok := s.downloadingVideo[videoID]; ok {
+		s.downloadingVideoLock.Unlock()
+		return
+	}
+	s.downloadingVideo[videoID] = DownloadingVideoStatus
+	s.downloadingVideoLock.Unlock()
+
+	releaseLocal := func() {
+		s.downloadingVideoLock.Lock()
+		delete(s.downloadingVideo, videoID)
+		s.downloadingVideoLock.Unlock()
+	}
+
+	if s.leaser == nil {
+		claimCtx = ctx
+		release = releaseLocal
+		claimed = true
+		return
+	}
+
+	claimed, err = s.leaser.Acquire(ctx, videoID, s.leaseTTL)
+	if err != nil || !claimed {
+		claimed = false
+		releaseLocal()
+		return
+	}
+
+	// keep renewing the lease because downloads, especially live streams, can outlast the ttl
+	claimCtx, cancel := context.WithCancel(ctx)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := s.leaser.Renew(ctx, videoID, s.leaseTTL)
+				if err != nil {
+					s.logger.Errorf("Failed to renew download lease of video %s, stopping its download: %v", videoID, err)
+					cancel()
+					return
+				}
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	release = func() {
+		close(stop)
+		<-done
+		cancel()
+
+		// ctx might already be done, the lease must still be given back
+		err := s.leaser.Release(context.Background(), videoID)
+		if err != nil {
+			s.logger.Errorf("Failed to release download lease of video %s: %v", videoID, err)
+		}
+		releaseLocal()
+	}
+
+	return
+}
+
+// SetDownloadLeaser because coordinating replicas is optional, it doesn't have to be present at constructor function
+func (s *SaveVideo) SetDownloadLeaser(leaser DownloadLeaser, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	s.leaser = leaser
+	s.leaseTTL = ttl
+}
@@ -0,0 +1,164 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+func TestClaimVideo(t *testing.T) {
+	videoID := "dQw4w9WgXcQ"
+
+	t.Run("concurrent claims of the same video only succeed once", func(t *testing.T) {
+		sv := &SaveVideo{}
+		sv.downloadingVideo = make(map[string]bool, 8)
+
+		var wg sync.WaitGroup
+		var claimedCount int32
+		start := make(chan struct{})
+		releases := make(chan func(), 64)
+		for i := 0; i < 64; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+
+				_, release, claimed, err := sv.claimVideo(context.TODO(), videoID)
+				require.NoError(t, err)
+				if claimed {
+					atomic.AddInt32(&claimedCount, 1)
+					releases <- release
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(releases)
+
+		require.Equal(t, int32(1), claimedCount)
+
+		for release := range releases {
+			release()
+		}
+
+		_, release, claimed, err := sv.claimVideo(context.TODO(), videoID)
+		require.NoError(t, err)
+		require.True(t, claimed)
+		release()
+	})
+
+	t.Run("leaser denies the claim", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		leaser := mock.NewMockDownloadLeaser(ctrl)
+
+		sv := &SaveVideo{}
+		sv.downloadingVideo = make(map[string]bool, 8)
+		sv.SetDownloadLeaser(leaser, 0)
+		require.Equal(t, DefaultLeaseTTL, sv.leaseTTL)
+
+		leaser.EXPECT().Acquire(gomock.Any(), videoID, DefaultLeaseTTL).Return(false, nil)
+
+		_, release, claimed, err := sv.claimVideo(context.TODO(), videoID)
+		require.NoError(t, err)
+		require.False(t, claimed)
+		require.Nil(t, release)
+		require.Empty(t, sv.downloadingVideo)
+	})
+
+	t.Run("leaser error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		leaser := mock.NewMockDownloadLeaser(ctrl)
+
+		sv := &SaveVideo{}
+		sv.downloadingVideo = make(map[string]bool, 8)
+		sv.SetDownloadLeaser(leaser, time.Minute)
+
+		leaser.EXPECT().Acquire(gomock.Any(), videoID, time.Minute).Return(false, fmt.Errorf("error"))
+
+		_, release, claimed, err := sv.claimVideo(context.TODO(), videoID)
+		require.Error(t, err)
+		require.False(t, claimed)
+		require.Nil(t, release)
+		require.Empty(t, sv.downloadingVideo)
+	})
+
+	t.Run("leaser renews until released", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		leaser := mock.NewMockDownloadLeaser(ctrl)
+
+		sv := &SaveVideo{}
+		sv.downloadingVideo = make(map[string]bool, 8)
+		ttl := 30 * time.Millisecond
+		sv.SetDownloadLeaser(leaser, ttl)
+
+		renewed := make(chan struct{}, 16)
+		leaser.EXPECT().Acquire(gomock.Any(), videoID, ttl).Return(true, nil)
+		leaser.EXPECT().Renew(gomock.Any(), videoID, ttl).DoAndReturn(func(ctx context.Context, videoID string, ttl time.Duration) error {
+			renewed <- struct{}{}
+			return nil
+		}).MinTimes(1)
+		leaser.EXPECT().Release(gomock.Any(), videoID).Return(nil)
+
+		_, release, claimed, err := sv.claimVideo(context.TODO(), videoID)
+		require.NoError(t, err)
+		require.True(t, claimed)
+
+		select {
+		case <-renewed:
+		case <-time.After(time.Second):
+			t.Fatal("lease was not renewed")
+		}
+
+		release()
+		require.Empty(t, sv.downloadingVideo)
+	})
+
+	t.Run("leaser failed renew cancels the claim", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		leaser := mock.NewMockDownloadLeaser(ctrl)
+		logger := mock.NewMockLogger(ctrl)
+
+		sv := &SaveVideo{}
+		sv.logger = logger
+		sv.downloadingVideo = make(map[string]bool, 8)
+		ttl := 30 * time.Millisecond
+		sv.SetDownloadLeaser(leaser, ttl)
+
+		leaser.EXPECT().Acquire(gomock.Any(), videoID, ttl).Return(true, nil)
+		leaser.EXPECT().Renew(gomock.Any(), videoID, ttl).Return(fmt.Errorf("lease lost"))
+		leaser.EXPECT().Release(gomock.Any(), videoID).Return(nil)
+		logger.EXPECT().Errorf(
+			gomock.AssignableToTypeOf("failed to renew"),
+			videoID,
+			gomock.Any(),
+		)
+
+		claimCtx, release, claimed, err := sv.claimVideo(context.TODO(), videoID)
+		require.NoError(t, err)
+		require.True(t, claimed)
+
+		select {
+		case <-claimCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("claim was not cancelled")
+		}
+
+		release()
+		require.Empty(t, sv.downloadingVideo)
+	})
+}
@@ -0,0 +1,162 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DownloaderYoutubeDL = "youtube-dl"
+	DownloaderYTDLP     = "yt-dlp"
+	DownloaderTemplate  = "template"
+
+	DefaultCommandTemplateName = "ytfeed-savevideo-command"
+)
+
+var (
+	ErrEmptyCommandTemplate = errors.New("empty command template")
+)
+
+// Downloader creates the command that downloads url in format into tmpFilePath
+type Downloader interface {
+	Command(ctx context.Context, tmpFilePath, url string, format Format) (*exec.Cmd, error)
+}
+
+// CommandTemplateData is the data available to a command template
+type CommandTemplateData struct {
+	Output            string
+	URL               string
+	Quality           string
+	Extension         string
+	IsLive            bool
+	AudioOnly         bool
+	Format            string
+	MergeOutputFormat string
+	// SubtitleLanguages are comma separated, empty if no subtitles are requested
+	SubtitleLanguages string
+	AutoSubtitles     bool
+	EmbedSubtitles    bool
+}
+
+// TemplateDownloader runs an arbitrary command built from a template.
+// The template is split on whitespace outside of actions first and each field is rendered on its own,
+// so a rendered value containing spaces stays a single argument. A field rendering to empty is dropped.
+type TemplateDownloader struct {
+	binary string
+	args   []*template.Template
+}
+
+func (t *TemplateDownloader) Command(ctx context.Context, tmpFilePath, url string, format Format) (cmd *exec.Cmd, err error) {
+	data := CommandTemplateData{}
+	data.Output = tmpFilePath
+	data.URL = url
+	data.Quality = format.Quality
+	data.Extension = format.Extension
+	data.IsLive = format.IsLive
+	data.AudioOnly = format.AudioOnly
+	data.Format = format.Selector
+	data.MergeOutputFormat = format.MergeOutputFormat
+	data.SubtitleLanguages = strings.Join(format.SubtitleLanguages, SubtitleLanguageSeparator)
+	data.AutoSubtitles = format.AutoSubtitles
+	data.EmbedSubtitles = format.EmbedSubtitles
+
+	args := make([]string, 0, len(t.args))
+	for _, tpl := range t.args {
+		arg := bytes.NewBuffer(nil)
+		err = tpl.Execute(arg, data)
+		if err != nil {
+			err = errors.Wrap(err, "failed to render command template")
+			return
+		}
+		if arg.Len() == 0 {
+			continue
+		}
+		args = append(args, arg.String())
+	}
+
+	cmd = exec.CommandContext(ctx, t.binary, args...)
+
+	return
+}
+
+// NewTemplateDownloader parses commandTemplate, e.g. `yt-dlp -f {{.Format}} -o {{.Output}} {{.URL}}`
+func NewTemplateDownloader(commandTemplate string) (t *TemplateDownloader, err error) {
+	fields := splitCommandTemplate(commandTemplate)
+	if len(fields) == 0 {
+		err = ErrEmptyCommandTemplate
+		return
+	}
+
+	t = &TemplateDownloader{}
+	t.binary = fields[0]
+	t.args = make([]*template.Template, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		var tpl *template.Template
+		tpl, err = template.New(DefaultCommandTemplateName).Parse(field)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse command template field %s", field)
+			t = nil
+			return
+		}
+		t.args = append(t.args, tpl)
+	}
+
+	return
+}
+
+// splitCommandTemplate splits on whitespace like strings.Fields but keeps {{ }} actions whole
+func splitCommandTemplate(commandTemplate string) (fields []string) {
+	field := strings.Builder{}
+	depth := 0
+	for i := 0; i < len(commandTemplate); i++ {
+		switch {
+		case strings.HasPrefix(commandTemplate[i:], "{{"):
+			depth++
+			field.WriteString("{{")
+			i++
+		case strings.HasPrefix(commandTemplate[i:], "}}") && depth > 0:
+			depth--
+			field.WriteString("}}")
+			i++
+		case depth == 0 && unicode.IsSpace(rune(commandTemplate[i])):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteByte(commandTemplate[i])
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return
+}
+
+// NewDownloader returns the downloader by name, binary overrides the default binary of youtube-dl and yt-dlp
+func NewDownloader(name, binary, commandTemplate string) (d Downloader, err error) {
+	switch name {
+	case DownloaderYoutubeDL:
+		d = NewYoutubeDL(binary)
+	case DownloaderYTDLP:
+		d = NewYTDLP(binary)
+	case DownloaderTemplate:
+		var t *TemplateDownloader
+		t, err = NewTemplateDownloader(commandTemplate)
+		if err != nil {
+			return
+		}
+		d = t
+	default:
+		err = errors.Errorf("unknown downloader %s", name)
+	}
+
+	return
+}
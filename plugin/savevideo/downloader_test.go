@@ -0,0 +1,155 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+// fakeDownloaderScript mimics youtube-dl without network, it writes the url into the -o file, or stdout if it is "-",
+// and fails if the url contains "fail"
+const fakeDownloaderScript = `#!/bin/sh
+out=""
+url=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-o) out="$2"; shift 2 ;;
+		-f|--merge-output-format) shift 2 ;;
+		*) url="$1"; shift ;;
+	esac
+done
+if [ "$out" = "-" ]; then
+	printf 'fake video %s' "$url"
+else
+	printf 'fake video %s' "$url" > "$out"
+fi
+case "$url" in
+	*fail*) echo "ERROR: unable to download $url" >&2; exit 1 ;;
+esac
+`
+
+// writeFakeDownloader writes script as an executable into dir and returns its path
+func writeFakeDownloader(t *testing.T, dir, script string) string {
+	binary := filepath.Join(dir, "fake-youtube-dl")
+	err := ioutil.WriteFile(binary, []byte(script), 0755)
+	require.NoError(t, err)
+
+	return binary
+}
+
+func TestDownloader(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	policy := &FormatPolicy{Mode: FormatModeExact}
+
+	t.Run("NewDownloader", func(t *testing.T) {
+		d, err := NewDownloader(DownloaderYoutubeDL, "", "")
+		require.NoError(t, err)
+		require.Equal(t, YoutubeDLCommand, d.(*YoutubeDL).binary)
+
+		d, err = NewDownloader(DownloaderYTDLP, "", "")
+		require.NoError(t, err)
+		require.Equal(t, YTDLPCommand, d.(*YoutubeDL).binary)
+
+		d, err = NewDownloader(DownloaderTemplate, "", "yt-dlp -o {{.Output}} {{.URL}}")
+		require.NoError(t, err)
+		require.IsType(t, &TemplateDownloader{}, d)
+
+		d, err = NewDownloader(DownloaderTemplate, "", "")
+		require.Equal(t, ErrEmptyCommandTemplate, err)
+		require.Nil(t, d)
+
+		d, err = NewDownloader("invalid", "", "")
+		require.Error(t, err)
+		require.Nil(t, d)
+	})
+
+	t.Run("TemplateDownloader success", func(t *testing.T) {
+		d, err := NewTemplateDownloader("yt-dlp --no-part -f {{.Format}} {{if .MergeOutputFormat}}--merge-output-format{{end}} {{.MergeOutputFormat}} -o {{.Output}} {{.URL}}")
+		require.NoError(t, err)
+
+		format, err := policy.Format("1080", "mp4", false)
+		require.NoError(t, err)
+		x, err := d.Command(context.TODO(), "./my video", url, format)
+		require.NoError(t, err)
+		expectedArgs := []string{"yt-dlp", "--no-part", "-f", "bestvideo[ext=mp4][height=1080]+bestaudio[ext=m4a]", "--merge-output-format", "mp4", "-o", "./my video", url}
+		require.Equal(t, expectedArgs, x.Args)
+
+		format, err = policy.Format("1080", "mp4", true)
+		require.NoError(t, err)
+		x, err = d.Command(context.TODO(), "./my video", url, format)
+		require.NoError(t, err)
+		expectedArgs = []string{"yt-dlp", "--no-part", "-f", "[height=1080]", "-o", "./my video", url}
+		require.Equal(t, expectedArgs, x.Args)
+	})
+
+	t.Run("TemplateDownloader failed", func(t *testing.T) {
+		d, err := NewTemplateDownloader("yt-dlp {{.Output")
+		require.Error(t, err)
+		require.Nil(t, d)
+
+		d, err = NewTemplateDownloader("yt-dlp {{.Missing}}")
+		require.NoError(t, err)
+		_, err = d.Command(context.TODO(), "./", url, Format{})
+		require.Error(t, err)
+	})
+
+	t.Run("DownloadVideo with fake binary", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dirName, err := ioutil.TempDir("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dirName)
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+
+		var saved []byte
+		dataSaver.EXPECT().Exists(gomock.Any(), "channel/video.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "channel/video.mp4", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			saved, err = ioutil.ReadAll(r)
+			return int64(len(saved)), err
+		})
+
+		err = sv.DownloadVideo(context.TODO(), "channel/video.mp4", url, "1080", "mp4", false, dataSaver)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("fake video %s", url), string(saved))
+
+		_, err = os.Stat(filepath.Join(dirName, "channel/video.mp4"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("DownloadVideo with failing fake binary", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dirName, err := ioutil.TempDir("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dirName)
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYTDLP(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+
+		dataSaver.EXPECT().Exists(gomock.Any(), "video.mp4").Return(false, nil)
+
+		err = sv.DownloadVideo(context.TODO(), "video.mp4", url+"&fail", "1080", "mp4", false, dataSaver)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "ERROR: unable to download")
+	})
+}
@@ -0,0 +1,157 @@
+package savevideo
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+const (
+	PriorityRegular = 0
+	PriorityLive    = 1
+)
+
+type DownloadStats struct {
+	Queued            int            `json:"queued"`
+	Running           int            `json:"running"`
+	QueuedPerChannel  map[string]int `json:"queued_per_channel"`
+	RunningPerChannel map[string]int `json:"running_per_channel"`
+}
+
+type downloadRequest struct {
+	channelID string
+	priority  int
+	seq       uint64
+	granted   bool
+	ready     chan struct{}
+}
+
+// downloadQueue hands out download slots by priority, then by arrival, while keeping
+// the number of running downloads under the global and per channel limits. Zero means unlimited.
+type downloadQueue struct {
+	maxConcurrent     int
+	maxPerChannel     int
+	running           int
+	runningPerChannel map[string]int
+	waiting           []*downloadRequest
+	seq               uint64
+	lock              sync.Mutex
+}
+
+func newDownloadQueue(maxConcurrent, maxPerChannel int) (q *downloadQueue) {
+	q = &downloadQueue{}
+	q.maxConcurrent = maxConcurrent
+	q.maxPerChannel = maxPerChannel
+	q.runningPerChannel = make(map[string]int, 8)
+	q.waiting = make([]*downloadRequest, 0, 8)
+
+	return
+}
+
+// acquire blocks until a slot is available or ctx is done, the returned release must be called once the download finished
+func (q *downloadQueue) acquire(ctx context.Context, channelID string, priority int) (release func(), err error) {
+	q.lock.Lock()
+	q.seq++
+	req := &downloadRequest{
+		channelID: channelID,
+		priority:  priority,
+		seq:       q.seq,
+		ready:     make(chan struct{}),
+	}
+	// keep waiting sorted, higher priority first then first come first served
+	i := sort.Search(len(q.waiting), func(i int) bool {
+		return q.waiting[i].priority < req.priority
+	})
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = req
+	q.schedule()
+	q.lock.Unlock()
+
+	select {
+	case <-req.ready:
+		release = func() {
+			q.lock.Lock()
+			defer q.lock.Unlock()
+
+			q.release(req)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// granted at the same time ctx is done, give the slot back
+	if req.granted {
+		q.release(req)
+	} else {
+		q.remove(req)
+	}
+	err = ctx.Err()
+
+	return
+}
+
+// release must be called with lock held
+func (q *downloadQueue) release(req *downloadRequest) {
+	q.running--
+	q.runningPerChannel[req.channelID]--
+	if q.runningPerChannel[req.channelID] <= 0 {
+		delete(q.runningPerChannel, req.channelID)
+	}
+	q.schedule()
+}
+
+// schedule must be called with lock held
+func (q *downloadQueue) schedule() {
+	waiting := q.waiting[:0]
+	for _, req := range q.waiting {
+		if q.maxConcurrent > 0 && q.running >= q.maxConcurrent {
+			waiting = append(waiting, req)
+			continue
+		}
+		if q.maxPerChannel > 0 && q.runningPerChannel[req.channelID] >= q.maxPerChannel {
+			waiting = append(waiting, req)
+			continue
+		}
+
+		q.running++
+		q.runningPerChannel[req.channelID]++
+		req.granted = true
+		close(req.ready)
+	}
+	for i := len(waiting); i < len(q.waiting); i++ {
+		q.waiting[i] = nil
+	}
+	q.waiting = waiting
+}
+
+// remove must be called with lock held
+func (q *downloadQueue) remove(req *downloadRequest) {
+	for i, r := range q.waiting {
+		if r == req {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *downloadQueue) stats() (st DownloadStats) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	st.Queued = len(q.waiting)
+	st.Running = q.running
+	st.QueuedPerChannel = make(map[string]int, len(q.waiting))
+	for _, req := range q.waiting {
+		st.QueuedPerChannel[req.channelID]++
+	}
+	st.RunningPerChannel = make(map[string]int, len(q.runningPerChannel))
+	for k, v := range q.runningPerChannel {
+		st.RunningPerChannel[k] = v
+	}
+
+	return
+}
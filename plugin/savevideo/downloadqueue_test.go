@@ -0,0 +1,112 @@
+package savevideo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadQueue(t *testing.T) {
+	t.Run("unlimited grants immediately", func(t *testing.T) {
+		q := newDownloadQueue(0, 0)
+
+		releases := make([]func(), 0, 3)
+		for i := 0; i < 3; i++ {
+			release, err := q.acquire(context.TODO(), "channel", PriorityRegular)
+			require.NoError(t, err)
+			releases = append(releases, release)
+		}
+
+		st := q.stats()
+		require.Equal(t, 3, st.Running)
+		require.Equal(t, 0, st.Queued)
+		require.Equal(t, 3, st.RunningPerChannel["channel"])
+
+		for _, release := range releases {
+			release()
+		}
+		st = q.stats()
+		require.Equal(t, 0, st.Running)
+		require.Empty(t, st.RunningPerChannel)
+	})
+
+	t.Run("global limit queues and live goes first", func(t *testing.T) {
+		q := newDownloadQueue(1, 0)
+
+		release, err := q.acquire(context.TODO(), "a", PriorityRegular)
+		require.NoError(t, err)
+
+		order := make(chan string, 2)
+		waitFor := func(name, channelID string, priority int) {
+			release, err := q.acquire(context.TODO(), channelID, priority)
+			require.NoError(t, err)
+			order <- name
+			release()
+		}
+
+		go waitFor("regular", "b", PriorityRegular)
+		require.Eventually(t, func() bool { return q.stats().Queued == 1 }, time.Second, time.Millisecond)
+		go waitFor("live", "c", PriorityLive)
+		require.Eventually(t, func() bool { return q.stats().Queued == 2 }, time.Second, time.Millisecond)
+
+		st := q.stats()
+		require.Equal(t, 1, st.Running)
+		require.Equal(t, 1, st.QueuedPerChannel["b"])
+		require.Equal(t, 1, st.QueuedPerChannel["c"])
+
+		release()
+		require.Equal(t, "live", <-order)
+		require.Equal(t, "regular", <-order)
+	})
+
+	t.Run("per channel limit does not block other channels", func(t *testing.T) {
+		q := newDownloadQueue(0, 1)
+
+		release, err := q.acquire(context.TODO(), "a", PriorityRegular)
+		require.NoError(t, err)
+		defer release()
+
+		releaseOther, err := q.acquire(context.TODO(), "b", PriorityRegular)
+		require.NoError(t, err)
+		defer releaseOther()
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+		defer cancel()
+
+		releaseSame, err := q.acquire(ctx, "a", PriorityLive)
+		require.Error(t, err)
+		require.Nil(t, releaseSame)
+
+		st := q.stats()
+		require.Equal(t, 2, st.Running)
+		require.Equal(t, 0, st.Queued)
+	})
+
+	t.Run("raising the limit grants queued requests", func(t *testing.T) {
+		sv := &SaveVideo{}
+		sv.downloadQueue = newDownloadQueue(0, 0)
+		sv.SetDownloadLimits(1, 0)
+
+		release, err := sv.downloadQueue.acquire(context.TODO(), "a", PriorityRegular)
+		require.NoError(t, err)
+		defer release()
+
+		granted := make(chan func(), 1)
+		go func() {
+			release, err := sv.downloadQueue.acquire(context.TODO(), "a", PriorityRegular)
+			require.NoError(t, err)
+			granted <- release
+		}()
+		require.Eventually(t, func() bool { return sv.DownloadStats().Queued == 1 }, time.Second, time.Millisecond)
+
+		sv.SetDownloadLimits(2, 0)
+		select {
+		case release := <-granted:
+			release()
+		case <-time.After(time.Second):
+			t.Fatal("queued request was not granted")
+		}
+	})
+}
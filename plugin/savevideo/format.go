@@ -0,0 +1,221 @@
+package savevideo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FormatModeExact only downloads the exact quality, it fails if that height isn't available
+	FormatModeExact = "exact"
+	// FormatModeBest downloads the best quality up to the configured height
+	FormatModeBest = "best"
+
+	CodecAV1 = "av1"
+	CodecVP9 = "vp9"
+	CodecAVC = "avc"
+
+	ErrInvalidFormatMode = "invalid format mode: %s"
+	ErrInvalidCodec      = "invalid codec: %s"
+	ErrInvalidMaxFPS     = "invalid max fps: %d"
+
+	formatSeparator = "/"
+)
+
+var (
+	// vcodec prefixes as reported by youtube-dl and yt-dlp, e.g. vp9 and vp09.00.51.08
+	codecPrefixes = map[string][]string{
+		CodecAV1: {"av01"},
+		CodecVP9: {"vp9", "vp09"},
+		CodecAVC: {"avc1"},
+	}
+)
+
+// FormatPolicy decides which format youtube-dl downloads given the quality and extension
+type FormatPolicy struct {
+	// Mode is either FormatModeExact or FormatModeBest
+	Mode string
+	// MaxFPS limits the frame rate, 0 means any
+	MaxFPS int
+	// Codecs is the video codec preference, tried in order, empty means any codec in the extension's container
+	Codecs []string
+	// Fallback falls back to any codec, then a single file format and finally the best format if nothing else is available
+	Fallback bool
+	// AudioOnly downloads only the audio
+	AudioOnly bool
+	// AudioFormat is the format audio is converted to when AudioOnly, empty keeps the downloaded audio as is
+	AudioFormat string
+}
+
+// Format is the youtube-dl format selection for a download
+type Format struct {
+	Selector          string
+	MergeOutputFormat string
+	Quality           string
+	Extension         string
+	IsLive            bool
+	AudioOnly         bool
+	AudioFormat       string
+	SubtitleLanguages []string
+	AutoSubtitles     bool
+	EmbedSubtitles    bool
+}
+
+// Validate returns an error if the policy can't build a format
+func (p *FormatPolicy) Validate() (err error) {
+	switch p.Mode {
+	case FormatModeExact:
+	case FormatModeBest:
+	default:
+		err = fmt.Errorf(ErrInvalidFormatMode, p.Mode)
+		return
+	}
+
+	if p.MaxFPS < 0 {
+		err = fmt.Errorf(ErrInvalidMaxFPS, p.MaxFPS)
+		return
+	}
+
+	switch p.AudioFormat {
+	case "":
+	case AudioFormatM4A:
+	case AudioFormatOpus:
+	case AudioFormatMP3:
+	default:
+		err = fmt.Errorf(ErrInvalidAudioFormat, p.AudioFormat)
+		return
+	}
+
+	for _, codec := range p.Codecs {
+		_, ok := codecPrefixes[codec]
+		if !ok {
+			err = fmt.Errorf(ErrInvalidCodec, codec)
+			return
+		}
+	}
+
+	return
+}
+
+// Format builds the format selection for quality, ext and whether the video is a live stream
+func (p *FormatPolicy) Format(quality, ext string, isLive bool) (f Format, err error) {
+	err = p.Validate()
+	if err != nil {
+		return
+	}
+
+	var videoExt, audioExt string
+	switch ext {
+	case ExtensionMP4:
+		videoExt = ExtensionMP4
+		audioExt = AudioM4A
+	case ExtensionWebm:
+		fallthrough
+	case ExtensionMKV:
+		videoExt = ExtensionWebm
+		audioExt = AudioWebm
+	default:
+		err = fmt.Errorf(ErrInvalidVideoExtension, ext)
+		return
+	}
+
+	switch quality {
+	case Quality2160:
+	case Quality1440:
+	case Quality1080:
+	case Quality720:
+	case Quality640:
+	case Quality480:
+	case Quality360:
+	case Quality240:
+	case Quality144:
+	default:
+		err = fmt.Errorf(ErrInvalidVideoQuality, quality)
+		return
+	}
+
+	f.Quality = quality
+	f.Extension = ext
+	f.IsLive = isLive
+	f.AudioOnly = p.AudioOnly
+
+	heightFilter := fmt.Sprintf("[height<=%s]", quality)
+	if p.Mode == FormatModeExact {
+		heightFilter = fmt.Sprintf("[height=%s]", quality)
+	}
+	fpsFilter := ""
+	if p.MaxFPS > 0 {
+		fpsFilter = fmt.Sprintf("[fps<=%d]", p.MaxFPS)
+	}
+
+	selectors := make([]string, 0, 4)
+	switch {
+	case p.AudioOnly:
+		f.AudioFormat = p.AudioFormat
+		switch p.AudioFormat {
+		case AudioFormatM4A:
+			selectors = append(selectors, "bestaudio[ext=m4a]")
+		case AudioFormatOpus:
+			selectors = append(selectors, "bestaudio[acodec=opus]")
+		case AudioFormatMP3:
+			// converted by the audio tagger anyway
+			selectors = append(selectors, "bestaudio")
+		default:
+			selectors = append(selectors, fmt.Sprintf("bestaudio[ext=%s]", audioExt))
+		}
+		if p.Fallback && p.AudioFormat != AudioFormatMP3 {
+			selectors = append(selectors, "bestaudio")
+		}
+	case isLive:
+		// live streams are a single format containing both video and audio
+		if p.Mode == FormatModeExact {
+			selectors = append(selectors, fmt.Sprintf(FormatArgValueLiveFormat, quality)+fpsFilter)
+		} else {
+			selectors = append(selectors, "best"+heightFilter+fpsFilter)
+		}
+		if p.Fallback {
+			selectors = append(selectors, "best")
+		}
+	default:
+		f.MergeOutputFormat = ext
+		audio := fmt.Sprintf("bestaudio[ext=%s]", audioExt)
+		if len(p.Codecs) == 0 {
+			selectors = append(selectors, fmt.Sprintf("bestvideo[ext=%s]%s%s+%s", videoExt, heightFilter, fpsFilter, audio))
+		}
+		for _, codec := range p.Codecs {
+			for _, prefix := range codecPrefixes[codec] {
+				selectors = append(selectors, fmt.Sprintf("bestvideo[vcodec^=%s]%s%s+%s", prefix, heightFilter, fpsFilter, audio))
+			}
+		}
+		if p.Fallback {
+			selectors = append(selectors, fmt.Sprintf("bestvideo[height<=%s]+bestaudio", quality))
+			selectors = append(selectors, fmt.Sprintf("best[height<=%s]", quality))
+			selectors = append(selectors, "best")
+		}
+	}
+	f.Selector = strings.Join(selectors, formatSeparator)
+
+	return
+}
+
+// NewFormatPolicy returns a validated policy
+func NewFormatPolicy(mode string, maxFPS int, codecs []string, fallback, audioOnly bool, audioFormat string) (p *FormatPolicy, err error) {
+	p = &FormatPolicy{}
+	p.Mode = mode
+	p.MaxFPS = maxFPS
+	p.Codecs = codecs
+	p.Fallback = fallback
+	p.AudioOnly = audioOnly
+	p.AudioFormat = audioFormat
+
+	err = p.Validate()
+	if err != nil {
+		err = errors.Wrap(err, "invalid format policy")
+		p = nil
+		return
+	}
+
+	return
+}
@@ -0,0 +1,192 @@
+package savevideo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatPolicy(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	tests := []struct {
+		name         string
+		policy       FormatPolicy
+		quality      string
+		ext          string
+		isLive       bool
+		expectedArgs []string
+		expectError  bool
+	}{
+		{
+			name:         "exact is the legacy format",
+			policy:       FormatPolicy{Mode: FormatModeExact},
+			quality:      "1080",
+			ext:          "mp4",
+			expectedArgs: []string{"-f", "bestvideo[ext=mp4][height=1080]+bestaudio[ext=m4a]", "--merge-output-format", "mp4"},
+		},
+		{
+			name:         "exact live is the legacy format",
+			policy:       FormatPolicy{Mode: FormatModeExact},
+			quality:      "720",
+			ext:          "webm",
+			isLive:       true,
+			expectedArgs: []string{"-f", "[height=720]"},
+		},
+		{
+			name:         "best up to 2160",
+			policy:       FormatPolicy{Mode: FormatModeBest},
+			quality:      "2160",
+			ext:          "webm",
+			expectedArgs: []string{"-f", "bestvideo[ext=webm][height<=2160]+bestaudio[ext=webm]", "--merge-output-format", "webm"},
+		},
+		{
+			name:         "best up to 1440 at 60fps in mkv",
+			policy:       FormatPolicy{Mode: FormatModeBest, MaxFPS: 60},
+			quality:      "1440",
+			ext:          "mkv",
+			expectedArgs: []string{"-f", "bestvideo[ext=webm][height<=1440][fps<=60]+bestaudio[ext=webm]", "--merge-output-format", "mkv"},
+		},
+		{
+			name:         "best live at 30fps",
+			policy:       FormatPolicy{Mode: FormatModeBest, MaxFPS: 30},
+			quality:      "1080",
+			ext:          "mp4",
+			isLive:       true,
+			expectedArgs: []string{"-f", "best[height<=1080][fps<=30]"},
+		},
+		{
+			name:         "live with fallback",
+			policy:       FormatPolicy{Mode: FormatModeExact, Fallback: true},
+			quality:      "1080",
+			ext:          "mp4",
+			isLive:       true,
+			expectedArgs: []string{"-f", "[height=1080]/best"},
+		},
+		{
+			name:         "codec preference",
+			policy:       FormatPolicy{Mode: FormatModeBest, Codecs: []string{CodecAV1, CodecVP9, CodecAVC}},
+			quality:      "2160",
+			ext:          "mkv",
+			expectedArgs: []string{"-f", "bestvideo[vcodec^=av01][height<=2160]+bestaudio[ext=webm]/bestvideo[vcodec^=vp9][height<=2160]+bestaudio[ext=webm]/bestvideo[vcodec^=vp09][height<=2160]+bestaudio[ext=webm]/bestvideo[vcodec^=avc1][height<=2160]+bestaudio[ext=webm]", "--merge-output-format", "mkv"},
+		},
+		{
+			name:         "codec preference with fallback chain",
+			policy:       FormatPolicy{Mode: FormatModeExact, MaxFPS: 60, Codecs: []string{CodecAVC}, Fallback: true},
+			quality:      "1080",
+			ext:          "mp4",
+			expectedArgs: []string{"-f", "bestvideo[vcodec^=avc1][height=1080][fps<=60]+bestaudio[ext=m4a]/bestvideo[height<=1080]+bestaudio/best[height<=1080]/best", "--merge-output-format", "mp4"},
+		},
+		{
+			name:         "audio only",
+			policy:       FormatPolicy{Mode: FormatModeBest, AudioOnly: true},
+			quality:      "1080",
+			ext:          "mp4",
+			expectedArgs: []string{"-f", "bestaudio[ext=m4a]"},
+		},
+		{
+			name:         "audio only with fallback ignores live",
+			policy:       FormatPolicy{Mode: FormatModeBest, AudioOnly: true, Fallback: true},
+			quality:      "1080",
+			ext:          "webm",
+			isLive:       true,
+			expectedArgs: []string{"-f", "bestaudio[ext=webm]/bestaudio"},
+		},
+		{
+			name:         "audio only as m4a",
+			policy:       FormatPolicy{Mode: FormatModeBest, AudioOnly: true, AudioFormat: AudioFormatM4A, Fallback: true},
+			quality:      "1080",
+			ext:          "webm",
+			expectedArgs: []string{"-f", "bestaudio[ext=m4a]/bestaudio"},
+		},
+		{
+			name:         "audio only as opus",
+			policy:       FormatPolicy{Mode: FormatModeBest, AudioOnly: true, AudioFormat: AudioFormatOpus},
+			quality:      "1080",
+			ext:          "mp4",
+			expectedArgs: []string{"-f", "bestaudio[acodec=opus]"},
+		},
+		{
+			name:         "audio only as mp3",
+			policy:       FormatPolicy{Mode: FormatModeBest, AudioOnly: true, AudioFormat: AudioFormatMP3, Fallback: true},
+			quality:      "1080",
+			ext:          "mp4",
+			expectedArgs: []string{"-f", "bestaudio"},
+		},
+		{
+			name:        "invalid audio format",
+			policy:      FormatPolicy{Mode: FormatModeBest, AudioOnly: true, AudioFormat: "flac"},
+			quality:     "1080",
+			ext:         "mp4",
+			expectError: true,
+		},
+		{
+			name:        "invalid mode",
+			policy:      FormatPolicy{Mode: "worst"},
+			quality:     "1080",
+			ext:         "mp4",
+			expectError: true,
+		},
+		{
+			name:        "invalid codec",
+			policy:      FormatPolicy{Mode: FormatModeBest, Codecs: []string{"h265"}},
+			quality:     "1080",
+			ext:         "mp4",
+			expectError: true,
+		},
+		{
+			name:        "invalid max fps",
+			policy:      FormatPolicy{Mode: FormatModeBest, MaxFPS: -1},
+			quality:     "1080",
+			ext:         "mp4",
+			expectError: true,
+		},
+		{
+			name:        "invalid quality",
+			policy:      FormatPolicy{Mode: FormatModeBest},
+			quality:     "4320",
+			ext:         "mp4",
+			expectError: true,
+		},
+		{
+			name:        "invalid extension",
+			policy:      FormatPolicy{Mode: FormatModeBest},
+			quality:     "1080",
+			ext:         "avi",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			format, err := test.policy.Format(test.quality, test.ext, test.isLive)
+			if test.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.isLive, format.IsLive)
+			require.Equal(t, test.policy.AudioOnly, format.AudioOnly)
+			require.Equal(t, test.policy.AudioFormat, format.AudioFormat)
+
+			x, err := NewYoutubeDL("").Command(context.TODO(), "./", url, format)
+			require.NoError(t, err)
+
+			expectedArgs := append([]string{"youtube-dl"}, test.expectedArgs...)
+			expectedArgs = append(expectedArgs, "-o", "./", url)
+			require.Equal(t, expectedArgs, x.Args)
+		})
+	}
+
+	t.Run("NewFormatPolicy", func(t *testing.T) {
+		p, err := NewFormatPolicy(FormatModeBest, 60, []string{CodecVP9}, true, false, "")
+		require.NoError(t, err)
+		require.Equal(t, &FormatPolicy{Mode: FormatModeBest, MaxFPS: 60, Codecs: []string{CodecVP9}, Fallback: true}, p)
+
+		p, err = NewFormatPolicy(FormatModeBest, 60, []string{"mpeg2"}, true, false, "")
+		require.Error(t, err)
+		require.Nil(t, p)
+	})
+}
@@ -0,0 +1,262 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+const (
+	LiveChatRecorderNone       = "none"
+	LiveChatRecorderAPI        = "api"
+	LiveChatRecorderDownloader = "downloader"
+
+	// LiveChatSuffix is appended to the video name without extension
+	LiveChatSuffix = ".live_chat.ndjson"
+
+	// DefaultLiveChatPollInterval is used if the API doesn't return a polling interval
+	DefaultLiveChatPollInterval = 5 * time.Second
+	// DefaultLiveChatGracePeriod is how long the recorder keeps running after the live stream download ended
+	DefaultLiveChatGracePeriod = 1 * time.Minute
+
+	liveChatEndedReason = "liveChatEnded"
+
+	// yt-dlp downloads the chat as the live_chat subtitle in NDJSON
+	LiveChatSubLang    = "live_chat"
+	liveChatFileSuffix = ".live_chat.json"
+	partFileSuffix     = ".part"
+	SkipDownloadArg    = "--skip-download"
+	WriteSubsArg       = "--write-subs"
+	SubLangsArg        = "--sub-langs"
+)
+
+var (
+	ErrNoLiveChat = errors.New("video has no live chat")
+
+	liveChatParts = []string{
+		"id",
+		"snippet",
+		"authorDetails",
+	}
+)
+
+// LiveChatRecorder writes the chat messages of a live broadcast as NDJSON into w until the chat ends or ctx is done
+type LiveChatRecorder interface {
+	Record(ctx context.Context, url string, item *youtube.Video, w io.Writer) error
+}
+
+type YoutubeLiveChatLister interface {
+	List(liveChatId string, part []string) *youtube.LiveChatMessagesListCall
+}
+
+// APILiveChatRecorder polls the active live chat with the YouTube Data API, it can't record chat replays
+type APILiveChatRecorder struct {
+	lcs          YoutubeLiveChatLister
+	pollInterval time.Duration
+}
+
+func (r *APILiveChatRecorder) Record(ctx context.Context, url string, item *youtube.Video, w io.Writer) (err error) {
+	if item.LiveStreamingDetails == nil || item.LiveStreamingDetails.ActiveLiveChatId == "" {
+		err = ErrNoLiveChat
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	pageToken := ""
+	for {
+		call := r.lcs.List(item.LiveStreamingDetails.ActiveLiveChatId, liveChatParts).Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		var res *youtube.LiveChatMessageListResponse
+		res, err = call.Do()
+		if ctx.Err() != nil {
+			err = nil
+			return
+		}
+		if isLiveChatEnded(err) {
+			err = nil
+			return
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list live chat messages of %s", url)
+			return
+		}
+
+		for _, message := range res.Items {
+			err = encoder.Encode(message)
+			if err != nil {
+				err = errors.Wrap(err, "failed to write live chat message")
+				return
+			}
+		}
+		if res.OfflineAt != "" {
+			return
+		}
+		pageToken = res.NextPageToken
+
+		pollInterval := r.pollInterval
+		if res.PollingIntervalMillis > 0 {
+			pollInterval = time.Duration(res.PollingIntervalMillis) * time.Millisecond
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func isLiveChatEnded(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == liveChatEndedReason {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewAPILiveChatRecorder polls at least every pollInterval, DefaultLiveChatPollInterval is used if it is zero
+func NewAPILiveChatRecorder(lcs YoutubeLiveChatLister, pollInterval time.Duration) *APILiveChatRecorder {
+	if pollInterval <= 0 {
+		pollInterval = DefaultLiveChatPollInterval
+	}
+
+	return &APILiveChatRecorder{lcs: lcs, pollInterval: pollInterval}
+}
+
+// DownloaderLiveChatRecorder runs yt-dlp to download the live chat, it follows the chat of live broadcasts
+// and downloads the chat replay of finished ones
+type DownloaderLiveChatRecorder struct {
+	binary string
+	tmpDir string
+}
+
+func (r *DownloaderLiveChatRecorder) Record(ctx context.Context, url string, item *youtube.Video, w io.Writer) (err error) {
+	tmpDownloadDirPath, err := ioutil.TempDir(r.tmpDir, "ytfeed-live-chat-")
+	if err != nil {
+		err = errors.Wrap(err, "failed to create temporary live chat dir")
+		return
+	}
+	defer os.RemoveAll(tmpDownloadDirPath)
+
+	tmpFilePath := filepath.Join(tmpDownloadDirPath, "chat")
+	stdErrCollector := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, r.binary, SkipDownloadArg, WriteSubsArg, SubLangsArg, LiveChatSubLang, OutputArg, tmpFilePath, url)
+	cmd.Stderr = stdErrCollector
+	runErr := cmd.Run()
+
+	// a killed recorder leaves the chat it got so far in the part file
+	chat, err := os.Open(tmpFilePath + liveChatFileSuffix)
+	if os.IsNotExist(err) {
+		chat, err = os.Open(tmpFilePath + liveChatFileSuffix + partFileSuffix)
+	}
+	if os.IsNotExist(err) {
+		err = ErrNoLiveChat
+		if runErr != nil && ctx.Err() == nil {
+			err = errors.Wrapf(runErr, "failed to run live chat command args %v and stderr: %s", cmd.Args, stdErrCollector.String())
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer chat.Close()
+
+	_, err = io.Copy(w, chat)
+
+	return
+}
+
+// NewDownloaderLiveChatRecorder uses yt-dlp at binary, YTDLPCommand is used if binary is empty
+func NewDownloaderLiveChatRecorder(binary, tmpDir string) *DownloaderLiveChatRecorder {
+	if binary == "" {
+		binary = YTDLPCommand
+	}
+
+	return &DownloaderLiveChatRecorder{binary: binary, tmpDir: tmpDir}
+}
+
+// recordLiveChat starts recording the live chat into a temporary file, the returned function waits for the recorder
+// and saves the chat next to the video. A live chat gets the grace period to end after the live stream download.
+func (s *SaveVideo) recordLiveChat(ctx context.Context, videoName, url string, item *youtube.Video, isLive bool) (stop func()) {
+	stop = func() {}
+
+	tmpFile, err := ioutil.TempFile(s.tmpDir, "ytfeed-live-chat-")
+	if err != nil {
+		s.logger.Errorf("Failed to create temporary live chat file of video %s: %v", url, err)
+		return
+	}
+
+	s.logger.Infof("Recording live chat of video %s", url)
+	recordCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.liveChatRecorder.Record(recordCtx, url, item, tmpFile)
+	}()
+
+	stop = func() {
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+		defer cancel()
+
+		if isLive {
+			timer := time.AfterFunc(s.liveChatGracePeriod, cancel)
+			defer timer.Stop()
+		}
+		err := <-done
+		if err == ErrNoLiveChat {
+			s.logger.Infof("Video %s has no live chat", url)
+			return
+		}
+		if err != nil {
+			s.logger.Errorf("Failed to record live chat of video %s: %v", url, err)
+		}
+
+		var size int64
+		size, err = tmpFile.Seek(0, io.SeekCurrent)
+		if err != nil || size == 0 {
+			return
+		}
+		_, err = tmpFile.Seek(0, io.SeekStart)
+		if err != nil {
+			s.logger.Errorf("Failed to rewind live chat of video %s: %v", url, err)
+			return
+		}
+		name := sidecarBaseName(videoName) + LiveChatSuffix
+		_, err = s.dataSaver.SaveAs(ctx, name, tmpFile)
+		if err != nil {
+			s.logger.Errorf("Failed to save live chat of video %s: %v", url, err)
+			return
+		}
+		s.logger.Infof("Live chat of video %s saved as %s", url, name)
+	}
+
+	return
+}
+
+// SetLiveChatRecorder because recording live chat is optional, it doesn't have to be present at constructor function.
+// DefaultLiveChatGracePeriod is used if gracePeriod is zero.
+func (s *SaveVideo) SetLiveChatRecorder(recorder LiveChatRecorder, gracePeriod time.Duration) {
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultLiveChatGracePeriod
+	}
+	s.liveChatRecorder = recorder
+	s.liveChatGracePeriod = gracePeriod
+}
@@ -0,0 +1,183 @@
+package savevideo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/mock"
+	"google.golang.org/api/option"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+// fakeLiveChatScript mimics yt-dlp writing the live chat subtitle, there is no chat if the url contains "nochat"
+const fakeLiveChatScript = `#!/bin/sh
+out=""
+url=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-o) out="$2"; shift 2 ;;
+		--sub-langs) shift 2 ;;
+		--*) shift ;;
+		*) url="$1"; shift ;;
+	esac
+done
+case "$url" in
+	*nochat*) exit 0 ;;
+esac
+printf '{"message":1}\n{"message":2}\n' > "$out.live_chat.json"
+`
+
+type fakeLiveChatRecorder struct {
+	chat  string
+	err   error
+	block bool
+}
+
+func (f *fakeLiveChatRecorder) Record(ctx context.Context, url string, item *youtube.Video, w io.Writer) error {
+	_, err := io.WriteString(w, f.chat)
+	if err != nil {
+		return err
+	}
+	if f.block {
+		<-ctx.Done()
+	}
+
+	return f.err
+}
+
+func TestLiveChat(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	item := &youtube.Video{LiveStreamingDetails: &youtube.VideoLiveStreamingDetails{ActiveLiveChatId: "chat-id"}}
+
+	dirName, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	newService := func(t *testing.T, handler http.HandlerFunc) *youtube.Service {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+
+		yts, err := youtube.NewService(context.TODO(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+		require.NoError(t, err)
+
+		return yts
+	}
+
+	t.Run("API recorder follows pages until the chat is offline", func(t *testing.T) {
+		yts := newService(t, func(w http.ResponseWriter, req *http.Request) {
+			require.Equal(t, "chat-id", req.URL.Query().Get("liveChatId"))
+			if req.URL.Query().Get("pageToken") == "" {
+				fmt.Fprint(w, `{"items":[{"id":"1"},{"id":"2"}],"nextPageToken":"next","pollingIntervalMillis":1}`)
+				return
+			}
+			fmt.Fprint(w, `{"items":[{"id":"3"}],"offlineAt":"2020-01-01T00:00:00Z"}`)
+		})
+
+		chat := &strings.Builder{}
+		err := NewAPILiveChatRecorder(yts.LiveChatMessages, time.Hour).Record(context.TODO(), url, item, chat)
+		require.NoError(t, err)
+		require.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n{\"id\":\"3\"}\n", chat.String())
+	})
+
+	t.Run("API recorder stops when the chat ended", func(t *testing.T) {
+		yts := newService(t, func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"errors":[{"reason":"liveChatEnded"}]}}`)
+		})
+
+		err := NewAPILiveChatRecorder(yts.LiveChatMessages, 0).Record(context.TODO(), url, item, ioutil.Discard)
+		require.NoError(t, err)
+	})
+
+	t.Run("API recorder failed", func(t *testing.T) {
+		yts := newService(t, func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"errors":[{"reason":"forbidden"}]}}`)
+		})
+
+		recorder := NewAPILiveChatRecorder(yts.LiveChatMessages, 0)
+		err := recorder.Record(context.TODO(), url, item, ioutil.Discard)
+		require.Error(t, err)
+
+		err = recorder.Record(context.TODO(), url, &youtube.Video{}, ioutil.Discard)
+		require.Equal(t, ErrNoLiveChat, err)
+	})
+
+	t.Run("Downloader recorder", func(t *testing.T) {
+		binary := writeFakeDownloader(t, dirName, fakeLiveChatScript)
+		recorder := NewDownloaderLiveChatRecorder(binary, dirName)
+
+		chat := &strings.Builder{}
+		err := recorder.Record(context.TODO(), url, item, chat)
+		require.NoError(t, err)
+		require.Equal(t, "{\"message\":1}\n{\"message\":2}\n", chat.String())
+
+		err = recorder.Record(context.TODO(), url+"nochat", item, ioutil.Discard)
+		require.Equal(t, ErrNoLiveChat, err)
+
+		err = NewDownloaderLiveChatRecorder(dirName+"/does-not-exist", dirName).Record(context.TODO(), url, item, ioutil.Discard)
+		require.Error(t, err)
+		require.NotEqual(t, ErrNoLiveChat, err)
+	})
+
+	t.Run("recordLiveChat saves the chat next to the video", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		// the live chat ends 10ms after the live stream download
+		sv.SetLiveChatRecorder(&fakeLiveChatRecorder{chat: "{}\n", block: true}, 10*time.Millisecond)
+
+		var saved []byte
+		logger.EXPECT().Infof(gomock.Any(), url)
+		logger.EXPECT().Infof(gomock.Any(), url, "channel/video.live_chat.ndjson")
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "channel/video.live_chat.ndjson", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			saved, err = ioutil.ReadAll(r)
+			return int64(len(saved)), err
+		})
+
+		stop := sv.recordLiveChat(context.TODO(), "channel/video.mp4", url, item, true)
+		stop()
+		require.Equal(t, "{}\n", string(saved))
+	})
+
+	t.Run("recordLiveChat saves partial chat and skips missing chat", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Errorf(gomock.Any(), url, gomock.Any()).Times(2)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.live_chat.ndjson", gomock.Any()).Return(int64(3), nil)
+
+		sv.SetLiveChatRecorder(&fakeLiveChatRecorder{chat: "{}\n", err: errors.New("expected error")}, 0)
+		sv.recordLiveChat(context.TODO(), "video.mp4", url, item, false)()
+
+		sv.SetLiveChatRecorder(&fakeLiveChatRecorder{err: errors.New("expected error")}, 0)
+		sv.recordLiveChat(context.TODO(), "video.mp4", url, item, false)()
+
+		sv.SetLiveChatRecorder(&fakeLiveChatRecorder{err: ErrNoLiveChat}, 0)
+		sv.recordLiveChat(context.TODO(), "video.mp4", url, item, false)()
+	})
+}
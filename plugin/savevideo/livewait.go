@@ -0,0 +1,199 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/worksinmagic/ytfeed"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+const (
+	DefaultLiveWaitWindow          = 10 * time.Minute
+	DefaultLiveWaitPollInterval    = 30 * time.Second
+	DefaultLiveWaitMaxPollInterval = 5 * time.Minute
+	DefaultLiveWaitGiveUpAfter     = 12 * time.Hour
+)
+
+// LiveWaitPolicy decides how long and how often an upcoming stream is polled until it goes live
+type LiveWaitPolicy struct {
+	// Window is how long before the scheduled start polling begins, streams starting later are polled from then on
+	Window time.Duration
+	// PollInterval is the first interval between polls, it doubles after every poll up to MaxPollInterval
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+	// GiveUpAfter is how long after the scheduled start polling stops if the stream isn't live by then
+	GiveUpAfter time.Duration
+}
+
+// getVideo returns the video, item is nil if it doesn't exist anymore
+func (s *SaveVideo) getVideo(ctx context.Context, videoID string) (item *youtube.Video, err error) {
+	vlcall := s.vs.List(defaultParts)
+	vlcall = vlcall.Id(videoID)
+	vlresp, err := vlcall.Context(ctx).Do()
+	if err != nil {
+		return
+	}
+	if len(vlresp.Items) < 1 {
+		return
+	}
+	item = vlresp.Items[0]
+
+	return
+}
+
+// liveWait is what is remembered of an upcoming stream between polls
+type liveWait struct {
+	scheduledStartTime string
+	interval           time.Duration
+	// polling is set while the stream is polled in memory because there is no stream scheduler
+	polling bool
+}
+
+// waitForLive handles the video again when the upcoming stream is polled next, the wait is persisted by the stream scheduler
+// so it survives a restart. Callers that retry later are asked to give the video again, otherwise it is registered to the stream scheduler.
+func (s *SaveVideo) waitForLive(ctx context.Context, entry Entry, item *youtube.Video, d *ytfeed.Data) (err error) {
+	if item.LiveStreamingDetails == nil || item.LiveStreamingDetails.ScheduledStartTime == "" {
+		s.logger.Infof("Stream video %s was cancelled", entry.LinkURL)
+		s.forgetLiveWait(entry.VideoID)
+		return
+	}
+	scheduledStartTime := item.LiveStreamingDetails.ScheduledStartTime
+	scheduledStart, err := time.Parse(time.RFC3339, scheduledStartTime)
+	if err != nil {
+		s.logger.Errorf("Invalid scheduled start time of stream video %s: %v", entry.LinkURL, err)
+		s.forgetLiveWait(entry.VideoID)
+		err = nil
+		return
+	}
+	if time.Since(scheduledStart) > s.liveWait.GiveUpAfter {
+		s.logger.Warnf("Giving up on stream video %s scheduled at %s, it didn't go live", entry.LinkURL, scheduledStart)
+		s.forgetLiveWait(entry.VideoID)
+		return
+	}
+
+	s.logger.Infof("Upcoming stream video %s at %s", entry.LinkURL, scheduledStart)
+	far := time.Until(scheduledStart) > s.liveWait.Window
+	var runAt time.Time
+	if far {
+		runAt = scheduledStart.Add(-s.liveWait.Window)
+	} else {
+		runAt = time.Now().Add(s.nextLivePollInterval(entry, scheduledStartTime))
+	}
+
+	if ytfeed.RetriesLater(ctx) {
+		s.logger.Infof("Waiting for stream video %s scheduled at %s to go live, polling again at %s", entry.LinkURL, scheduledStart, runAt)
+		err = &ytfeed.RetryLaterError{RetryAt: runAt, Reason: fmt.Sprintf("stream video %s is upcoming", entry.LinkURL)}
+		return
+	}
+
+	if s.streamScheduler != nil {
+		s.logger.Infof("Registering video %s to scheduler to be ran at %s", entry.LinkURL, runAt)
+		err = s.streamScheduler.RegisterSchedule(runAt, d)
+		if err != nil {
+			s.logger.Errorf("Failed to register schedule for stream video %s: %v. Original message was: `%s`", entry.LinkURL, err, d.OriginalXMLMessage)
+		}
+		return
+	}
+
+	if far {
+		s.logger.Warnf("Not waiting for stream video %s scheduled at %s, there is no stream scheduler to wait until it is close", entry.LinkURL, scheduledStart)
+		s.forgetLiveWait(entry.VideoID)
+		return
+	}
+	s.pollInMemory(ctx, entry, runAt, d)
+
+	return
+}
+
+// nextLivePollInterval returns how long until the upcoming stream is polled again, the interval doubles after every poll
+// up to MaxPollInterval and is reset when the stream is rescheduled. It is only remembered in memory, after a restart polling starts over.
+func (s *SaveVideo) nextLivePollInterval(entry Entry, scheduledStartTime string) (interval time.Duration) {
+	s.liveWaitsLock.Lock()
+	defer s.liveWaitsLock.Unlock()
+
+	w, ok := s.liveWaits[entry.VideoID]
+	switch {
+	case !ok || w.interval == 0:
+		w.interval = s.liveWait.PollInterval
+	case w.scheduledStartTime != scheduledStartTime:
+		s.logger.Infof("Stream video %s was rescheduled from %s to %s", entry.LinkURL, w.scheduledStartTime, scheduledStartTime)
+		w.interval = s.liveWait.PollInterval
+	default:
+		w.interval *= 2
+		if w.interval > s.liveWait.MaxPollInterval {
+			w.interval = s.liveWait.MaxPollInterval
+		}
+	}
+	w.scheduledStartTime = scheduledStartTime
+	s.liveWaits[entry.VideoID] = w
+	interval = w.interval
+
+	return
+}
+
+// forgetLiveWait drops what is remembered of the stream once it isn't waited for anymore
+func (s *SaveVideo) forgetLiveWait(videoID string) {
+	s.liveWaitsLock.Lock()
+	defer s.liveWaitsLock.Unlock()
+
+	if !s.liveWaits[videoID].polling {
+		delete(s.liveWaits, videoID)
+	}
+}
+
+// pollInMemory handles the video again at runAt in the background, without a stream scheduler the wait is lost on restart
+func (s *SaveVideo) pollInMemory(ctx context.Context, entry Entry, runAt time.Time, d *ytfeed.Data) {
+	s.liveWaitsLock.Lock()
+	w := s.liveWaits[entry.VideoID]
+	if w.polling {
+		s.liveWaitsLock.Unlock()
+		s.logger.Infof("Already waiting for stream video %s to go live", entry.LinkURL)
+		return
+	}
+	w.polling = true
+	s.liveWaits[entry.VideoID] = w
+	s.liveWaitsLock.Unlock()
+
+	s.logger.Warnf("Waiting for stream video %s to go live in memory, polling again at %s. There is no stream scheduler to persist the wait", entry.LinkURL, runAt)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(runAt)):
+		}
+
+		s.liveWaitsLock.Lock()
+		w := s.liveWaits[entry.VideoID]
+		w.polling = false
+		s.liveWaits[entry.VideoID] = w
+		s.liveWaitsLock.Unlock()
+
+		if ctx.Err() != nil {
+			s.forgetLiveWait(entry.VideoID)
+			return
+		}
+		_ = s.HandleData(ctx, d)
+	}()
+}
+
+// SetLiveWaitPolicy because upcoming streams are polled with the default policy, it doesn't have to be present at constructor function.
+// Zero values are replaced by the defaults.
+func (s *SaveVideo) SetLiveWaitPolicy(p LiveWaitPolicy) {
+	if p.Window <= 0 {
+		p.Window = DefaultLiveWaitWindow
+	}
+	if p.PollInterval <= 0 {
+		p.PollInterval = DefaultLiveWaitPollInterval
+	}
+	if p.MaxPollInterval < p.PollInterval {
+		p.MaxPollInterval = DefaultLiveWaitMaxPollInterval
+		if p.MaxPollInterval < p.PollInterval {
+			p.MaxPollInterval = p.PollInterval
+		}
+	}
+	if p.GiveUpAfter <= 0 {
+		p.GiveUpAfter = DefaultLiveWaitGiveUpAfter
+	}
+	s.liveWait = p
+}
@@ -0,0 +1,357 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+	"google.golang.org/api/option"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+// fakeVideosService answers video list calls with the responses in order, repeating the last one
+func fakeVideosService(t *testing.T, responses ...string) *youtube.VideosService {
+	lock := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		fmt.Fprint(w, responses[0])
+		if len(responses) > 1 {
+			responses = responses[1:]
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	yts, err := youtube.NewService(context.TODO(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	return yts.Videos
+}
+
+func videoResponse(content string, scheduledStart time.Time) string {
+	return fmt.Sprintf(`{"items":[{"id":"dQw4w9WgXcQ","snippet":{"title":"title","liveBroadcastContent":"%s","publishedAt":"2020-01-01T00:00:00Z"},"liveStreamingDetails":{"scheduledStartTime":"%s"}}]}`,
+		content, scheduledStart.UTC().Format(time.RFC3339))
+}
+
+func TestLiveWait(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	entry := Entry{VideoID: "dQw4w9WgXcQ", LinkURL: url}
+	d := &ytfeed.Data{}
+	policy := LiveWaitPolicy{Window: time.Minute, PollInterval: time.Millisecond, MaxPollInterval: 2 * time.Millisecond, GiveUpAfter: time.Hour}
+
+	dirName, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	upcoming := func(scheduledStart time.Time) *youtube.Video {
+		return &youtube.Video{
+			Snippet:              &youtube.VideoSnippet{LiveBroadcastContent: LiveBroadcastContentUpcoming},
+			LiveStreamingDetails: &youtube.VideoLiveStreamingDetails{ScheduledStartTime: scheduledStart.UTC().Format(time.RFC3339)},
+		}
+	}
+
+	newSaveVideo := func(ctrl *gomock.Controller, logger *mock.MockLogger, responses ...string) *SaveVideo {
+		var vs YoutubeVideoLister
+		if len(responses) > 0 {
+			vs = fakeVideosService(t, responses...)
+		}
+		sv, err := New(logger, vs, mock.NewMockDataSaver(ctrl), dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetLiveWaitPolicy(policy)
+
+		return sv
+	}
+
+	t.Run("SetLiveWaitPolicy defaults", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sv := newSaveVideo(ctrl, mock.NewMockLogger(ctrl))
+		sv.SetLiveWaitPolicy(LiveWaitPolicy{PollInterval: time.Hour})
+		require.Equal(t, LiveWaitPolicy{
+			Window:          DefaultLiveWaitWindow,
+			PollInterval:    time.Hour,
+			MaxPollInterval: time.Hour,
+			GiveUpAfter:     DefaultLiveWaitGiveUpAfter,
+		}, sv.liveWait)
+	})
+
+	t.Run("Stream far away is registered before its window", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		scheduler := mock.NewMockStreamScheduler(ctrl)
+		sv := newSaveVideo(ctrl, logger)
+		sv.SetStreamScheduler(scheduler)
+
+		scheduledStart := time.Now().Add(time.Hour).Truncate(time.Second)
+		logger.EXPECT().Infof(gomock.Any(), url, gomock.Any()).Times(2)
+		scheduler.EXPECT().RegisterSchedule(scheduledStart.Add(-time.Minute).UTC(), d).Return(nil)
+
+		require.NoError(t, sv.waitForLive(context.TODO(), entry, upcoming(scheduledStart), d))
+	})
+
+	t.Run("Stream in the window is registered for the next poll", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		scheduler := mock.NewMockStreamScheduler(ctrl)
+		sv := newSaveVideo(ctrl, logger)
+		sv.SetStreamScheduler(scheduler)
+		sv.SetLiveWaitPolicy(LiveWaitPolicy{Window: time.Minute, PollInterval: time.Hour, GiveUpAfter: time.Hour})
+
+		logger.EXPECT().Infof(gomock.Any(), url, gomock.Any()).Times(2)
+		scheduler.EXPECT().RegisterSchedule(gomock.Any(), d).DoAndReturn(func(runAt time.Time, d *ytfeed.Data) error {
+			require.WithinDuration(t, time.Now().Add(time.Hour), runAt, time.Second)
+			return nil
+		})
+
+		require.NoError(t, sv.waitForLive(context.TODO(), entry, upcoming(time.Now()), d))
+	})
+
+	t.Run("Caller that retries later is asked to give the stream again at the next poll", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		sv := newSaveVideo(ctrl, logger)
+		sv.SetLiveWaitPolicy(LiveWaitPolicy{Window: time.Minute, PollInterval: time.Hour, MaxPollInterval: 3 * time.Hour, GiveUpAfter: time.Hour})
+
+		ctx := ytfeed.WithRetryLater(context.TODO())
+		scheduledStart := time.Now().Truncate(time.Second)
+		rescheduledStart := scheduledStart.Add(time.Second)
+		logger.EXPECT().Infof(gomock.Any(), url, scheduledStart.UTC().Format(time.RFC3339), rescheduledStart.UTC().Format(time.RFC3339))
+		logger.EXPECT().Infof(gomock.Any(), url, gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), url, gomock.Any(), gomock.Any()).AnyTimes()
+
+		retryAt := func(err error) time.Time {
+			later, ok := err.(*ytfeed.RetryLaterError)
+			require.True(t, ok)
+			return later.RetryAt
+		}
+
+		// the interval doubles after every poll up to the max
+		for _, interval := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour} {
+			err := sv.waitForLive(ctx, entry, upcoming(scheduledStart), d)
+			require.WithinDuration(t, time.Now().Add(interval), retryAt(err), time.Second)
+		}
+
+		// a reschedule resets it
+		err := sv.waitForLive(ctx, entry, upcoming(rescheduledStart), d)
+		require.WithinDuration(t, time.Now().Add(time.Hour), retryAt(err), time.Second)
+	})
+
+	t.Run("Stream is cancelled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		sv := newSaveVideo(ctrl, logger)
+
+		logger.EXPECT().Infof(gomock.Any(), url)
+
+		item := upcoming(time.Now())
+		item.LiveStreamingDetails = nil
+		require.NoError(t, sv.waitForLive(ytfeed.WithRetryLater(context.TODO()), entry, item, d))
+	})
+
+	t.Run("Give up on stream that never goes live", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		sv := newSaveVideo(ctrl, logger)
+
+		logger.EXPECT().Warnf(gomock.Any(), url, gomock.Any())
+
+		require.NoError(t, sv.waitForLive(ytfeed.WithRetryLater(context.TODO()), entry, upcoming(time.Now().Add(-2*time.Hour)), d))
+	})
+
+	t.Run("Stream far away without stream scheduler is not waited for", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		sv := newSaveVideo(ctrl, logger)
+
+		logger.EXPECT().Infof(gomock.Any(), url, gomock.Any())
+		logger.EXPECT().Warnf(gomock.Any(), url, gomock.Any())
+
+		require.NoError(t, sv.waitForLive(context.TODO(), entry, upcoming(time.Now().Add(time.Hour)), d))
+	})
+
+	t.Run("HandleData asks to be retried later until the stream is live", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+		scheduledStart := time.Now()
+
+		sv, err := New(logger, fakeVideosService(t,
+			videoResponse(LiveBroadcastContentUpcoming, scheduledStart),
+			videoResponse(LiveBroadcastContentLive, scheduledStart),
+		), dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetLiveWaitPolicy(policy)
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		dataSaver.EXPECT().Exists(gomock.Any(), "dQw4w9WgXcQ.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "dQw4w9WgXcQ.mp4", gomock.Any()).Return(int64(0), nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "dQw4w9WgXcQ.live.json", gomock.Any()).Return(int64(0), nil)
+
+		ctx := ytfeed.WithRetryLater(context.TODO())
+		data := &ytfeed.Data{}
+		data.Feed.Entry.VideoID = entry.VideoID
+		data.Feed.Entry.Link.Href = url
+		err = sv.HandleData(ctx, data)
+		_, ok := err.(*ytfeed.RetryLaterError)
+		require.True(t, ok)
+
+		require.NoError(t, sv.HandleData(ctx, data))
+		require.Empty(t, sv.liveWaits)
+	})
+
+	t.Run("DataHandler downloads the stream once it is live", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+		scheduledStart := time.Now()
+
+		sv, err := New(logger, fakeVideosService(t,
+			videoResponse(LiveBroadcastContentUpcoming, scheduledStart),
+			videoResponse(LiveBroadcastContentLive, scheduledStart),
+		), dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetLiveWaitPolicy(policy)
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Warnf(gomock.Any(), url, gomock.Any())
+		dataSaver.EXPECT().Exists(gomock.Any(), "dQw4w9WgXcQ.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "dQw4w9WgXcQ.mp4", gomock.Any()).Return(int64(0), nil)
+
+		downloaded := make(chan struct{})
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "dQw4w9WgXcQ.live.json", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			close(downloaded)
+			return 0, nil
+		})
+
+		data := &ytfeed.Data{}
+		data.Feed.Entry.VideoID = entry.VideoID
+		data.Feed.Entry.Link.Href = url
+		sv.DataHandler(context.TODO(), data)
+
+		select {
+		case <-downloaded:
+		case <-time.After(time.Second):
+			t.Fatal("stream was not downloaded once it was live")
+		}
+	})
+
+	t.Run("DataHandler returns while waiting without holding the claim", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		sv := newSaveVideo(ctrl, logger, videoResponse(LiveBroadcastContentUpcoming, time.Now()))
+		sv.SetLiveWaitPolicy(LiveWaitPolicy{Window: time.Minute, PollInterval: time.Hour, GiveUpAfter: time.Hour})
+
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Infof(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		logger.EXPECT().Warnf(gomock.Any(), url, gomock.Any())
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		data := &ytfeed.Data{}
+		data.Feed.Entry.VideoID = entry.VideoID
+		data.Feed.Entry.Link.Href = url
+		sv.DataHandler(ctx, data)
+
+		_, release, claimed, err := sv.claimVideo(context.TODO(), entry.VideoID)
+		require.NoError(t, err)
+		require.True(t, claimed)
+		release()
+
+		// another notification of the same stream doesn't start another poller
+		logger.EXPECT().Infof(gomock.Any(), url)
+		sv.DataHandler(ctx, data)
+
+		cancel()
+		require.Eventually(t, func() bool {
+			sv.liveWaitsLock.Lock()
+			defer sv.liveWaitsLock.Unlock()
+
+			return len(sv.liveWaits) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("HandleData returns the error of a failed download", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, fakeVideosService(t, videoResponse(LiveBroadcastContentNone, time.Now())), dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		logger.EXPECT().Infof(gomock.Any(), url)
+		logger.EXPECT().Errorf(gomock.Any(), url, gomock.Any(), gomock.Any())
+		dataSaver.EXPECT().Exists(gomock.Any(), "dQw4w9WgXcQ.mp4").Return(false, fmt.Errorf("error"))
+
+		data := &ytfeed.Data{}
+		data.Feed.Entry.VideoID = entry.VideoID
+		data.Feed.Entry.Link.Href = url
+		err = sv.HandleData(context.TODO(), data)
+		require.Error(t, err)
+	})
+
+	t.Run("HandleData doesn't return an error for a video that is already downloaded", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, fakeVideosService(t, videoResponse(LiveBroadcastContentNone, time.Now())), dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		logger.EXPECT().Infof(gomock.Any(), url)
+		logger.EXPECT().Errorf(gomock.Any(), url, gomock.Any(), gomock.Any())
+		dataSaver.EXPECT().Exists(gomock.Any(), "dQw4w9WgXcQ.mp4").Return(true, nil)
+
+		data := &ytfeed.Data{}
+		data.Feed.Entry.VideoID = entry.VideoID
+		data.Feed.Entry.Link.Href = url
+		err = sv.HandleData(context.TODO(), data)
+		require.NoError(t, err)
+
+		// data savers and callers may wrap it
+		require.True(t, IsErrorAlreadyExists(errors.Wrap(errors.Wrapf(ErrFileAlreadyExists, "file %s", "dQw4w9WgXcQ.mp4"), "failed to download")))
+		require.False(t, IsErrorAlreadyExists(errors.New("failed to check if file dQw4w9WgXcQ.mp4 already exists")))
+	})
+}
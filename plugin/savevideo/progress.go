@@ -0,0 +1,377 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/worksinmagic/ytfeed"
+)
+
+const (
+	DefaultProgressPublishInterval = 10 * time.Second
+	// DefaultRecentDownloads is how many finished downloads are kept for the status
+	DefaultRecentDownloads = 50
+
+	// longest partial line kept while waiting for a line separator
+	maxProgressLineLength = 4096
+)
+
+var (
+	// matches youtube-dl and yt-dlp lines like
+	// [download]  45.3% of 123.45MiB at  2.34MiB/s ETA 00:42
+	// [download]  12.0% of ~ 45.67MiB at 1.23MiB/s ETA 00:30 (frag 5/40)
+	// [download] 100% of 123.45MiB in 00:52
+	progressLineRegexp = regexp.MustCompile(`^\[download\]\s+(\d+(?:\.\d+)?)%\s+of\s+~?\s*(\d+(?:\.\d+)?[KMGTPE]?i?B)(?:\s+at\s+(\S+))?(?:\s+ETA\s+(\S+))?`)
+
+	byteUnits = map[string]float64{
+		"B":   1,
+		"KiB": 1 << 10,
+		"MiB": 1 << 20,
+		"GiB": 1 << 30,
+		"TiB": 1 << 40,
+		"PiB": 1 << 50,
+		"EiB": 1 << 60,
+		"KB":  1e3,
+		"MB":  1e6,
+		"GB":  1e9,
+		"TB":  1e12,
+		"PB":  1e15,
+		"EB":  1e18,
+	}
+)
+
+type DownloadStatus struct {
+	DownloadStats
+	Downloads []ytfeed.DownloadProgress `json:"downloads"`
+	Recent    []ytfeed.DownloadProgress `json:"recent"`
+}
+
+// parseProgressLine parses a youtube-dl progress line, ok is false if it is not one
+func parseProgressLine(line string) (p ytfeed.DownloadProgress, ok bool) {
+	matches := progressLineRegexp.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return
+	}
+
+	var err error
+	p.Percent, err = strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return
+	}
+	totalBytes, ok := parseBytes(matches[2])
+	if !ok {
+		return
+	}
+	p.TotalBytes = int64(totalBytes)
+	p.DownloadedBytes = int64(totalBytes * p.Percent / 100)
+	// speed and eta are reported as Unknown at the start of a download
+	p.SpeedBytesPerSecond, _ = parseBytes(strings.TrimSuffix(matches[3], "/s"))
+	p.ETASeconds, _ = parseETA(matches[4])
+
+	return
+}
+
+// parseBytes parses sizes like 123.45MiB
+func parseBytes(s string) (b float64, ok bool) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return
+	}
+
+	unit, ok := byteUnits[s[i:]]
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		ok = false
+		return
+	}
+	b = n * unit
+
+	return
+}
+
+// parseETA parses durations like 00:42 or 1:02:03
+func parseETA(s string) (seconds int64, ok bool) {
+	if s == "" {
+		return
+	}
+
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			seconds = 0
+			return
+		}
+		seconds = seconds*60 + n
+	}
+	ok = true
+
+	return
+}
+
+// progressWriter calls onProgress for every progress line written to it,
+// youtube-dl separates progress updates with carriage returns when the output is not a terminal.
+// onLine is optional and gets every line.
+type progressWriter struct {
+	onProgress func(p ytfeed.DownloadProgress)
+	onLine     func(line string)
+	line       []byte
+	lock       sync.Mutex
+}
+
+func (w *progressWriter) Write(b []byte) (n int, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	n = len(b)
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			w.line = append(w.line, b...)
+			if len(w.line) > maxProgressLineLength {
+				w.line = w.line[:0]
+			}
+			return
+		}
+
+		w.line = append(w.line, b[:i]...)
+		p, ok := parseProgressLine(string(w.line))
+		if ok {
+			w.onProgress(p)
+		}
+		if w.onLine != nil {
+			w.onLine(string(w.line))
+		}
+		w.line = w.line[:0]
+		b = b[i+1:]
+	}
+
+	return
+}
+
+func newProgressWriter(onProgress func(p ytfeed.DownloadProgress)) *progressWriter {
+	return &progressWriter{onProgress: onProgress}
+}
+
+// progressPublisher hands the progress of one download to the progress handlers from its own goroutine
+// so a slow handler never stalls the downloader output. An update that is still waiting when a newer one
+// arrives is replaced by it, the first and final status are always published.
+type progressPublisher struct {
+	publish func(p *ytfeed.DownloadProgress)
+	pending []*ytfeed.DownloadProgress
+	// replaceable is true if the last pending progress is an update that a newer one can replace
+	replaceable bool
+	closed      bool
+	lock        sync.Mutex
+	wakeup      chan struct{}
+	done        chan struct{}
+}
+
+func newProgressPublisher(publish func(p *ytfeed.DownloadProgress)) (pp *progressPublisher) {
+	pp = &progressPublisher{}
+	pp.publish = publish
+	pp.wakeup = make(chan struct{}, 1)
+	pp.done = make(chan struct{})
+	go pp.run()
+
+	return
+}
+
+func (pp *progressPublisher) run() {
+	defer close(pp.done)
+
+	for range pp.wakeup {
+		pp.lock.Lock()
+		pending := pp.pending
+		pp.pending = nil
+		pp.replaceable = false
+		closed := pp.closed
+		pp.lock.Unlock()
+
+		for _, p := range pending {
+			pp.publish(p)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// send queues p to be published, it is only dropped if it is replaceable and a newer update comes before it is published
+func (pp *progressPublisher) send(p *ytfeed.DownloadProgress, replaceable bool) {
+	pp.lock.Lock()
+	if pp.replaceable && len(pp.pending) > 0 {
+		pp.pending[len(pp.pending)-1] = p
+	} else {
+		pp.pending = append(pp.pending, p)
+	}
+	pp.replaceable = replaceable
+	pp.lock.Unlock()
+
+	pp.wake()
+}
+
+// close publishes p as the final status and waits until it is published
+func (pp *progressPublisher) close(p *ytfeed.DownloadProgress) {
+	pp.lock.Lock()
+	pp.pending = append(pp.pending, p)
+	pp.replaceable = false
+	pp.closed = true
+	pp.lock.Unlock()
+
+	pp.wake()
+	<-pp.done
+}
+
+func (pp *progressPublisher) wake() {
+	select {
+	case pp.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// startProgress tracks videoName as downloading and returns the writer parsing the downloader output
+func (s *SaveVideo) startProgress(ctx context.Context, videoName, url string) *progressWriter {
+	now := time.Now()
+	progress := &ytfeed.DownloadProgress{}
+	progress.VideoName = videoName
+	progress.URL = url
+	progress.Status = ytfeed.DownloadStatusDownloading
+	progress.StartedAt = now
+	progress.UpdatedAt = now
+
+	var publisher *progressPublisher
+	if len(s.progressHandlers) > 0 {
+		publisher = newProgressPublisher(func(p *ytfeed.DownloadProgress) {
+			s.publishProgress(ctx, p)
+		})
+	}
+
+	s.progressLock.Lock()
+	s.progress[videoName] = progress
+	if publisher != nil {
+		s.progressPublishers[videoName] = publisher
+	}
+	snapshot := *progress
+	s.progressLock.Unlock()
+
+	if publisher != nil {
+		publisher.send(&snapshot, false)
+	}
+
+	lastPublished := now
+	return newProgressWriter(func(p ytfeed.DownloadProgress) {
+		s.progressLock.Lock()
+		progress.Percent = p.Percent
+		progress.TotalBytes = p.TotalBytes
+		progress.DownloadedBytes = p.DownloadedBytes
+		progress.SpeedBytesPerSecond = p.SpeedBytesPerSecond
+		progress.ETASeconds = p.ETASeconds
+		progress.UpdatedAt = time.Now()
+		snapshot := *progress
+		s.progressLock.Unlock()
+
+		if publisher == nil {
+			return
+		}
+
+		// youtube-dl reports many times per second, handlers only get one update per interval
+		if snapshot.UpdatedAt.Sub(lastPublished) < s.progressInterval {
+			return
+		}
+		lastPublished = snapshot.UpdatedAt
+		publisher.send(&snapshot, true)
+	})
+}
+
+// finishProgress stops tracking videoName and publishes its final status
+func (s *SaveVideo) finishProgress(ctx context.Context, videoName string, err error) {
+	s.progressLock.Lock()
+	progress, ok := s.progress[videoName]
+	if !ok {
+		s.progressLock.Unlock()
+		return
+	}
+	delete(s.progress, videoName)
+	publisher := s.progressPublishers[videoName]
+	delete(s.progressPublishers, videoName)
+
+	progress.UpdatedAt = time.Now()
+	progress.Status = ytfeed.DownloadStatusFinished
+	if err != nil {
+		progress.Status = ytfeed.DownloadStatusFailed
+		progress.Error = err.Error()
+	}
+	s.recentDownloads = append(s.recentDownloads, *progress)
+	if len(s.recentDownloads) > DefaultRecentDownloads {
+		s.recentDownloads = s.recentDownloads[len(s.recentDownloads)-DefaultRecentDownloads:]
+	}
+	s.progressLock.Unlock()
+
+	if publisher != nil {
+		publisher.close(progress)
+	}
+}
+
+func (s *SaveVideo) publishProgress(ctx context.Context, p *ytfeed.DownloadProgress) {
+	for _, handler := range s.progressHandlers {
+		handler(ctx, p)
+	}
+}
+
+// Progress returns the progress of running downloads sorted by video name
+func (s *SaveVideo) Progress() (progress []ytfeed.DownloadProgress) {
+	s.progressLock.RLock()
+	progress = make([]ytfeed.DownloadProgress, 0, len(s.progress))
+	for _, p := range s.progress {
+		progress = append(progress, *p)
+	}
+	s.progressLock.RUnlock()
+
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].VideoName < progress[j].VideoName
+	})
+
+	return
+}
+
+// RecentDownloads returns the final status of the last finished downloads, most recent first
+func (s *SaveVideo) RecentDownloads() (recent []ytfeed.DownloadProgress) {
+	s.progressLock.RLock()
+	recent = make([]ytfeed.DownloadProgress, 0, len(s.recentDownloads))
+	for i := len(s.recentDownloads) - 1; i >= 0; i-- {
+		recent = append(recent, s.recentDownloads[i])
+	}
+	s.progressLock.RUnlock()
+
+	return
+}
+
+// Status returns the download stats, the progress of running downloads and the recently finished ones
+func (s *SaveVideo) Status() (status DownloadStatus) {
+	status.DownloadStats = s.DownloadStats()
+	status.Downloads = s.Progress()
+	status.Recent = s.RecentDownloads()
+
+	return
+}
+
+// SetProgressHandlers because publishing progress is optional, it doesn't have to be present at constructor function.
+// Handlers get the first and final status of every download and at most one update per interval in between.
+func (s *SaveVideo) SetProgressHandlers(interval time.Duration, handlers ...ytfeed.DownloadProgressHandlerFunc) {
+	if interval <= 0 {
+		interval = DefaultProgressPublishInterval
+	}
+	s.progressInterval = interval
+	s.progressHandlers = handlers
+}
@@ -0,0 +1,238 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+func TestProgress(t *testing.T) {
+	t.Run("parseProgressLine", func(t *testing.T) {
+		tests := []struct {
+			line     string
+			ok       bool
+			expected ytfeed.DownloadProgress
+		}{
+			{
+				line:     "[download]  50.0% of 10.00MiB at  2.00MiB/s ETA 00:42",
+				ok:       true,
+				expected: ytfeed.DownloadProgress{Percent: 50, TotalBytes: 10 << 20, DownloadedBytes: 5 << 20, SpeedBytesPerSecond: 2 << 20, ETASeconds: 42},
+			},
+			{
+				line:     "[download]  25.0% of ~ 4.00KiB at 512B/s ETA 1:00:03 (frag 5/40)",
+				ok:       true,
+				expected: ytfeed.DownloadProgress{Percent: 25, TotalBytes: 4 << 10, DownloadedBytes: 1 << 10, SpeedBytesPerSecond: 512, ETASeconds: 3603},
+			},
+			{
+				line:     "[download]   0.0% of 1.00GiB at Unknown speed ETA Unknown ETA",
+				ok:       true,
+				expected: ytfeed.DownloadProgress{Percent: 0, TotalBytes: 1 << 30},
+			},
+			{
+				line:     "[download] 100% of 10.00MiB in 00:52",
+				ok:       true,
+				expected: ytfeed.DownloadProgress{Percent: 100, TotalBytes: 10 << 20, DownloadedBytes: 10 << 20},
+			},
+			{
+				line: "[download] Destination: video.f137.mp4",
+			},
+			{
+				line: "[youtube] dQw4w9WgXcQ: Downloading webpage",
+			},
+			{
+				line: "[download]  50.0% of 10.00XiB",
+			},
+		}
+
+		for _, test := range tests {
+			p, ok := parseProgressLine(test.line)
+			require.Equal(t, test.ok, ok, test.line)
+			if test.ok {
+				require.Equal(t, test.expected, p, test.line)
+			}
+		}
+	})
+
+	t.Run("progressWriter splits on carriage return and newline", func(t *testing.T) {
+		percents := make([]float64, 0, 3)
+		w := newProgressWriter(func(p ytfeed.DownloadProgress) {
+			percents = append(percents, p.Percent)
+		})
+
+		_, err := io.WriteString(w, "[youtube] dQw4w9WgXcQ: Downloading webpage\n\r[download]  10.0% of 1.00MiB")
+		require.NoError(t, err)
+		_, err = io.WriteString(w, " at 1.00KiB/s ETA 00:10\r[download]  20.0% of 1.00MiB at 1.00KiB/s ETA 00:09\r\n[download] 100% of 1.00MiB in 00:10\n")
+		require.NoError(t, err)
+
+		require.Equal(t, []float64{10, 20, 100}, percents)
+	})
+
+	t.Run("DownloadVideo tracks and publishes progress", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dirName, err := ioutil.TempDir("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dirName)
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+		script := strings.Replace(fakeDownloaderScript, "#!/bin/sh\n", "#!/bin/sh\n"+
+			"printf '[download]  50.0%% of 1.00MiB at 1.00KiB/s ETA 00:10\\r'\n"+
+			"printf '[download] 100%% of 1.00MiB in 00:10\\n'\n", 1)
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, script)))
+
+		published := make([]ytfeed.DownloadProgress, 0, 4)
+		sv.SetProgressHandlers(time.Nanosecond, func(ctx context.Context, p *ytfeed.DownloadProgress) {
+			published = append(published, *p)
+		})
+
+		dataSaver.EXPECT().Exists(gomock.Any(), "video.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.mp4", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			// still tracked while saving
+			require.Len(t, sv.Progress(), 1)
+			require.Equal(t, float64(100), sv.Progress()[0].Percent)
+
+			return io.Copy(ioutil.Discard, r)
+		})
+
+		err = sv.DownloadVideo(context.TODO(), "video.mp4", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "1080", "mp4", false, dataSaver)
+		require.NoError(t, err)
+		require.Empty(t, sv.Progress())
+
+		// the 50% update may be replaced by the 100% one before it is published
+		require.True(t, len(published) == 3 || len(published) == 4)
+		require.Equal(t, ytfeed.DownloadStatusDownloading, published[0].Status)
+		if len(published) == 4 {
+			require.Equal(t, float64(50), published[1].Percent)
+			require.Equal(t, int64(10), published[1].ETASeconds)
+		}
+		require.Equal(t, float64(100), published[len(published)-2].Percent)
+		require.Equal(t, ytfeed.DownloadStatusFinished, published[len(published)-1].Status)
+		require.Equal(t, "video.mp4", published[len(published)-1].VideoName)
+	})
+
+	t.Run("slow progress handlers don't stall the downloader output", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sv, err := New(mock.NewMockLogger(ctrl), nil, nil, "", "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		unblock := make(chan struct{})
+		published := make([]ytfeed.DownloadProgress, 0, 4)
+		sv.SetProgressHandlers(time.Nanosecond, func(ctx context.Context, p *ytfeed.DownloadProgress) {
+			<-unblock
+			published = append(published, *p)
+		})
+
+		w := sv.startProgress(context.TODO(), "video.mp4", "url")
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			for i := 1; i <= 100; i++ {
+				_, err := fmt.Fprintf(w, "[download]  %d.0%% of 1.00MiB at 1.00KiB/s ETA 00:10\n", i)
+				require.NoError(t, err)
+				time.Sleep(time.Microsecond)
+			}
+		}()
+
+		select {
+		case <-written:
+		case <-time.After(time.Second):
+			t.Fatal("downloader output was stalled by the progress handler")
+		}
+
+		close(unblock)
+		sv.finishProgress(context.TODO(), "video.mp4", nil)
+
+		// stale updates were dropped but the first, latest and final status are published
+		require.Less(t, len(published), 102)
+		require.Equal(t, ytfeed.DownloadStatusDownloading, published[0].Status)
+		require.Equal(t, float64(100), published[len(published)-2].Percent)
+		require.Equal(t, ytfeed.DownloadStatusFinished, published[len(published)-1].Status)
+	})
+
+	t.Run("progress handlers are throttled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sv, err := New(mock.NewMockLogger(ctrl), nil, nil, "", "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		published := 0
+		sv.SetProgressHandlers(time.Hour, func(ctx context.Context, p *ytfeed.DownloadProgress) {
+			published++
+		})
+
+		w := sv.startProgress(context.TODO(), "video.mp4", "url")
+		for i := 0; i < 10; i++ {
+			_, err = io.WriteString(w, "[download]  50.0% of 1.00MiB at 1.00KiB/s ETA 00:10\n")
+			require.NoError(t, err)
+		}
+		sv.finishProgress(context.TODO(), "video.mp4", io.EOF)
+
+		// first and final status only
+		require.Equal(t, 2, published)
+	})
+
+	t.Run("RecentDownloads", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sv, err := New(mock.NewMockLogger(ctrl), nil, nil, "", "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		sv.startProgress(context.TODO(), "failed.mp4", "url-failed")
+		sv.finishProgress(context.TODO(), "failed.mp4", io.EOF)
+		for i := 0; i < DefaultRecentDownloads; i++ {
+			sv.startProgress(context.TODO(), "video.mp4", "url")
+			sv.finishProgress(context.TODO(), "video.mp4", nil)
+		}
+		require.Len(t, sv.RecentDownloads(), DefaultRecentDownloads)
+
+		sv.startProgress(context.TODO(), "failed.mp4", "url-failed")
+		sv.finishProgress(context.TODO(), "failed.mp4", io.EOF)
+		recent := sv.RecentDownloads()
+		require.Len(t, recent, DefaultRecentDownloads)
+		require.Equal(t, "failed.mp4", recent[0].VideoName)
+		require.Equal(t, ytfeed.DownloadStatusFailed, recent[0].Status)
+		require.Equal(t, io.EOF.Error(), recent[0].Error)
+		require.Equal(t, ytfeed.DownloadStatusFinished, recent[1].Status)
+		require.Empty(t, recent[1].Error)
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sv, err := New(mock.NewMockLogger(ctrl), nil, nil, "", "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		w := sv.startProgress(context.TODO(), "b.mp4", "url-b")
+		_, err = io.WriteString(w, "[download]  50.0% of 1.00MiB at 1.00KiB/s ETA 00:10\n")
+		require.NoError(t, err)
+		sv.startProgress(context.TODO(), "a.mp4", "url-a")
+
+		status := sv.Status()
+		require.Len(t, status.Downloads, 2)
+		require.Equal(t, "a.mp4", status.Downloads[0].VideoName)
+		require.Equal(t, "b.mp4", status.Downloads[1].VideoName)
+		require.Equal(t, float64(50), status.Downloads[1].Percent)
+		require.Equal(t, ytfeed.DownloadStatusDownloading, status.Downloads[1].Status)
+		require.Empty(t, status.Recent)
+	})
+}
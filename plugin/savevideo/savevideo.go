@@ -3,10 +3,11 @@ package savevideo
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -29,11 +30,12 @@ const (
 	DownloadingVideoStatus = true
 
 	DefaultTemporaryDownloadDirectoryPermission = 0755
-
-	ErrFileAlreadyExistsFormat = "file %s already exists"
 )
 
 var (
+	// ErrFileAlreadyExists is the cause of the error of a download whose file is already saved
+	ErrFileAlreadyExists = errors.New("file already exists")
+
 	defaultParts []string = []string{
 		"snippet",
 		"liveStreamingDetails",
@@ -78,6 +80,11 @@ type StreamScheduler interface {
 	RegisterSchedule(runAt time.Time, data *ytfeed.Data) error
 }
 
+// StoragePather returns the storage path override of a channel, it is empty if there is none
+type StoragePather interface {
+	StoragePath(ctx context.Context, channelID string) (string, error)
+}
+
 type SaveVideo struct {
 	logger               ytfeed.Logger
 	vs                   YoutubeVideoLister
@@ -87,13 +94,43 @@ type SaveVideo struct {
 	videoFormatExtension string
 	downloadingVideo     map[string]bool
 	streamScheduler      StreamScheduler
+	storagePather        StoragePather
 	tmpDir               string
 	maxRetries           int
 	retryDelay           time.Duration
+	downloadQueue        *downloadQueue
+	leaser               DownloadLeaser
+	downloader           Downloader
+	streaming            bool
+	progress             map[string]*ytfeed.DownloadProgress
+	progressPublishers   map[string]*progressPublisher
+	recentDownloads      []ytfeed.DownloadProgress
+	progressHandlers     []ytfeed.DownloadProgressHandlerFunc
+	progressInterval     time.Duration
+	progressLock         sync.RWMutex
+	formatPolicy         *FormatPolicy
+	audioTagger          AudioTagger
+	sidecarOptions       SidecarOptions
+	httpClient           HTTPDoer
+	subtitleOptions      SubtitleOptions
+	liveChatRecorder     LiveChatRecorder
+	liveChatGracePeriod  time.Duration
+	vodMode              string
+	vodRearchiveDelay    time.Duration
+	liveWait             LiveWaitPolicy
+	liveWaits            map[string]liveWait
+	liveWaitsLock        sync.Mutex
+	leaseTTL             time.Duration
 	downloadingVideoLock sync.Mutex
 }
 
 func (s *SaveVideo) DataHandler(ctx context.Context, d *ytfeed.Data) {
+	_ = s.HandleData(ctx, d)
+}
+
+// HandleData is DataHandler that returns the error of a video that failed to download so it can be retried,
+// videos that are skipped or already downloaded are not errors
+func (s *SaveVideo) HandleData(ctx context.Context, d *ytfeed.Data) (err error) {
 	// if deletion entry, ignore
 	if d.Feed.DeletedEntry.Link.Href != "" {
 		s.logger.Warnf("Deletion entry for %s, ignored", d.Feed.DeletedEntry.Link.Href)
@@ -101,41 +138,59 @@ func (s *SaveVideo) DataHandler(ctx context.Context, d *ytfeed.Data) {
 	}
 
 	entry := Entry{}
+	entry.Title = d.Feed.Entry.Title
 	entry.Author = d.Feed.Entry.Author.Name
 	entry.ChannelID = d.Feed.Entry.ChannelID
 	entry.VideoID = d.Feed.Entry.VideoID
 	entry.LinkURL = d.Feed.Entry.Link.Href
-	entry.VideoExtension = s.videoFormatExtension
+	entry.VideoExtension = s.audioExtension()
 	entry.VideoQuality = s.videoFormatQuality
 
-	// check first if currently downloading the same video
-	if _, ok := s.downloadingVideo[entry.LinkURL]; ok {
+	// claim the video so concurrent notifications of the same video, here or on another replica, don't download it twice
+	claimCtx, release, claimed, err := s.claimVideo(ctx, entry.VideoID)
+	if err != nil {
+		s.logger.Errorf("Failed to claim video %s: %v", entry.LinkURL, err)
+		return
+	}
+	if !claimed {
 		s.logger.Warnf("Already downloading video %s", entry.LinkURL)
 		return
 	}
 
-	// set the downloading status
-	s.downloadingVideoLock.Lock()
-	s.downloadingVideo[entry.LinkURL] = DownloadingVideoStatus
-	s.downloadingVideoLock.Unlock()
-	defer func() {
-		s.downloadingVideoLock.Lock()
-		delete(s.downloadingVideo, entry.LinkURL)
-		s.downloadingVideoLock.Unlock()
-	}()
+	upcoming, err := s.handleVideo(claimCtx, d, entry)
+	release()
 
-	vlcall := s.vs.List(defaultParts)
-	vlcall = vlcall.Id(entry.VideoID)
-	vlresp, err := vlcall.Do()
+	// waiting for the stream to go live holds neither the claim of the video nor the data handler
+	if upcoming != nil {
+		err = s.waitForLive(ctx, entry, upcoming, d)
+	} else {
+		s.forgetLiveWait(entry.VideoID)
+	}
+
+	return
+}
+
+// handleVideo downloads or schedules the video, upcoming is the video if it is an upcoming stream that has to be waited for
+func (s *SaveVideo) handleVideo(ctx context.Context, d *ytfeed.Data, entry Entry) (upcoming *youtube.Video, err error) {
+	item, err := s.getVideo(ctx, entry.VideoID)
 	if err != nil {
 		s.logger.Errorf("Failed to get video %s info: %v", entry.LinkURL, err)
 		return
 	}
-	if len(vlresp.Items) < 1 {
+	if item == nil {
 		s.logger.Warnf("No item in video list response of video %s", entry.LinkURL)
 		return
 	}
-	item := vlresp.Items[0]
+	// downloads that didn't come from the hub only know the video
+	if entry.Title == "" {
+		entry.Title = item.Snippet.Title
+	}
+	if entry.ChannelID == "" {
+		entry.ChannelID = item.Snippet.ChannelId
+	}
+	if entry.Author == "" {
+		entry.Author = item.Snippet.ChannelTitle
+	}
 
 	// use Youtube's published_at instead of feed's
 	// if live broadcast, use scheduled start time instead
@@ -166,49 +221,118 @@ func (s *SaveVideo) DataHandler(ctx context.Context, d *ytfeed.Data) {
 		s.logger.Errorf("Failed to render file template name: %v", err)
 		return
 	}
-	switch item.Snippet.LiveBroadcastContent {
+	broadcastContent := item.Snippet.LiveBroadcastContent
+	if isCompletedBroadcast(item) {
+		broadcastContent = LiveBroadcastContentCompleted
+	}
+	videoName := fileName.String()
+	if s.storagePather != nil {
+		storagePath, pathErr := s.storagePather.StoragePath(ctx, entry.ChannelID)
+		if pathErr != nil {
+			s.logger.Warnf("Failed to get storage path of channel %s: %v, using default storage path instead", entry.ChannelID, pathErr)
+		}
+		if storagePath != "" && !isContainedPath(storagePath) {
+			s.logger.Warnf("Invalid storage path %s of channel %s, using default storage path instead", storagePath, entry.ChannelID)
+			storagePath = ""
+		}
+		if storagePath != "" {
+			videoName = path.Join(storagePath, videoName)
+		}
+	}
+	// the sidecars of a VOD are named after the video it is archived next to or instead of
+	archiveName := videoName
+	var vod vodArchive
+	switch broadcastContent {
+	case LiveBroadcastContentCompleted:
+		// the processed VOD is downloaded, not the live stream
+		isLiveBroadcast = false
+		var ok bool
+		vod, ok, err = s.vodTarget(ctx, entry.LinkURL, videoName)
+		if err != nil {
+			s.logger.Errorf("Failed to check VOD of video %s: %v", entry.LinkURL, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		videoName = vod.videoName
+		fallthrough
 	case LiveBroadcastContentNone:
 		fallthrough
 	case LiveBroadcastContentLive:
+		priority := PriorityRegular
+		if item.Snippet.LiveBroadcastContent == LiveBroadcastContentLive {
+			priority = PriorityLive
+		}
+		var releaseSlot func()
+		releaseSlot, err = s.downloadQueue.acquire(ctx, entry.ChannelID, priority)
+		if err != nil {
+			s.logger.Errorf("Failed to wait for download slot of video %s: %v", entry.LinkURL, err)
+			return
+		}
+		defer releaseSlot()
+
 		s.logger.Infof("Downloading video %s", entry.LinkURL)
 
+		var tags *AudioTags
+		if s.formatPolicy.AudioOnly {
+			tags = getAudioTags(entry, publishedDate.Format(AudioTagDateLayout), item.Snippet.Thumbnails)
+		}
+
+		// the chat of live broadcasts is recorded while the live stream downloads, finished ones have a chat replay
+		stopLiveChat := func() {}
+		if item.LiveStreamingDetails != nil && s.liveChatRecorder != nil {
+			stopLiveChat = s.recordLiveChat(ctx, videoName, entry.LinkURL, item, broadcastContent == LiveBroadcastContentLive)
+		}
+
 		if s.retryDelay > 0 && s.maxRetries > 0 {
-			err = s.DownloadVideoWithRetries(ctx, s.retryDelay, s.maxRetries, fileName.String(), entry.LinkURL, s.videoFormatQuality, s.videoFormatExtension, isLiveBroadcast, s.dataSaver)
+			err = s.downloadVideoWithRetries(ctx, s.retryDelay, s.maxRetries, videoName, entry.LinkURL, s.videoFormatQuality, s.videoFormatExtension, isLiveBroadcast, s.dataSaver, tags, vod.overwrite)
 		} else {
-			err = s.DownloadVideo(ctx, fileName.String(), entry.LinkURL, s.videoFormatQuality, s.videoFormatExtension, isLiveBroadcast, s.dataSaver)
+			err = s.downloadVideo(ctx, videoName, entry.LinkURL, s.videoFormatQuality, s.videoFormatExtension, isLiveBroadcast, s.dataSaver, tags, vod.overwrite)
 		}
+		stopLiveChat()
 
 		if err != nil {
 			s.logger.Errorf("Failed to download video %s: %v. Original message was: `%s`", entry.LinkURL, err, d.OriginalXMLMessage)
+			// a video that is already downloaded doesn't have to be retried, failing to check whether it is does
+			if IsErrorAlreadyExists(err) {
+				err = nil
+			}
 			return
 		}
 
 		s.logger.Infof("Video %s downloaded", entry.LinkURL)
-	case LiveBroadcastContentUpcoming:
-		s.logger.Infof("Upcoming stream video %s at %s", entry.LinkURL, item.LiveStreamingDetails.ScheduledStartTime)
-		if s.streamScheduler != nil {
-			var runAt time.Time
-			runAt, err = time.Parse(time.RFC3339, item.LiveStreamingDetails.ScheduledStartTime)
-			if err != nil {
-				s.logger.Errorf("Failed to register schedule for stream video %s: %v", entry.LinkURL, err)
-				return
-			}
-			s.logger.Infof("Registering video %s to scheduler to be ran at %s", entry.LinkURL, runAt)
-			err = s.streamScheduler.RegisterSchedule(runAt, d)
-			if err != nil {
-				s.logger.Errorf("Failed to register schedule for stream video %s: %v. Original message was: `%s`", entry.LinkURL, err, d.OriginalXMLMessage)
-				return
-			}
+
+		if broadcastContent == LiveBroadcastContentCompleted {
+			s.replaceLiveRecording(ctx, entry.LinkURL, &vod)
+			videoName = vod.videoName
+		}
+
+		s.saveSidecars(ctx, videoName, item, d)
+
+		switch broadcastContent {
+		case LiveBroadcastContentLive:
+			s.liveRecordingArchived(ctx, entry, videoName, d)
+		case LiveBroadcastContentCompleted:
+			s.vodArchived(ctx, entry, archiveName, vod)
 		}
+	case LiveBroadcastContentUpcoming:
+		upcoming = item
 	default:
-		s.logger.Warnf("Unexpected broadcast content %s for url: %s", item.Snippet.LiveBroadcastContent, entry.LinkURL)
+		s.logger.Warnf("Unexpected broadcast content %s for url: %s", broadcastContent, entry.LinkURL)
 	}
+
+	return
 }
 
 func (s *SaveVideo) DownloadVideoWithRetries(ctx context.Context, retryDelay time.Duration, maxRetries int, videoName, url, quality, ext string, isLive bool, dataSaver DataSaver) (err error) {
+	return s.downloadVideoWithRetries(ctx, retryDelay, maxRetries, videoName, url, quality, ext, isLive, dataSaver, nil, false)
+}
+
+func (s *SaveVideo) downloadVideoWithRetries(ctx context.Context, retryDelay time.Duration, maxRetries int, videoName, url, quality, ext string, isLive bool, dataSaver DataSaver, tags *AudioTags, overwrite bool) (err error) {
 	retries := 0
 	for {
-		err = s.DownloadVideo(ctx, videoName, url, quality, ext, isLive, dataSaver)
+		err = s.downloadVideo(ctx, videoName, url, quality, ext, isLive, dataSaver, tags, overwrite)
 		if err == nil {
 			return
 		}
@@ -231,14 +355,40 @@ func (s *SaveVideo) DownloadVideoWithRetries(ctx context.Context, retryDelay tim
 }
 
 func (s *SaveVideo) DownloadVideo(ctx context.Context, videoName, url, quality, ext string, isLive bool, dataSaver DataSaver) (err error) {
-	var exists bool
-	exists, err = dataSaver.Exists(ctx, videoName)
+	return s.downloadVideo(ctx, videoName, url, quality, ext, isLive, dataSaver, nil, false)
+}
+
+// downloadVideo downloads url into videoName and embeds tags if the format is converted audio and an audio tagger is set,
+// overwrite replaces an existing file and never streams because a failed stream deletes the partially saved file
+func (s *SaveVideo) downloadVideo(ctx context.Context, videoName, url, quality, ext string, isLive bool, dataSaver DataSaver, tags *AudioTags, overwrite bool) (err error) {
+	if !overwrite {
+		var exists bool
+		exists, err = dataSaver.Exists(ctx, videoName)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to check if file %s already exists", videoName)
+			return
+		}
+		if exists {
+			err = errors.Wrapf(ErrFileAlreadyExists, "file %s", videoName)
+			return
+		}
+	}
+
+	var format Format
+	format, err = s.formatPolicy.Format(quality, ext, isLive)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to check if file %s already exists", videoName)
+		err = errors.Wrapf(err, "failed to create downloader command from parameters: %s, %s, %s, %s", videoName, url, quality, ext)
 		return
 	}
-	if exists {
-		err = fmt.Errorf(ErrFileAlreadyExistsFormat, videoName)
+	s.subtitleOptions.apply(&format)
+
+	progress := s.startProgress(ctx, videoName, url)
+	defer func() {
+		s.finishProgress(ctx, videoName, err)
+	}()
+
+	if !overwrite && s.canStream(dataSaver, format) {
+		err = s.streamVideo(ctx, videoName, url, format, dataSaver, progress)
 		return
 	}
 
@@ -263,17 +413,28 @@ func (s *SaveVideo) DownloadVideo(ctx context.Context, videoName, url, quality,
 
 	stdErrCollector := &bytes.Buffer{}
 	var ytdlCmd *exec.Cmd
-	ytdlCmd, err = getYoutubeDLCommand(ctx, tmpFilePath, url, quality, ext, isLive)
+	ytdlCmd, err = s.downloader.Command(ctx, tmpFilePath, url, format)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to create youtube-dl command from parameters: %s, %s, %s, %s", videoName, url, quality, ext)
+		err = errors.Wrapf(err, "failed to create downloader command from parameters: %s, %s, %s", videoName, url, format.Selector)
 		return
 	}
+	var subtitles *subtitleCollector
+	if len(format.SubtitleLanguages) > 0 {
+		subtitles = newSubtitleCollector(tmpFilePath)
+		progress.onLine = subtitles.onLine
+	}
+	ytdlCmd.Stdout = progress
 	ytdlCmd.Stderr = stdErrCollector
 
 	// save to temporary file
 	err = ytdlCmd.Run()
 	if err != nil {
-		err = errors.Wrapf(err, "failed to run youtube-dl command from parameters: %s, %s, %s, %s and stderr: %s", videoName, url, quality, ext, stdErrCollector.String())
+		err = errors.Wrapf(err, "failed to run downloader command from parameters: %s, %s, %s and stderr: %s", videoName, url, format.Selector, stdErrCollector.String())
+		return
+	}
+
+	tmpFilePath, err = s.tagAudio(ctx, tmpFilePath, format, tags)
+	if err != nil {
 		return
 	}
 
@@ -288,24 +449,60 @@ func (s *SaveVideo) DownloadVideo(ctx context.Context, videoName, url, quality,
 	// pipe to data saver
 	_, err = dataSaver.SaveAs(ctx, videoName, tmpFile)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to save stream from youtube-dl command args %v", ytdlCmd.Args)
+		err = errors.Wrapf(err, "failed to save stream from downloader command args %v", ytdlCmd.Args)
 		return
 	}
 
+	if subtitles != nil {
+		s.saveSubtitles(ctx, videoName, format, dataSaver, subtitles)
+	}
+
 	return
 }
 
+// SetStoragePather because storage path overrides are optional, it doesn't have to be present at constructor function.
+// The storage path of the channel is prepended to the rendered file name.
+func (s *SaveVideo) SetStoragePather(p StoragePather) {
+	s.storagePather = p
+}
+
 // SetStreamScheduler because stream scheduler is optional, it doesn't have to be present at constructor function
 func (s *SaveVideo) SetStreamScheduler(sc StreamScheduler) {
 	s.streamScheduler = sc
 }
 
+// SetDownloader because youtube-dl is the default downloader, it doesn't have to be present at constructor function
+func (s *SaveVideo) SetDownloader(downloader Downloader) {
+	s.downloader = downloader
+}
+
+// SetFormatPolicy because the exact quality and extension is the default format, it doesn't have to be present at constructor function
+func (s *SaveVideo) SetFormatPolicy(p *FormatPolicy) {
+	s.formatPolicy = p
+}
+
 // SetRetry because retries is optional, it doesn't have to be present at constructor function
 func (s *SaveVideo) SetRetries(retryDelay time.Duration, maxRetries int) {
 	s.retryDelay = retryDelay
 	s.maxRetries = maxRetries
 }
 
+// SetDownloadLimits because download limits are optional, it doesn't have to be present at constructor function.
+// Zero means unlimited, live streams get a slot before regular videos.
+func (s *SaveVideo) SetDownloadLimits(maxConcurrent, maxPerChannel int) {
+	s.downloadQueue.lock.Lock()
+	defer s.downloadQueue.lock.Unlock()
+
+	s.downloadQueue.maxConcurrent = maxConcurrent
+	s.downloadQueue.maxPerChannel = maxPerChannel
+	s.downloadQueue.schedule()
+}
+
+// DownloadStats returns the number of queued and running downloads
+func (s *SaveVideo) DownloadStats() DownloadStats {
+	return s.downloadQueue.stats()
+}
+
 func New(
 	logger ytfeed.Logger, vs YoutubeVideoLister, dataSaver DataSaver,
 	tmpDir, filenameTemplate, quality, ext string,
@@ -319,6 +516,16 @@ func New(
 	s.videoFormatExtension = ext
 	s.downloadingVideo = make(map[string]bool, 8)
 	s.tmpDir = tmpDir
+	s.downloadQueue = newDownloadQueue(0, 0)
+	s.downloader = NewYoutubeDL(YoutubeDLCommand)
+	s.progress = make(map[string]*ytfeed.DownloadProgress, 8)
+	s.progressPublishers = make(map[string]*progressPublisher, 8)
+	s.progressInterval = DefaultProgressPublishInterval
+	s.formatPolicy = &FormatPolicy{Mode: FormatModeExact}
+	s.vodMode = VODModeSkip
+	s.SetLiveWaitPolicy(LiveWaitPolicy{})
+	s.liveWaits = make(map[string]liveWait, 8)
+	s.httpClient = http.DefaultClient
 
 	return
 }
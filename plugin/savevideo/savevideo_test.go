@@ -204,7 +204,7 @@ func TestSaveVideo(t *testing.T) {
 		mockData.Feed.Entry.VideoID = videoID
 		mockData.Feed.Entry.Link = ytfeed.Link{}
 		mockData.Feed.Entry.Link.Href = videoURL
-		sv.downloadingVideo[videoURL] = true
+		sv.downloadingVideo[videoID] = true
 
 		logger.EXPECT().Warnf(
 			gomock.AssignableToTypeOf("Already downloading video"),
@@ -310,7 +310,7 @@ func TestSaveVideo(t *testing.T) {
 	wg.Wait()
 
 	wg.Add(1)
-	t.Run("DataHandler failed to create youtube-dl command", func(t *testing.T) {
+	t.Run("DataHandler failed to create downloader command", func(t *testing.T) {
 		defer wg.Done()
 
 		ctrl := gomock.NewController(t)
@@ -0,0 +1,134 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+const (
+	SidecarJSONSuffix        = ".info.json"
+	SidecarDescriptionSuffix = ".description"
+	SidecarThumbnailFormat   = "%s.thumbnail.%s%s"
+
+	DefaultThumbnailExtension = ".jpg"
+)
+
+// SidecarOptions selects which files are saved next to the video
+type SidecarOptions struct {
+	JSON        bool
+	Thumbnails  bool
+	Description bool
+}
+
+// Sidecar is the content of the JSON sidecar
+type Sidecar struct {
+	Video              *youtube.Video `json:"video"`
+	OriginalXMLMessage string         `json:"original_xml_message,omitempty"`
+}
+
+// sidecarBaseName strips the extension of the video name so sidecars sort next to the video
+func sidecarBaseName(videoName string) string {
+	return strings.TrimSuffix(videoName, path.Ext(videoName))
+}
+
+// saveSidecars saves the enabled sidecars of the video, failures are logged because the video itself is already saved
+func (s *SaveVideo) saveSidecars(ctx context.Context, videoName string, item *youtube.Video, d *ytfeed.Data) {
+	baseName := sidecarBaseName(videoName)
+
+	if s.sidecarOptions.JSON {
+		err := s.saveSidecarJSON(ctx, baseName+SidecarJSONSuffix, item, d)
+		if err != nil {
+			s.logger.Errorf("Failed to save JSON sidecar of video %s: %v", videoName, err)
+		}
+	}
+
+	if s.sidecarOptions.Description && item.Snippet != nil {
+		_, err := s.dataSaver.SaveAs(ctx, baseName+SidecarDescriptionSuffix, strings.NewReader(item.Snippet.Description))
+		if err != nil {
+			s.logger.Errorf("Failed to save description sidecar of video %s: %v", videoName, err)
+		}
+	}
+
+	if s.sidecarOptions.Thumbnails && item.Snippet != nil && item.Snippet.Thumbnails != nil {
+		thumbnails := item.Snippet.Thumbnails
+		resolutions := []struct {
+			name      string
+			thumbnail *youtube.Thumbnail
+		}{
+			{"default", thumbnails.Default},
+			{"medium", thumbnails.Medium},
+			{"high", thumbnails.High},
+			{"standard", thumbnails.Standard},
+			{"maxres", thumbnails.Maxres},
+		}
+		for _, resolution := range resolutions {
+			if resolution.thumbnail == nil || resolution.thumbnail.Url == "" {
+				continue
+			}
+
+			ext := path.Ext(resolution.thumbnail.Url)
+			if ext == "" {
+				ext = DefaultThumbnailExtension
+			}
+			name := fmt.Sprintf(SidecarThumbnailFormat, baseName, resolution.name, ext)
+			err := s.saveSidecarThumbnail(ctx, name, resolution.thumbnail.Url)
+			if err != nil {
+				s.logger.Errorf("Failed to save %s thumbnail sidecar of video %s: %v", resolution.name, videoName, err)
+			}
+		}
+	}
+}
+
+func (s *SaveVideo) saveSidecarJSON(ctx context.Context, name string, item *youtube.Video, d *ytfeed.Data) (err error) {
+	sidecar := Sidecar{}
+	sidecar.Video = item
+	sidecar.OriginalXMLMessage = d.OriginalXMLMessage
+
+	var rawJSON []byte
+	rawJSON, err = json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal JSON")
+		return
+	}
+
+	_, err = s.dataSaver.SaveAs(ctx, name, bytes.NewReader(rawJSON))
+
+	return
+}
+
+func (s *SaveVideo) saveSidecarThumbnail(ctx context.Context, name, url string) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code %d fetching %s", res.StatusCode, url)
+		return
+	}
+
+	_, err = s.dataSaver.SaveAs(ctx, name, res.Body)
+
+	return
+}
+
+// SetSidecars because sidecars are optional, it doesn't have to be present at constructor function
+func (s *SaveVideo) SetSidecars(options SidecarOptions, httpClient HTTPDoer) {
+	s.sidecarOptions = options
+	s.httpClient = httpClient
+}
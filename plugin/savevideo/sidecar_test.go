@@ -0,0 +1,110 @@
+package savevideo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+func TestSidecar(t *testing.T) {
+	thumbnailServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, req.URL.Path)
+	}))
+	defer thumbnailServer.Close()
+
+	item := &youtube.Video{
+		Id: "dQw4w9WgXcQ",
+		Snippet: &youtube.VideoSnippet{
+			Title:       "title",
+			Description: "description",
+			Thumbnails: &youtube.ThumbnailDetails{
+				Default: &youtube.Thumbnail{Url: thumbnailServer.URL + "/default.jpg"},
+				High:    &youtube.Thumbnail{Url: thumbnailServer.URL + "/hqdefault.webp"},
+				Maxres:  &youtube.Thumbnail{Url: thumbnailServer.URL + "/missing.jpg"},
+			},
+		},
+	}
+	d := &ytfeed.Data{OriginalXMLMessage: "<feed></feed>"}
+
+	t.Run("sidecarBaseName", func(t *testing.T) {
+		require.Equal(t, "channel/video", sidecarBaseName("channel/video.mp4"))
+		require.Equal(t, "channel.name/video", sidecarBaseName("channel.name/video"))
+	})
+
+	t.Run("Save all sidecars", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, "", "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetSidecars(SidecarOptions{JSON: true, Thumbnails: true, Description: true}, http.DefaultClient)
+
+		saved := map[string]string{}
+		dataSaver.EXPECT().SaveAs(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			content, err := ioutil.ReadAll(r)
+			saved[name] = string(content)
+			return int64(len(content)), err
+		}).Times(4)
+		logger.EXPECT().Errorf(gomock.Any(), "maxres", "dir/video.mp4", gomock.Any())
+
+		sv.saveSidecars(context.TODO(), "dir/video.mp4", item, d)
+		require.Len(t, saved, 4)
+		require.Equal(t, "description", saved["dir/video.description"])
+		require.Equal(t, "/default.jpg", saved["dir/video.thumbnail.default.jpg"])
+		require.Equal(t, "/hqdefault.webp", saved["dir/video.thumbnail.high.webp"])
+
+		sidecar := Sidecar{}
+		err = json.Unmarshal([]byte(saved["dir/video.info.json"]), &sidecar)
+		require.NoError(t, err)
+		require.Equal(t, "<feed></feed>", sidecar.OriginalXMLMessage)
+		require.Equal(t, item.Id, sidecar.Video.Id)
+		require.Equal(t, item.Snippet.Title, sidecar.Video.Snippet.Title)
+	})
+
+	t.Run("Save nothing by default", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sv, err := New(mock.NewMockLogger(ctrl), nil, mock.NewMockDataSaver(ctrl), "", "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		sv.saveSidecars(context.TODO(), "video.mp4", item, d)
+	})
+
+	t.Run("Failed to save sidecar", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, "", "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetSidecars(SidecarOptions{JSON: true, Description: true}, http.DefaultClient)
+
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.info.json", gomock.Any()).Return(int64(0), errors.New("expected error"))
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.description", gomock.Any()).Return(int64(0), errors.New("expected error"))
+		logger.EXPECT().Errorf(gomock.Any(), "video.mp4", gomock.Any()).Times(2)
+
+		sv.saveSidecars(context.TODO(), "video.mp4", item, d)
+	})
+}
@@ -0,0 +1,101 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// StdoutOutput makes youtube-dl write the video to stdout
+	StdoutOutput = "-"
+)
+
+// StreamingDataSaver is implemented by data savers that can upload a stream of unknown size
+type StreamingDataSaver interface {
+	StreamingSupported() bool
+}
+
+// canStream returns true if streaming is enabled, the data saver supports it
+// and the format is a single file, formats that have to be merged by youtube-dl, converted by the audio tagger
+// or come with subtitles need a temporary file
+func (s *SaveVideo) canStream(dataSaver DataSaver, format Format) bool {
+	if !s.streaming {
+		return false
+	}
+
+	streamingDataSaver, ok := dataSaver.(StreamingDataSaver)
+	if !ok || !streamingDataSaver.StreamingSupported() {
+		return false
+	}
+
+	return format.MergeOutputFormat == "" && format.AudioFormat == "" && len(format.SubtitleLanguages) == 0
+}
+
+// streamVideo pipes the downloader stdout straight into the data saver,
+// the partially saved video is deleted if either side fails
+func (s *SaveVideo) streamVideo(ctx context.Context, videoName, url string, format Format, dataSaver DataSaver, progress io.Writer) (err error) {
+	// the downloader is killed if the data saver stops reading, otherwise it blocks writing to a full pipe
+	cmdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdErrCollector := &bytes.Buffer{}
+	ytdlCmd, err := s.downloader.Command(cmdCtx, StdoutOutput, url, format)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create downloader command from parameters: %s, %s, %s", videoName, url, format.Selector)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	ytdlCmd.Stdout = pw
+	// youtube-dl reports progress on stderr when the video goes to stdout
+	ytdlCmd.Stderr = io.MultiWriter(stdErrCollector, progress)
+
+	err = ytdlCmd.Start()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to start downloader command from parameters: %s, %s, %s", videoName, url, format.Selector)
+		return
+	}
+
+	waitErrChan := make(chan error, 1)
+	go func() {
+		err := ytdlCmd.Wait()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to run downloader command from parameters: %s, %s, %s and stderr: %s", videoName, url, format.Selector, stdErrCollector.String())
+		}
+		// the data saver sees the downloader error instead of a clean EOF so it doesn't commit a truncated video
+		pw.CloseWithError(err)
+		waitErrChan <- err
+	}()
+
+	_, err = dataSaver.SaveAs(ctx, videoName, pr)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to save stream from downloader command args %v", ytdlCmd.Args)
+		cancel()
+	}
+	pr.Close()
+
+	waitErr := <-waitErrChan
+	if err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		return
+	}
+
+	// ctx might already be done, the partial video must still be removed
+	deleteErr := dataSaver.Delete(context.Background(), videoName)
+	if deleteErr != nil {
+		s.logger.Errorf("Failed to delete partially saved video %s: %v", videoName, deleteErr)
+	}
+
+	return
+}
+
+// SetStreaming because streaming is optional, it doesn't have to be present at constructor function.
+// If enabled, videos that don't need merging are piped to data savers that implement StreamingDataSaver without a temporary file.
+func (s *SaveVideo) SetStreaming(streaming bool) {
+	s.streaming = streaming
+}
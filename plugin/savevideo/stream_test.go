@@ -0,0 +1,119 @@
+package savevideo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+type streamingDataSaver struct {
+	*mock.MockDataSaver
+}
+
+func (s *streamingDataSaver) StreamingSupported() bool {
+	return true
+}
+
+func TestStreamVideo(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	dirName, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	// tmpDir doesn't exist so any attempt to use a temporary file fails
+	tmpDir := dirName + "/does/not/exist"
+	binary := writeFakeDownloader(t, dirName, fakeDownloaderScript)
+
+	t.Run("canStream", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dataSaver := mock.NewMockDataSaver(ctrl)
+		sv, err := New(mock.NewMockLogger(ctrl), nil, dataSaver, tmpDir, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		live := Format{Selector: "[height=1080]"}
+		merged := Format{Selector: "bestvideo+bestaudio", MergeOutputFormat: "mp4"}
+
+		require.False(t, sv.canStream(&streamingDataSaver{dataSaver}, live))
+
+		sv.SetStreaming(true)
+		require.True(t, sv.canStream(&streamingDataSaver{dataSaver}, live))
+		require.False(t, sv.canStream(&streamingDataSaver{dataSaver}, merged))
+		require.False(t, sv.canStream(dataSaver, live))
+	})
+
+	t.Run("DownloadVideo streams without temporary file", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dataSaver := &streamingDataSaver{mock.NewMockDataSaver(ctrl)}
+		sv, err := New(mock.NewMockLogger(ctrl), nil, dataSaver, tmpDir, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(binary))
+		sv.SetStreaming(true)
+
+		var saved []byte
+		dataSaver.EXPECT().Exists(gomock.Any(), "live.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "live.mp4", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			saved, err = ioutil.ReadAll(r)
+			return int64(len(saved)), err
+		})
+
+		err = sv.DownloadVideo(context.TODO(), "live.mp4", url, "1080", "mp4", true, dataSaver)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("fake video %s", url), string(saved))
+	})
+
+	t.Run("DownloadVideo deletes partial video if downloader fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dataSaver := &streamingDataSaver{mock.NewMockDataSaver(ctrl)}
+		sv, err := New(mock.NewMockLogger(ctrl), nil, dataSaver, tmpDir, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(binary))
+		sv.SetStreaming(true)
+
+		dataSaver.EXPECT().Exists(gomock.Any(), "live.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "live.mp4", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			saved, err := ioutil.ReadAll(r)
+			return int64(len(saved)), err
+		})
+		dataSaver.EXPECT().Delete(gomock.Any(), "live.mp4").Return(nil)
+
+		err = sv.DownloadVideo(context.TODO(), "live.mp4", url+"&fail", "1080", "mp4", true, dataSaver)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "ERROR: unable to download")
+	})
+
+	t.Run("DownloadVideo kills downloader if data saver fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := &streamingDataSaver{mock.NewMockDataSaver(ctrl)}
+		sv, err := New(logger, nil, dataSaver, tmpDir, "{{.VideoID}}", "1080", "mp4")
+		require.NoError(t, err)
+		// never stops writing unless killed
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, "#!/bin/sh\nexec yes\n")))
+		sv.SetStreaming(true)
+
+		dataSaver.EXPECT().Exists(gomock.Any(), "live.mp4").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "live.mp4", gomock.Any()).Return(int64(0), fmt.Errorf("upload failed"))
+		dataSaver.EXPECT().Delete(gomock.Any(), "live.mp4").Return(fmt.Errorf("not found"))
+		logger.EXPECT().Errorf(gomock.Any(), "live.mp4", gomock.Any())
+
+		err = sv.DownloadVideo(context.TODO(), "live.mp4", url, "1080", "mp4", true, dataSaver)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "upload failed")
+	})
+}
@@ -0,0 +1,177 @@
+package savevideo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// SubtitleModeFiles saves every subtitle as a file next to the video
+	SubtitleModeFiles = "files"
+	// SubtitleModeEmbed embeds the subtitles into the video, mkv supports every subtitle format
+	SubtitleModeEmbed = "embed"
+
+	WriteSubArg     = "--write-sub"
+	WriteAutoSubArg = "--write-auto-sub"
+	SubLangArg      = "--sub-lang"
+	SubFormatArg    = "--sub-format"
+	EmbedSubsArg    = "--embed-subs"
+
+	SubtitleFormat            = "vtt/srt/best"
+	SubtitleLanguageSeparator = ","
+)
+
+var (
+	// matches youtube-dl and yt-dlp lines like
+	// [info] Writing video subtitles to: /tmp/ytfeed/video.mp4/video.mp4.en.vtt
+	subtitleLineRegexp = regexp.MustCompile(`^\[info\] Writing video subtitles to: (.+)$`)
+
+	subtitleExtensions = map[string]bool{
+		"vtt":   true,
+		"srt":   true,
+		"ttml":  true,
+		"srv1":  true,
+		"srv2":  true,
+		"srv3":  true,
+		"json3": true,
+		"ass":   true,
+		"lrc":   true,
+	}
+)
+
+// SubtitleOptions selects the subtitles downloaded with each video, no languages means no subtitles
+type SubtitleOptions struct {
+	// Languages are the subtitle languages like en or de
+	Languages []string
+	// Auto also downloads automatic captions if there are no subtitles in a language
+	Auto bool
+	// Mode is either SubtitleModeFiles or SubtitleModeEmbed
+	Mode string
+}
+
+// apply adds the subtitles to the format, audio has nothing to embed them into so it always gets files
+func (o *SubtitleOptions) apply(f *Format) {
+	if len(o.Languages) == 0 {
+		return
+	}
+
+	f.SubtitleLanguages = o.Languages
+	f.AutoSubtitles = o.Auto
+	f.EmbedSubtitles = o.Mode == SubtitleModeEmbed && !f.AudioOnly
+}
+
+// parseSubtitlePath returns the language and extension of a subtitle youtube-dl wrote next to tmpFilePath
+func parseSubtitlePath(tmpFilePath, subtitlePath string) (lang, ext string, ok bool) {
+	suffix := strings.TrimPrefix(subtitlePath, tmpFilePath+".")
+	if suffix == subtitlePath {
+		return
+	}
+
+	i := strings.LastIndex(suffix, ".")
+	if i <= 0 {
+		return
+	}
+	lang = suffix[:i]
+	ext = suffix[i+1:]
+	ok = subtitleExtensions[ext]
+
+	return
+}
+
+// subtitleCollector records the languages of the subtitles the downloader reports writing,
+// embedded subtitles are deleted by youtube-dl so its output is the only place to find them
+type subtitleCollector struct {
+	tmpFilePath string
+	languages   map[string]bool
+}
+
+func (c *subtitleCollector) onLine(line string) {
+	matches := subtitleLineRegexp.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return
+	}
+
+	lang, _, ok := parseSubtitlePath(c.tmpFilePath, matches[1])
+	if ok {
+		c.languages[lang] = true
+	}
+}
+
+// sortedLanguages returns the collected languages sorted
+func (c *subtitleCollector) sortedLanguages() (languages []string) {
+	languages = make([]string, 0, len(c.languages))
+	for lang := range c.languages {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	return
+}
+
+func newSubtitleCollector(tmpFilePath string) *subtitleCollector {
+	return &subtitleCollector{tmpFilePath: tmpFilePath, languages: make(map[string]bool)}
+}
+
+// saveSubtitles saves the subtitle files next to the video unless they were embedded and reports the available languages,
+// failures are logged because the video itself is already saved
+func (s *SaveVideo) saveSubtitles(ctx context.Context, videoName string, format Format, dataSaver DataSaver, subtitles *subtitleCollector) {
+	tmpFilePath := subtitles.tmpFilePath
+	if !format.EmbedSubtitles {
+		files, err := ioutil.ReadDir(filepath.Dir(tmpFilePath))
+		if err != nil {
+			s.logger.Errorf("Failed to list subtitles of video %s: %v", videoName, err)
+		}
+		for _, file := range files {
+			subtitlePath := filepath.Join(filepath.Dir(tmpFilePath), file.Name())
+			lang, ext, ok := parseSubtitlePath(tmpFilePath, subtitlePath)
+			if !ok {
+				continue
+			}
+
+			err = s.saveSubtitle(ctx, sidecarBaseName(videoName)+"."+lang+"."+ext, subtitlePath, dataSaver)
+			if err != nil {
+				s.logger.Errorf("Failed to save %s subtitle of video %s: %v", lang, videoName, err)
+				continue
+			}
+			subtitles.languages[lang] = true
+		}
+	}
+
+	languages := subtitles.sortedLanguages()
+	s.progressLock.Lock()
+	progress, ok := s.progress[videoName]
+	if ok {
+		progress.SubtitleLanguages = languages
+	}
+	s.progressLock.Unlock()
+
+	missing := make([]string, 0, len(format.SubtitleLanguages))
+	for _, lang := range format.SubtitleLanguages {
+		if !subtitles.languages[lang] {
+			missing = append(missing, lang)
+		}
+	}
+	s.logger.Infof("Subtitles of video %s available in %v, not available in %v", videoName, languages, missing)
+}
+
+func (s *SaveVideo) saveSubtitle(ctx context.Context, name, subtitlePath string, dataSaver DataSaver) (err error) {
+	subtitle, err := os.Open(subtitlePath)
+	if err != nil {
+		return
+	}
+	defer subtitle.Close()
+
+	_, err = dataSaver.SaveAs(ctx, name, subtitle)
+
+	return
+}
+
+// SetSubtitles because subtitles are optional, it doesn't have to be present at constructor function
+func (s *SaveVideo) SetSubtitles(options SubtitleOptions) {
+	s.subtitleOptions = options
+}
@@ -0,0 +1,151 @@
+package savevideo
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+)
+
+// fakeSubtitleDownloaderScript mimics youtube-dl writing subtitles, only english subtitles exist
+const fakeSubtitleDownloaderScript = `#!/bin/sh
+out=""
+url=""
+langs=""
+embed=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-o) out="$2"; shift 2 ;;
+		--sub-lang) langs="$2"; shift 2 ;;
+		-f|--merge-output-format|--sub-format) shift 2 ;;
+		--embed-subs) embed="1"; shift ;;
+		--*) shift ;;
+		*) url="$1"; shift ;;
+	esac
+done
+printf 'fake video %s' "$url" > "$out"
+case ",$langs," in
+	*,en,*)
+		echo "[info] Writing video subtitles to: $out.en.vtt"
+		[ -n "$embed" ] || printf 'WEBVTT' > "$out.en.vtt"
+		;;
+esac
+`
+
+func TestSubtitle(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	dirName, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	binary := writeFakeDownloader(t, dirName, fakeSubtitleDownloaderScript)
+
+	t.Run("parseSubtitlePath", func(t *testing.T) {
+		lang, ext, ok := parseSubtitlePath("/tmp/video.mkv", "/tmp/video.mkv.en-US.vtt")
+		require.True(t, ok)
+		require.Equal(t, "en-US", lang)
+		require.Equal(t, "vtt", ext)
+
+		_, _, ok = parseSubtitlePath("/tmp/video.mkv", "/tmp/video.mkv.tagged.mp3")
+		require.False(t, ok)
+
+		_, _, ok = parseSubtitlePath("/tmp/video.mkv", "/tmp/other.mkv.en.vtt")
+		require.False(t, ok)
+
+		_, _, ok = parseSubtitlePath("/tmp/video.mkv", "/tmp/video.mkv.vtt")
+		require.False(t, ok)
+	})
+
+	t.Run("Subtitle args", func(t *testing.T) {
+		format := Format{Selector: "best"}
+		options := SubtitleOptions{Languages: []string{"en", "de"}, Auto: true, Mode: SubtitleModeEmbed}
+		options.apply(&format)
+
+		x, err := NewYoutubeDL("").Command(context.TODO(), "./", url, format)
+		require.NoError(t, err)
+		require.Equal(t, []string{"youtube-dl", "-f", "best", "--write-sub", "--write-auto-sub", "--sub-lang", "en,de", "--sub-format", "vtt/srt/best", "--embed-subs", "-o", "./", url}, x.Args)
+
+		// audio gets files
+		format = Format{Selector: "bestaudio", AudioOnly: true}
+		options.apply(&format)
+		require.False(t, format.EmbedSubtitles)
+
+		// no languages means no subtitles
+		format = Format{Selector: "best"}
+		(&SubtitleOptions{Mode: SubtitleModeEmbed}).apply(&format)
+		require.Empty(t, format.SubtitleLanguages)
+		require.False(t, format.EmbedSubtitles)
+
+		d, err := NewTemplateDownloader("dl --sub-lang={{.SubtitleLanguages}} {{if .EmbedSubtitles}}--embed{{end}} {{.URL}}")
+		require.NoError(t, err)
+		format = Format{Selector: "best"}
+		options.apply(&format)
+		x, err = d.Command(context.TODO(), "./", url, format)
+		require.NoError(t, err)
+		require.Equal(t, []string{"dl", "--sub-lang=en,de", "--embed", url}, x.Args)
+	})
+
+	t.Run("Save subtitle files", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := &streamingDataSaver{mock.NewMockDataSaver(ctrl)}
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "webm")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(binary))
+		sv.SetSubtitles(SubtitleOptions{Languages: []string{"en", "de"}, Mode: SubtitleModeFiles})
+		// subtitles need a temporary file
+		sv.SetStreaming(true)
+
+		var final ytfeed.DownloadProgress
+		sv.SetProgressHandlers(time.Hour, func(ctx context.Context, p *ytfeed.DownloadProgress) {
+			final = *p
+		})
+
+		saved := map[string]string{}
+		dataSaver.EXPECT().Exists(gomock.Any(), "channel/video.webm").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			content, err := ioutil.ReadAll(r)
+			saved[name] = string(content)
+			return int64(len(content)), err
+		}).Times(2)
+		logger.EXPECT().Infof(gomock.Any(), "channel/video.webm", []string{"en"}, []string{"de"})
+
+		err = sv.downloadVideo(context.TODO(), "channel/video.webm", url, "1080", "webm", false, dataSaver, nil, false)
+		require.NoError(t, err)
+		require.Equal(t, "fake video "+url, saved["channel/video.webm"])
+		require.Equal(t, "WEBVTT", saved["channel/video.en.vtt"])
+		require.Equal(t, ytfeed.DownloadStatusFinished, final.Status)
+		require.Equal(t, []string{"en"}, final.SubtitleLanguages)
+	})
+
+	t.Run("Embed subtitles", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mkv")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(binary))
+		sv.SetSubtitles(SubtitleOptions{Languages: []string{"en"}, Mode: SubtitleModeEmbed})
+
+		dataSaver.EXPECT().Exists(gomock.Any(), "video.mkv").Return(false, nil)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.mkv", gomock.Any()).Return(int64(0), nil)
+		logger.EXPECT().Infof(gomock.Any(), "video.mkv", []string{"en"}, []string{})
+
+		err = sv.downloadVideo(context.TODO(), "video.mkv", url, "1080", "mkv", false, dataSaver, nil, false)
+		require.NoError(t, err)
+	})
+}
@@ -1,11 +1,20 @@
 package savevideo
 
-import "strings"
+import (
+	"path"
+	"strings"
 
+	"github.com/pkg/errors"
+)
+
+// IsErrorAlreadyExists reports whether the download failed because its file is already saved, even if the error was wrapped
 func IsErrorAlreadyExists(err error) bool {
-	if err == nil {
-		return false
-	}
+	return err != nil && errors.Cause(err) == ErrFileAlreadyExists
+}
+
+// isContainedPath reports whether p is relative and doesn't climb out of the directory it is joined to with ..
+func isContainedPath(p string) bool {
+	p = path.Clean(p)
 
-	return strings.Contains(err.Error(), "already exists")
+	return !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
 }
@@ -0,0 +1,194 @@
+package savevideo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+const (
+	// VODModeSkip keeps the live recording and doesn't archive the VOD if there is one
+	VODModeSkip = "skip"
+	// VODModeReplace replaces the live recording with the VOD
+	VODModeReplace = "replace"
+	// VODModeKeep archives the VOD next to the live recording
+	VODModeKeep = "keep"
+
+	// VODSuffix is inserted before the extension of the VOD kept next to the live recording
+	VODSuffix = ".vod"
+
+	LiveRecordingMetadataSuffix = ".live.json"
+	VODMetadataSuffix           = ".vod.json"
+)
+
+// ArchiveMetadata tracks the live recording and the VOD of a live broadcast
+type ArchiveMetadata struct {
+	VideoID               string    `json:"video_id"`
+	URL                   string    `json:"url"`
+	LiveRecording         string    `json:"live_recording,omitempty"`
+	VOD                   string    `json:"vod,omitempty"`
+	LiveRecordingReplaced bool      `json:"live_recording_replaced,omitempty"`
+	ArchivedAt            time.Time `json:"archived_at"`
+}
+
+// RenamingDataSaver is implemented by data savers that can move a saved file over another one
+type RenamingDataSaver interface {
+	Rename(ctx context.Context, from, to string) error
+}
+
+// vodArchive is where the VOD of a completed broadcast is saved, a VOD replacing the live recording is saved
+// next to it first and only moved over it once it is saved completely
+type vodArchive struct {
+	videoName     string
+	liveRecording string
+	overwrite     bool
+	replace       bool
+	replaced      bool
+}
+
+// isCompletedBroadcast returns true for the VOD of a live broadcast that has ended,
+// the API reports those as none with the end time in the live streaming details
+func isCompletedBroadcast(item *youtube.Video) bool {
+	if item.Snippet.LiveBroadcastContent == LiveBroadcastContentCompleted {
+		return true
+	}
+
+	return item.Snippet.LiveBroadcastContent == LiveBroadcastContentNone &&
+		item.LiveStreamingDetails != nil && item.LiveStreamingDetails.ActualEndTime != ""
+}
+
+// vodFileName inserts VODSuffix before the extension of fileName
+func vodFileName(fileName string) string {
+	return sidecarBaseName(fileName) + VODSuffix + path.Ext(fileName)
+}
+
+// vodTarget decides where the VOD of a completed broadcast is saved, ok is false if it isn't archived
+func (s *SaveVideo) vodTarget(ctx context.Context, url, fileName string) (target vodArchive, ok bool, err error) {
+	exists, err := s.dataSaver.Exists(ctx, sidecarBaseName(fileName)+VODMetadataSuffix)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check if VOD of %s is already archived", fileName)
+		return
+	}
+	if exists {
+		s.logger.Infof("VOD of video %s is already archived", url)
+		return
+	}
+
+	exists, err = s.dataSaver.Exists(ctx, fileName)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check if file %s already exists", fileName)
+		return
+	}
+
+	target.videoName = fileName
+	if !exists {
+		ok = true
+		return
+	}
+
+	target.liveRecording = fileName
+	switch s.vodMode {
+	case VODModeReplace:
+		// a VOD left next to the live recording by a failed replacement is overwritten
+		target.videoName = vodFileName(fileName)
+		target.overwrite = true
+		target.replace = true
+	case VODModeKeep:
+		target.videoName = vodFileName(fileName)
+	default:
+		s.logger.Infof("Keeping live recording %s of video %s, VOD is not archived", fileName, url)
+		return
+	}
+	ok = true
+
+	return
+}
+
+// saveArchiveMetadata saves the metadata as JSON next to the video
+func (s *SaveVideo) saveArchiveMetadata(ctx context.Context, name string, metadata *ArchiveMetadata) (err error) {
+	rawJSON, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal JSON")
+		return
+	}
+
+	_, err = s.dataSaver.SaveAs(ctx, name, bytes.NewReader(rawJSON))
+
+	return
+}
+
+// liveRecordingArchived tracks the live recording and schedules archiving the VOD once YouTube processed it
+func (s *SaveVideo) liveRecordingArchived(ctx context.Context, entry Entry, videoName string, d *ytfeed.Data) {
+	metadata := &ArchiveMetadata{}
+	metadata.VideoID = entry.VideoID
+	metadata.URL = entry.LinkURL
+	metadata.LiveRecording = videoName
+	metadata.ArchivedAt = time.Now()
+	err := s.saveArchiveMetadata(ctx, sidecarBaseName(videoName)+LiveRecordingMetadataSuffix, metadata)
+	if err != nil {
+		s.logger.Errorf("Failed to save live recording metadata of video %s: %v", entry.LinkURL, err)
+	}
+
+	if s.streamScheduler == nil || s.vodRearchiveDelay <= 0 || s.vodMode == VODModeSkip {
+		return
+	}
+	runAt := time.Now().Add(s.vodRearchiveDelay)
+	s.logger.Infof("Registering video %s to scheduler to archive the VOD at %s", entry.LinkURL, runAt)
+	err = s.streamScheduler.RegisterSchedule(runAt, d)
+	if err != nil {
+		s.logger.Errorf("Failed to register schedule for VOD of video %s: %v", entry.LinkURL, err)
+	}
+}
+
+// replaceLiveRecording moves the saved VOD over the live recording, the VOD stays next to the live recording
+// if the data saver can't do that
+func (s *SaveVideo) replaceLiveRecording(ctx context.Context, url string, target *vodArchive) {
+	if !target.replace {
+		return
+	}
+
+	renamingDataSaver, ok := s.dataSaver.(RenamingDataSaver)
+	if !ok {
+		s.logger.Warnf("Data saver can't replace live recording %s of video %s, keeping the VOD next to it", target.liveRecording, url)
+		return
+	}
+
+	err := renamingDataSaver.Rename(ctx, target.videoName, target.liveRecording)
+	if err != nil {
+		s.logger.Errorf("Failed to replace live recording %s of video %s, keeping the VOD next to it: %v", target.liveRecording, url, err)
+		return
+	}
+	target.videoName = target.liveRecording
+	target.replaced = true
+}
+
+// vodArchived tracks the VOD and what happened to the live recording
+func (s *SaveVideo) vodArchived(ctx context.Context, entry Entry, fileName string, target vodArchive) {
+	metadata := &ArchiveMetadata{}
+	metadata.VideoID = entry.VideoID
+	metadata.URL = entry.LinkURL
+	metadata.VOD = target.videoName
+	if !target.replaced {
+		metadata.LiveRecording = target.liveRecording
+	}
+	metadata.LiveRecordingReplaced = target.replaced
+	metadata.ArchivedAt = time.Now()
+	err := s.saveArchiveMetadata(ctx, sidecarBaseName(fileName)+VODMetadataSuffix, metadata)
+	if err != nil {
+		s.logger.Errorf("Failed to save VOD metadata of video %s: %v", entry.LinkURL, err)
+	}
+}
+
+// SetVODArchive because archiving the VOD of completed broadcasts next to or instead of the live recording is optional,
+// it doesn't have to be present at constructor function. The VOD is archived rearchiveDelay after the live recording
+// if the stream scheduler is set, zero waits for YouTube to notify about the completed broadcast instead.
+func (s *SaveVideo) SetVODArchive(mode string, rearchiveDelay time.Duration) {
+	s.vodMode = mode
+	s.vodRearchiveDelay = rearchiveDelay
+}
@@ -0,0 +1,306 @@
+package savevideo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+	"github.com/worksinmagic/ytfeed/mock"
+	youtube "google.golang.org/api/youtube/v3"
+)
+
+type renamingDataSaver struct {
+	*mock.MockDataSaver
+	renamed map[string]string
+	err     error
+}
+
+func (r *renamingDataSaver) Rename(ctx context.Context, from, to string) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.renamed[from] = to
+
+	return nil
+}
+
+type storagePather string
+
+func (p storagePather) StoragePath(ctx context.Context, channelID string) (string, error) {
+	return string(p), nil
+}
+
+func TestVOD(t *testing.T) {
+	url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	entry := Entry{VideoID: "dQw4w9WgXcQ", LinkURL: url}
+
+	dirName, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirName)
+
+	t.Run("isCompletedBroadcast", func(t *testing.T) {
+		require.True(t, isCompletedBroadcast(&youtube.Video{Snippet: &youtube.VideoSnippet{LiveBroadcastContent: LiveBroadcastContentCompleted}}))
+		require.True(t, isCompletedBroadcast(&youtube.Video{
+			Snippet:              &youtube.VideoSnippet{LiveBroadcastContent: LiveBroadcastContentNone},
+			LiveStreamingDetails: &youtube.VideoLiveStreamingDetails{ActualEndTime: "2020-01-01T00:00:00Z"},
+		}))
+		require.False(t, isCompletedBroadcast(&youtube.Video{Snippet: &youtube.VideoSnippet{LiveBroadcastContent: LiveBroadcastContentNone}}))
+		require.False(t, isCompletedBroadcast(&youtube.Video{
+			Snippet:              &youtube.VideoSnippet{LiveBroadcastContent: LiveBroadcastContentLive},
+			LiveStreamingDetails: &youtube.VideoLiveStreamingDetails{},
+		}))
+	})
+
+	t.Run("vodFileName", func(t *testing.T) {
+		require.Equal(t, "channel/video.vod.mp4", vodFileName("channel/video.mp4"))
+	})
+
+	t.Run("vodTarget", func(t *testing.T) {
+		tests := []struct {
+			name            string
+			mode            string
+			archived        bool
+			recorded        bool
+			expectedOK      bool
+			expectedTarget  vodArchive
+			expectedLogArgs []interface{}
+		}{
+			{
+				name:           "no live recording",
+				mode:           VODModeSkip,
+				expectedOK:     true,
+				expectedTarget: vodArchive{videoName: "video.mp4"},
+			},
+			{
+				name:            "skip",
+				mode:            VODModeSkip,
+				recorded:        true,
+				expectedLogArgs: []interface{}{gomock.Any(), "video.mp4", url},
+			},
+			{
+				name:           "replace",
+				mode:           VODModeReplace,
+				recorded:       true,
+				expectedOK:     true,
+				expectedTarget: vodArchive{videoName: "video.vod.mp4", liveRecording: "video.mp4", overwrite: true, replace: true},
+			},
+			{
+				name:           "keep",
+				mode:           VODModeKeep,
+				recorded:       true,
+				expectedOK:     true,
+				expectedTarget: vodArchive{videoName: "video.vod.mp4", liveRecording: "video.mp4"},
+			},
+			{
+				name:            "already archived",
+				mode:            VODModeReplace,
+				archived:        true,
+				expectedLogArgs: []interface{}{gomock.Any(), url},
+			},
+		}
+
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				logger := mock.NewMockLogger(ctrl)
+				dataSaver := mock.NewMockDataSaver(ctrl)
+
+				sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+				require.NoError(t, err)
+				sv.SetVODArchive(test.mode, 0)
+
+				dataSaver.EXPECT().Exists(gomock.Any(), "video.vod.json").Return(test.archived, nil)
+				if !test.archived {
+					dataSaver.EXPECT().Exists(gomock.Any(), "video.mp4").Return(test.recorded, nil)
+				}
+				if test.expectedLogArgs != nil {
+					logger.EXPECT().Infof(test.expectedLogArgs[0], test.expectedLogArgs[1:]...)
+				}
+
+				target, ok, err := sv.vodTarget(context.TODO(), url, "video.mp4")
+				require.NoError(t, err)
+				require.Equal(t, test.expectedOK, ok)
+				if ok {
+					require.Equal(t, test.expectedTarget, target)
+				}
+			})
+		}
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dataSaver := mock.NewMockDataSaver(ctrl)
+		sv, err := New(mock.NewMockLogger(ctrl), nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		dataSaver.EXPECT().Exists(gomock.Any(), "video.vod.json").Return(false, errors.New("expected error"))
+		_, ok, err := sv.vodTarget(context.TODO(), url, "video.mp4")
+		require.Error(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("Live recording schedules the VOD", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := mock.NewMockDataSaver(ctrl)
+		scheduler := mock.NewMockStreamScheduler(ctrl)
+
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetStreamScheduler(scheduler)
+		sv.SetVODArchive(VODModeKeep, time.Hour)
+
+		d := &ytfeed.Data{}
+		var metadata ArchiveMetadata
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.live.json", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			return 0, json.NewDecoder(r).Decode(&metadata)
+		})
+		logger.EXPECT().Infof(gomock.Any(), url, gomock.Any())
+		scheduler.EXPECT().RegisterSchedule(gomock.Any(), d).DoAndReturn(func(runAt time.Time, data *ytfeed.Data) error {
+			require.WithinDuration(t, time.Now().Add(time.Hour), runAt, time.Minute)
+			return nil
+		})
+
+		sv.liveRecordingArchived(context.TODO(), entry, "video.mp4", d)
+		require.Equal(t, "video.mp4", metadata.LiveRecording)
+		require.Equal(t, entry.VideoID, metadata.VideoID)
+		require.Empty(t, metadata.VOD)
+
+		// skip never archives the VOD
+		sv.SetVODArchive(VODModeSkip, time.Hour)
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.live.json", gomock.Any()).Return(int64(0), nil)
+		sv.liveRecordingArchived(context.TODO(), entry, "video.mp4", d)
+	})
+
+	t.Run("VOD metadata", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dataSaver := mock.NewMockDataSaver(ctrl)
+		sv, err := New(mock.NewMockLogger(ctrl), nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		var metadata ArchiveMetadata
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.vod.json", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			metadata = ArchiveMetadata{}
+			return 0, json.NewDecoder(r).Decode(&metadata)
+		}).Times(2)
+
+		sv.vodArchived(context.TODO(), entry, "video.mp4", vodArchive{videoName: "video.vod.mp4", liveRecording: "video.mp4"})
+		require.Equal(t, "video.vod.mp4", metadata.VOD)
+		require.Equal(t, "video.mp4", metadata.LiveRecording)
+		require.False(t, metadata.LiveRecordingReplaced)
+
+		sv.vodArchived(context.TODO(), entry, "video.mp4", vodArchive{videoName: "video.mp4", liveRecording: "video.mp4", overwrite: true, replace: true, replaced: true})
+		require.Equal(t, "video.mp4", metadata.VOD)
+		require.Empty(t, metadata.LiveRecording)
+		require.True(t, metadata.LiveRecordingReplaced)
+	})
+
+	t.Run("replaceLiveRecording", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		dataSaver := &renamingDataSaver{MockDataSaver: mock.NewMockDataSaver(ctrl), renamed: make(map[string]string)}
+		sv, err := New(logger, nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+
+		target := vodArchive{videoName: "video.vod.mp4", liveRecording: "video.mp4", overwrite: true, replace: true}
+		sv.replaceLiveRecording(context.TODO(), url, &target)
+		require.Equal(t, map[string]string{"video.vod.mp4": "video.mp4"}, dataSaver.renamed)
+		require.Equal(t, "video.mp4", target.videoName)
+		require.True(t, target.replaced)
+
+		// the live recording is untouched if moving the VOD over it failed
+		dataSaver.err = errors.New("expected error")
+		target = vodArchive{videoName: "video.vod.mp4", liveRecording: "video.mp4", overwrite: true, replace: true}
+		logger.EXPECT().Errorf(gomock.Any(), "video.mp4", url, dataSaver.err)
+		sv.replaceLiveRecording(context.TODO(), url, &target)
+		require.Equal(t, "video.vod.mp4", target.videoName)
+		require.False(t, target.replaced)
+
+		// data savers that can't rename keep the VOD next to the live recording
+		sv.dataSaver = dataSaver.MockDataSaver
+		logger.EXPECT().Warnf(gomock.Any(), "video.mp4", url)
+		sv.replaceLiveRecording(context.TODO(), url, &target)
+		require.Equal(t, "video.vod.mp4", target.videoName)
+		require.False(t, target.replaced)
+	})
+
+	t.Run("Replacing overwrites without streaming", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dataSaver := &streamingDataSaver{mock.NewMockDataSaver(ctrl)}
+		sv, err := New(mock.NewMockLogger(ctrl), nil, dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+		require.NoError(t, err)
+		sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+		sv.SetStreaming(true)
+
+		var saved []byte
+		dataSaver.EXPECT().SaveAs(gomock.Any(), "video.mp4", gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+			saved, err = ioutil.ReadAll(r)
+			return int64(len(saved)), err
+		})
+
+		err = sv.downloadVideo(context.TODO(), "video.mp4", url, "1080", "mp4", false, dataSaver, nil, true)
+		require.NoError(t, err)
+		require.Equal(t, "fake video "+url, string(saved))
+	})
+
+	t.Run("VOD is archived under the storage path of the channel", func(t *testing.T) {
+		for _, test := range []struct {
+			storagePath string
+			videoName   string
+		}{
+			{"channel", "channel/dQw4w9WgXcQ"},
+			{"../escape", "dQw4w9WgXcQ"},
+		} {
+			t.Run(test.storagePath, func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				logger := mock.NewMockLogger(ctrl)
+				dataSaver := mock.NewMockDataSaver(ctrl)
+				sv, err := New(logger, fakeVideosService(t, videoResponse(LiveBroadcastContentCompleted, time.Now())), dataSaver, dirName, "{{.VideoID}}.{{.VideoExtension}}", "1080", "mp4")
+				require.NoError(t, err)
+				sv.SetDownloader(NewYoutubeDL(writeFakeDownloader(t, dirName, fakeDownloaderScript)))
+				sv.SetStoragePather(storagePather(test.storagePath))
+				sv.SetVODArchive(VODModeKeep, 0)
+
+				logger.EXPECT().Infof(gomock.Any(), url).AnyTimes()
+				logger.EXPECT().Warnf(gomock.Any(), test.storagePath, gomock.Any()).AnyTimes()
+				dataSaver.EXPECT().Exists(gomock.Any(), test.videoName+VODMetadataSuffix).Return(false, nil)
+				dataSaver.EXPECT().Exists(gomock.Any(), test.videoName+".mp4").Return(true, nil)
+				dataSaver.EXPECT().Exists(gomock.Any(), test.videoName+".vod.mp4").Return(false, nil)
+				dataSaver.EXPECT().SaveAs(gomock.Any(), test.videoName+".vod.mp4", gomock.Any()).Return(int64(0), nil)
+
+				var metadata ArchiveMetadata
+				dataSaver.EXPECT().SaveAs(gomock.Any(), test.videoName+VODMetadataSuffix, gomock.Any()).DoAndReturn(func(ctx context.Context, name string, r io.Reader) (int64, error) {
+					return 0, json.NewDecoder(r).Decode(&metadata)
+				})
+
+				data := &ytfeed.Data{}
+				data.Feed.Entry.VideoID = entry.VideoID
+				data.Feed.Entry.Link.Href = url
+				require.NoError(t, sv.HandleData(context.TODO(), data))
+				require.Equal(t, test.videoName+".vod.mp4", metadata.VOD)
+				require.Equal(t, test.videoName+".mp4", metadata.LiveRecording)
+			})
+		}
+	})
+}
@@ -2,8 +2,8 @@ package savevideo
 
 import (
 	"context"
-	"fmt"
 	"os/exec"
+	"strings"
 )
 
 const (
@@ -15,6 +15,7 @@ const (
 	// for live download
 	// youtube-dl -f "[height=%s]" -o temporaryfiledir/randomname https://www.youtube.com/watch?v=yF2va6QbnOs
 	YoutubeDLCommand         = "youtube-dl"
+	YTDLPCommand             = "yt-dlp"
 	FormatArg                = "-f"
 	FormatArgValueFormat     = "bestvideo[ext=%s][height=%s]+bestaudio[ext=%s]"
 	FormatArgValueLiveFormat = "[height=%s]"
@@ -29,6 +30,8 @@ const (
 	ExtensionWebm = "webm"
 	ExtensionMKV  = "mkv"
 
+	Quality2160 = "2160"
+	Quality1440 = "1440"
 	Quality1080 = "1080"
 	Quality720  = "720"
 	Quality640  = "640"
@@ -38,49 +41,55 @@ const (
 	Quality144  = "144"
 )
 
-func getYoutubeDLCommand(ctx context.Context, tmpFilePath, url, quality, ext string, isLive bool) (cmd *exec.Cmd, err error) {
-	var videoExt, audioExt string
-	switch ext {
-	case ExtensionMP4:
-		videoExt = ExtensionMP4
-		audioExt = AudioM4A
-	case ExtensionWebm:
-		fallthrough
-	case ExtensionMKV:
-		videoExt = ExtensionWebm
-		audioExt = AudioWebm
-	default:
-		err = fmt.Errorf(ErrInvalidVideoExtension, ext)
-		return
-	}
-
-	switch quality {
-	case Quality1080:
-	case Quality720:
-	case Quality640:
-	case Quality480:
-	case Quality360:
-	case Quality240:
-	case Quality144:
-	default:
-		err = fmt.Errorf(ErrInvalidVideoQuality, quality)
-		return
-	}
+// YoutubeDL runs youtube-dl or a command line compatible fork like yt-dlp
+type YoutubeDL struct {
+	binary string
+}
 
+func (y *YoutubeDL) Command(ctx context.Context, tmpFilePath, url string, format Format) (cmd *exec.Cmd, err error) {
 	ytdlArgs := make([]string, 0, 7)
 	ytdlArgs = append(ytdlArgs, FormatArg)
-	if isLive {
-		ytdlArgs = append(ytdlArgs, fmt.Sprintf(FormatArgValueLiveFormat, quality))
-	} else {
-		ytdlArgs = append(ytdlArgs, fmt.Sprintf(FormatArgValueFormat, videoExt, quality, audioExt))
+	ytdlArgs = append(ytdlArgs, format.Selector)
+	if format.MergeOutputFormat != "" {
 		ytdlArgs = append(ytdlArgs, MergeOutputFormatArg)
-		ytdlArgs = append(ytdlArgs, ext)
+		ytdlArgs = append(ytdlArgs, format.MergeOutputFormat)
+	}
+	if len(format.SubtitleLanguages) > 0 {
+		ytdlArgs = append(ytdlArgs, WriteSubArg)
+		if format.AutoSubtitles {
+			ytdlArgs = append(ytdlArgs, WriteAutoSubArg)
+		}
+		ytdlArgs = append(ytdlArgs, SubLangArg)
+		ytdlArgs = append(ytdlArgs, strings.Join(format.SubtitleLanguages, SubtitleLanguageSeparator))
+		ytdlArgs = append(ytdlArgs, SubFormatArg)
+		ytdlArgs = append(ytdlArgs, SubtitleFormat)
+		if format.EmbedSubtitles {
+			ytdlArgs = append(ytdlArgs, EmbedSubsArg)
+		}
 	}
 	ytdlArgs = append(ytdlArgs, OutputArg)
 	ytdlArgs = append(ytdlArgs, tmpFilePath)
 	ytdlArgs = append(ytdlArgs, url)
 
-	cmd = exec.CommandContext(ctx, YoutubeDLCommand, ytdlArgs...)
+	cmd = exec.CommandContext(ctx, y.binary, ytdlArgs...)
 
 	return
 }
+
+// NewYoutubeDL uses youtube-dl at binary, YoutubeDLCommand is used if binary is empty
+func NewYoutubeDL(binary string) *YoutubeDL {
+	if binary == "" {
+		binary = YoutubeDLCommand
+	}
+
+	return &YoutubeDL{binary: binary}
+}
+
+// NewYTDLP uses yt-dlp at binary, YTDLPCommand is used if binary is empty
+func NewYTDLP(binary string) *YoutubeDL {
+	if binary == "" {
+		binary = YTDLPCommand
+	}
+
+	return &YoutubeDL{binary: binary}
+}
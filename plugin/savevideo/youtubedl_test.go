@@ -7,7 +7,9 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestGetYoutubeDLCommand(t *testing.T) {
+func TestYoutubeDL(t *testing.T) {
+	exactPolicy := &FormatPolicy{Mode: FormatModeExact}
+
 	t.Run("success mp4", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.TODO())
 		defer cancel()
@@ -16,7 +18,9 @@ func TestGetYoutubeDLCommand(t *testing.T) {
 		q := "1080"
 		m := "mp4"
 		l := false
-		x, err := getYoutubeDLCommand(ctx, tmpFilePath, url, q, m, l)
+		format, err := exactPolicy.Format(q, m, l)
+		require.NoError(t, err)
+		x, err := NewYoutubeDL("").Command(ctx, tmpFilePath, url, format)
 		require.NoError(t, err)
 		require.NotNil(t, x)
 
@@ -32,7 +36,9 @@ func TestGetYoutubeDLCommand(t *testing.T) {
 		q := "1080"
 		m := "webm"
 		l := false
-		x, err := getYoutubeDLCommand(ctx, tmpFilePath, url, q, m, l)
+		format, err := exactPolicy.Format(q, m, l)
+		require.NoError(t, err)
+		x, err := NewYoutubeDL("").Command(ctx, tmpFilePath, url, format)
 		require.NoError(t, err)
 		require.NotNil(t, x)
 
@@ -41,16 +47,11 @@ func TestGetYoutubeDLCommand(t *testing.T) {
 	})
 
 	t.Run("failed quality check", func(t *testing.T) {
-		ctx, cancel := context.WithCancel(context.TODO())
-		defer cancel()
-		tmpFilePath := "./"
-		url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
 		q := "invalid"
 		m := "webm"
 		l := false
-		x, err := getYoutubeDLCommand(ctx, tmpFilePath, url, q, m, l)
+		_, err := exactPolicy.Format(q, m, l)
 		require.Error(t, err)
-		require.Nil(t, x)
 	})
 
 	t.Run("success live, and ignore extension setting", func(t *testing.T) {
@@ -61,11 +62,39 @@ func TestGetYoutubeDLCommand(t *testing.T) {
 		q := "1080"
 		m := "webm"
 		l := true
-		x, err := getYoutubeDLCommand(ctx, tmpFilePath, url, q, m, l)
+		format, err := exactPolicy.Format(q, m, l)
+		require.NoError(t, err)
+		x, err := NewYoutubeDL("").Command(ctx, tmpFilePath, url, format)
 		require.NoError(t, err)
 		require.NotNil(t, x)
 
 		expectedArgs := []string{"youtube-dl", "-f", "[height=1080]", "-o", "./", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
 		require.Equal(t, expectedArgs, x.Args)
 	})
+
+	t.Run("success yt-dlp", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		tmpFilePath := "./"
+		url := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+		q := "720"
+		m := "mp4"
+		l := false
+		format, err := exactPolicy.Format(q, m, l)
+		require.NoError(t, err)
+		x, err := NewYTDLP("").Command(ctx, tmpFilePath, url, format)
+		require.NoError(t, err)
+		require.NotNil(t, x)
+
+		expectedArgs := []string{"yt-dlp", "-f", "bestvideo[ext=mp4][height=720]+bestaudio[ext=m4a]", "--merge-output-format", "mp4", "-o", "./", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+		require.Equal(t, expectedArgs, x.Args)
+	})
+
+	t.Run("success custom binary", func(t *testing.T) {
+		format, err := exactPolicy.Format("1080", "mp4", true)
+		require.NoError(t, err)
+		x, err := NewYoutubeDL("/opt/bin/youtube-dl").Command(context.TODO(), "./", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", format)
+		require.NoError(t, err)
+		require.Equal(t, "/opt/bin/youtube-dl", x.Args[0])
+	})
 }
@@ -1,9 +1,14 @@
 package streamschedule
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,57 +20,285 @@ const (
 	DefaultFilePermission      = 0666
 	DefaultDatabaseOpenTimeout = time.Second
 	DefaultBucketName          = "ytfeed"
+	// DefaultIndexBucketName orders the schedules by their RunAt, keys are the big endian RunAt in nanoseconds followed by the link URL
+	DefaultIndexBucketName = "ytfeed-run-at"
+	// DefaultDeadLetterBucketName keeps the schedules that failed DefaultMaxAttempts times, keyed by the link URL
+	DefaultDeadLetterBucketName = "ytfeed-dead-letter"
+	DefaultMaxAttempts          = 5
+	DefaultRetryBackoff         = time.Minute
+	DefaultMaxRetryBackoff      = time.Hour
+
+	ScheduleStateScheduled  = "scheduled"
+	ScheduleStateInProgress = "in_progress"
+)
 
-	ErrFailedToDeleteKeysFormat = "failed to delete key(s): %s"
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
 )
 
 type Databaser interface {
 	Close() error
 	Update(func(tx *bbolt.Tx) error) error
+	View(func(tx *bbolt.Tx) error) error
 }
 
+// Schedule is deleted only once every data handler succeeded, State is in progress while they run
+// so schedules of a crashed run are fired again on the next start
 type Schedule struct {
 	RunAt time.Time    `json:"run_at"`
 	Data  *ytfeed.Data `json:"data"`
+	State string       `json:"state,omitempty"`
+	// PendingHandlers are the data handlers that haven't succeeded yet, they are set when the schedule is fired the first time
+	PendingHandlers []string `json:"pending_handlers,omitempty"`
+	Attempts        int      `json:"attempts,omitempty"`
+	LastError       string   `json:"last_error,omitempty"`
+}
+
+// DeadLetter is a schedule that failed max attempts times
+type DeadLetter struct {
+	Schedule
+	FailedAt time.Time `json:"failed_at"`
 }
 
 type StreamSchedule struct {
-	logger         ytfeed.Logger
-	workerInterval time.Duration
-	database       Databaser
-	dataHandlers   []ytfeed.DataHandlerFunc
+	logger          ytfeed.Logger
+	workerInterval  time.Duration
+	database        Databaser
+	handlerNames    []string
+	dataHandlers    map[string]ytfeed.DataHandlerErrFunc
+	maxAttempts     int
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
 }
 
-func (s *StreamSchedule) RegisterDataHandler(d ...ytfeed.DataHandlerFunc) {
-	s.dataHandlers = d
+// indexKey returns the key of the schedule in the RunAt index
+func indexKey(runAt time.Time, linkURL string) (k []byte) {
+	k = make([]byte, 8, 8+len(linkURL))
+	binary.BigEndian.PutUint64(k, uint64(runAt.UnixNano()))
+	k = append(k, linkURL...)
+
+	return
+}
+
+// putJSON marshals v and saves it at key
+func putJSON(b *bbolt.Bucket, key string, v interface{}) (err error) {
+	rawData, err := json.Marshal(v)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to json marshal schedule of video %s", key)
+		return
+	}
+	err = b.Put([]byte(key), rawData)
+
+	return
+}
+
+// putSchedule saves the schedule and replaces its previous index key if it was already scheduled
+func putSchedule(tx *bbolt.Tx, linkURL string, sch Schedule) (err error) {
+	b := tx.Bucket([]byte(DefaultBucketName))
+	ib := tx.Bucket([]byte(DefaultIndexBucketName))
+
+	err = deleteIndexKey(b, ib, linkURL)
+	if err != nil {
+		return
+	}
+
+	err = putJSON(b, linkURL, sch)
+	if err != nil {
+		return
+	}
+	err = ib.Put(indexKey(sch.RunAt, linkURL), []byte(linkURL))
+
+	return
 }
 
+// deleteIndexKey removes the index key of the current schedule of linkURL, if any
+func deleteIndexKey(b, ib *bbolt.Bucket, linkURL string) (err error) {
+	v := b.Get([]byte(linkURL))
+	if v == nil {
+		return
+	}
+
+	sch := Schedule{}
+	err = json.Unmarshal(v, &sch)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to unmarshal schedule json with key %s", linkURL)
+		return
+	}
+	err = ib.Delete(indexKey(sch.RunAt, linkURL))
+
+	return
+}
+
+// RegisterDataHandler must be called before RunWorker, the name is what gets persisted as pending
+// so it must stay the same across restarts for failed handlers to be retried, a handler fails when it returns an error or panics
+func (s *StreamSchedule) RegisterDataHandler(name string, d ytfeed.DataHandlerErrFunc) {
+	if _, ok := s.dataHandlers[name]; !ok {
+		s.handlerNames = append(s.handlerNames, name)
+	}
+	s.dataHandlers[name] = d
+}
+
+// SetRetryPolicy because failed schedules are retried with the default policy, it doesn't have to be present at constructor function.
+// The backoff doubles after every failed attempt up to maxBackoff, zero values are replaced by the defaults.
+func (s *StreamSchedule) SetRetryPolicy(maxAttempts int, backoff, maxBackoff time.Duration) {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	if maxBackoff < backoff {
+		maxBackoff = DefaultMaxRetryBackoff
+		if maxBackoff < backoff {
+			maxBackoff = backoff
+		}
+	}
+	s.maxAttempts = maxAttempts
+	s.retryBackoff = backoff
+	s.maxRetryBackoff = maxBackoff
+}
+
+// RegisterSchedule schedules the data to be handled again at runAt, an existing schedule of the same video is replaced
 func (s *StreamSchedule) RegisterSchedule(runAt time.Time, data *ytfeed.Data) (err error) {
 	sch := Schedule{}
 	sch.RunAt = runAt
 	sch.Data = data
+	sch.State = ScheduleStateScheduled
 
 	// ignore deleted entry
 	if data.Feed.DeletedEntry.Link.Href != "" {
 		return
 	}
 
-	var rawData []byte
-	rawData, err = json.Marshal(sch)
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		return putSchedule(tx, sch.Data.Feed.Entry.Link.Href, sch)
+	})
 	if err != nil {
-		err = errors.Wrapf(err, "failed to json marshal schedule of video %s", sch.Data.Feed.Entry.Link.Href)
+		err = errors.Wrapf(err, "failed to schedule video %s", sch.Data.Feed.Entry.Link.Href)
+	}
+
+	return
+}
+
+// List returns all schedules ordered by their RunAt
+func (s *StreamSchedule) List() (schedules []Schedule, err error) {
+	schedules = make([]Schedule, 0, 16)
+	err = s.database.View(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+
+		return b.ForEach(func(k, v []byte) (err error) {
+			sch := Schedule{}
+			err = json.Unmarshal(v, &sch)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal schedule json with key %s", string(k))
+				return
+			}
+			schedules = append(schedules, sch)
+
+			return
+		})
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to list schedules")
 		return
 	}
 
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return schedules[i].RunAt.Before(schedules[j].RunAt)
+	})
+
+	return
+}
+
+// Get returns the schedule of the video, err is ErrScheduleNotFound if it isn't scheduled
+func (s *StreamSchedule) Get(linkURL string) (sch *Schedule, err error) {
+	err = s.database.View(func(tx *bbolt.Tx) (err error) {
+		v := tx.Bucket([]byte(DefaultBucketName)).Get([]byte(linkURL))
+		if v == nil {
+			return ErrScheduleNotFound
+		}
+
+		sch = &Schedule{}
+		err = json.Unmarshal(v, sch)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal schedule json with key %s", linkURL)
+		}
+
+		return
+	})
+	if err != nil {
+		sch = nil
+	}
+
+	return
+}
+
+// Cancel removes the schedule of the video, err is ErrScheduleNotFound if it isn't scheduled
+func (s *StreamSchedule) Cancel(linkURL string) (err error) {
 	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
 		b := tx.Bucket([]byte(DefaultBucketName))
+		if b.Get([]byte(linkURL)) == nil {
+			return ErrScheduleNotFound
+		}
+
+		err = deleteIndexKey(b, tx.Bucket([]byte(DefaultIndexBucketName)), linkURL)
+		if err != nil {
+			return
+		}
+		err = b.Delete([]byte(linkURL))
 
-		err = b.Put([]byte(sch.Data.Feed.Entry.Link.Href), rawData)
 		return
 	})
+
+	return
+}
+
+// Reschedule moves the schedule of the video to runAt, err is ErrScheduleNotFound if it isn't scheduled
+func (s *StreamSchedule) Reschedule(linkURL string, runAt time.Time) (err error) {
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		v := tx.Bucket([]byte(DefaultBucketName)).Get([]byte(linkURL))
+		if v == nil {
+			return ErrScheduleNotFound
+		}
+
+		sch := Schedule{}
+		err = json.Unmarshal(v, &sch)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal schedule json with key %s", linkURL)
+			return
+		}
+		sch.RunAt = runAt
+		// a firing in progress leaves the moved schedule alone when it finishes
+		sch.State = ScheduleStateScheduled
+
+		return putSchedule(tx, linkURL, sch)
+	})
+
+	return
+}
+
+// DataHandler cancels the schedule of videos the hub notified as deleted
+func (s *StreamSchedule) DataHandler(ctx context.Context, d *ytfeed.Data) {
+	_ = s.HandleData(ctx, d)
+}
+
+// HandleData is DataHandler that returns the error of a schedule that failed to be cancelled
+func (s *StreamSchedule) HandleData(ctx context.Context, d *ytfeed.Data) (err error) {
+	linkURL := d.Feed.DeletedEntry.Link.Href
+	if linkURL == "" {
+		return
+	}
+
+	err = s.Cancel(linkURL)
+	if err == ErrScheduleNotFound {
+		err = nil
+		return
+	}
 	if err != nil {
-		err = errors.Wrapf(err, "failed to schedule video %s", sch.Data.Feed.Entry.Link.Href)
+		s.logger.Errorf("Failed to cancel schedule of deleted video %s: %v", linkURL, err)
+		return
 	}
+	s.logger.Infof("Cancelled schedule of deleted video %s", linkURL)
 
 	return
 }
@@ -89,12 +322,27 @@ func (s *StreamSchedule) RunWorker(ctx context.Context) (err error) {
 	}
 }
 
+// work marks the due schedules as in progress and fires them, the whole walk is one transaction
+// so nothing is fired if any of the due schedules can't be marked
 func (s *StreamSchedule) work(ctx context.Context) (err error) {
+	fired := make([]Schedule, 0, 16)
 	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
 		b := tx.Bucket([]byte(DefaultBucketName))
+		ib := tx.Bucket([]byte(DefaultIndexBucketName))
+
+		// only walk the index up to now instead of scanning every schedule
+		until := indexKey(time.Now(), "")
+		indexKeys := make([][]byte, 0, 16)
+		c := ib.Cursor()
+		for ik, k := c.First(); ik != nil && bytes.Compare(ik[:8], until) <= 0; ik, k = c.Next() {
+			indexKeys = append(indexKeys, append([]byte{}, ik...))
+
+			v := b.Get(k)
+			if v == nil {
+				// stale index key of a cancelled schedule
+				continue
+			}
 
-		successKeys := make([]string, 0, 16)
-		err = b.ForEach(func(k, v []byte) (err error) {
 			sch := Schedule{}
 			err = json.Unmarshal(v, &sch)
 			if err != nil {
@@ -102,53 +350,283 @@ func (s *StreamSchedule) work(ctx context.Context) (err error) {
 				return
 			}
 
-			// time to resend messages
-			if time.Now().After(sch.RunAt) {
-				for _, d := range s.dataHandlers {
-					go d(ctx, sch.Data)
-				}
+			if len(sch.PendingHandlers) == 0 {
+				sch.PendingHandlers = append(make([]string, 0, len(s.handlerNames)), s.handlerNames...)
+			}
+			sch.State = ScheduleStateInProgress
+			sch.Attempts++
+			err = putJSON(b, string(k), sch)
+			if err != nil {
+				return
+			}
+			fired = append(fired, sch)
+		}
+
+		// the index of fired schedules is only written again if they have to be retried
+		for _, ik := range indexKeys {
+			err = ib.Delete(ik)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to delete index key of video %s", string(ik[8:]))
+				return
+			}
+		}
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	for _, sch := range fired {
+		go s.fire(ctx, sch)
+	}
 
-				// prepare the success key(s) to be deleted
-				successKeys = append(successKeys, string(k))
+	return
+}
+
+// fire runs the pending data handlers of the schedule concurrently and records the outcome
+func (s *StreamSchedule) fire(ctx context.Context, sch Schedule) {
+	linkURL := sch.Data.Feed.Entry.Link.Href
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failures := make(map[string]string, len(sch.PendingHandlers))
+	laters := make(map[string]time.Time, len(sch.PendingHandlers))
+	for _, name := range sch.PendingHandlers {
+		d, ok := s.dataHandlers[name]
+		if !ok {
+			s.logger.Warnf("Data handler %s is no longer registered, dropping it from schedule of video %s", name, linkURL)
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, d ytfeed.DataHandlerErrFunc) {
+			defer wg.Done()
+
+			err := runDataHandler(ytfeed.WithRetryLater(ctx), d, sch.Data)
+			if err == nil {
+				return
 			}
 
+			lock.Lock()
+			defer lock.Unlock()
+			if later, ok := errors.Cause(err).(*ytfeed.RetryLaterError); ok {
+				laters[name] = later.RetryAt
+				return
+			}
+			failures[name] = err.Error()
+		}(name, d)
+	}
+	wg.Wait()
+
+	// unfinished because of shutdown, it stays in progress to be fired again on the next start
+	if ctx.Err() != nil {
+		return
+	}
+
+	err := s.finish(linkURL, sch.Attempts, failures, laters)
+	if err != nil {
+		s.logger.Errorf("Failed to finish schedule of video %s: %v", linkURL, err)
+	}
+}
+
+// runDataHandler reports a data handler that returned an error or panicked as failed
+func runDataHandler(ctx context.Context, d ytfeed.DataHandlerErrFunc, data *ytfeed.Data) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("data handler panicked: %v", r)
+		}
+	}()
+	err = d(ctx, data)
+
+	return
+}
+
+// finish deletes the schedule if every data handler succeeded, otherwise it is retried with backoff
+// or moved to the dead letter bucket after max attempts. Data handlers that asked to be retried later
+// are fired again at the time they asked for, that alone isn't a failed attempt.
+func (s *StreamSchedule) finish(linkURL string, attempts int, failures map[string]string, laters map[string]time.Time) (err error) {
+	var retryAt time.Time
+	deadLetter := false
+	failed := len(failures) > 0
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+
+		v := b.Get([]byte(linkURL))
+		if v == nil {
+			// cancelled while firing
 			return
-		})
+		}
 
-		// we collect the error(s) instead of straight jumping out at the first error
-		failedKeysToDelete := make([]FailedOperation, 0, len(successKeys))
+		sch := Schedule{}
+		err = json.Unmarshal(v, &sch)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal schedule json with key %s", linkURL)
+			return
+		}
 
-		// delete success keys
-		for _, k := range successKeys {
-			// delete key from database
-			err = b.Delete([]byte(k))
+		// registered again or rescheduled while firing, the new schedule wins
+		if sch.State != ScheduleStateInProgress || sch.Attempts != attempts {
+			return
+		}
+
+		if len(failures) == 0 && len(laters) == 0 {
+			err = b.Delete([]byte(linkURL))
+			return
+		}
+
+		pending := make([]string, 0, len(failures)+len(laters))
+		errMessages := make([]string, 0, len(failures))
+		var laterAt time.Time
+		for _, name := range sch.PendingHandlers {
+			if failure, ok := failures[name]; ok {
+				pending = append(pending, name)
+				errMessages = append(errMessages, fmt.Sprintf("%s: %s", name, failure))
+			}
+			if at, ok := laters[name]; ok {
+				pending = append(pending, name)
+				if laterAt.IsZero() || at.Before(laterAt) {
+					laterAt = at
+				}
+			}
+		}
+		sch.PendingHandlers = pending
+		sch.LastError = strings.Join(errMessages, ", ")
+
+		if !failed {
+			sch.State = ScheduleStateScheduled
+			sch.Attempts = attempts - 1
+			sch.RunAt = laterAt
+			retryAt = sch.RunAt
+			err = putSchedule(tx, linkURL, sch)
+			return
+		}
+
+		if sch.Attempts >= s.maxAttempts {
+			deadLetter = true
+			err = putJSON(tx.Bucket([]byte(DefaultDeadLetterBucketName)), linkURL, DeadLetter{Schedule: sch, FailedAt: time.Now()})
 			if err != nil {
-				failedKeysToDelete = append(failedKeysToDelete, FailedOperation{
-					Error: err,
-					Key:   k,
-				})
+				return
 			}
+			err = b.Delete([]byte(linkURL))
+			return
 		}
 
-		// if there is failure in deleting success keys, collect the error from those keys here
-		if len(failedKeysToDelete) > 0 {
-			errMessage := ""
-			for _, fk := range failedKeysToDelete {
-				errMessage += fmt.Sprintf("key %s and error %v, ", fk.Key, fk.Error)
+		sch.State = ScheduleStateScheduled
+		sch.RunAt = time.Now().Add(s.backoff(sch.Attempts))
+		if !laterAt.IsZero() && laterAt.Before(sch.RunAt) {
+			sch.RunAt = laterAt
+		}
+		retryAt = sch.RunAt
+		err = putSchedule(tx, linkURL, sch)
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	if deadLetter {
+		s.logger.Errorf("Schedule of video %s failed %d times, moved it to dead letters", linkURL, attempts)
+	} else if !retryAt.IsZero() && failed {
+		s.logger.Warnf("Schedule of video %s failed, retrying at %s", linkURL, retryAt)
+	} else if !retryAt.IsZero() {
+		s.logger.Infof("Schedule of video %s is due again at %s", linkURL, retryAt)
+	}
+
+	return
+}
+
+// backoff returns the delay before the next attempt after attempts failed ones
+func (s *StreamSchedule) backoff(attempts int) (delay time.Duration) {
+	delay = s.retryBackoff
+	for i := 1; i < attempts && delay < s.maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.maxRetryBackoff {
+		delay = s.maxRetryBackoff
+	}
+
+	return
+}
+
+// DeadLetters returns the schedules that failed max attempts times ordered by when they failed
+func (s *StreamSchedule) DeadLetters() (deadLetters []DeadLetter, err error) {
+	deadLetters = make([]DeadLetter, 0, 16)
+	err = s.database.View(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultDeadLetterBucketName))
+
+		return b.ForEach(func(k, v []byte) (err error) {
+			dl := DeadLetter{}
+			err = json.Unmarshal(v, &dl)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal dead letter json with key %s", string(k))
+				return
 			}
-			err = fmt.Errorf(ErrFailedToDeleteKeysFormat, errMessage)
+			deadLetters = append(deadLetters, dl)
+
 			return
+		})
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to list dead letters")
+		return
+	}
+
+	sort.SliceStable(deadLetters, func(i, j int) bool {
+		return deadLetters[i].FailedAt.Before(deadLetters[j].FailedAt)
+	})
+
+	return
+}
+
+// RequeueDeadLetter schedules the failed data handlers of the dead letter again at runAt with fresh attempts,
+// err is ErrScheduleNotFound if there is no such dead letter
+func (s *StreamSchedule) RequeueDeadLetter(linkURL string, runAt time.Time) (err error) {
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		db := tx.Bucket([]byte(DefaultDeadLetterBucketName))
+		v := db.Get([]byte(linkURL))
+		if v == nil {
+			return ErrScheduleNotFound
 		}
 
+		dl := DeadLetter{}
+		err = json.Unmarshal(v, &dl)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal dead letter json with key %s", linkURL)
+			return
+		}
+
+		sch := dl.Schedule
+		sch.RunAt = runAt
+		sch.State = ScheduleStateScheduled
+		// keep the pending handlers so only the failed ones run again
+		sch.Attempts = 0
+		err = putSchedule(tx, linkURL, sch)
+		if err != nil {
+			return
+		}
+		err = db.Delete([]byte(linkURL))
+
 		return
 	})
 
 	return
 }
 
-type FailedOperation struct {
-	Error error
-	Key   string
+// DeleteDeadLetter drops the dead letter for good, err is ErrScheduleNotFound if there is no such dead letter
+func (s *StreamSchedule) DeleteDeadLetter(linkURL string) (err error) {
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		db := tx.Bucket([]byte(DefaultDeadLetterBucketName))
+		if db.Get([]byte(linkURL)) == nil {
+			return ErrScheduleNotFound
+		}
+		err = db.Delete([]byte(linkURL))
+
+		return
+	})
+
+	return
 }
 
 func (s *StreamSchedule) CloseDatabase() (err error) {
@@ -159,15 +637,72 @@ func New(logger ytfeed.Logger, databasePath string, workerInterval time.Duration
 	s = &StreamSchedule{}
 	s.logger = logger
 	s.workerInterval = workerInterval
+	s.handlerNames = make([]string, 0, 4)
+	s.dataHandlers = make(map[string]ytfeed.DataHandlerErrFunc, 4)
+	s.SetRetryPolicy(0, 0, 0)
 	s.database, err = bbolt.Open(databasePath, DefaultFilePermission, &bbolt.Options{Timeout: DefaultDatabaseOpenTimeout})
 	if err != nil {
 		return
 	}
 
 	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
-		_, err = tx.CreateBucketIfNotExists([]byte(DefaultBucketName))
+		b, err := tx.CreateBucketIfNotExists([]byte(DefaultBucketName))
+		if err != nil {
+			return
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(DefaultDeadLetterBucketName))
+		if err != nil {
+			return
+		}
+
+		// schedules registered before the index existed are indexed once
+		ib := tx.Bucket([]byte(DefaultIndexBucketName))
+		reindex := ib == nil
+		if reindex {
+			ib, err = tx.CreateBucket([]byte(DefaultIndexBucketName))
+			if err != nil {
+				return
+			}
+		}
+
+		// schedules that were in progress when the previous run stopped are fired again right away
+		interrupted := make(map[string]Schedule)
+		err = b.ForEach(func(k, v []byte) (err error) {
+			sch := Schedule{}
+			err = json.Unmarshal(v, &sch)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal schedule json with key %s", string(k))
+				return
+			}
+
+			if sch.State == ScheduleStateInProgress {
+				sch.State = ScheduleStateScheduled
+				interrupted[string(k)] = sch
+			} else if !reindex {
+				return
+			}
+
+			return ib.Put(indexKey(sch.RunAt, string(k)), k)
+		})
+		if err != nil {
+			return
+		}
+
+		for k, sch := range interrupted {
+			err = putJSON(b, k, sch)
+			if err != nil {
+				return
+			}
+		}
+
 		return
 	})
+	if err != nil {
+		_ = s.database.Close()
+		s = nil
+		err = errors.Wrap(err, "failed to load stream schedules")
+		return
+	}
 
 	return
 }
@@ -2,6 +2,7 @@ package streamschedule
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -12,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/worksinmagic/ytfeed"
 	"github.com/worksinmagic/ytfeed/mock"
+	"go.etcd.io/bbolt"
 )
 
 const (
@@ -27,7 +29,7 @@ func TestStreamSchedule(t *testing.T) {
 	var lock sync.Mutex
 	runTimes := 0
 	runVideoURL := ""
-	mockDataHandler := func(ctx context.Context, d *ytfeed.Data) {
+	mockDataHandler := func(ctx context.Context, d *ytfeed.Data) error {
 		defer mockDataHandlerWG.Done()
 
 		lock.Lock()
@@ -41,6 +43,8 @@ func TestStreamSchedule(t *testing.T) {
 
 		runVideoURL = d.Feed.Entry.Link.Href
 		runTimes++
+
+		return nil
 	}
 
 	// handler must be run exactly twice for same video URL
@@ -49,7 +53,7 @@ func TestStreamSchedule(t *testing.T) {
 	var lock2 sync.Mutex
 	runTimes2 := 0
 	runVideoURL2 := ""
-	mockDataHandler2 := func(ctx context.Context, d *ytfeed.Data) {
+	mockDataHandler2 := func(ctx context.Context, d *ytfeed.Data) error {
 		defer mockDataHandlerWG2.Done()
 
 		lock2.Lock()
@@ -63,6 +67,8 @@ func TestStreamSchedule(t *testing.T) {
 
 		runVideoURL2 = d.Feed.Entry.Link.Href
 		runTimes2++
+
+		return nil
 	}
 
 	var wg sync.WaitGroup
@@ -85,7 +91,7 @@ func TestStreamSchedule(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, s)
 
-		s.RegisterDataHandler(mockDataHandler)
+		s.RegisterDataHandler("mock", mockDataHandler)
 
 		mockDataHandlerWG.Add(1)
 		go func() {
@@ -138,7 +144,7 @@ func TestStreamSchedule(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, s)
 
-		s.RegisterDataHandler(mockDataHandler2)
+		s.RegisterDataHandler("mock", mockDataHandler2)
 
 		mockDataHandlerWG2.Add(1)
 		go func() {
@@ -188,9 +194,432 @@ func TestStreamSchedule(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, s)
 
-		s.RegisterDataHandler(mockDataHandler)
+		s.RegisterDataHandler("mock", mockDataHandler)
 
 		err = s.CloseDatabase()
 		require.NoError(t, err)
 	})
+
+	// waitFinished waits until the fired schedule is deleted
+	waitFinished := func(t *testing.T, s *StreamSchedule, linkURL string) {
+		require.Eventually(t, func() bool {
+			_, err := s.Get(linkURL)
+			return err == ErrScheduleNotFound
+		}, time.Second, time.Millisecond)
+	}
+
+	newData := func(linkURL string) *ytfeed.Data {
+		data := &ytfeed.Data{}
+		data.OriginalXMLMessage = xmlData
+		data.Feed.Entry.Link.Href = linkURL
+
+		return data
+	}
+
+	t.Run("List, Get, Cancel and Reschedule", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s, err := New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+
+		runAt := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+		require.NoError(t, s.RegisterSchedule(runAt.Add(time.Minute), newData(videoURL)))
+		require.NoError(t, s.RegisterSchedule(runAt, newData(videoURL2)))
+
+		schedules, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, schedules, 2)
+		require.Equal(t, videoURL2, schedules[0].Data.Feed.Entry.Link.Href)
+		require.Equal(t, videoURL, schedules[1].Data.Feed.Entry.Link.Href)
+
+		sch, err := s.Get(videoURL2)
+		require.NoError(t, err)
+		require.True(t, runAt.Equal(sch.RunAt))
+		require.Equal(t, xmlData, sch.Data.OriginalXMLMessage)
+
+		_, err = s.Get("https://www.youtube.com/watch?v=unknown")
+		require.Equal(t, ErrScheduleNotFound, err)
+
+		require.NoError(t, s.Reschedule(videoURL, runAt.Add(-time.Minute)))
+		schedules, err = s.List()
+		require.NoError(t, err)
+		require.Equal(t, videoURL, schedules[0].Data.Feed.Entry.Link.Href)
+		require.True(t, runAt.Add(-time.Minute).Equal(schedules[0].RunAt))
+		require.Equal(t, ErrScheduleNotFound, s.Reschedule("https://www.youtube.com/watch?v=unknown", runAt))
+
+		require.NoError(t, s.Cancel(videoURL))
+		require.Equal(t, ErrScheduleNotFound, s.Cancel(videoURL))
+		schedules, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, schedules, 1)
+
+		// the index has no stale keys left
+		err = s.database.View(func(tx *bbolt.Tx) error {
+			require.Equal(t, 1, tx.Bucket([]byte(DefaultIndexBucketName)).Stats().KeyN)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Rescheduled and cancelled schedules are not ran at their old time", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s, err := New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+
+		ran := make(chan string, 2)
+		s.RegisterDataHandler("mock", func(ctx context.Context, d *ytfeed.Data) error {
+			ran <- d.Feed.Entry.Link.Href
+
+			return nil
+		})
+
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(-time.Minute), newData(videoURL)))
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(-time.Minute), newData(videoURL2)))
+		require.NoError(t, s.Reschedule(videoURL, time.Now().Add(time.Hour)))
+		require.NoError(t, s.Cancel(videoURL2))
+
+		require.NoError(t, s.work(context.TODO()))
+		select {
+		case linkURL := <-ran:
+			t.Fatalf("schedule of %s must not run", linkURL)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NoError(t, s.Reschedule(videoURL, time.Now().Add(-time.Second)))
+		require.NoError(t, s.work(context.TODO()))
+		require.Equal(t, videoURL, <-ran)
+		waitFinished(t, s, videoURL)
+
+		schedules, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, schedules)
+	})
+
+	t.Run("DataHandler cancels schedule of deleted entry", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		s, err := New(logger, databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(time.Hour), newData(videoURL)))
+
+		// not a deleted entry
+		s.DataHandler(context.TODO(), newData(videoURL))
+		_, err = s.Get(videoURL)
+		require.NoError(t, err)
+
+		deleted := &ytfeed.Data{}
+		deleted.Feed.DeletedEntry.Link.Href = videoURL
+		logger.EXPECT().Infof(gomock.Any(), videoURL)
+		s.DataHandler(context.TODO(), deleted)
+		_, err = s.Get(videoURL)
+		require.Equal(t, ErrScheduleNotFound, err)
+
+		// deleted entry without schedule
+		require.NoError(t, s.HandleData(context.TODO(), deleted))
+	})
+
+	t.Run("New indexes schedules of databases without index", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s, err := New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(-time.Minute), newData(videoURL)))
+		err = s.database.Update(func(tx *bbolt.Tx) error {
+			return tx.DeleteBucket([]byte(DefaultIndexBucketName))
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.CloseDatabase())
+
+		s, err = New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+
+		ran := make(chan string, 1)
+		s.RegisterDataHandler("mock", func(ctx context.Context, d *ytfeed.Data) error {
+			ran <- d.Feed.Entry.Link.Href
+
+			return nil
+		})
+		require.NoError(t, s.work(context.TODO()))
+		require.Equal(t, videoURL, <-ran)
+		waitFinished(t, s, videoURL)
+	})
+
+	t.Run("New failed closes the database", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s, err := New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+		err = s.database.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte(DefaultBucketName)).Put([]byte(videoURL), []byte("not json"))
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.CloseDatabase())
+
+		s, err = New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.Error(t, err)
+		require.Nil(t, s)
+
+		// the file is not left locked
+		db, err := bbolt.Open(databasePath, DefaultFilePermission, &bbolt.Options{Timeout: DefaultDatabaseOpenTimeout})
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		s := &StreamSchedule{}
+		s.SetRetryPolicy(0, 0, 0)
+		require.Equal(t, DefaultMaxAttempts, s.maxAttempts)
+		require.Equal(t, DefaultRetryBackoff, s.backoff(1))
+		require.Equal(t, 4*DefaultRetryBackoff, s.backoff(3))
+		require.Equal(t, DefaultMaxRetryBackoff, s.backoff(100))
+	})
+
+	t.Run("Schedules with handlers that return an error or panic are retried then dead lettered", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		s, err := New(logger, databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+		s.SetRetryPolicy(2, time.Millisecond, time.Millisecond)
+
+		var handlerLock sync.Mutex
+		okRuns := 0
+		failing := true
+		flakyRuns := 0
+		s.RegisterDataHandler("ok", func(ctx context.Context, d *ytfeed.Data) error {
+			handlerLock.Lock()
+			defer handlerLock.Unlock()
+			okRuns++
+
+			return nil
+		})
+		s.RegisterDataHandler("flaky", func(ctx context.Context, d *ytfeed.Data) error {
+			handlerLock.Lock()
+			defer handlerLock.Unlock()
+			flakyRuns++
+			if failing && flakyRuns == 1 {
+				return fmt.Errorf("expected error")
+			}
+			if failing {
+				panic("expected panic")
+			}
+
+			return nil
+		})
+
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(-time.Minute), newData(videoURL)))
+
+		// first attempt fails and is retried
+		retried := make(chan struct{})
+		logger.EXPECT().Warnf(gomock.Any(), videoURL, gomock.Any()).Do(func(format string, args ...interface{}) {
+			close(retried)
+		})
+		require.NoError(t, s.work(context.TODO()))
+		<-retried
+
+		sch, err := s.Get(videoURL)
+		require.NoError(t, err)
+		require.Equal(t, ScheduleStateScheduled, sch.State)
+		require.Equal(t, 1, sch.Attempts)
+		require.Equal(t, []string{"flaky"}, sch.PendingHandlers)
+		require.Contains(t, sch.LastError, "flaky: expected error")
+
+		// second attempt fails for good, only the failed handler runs again
+		deadLettered := make(chan struct{})
+		logger.EXPECT().Errorf(gomock.Any(), videoURL, 2).Do(func(format string, args ...interface{}) {
+			close(deadLettered)
+		})
+		require.Eventually(t, func() bool {
+			require.NoError(t, s.work(context.TODO()))
+			select {
+			case <-deadLettered:
+				return true
+			default:
+				return false
+			}
+		}, time.Second, 5*time.Millisecond)
+
+		_, err = s.Get(videoURL)
+		require.Equal(t, ErrScheduleNotFound, err)
+		deadLetters, err := s.DeadLetters()
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		require.Equal(t, videoURL, deadLetters[0].Data.Feed.Entry.Link.Href)
+		require.Equal(t, []string{"flaky"}, deadLetters[0].PendingHandlers)
+		require.Equal(t, 2, deadLetters[0].Attempts)
+		require.Contains(t, deadLetters[0].LastError, "flaky: data handler panicked: expected panic")
+
+		// requeued dead letter gets fresh attempts and succeeds
+		handlerLock.Lock()
+		failing = false
+		handlerLock.Unlock()
+		require.Equal(t, ErrScheduleNotFound, s.RequeueDeadLetter(videoURL2, time.Now()))
+		require.NoError(t, s.RequeueDeadLetter(videoURL, time.Now().Add(-time.Second)))
+		deadLetters, err = s.DeadLetters()
+		require.NoError(t, err)
+		require.Empty(t, deadLetters)
+		sch, err = s.Get(videoURL)
+		require.NoError(t, err)
+		require.Equal(t, 0, sch.Attempts)
+
+		require.NoError(t, s.work(context.TODO()))
+		waitFinished(t, s, videoURL)
+		handlerLock.Lock()
+		require.Equal(t, 1, okRuns)
+		handlerLock.Unlock()
+
+		require.Equal(t, ErrScheduleNotFound, s.DeleteDeadLetter(videoURL))
+		err = s.database.Update(func(tx *bbolt.Tx) error {
+			return putJSON(tx.Bucket([]byte(DefaultDeadLetterBucketName)), videoURL, DeadLetter{})
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteDeadLetter(videoURL))
+	})
+
+	t.Run("Schedules in progress are fired again after restart", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s, err := New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+
+		started := make(chan struct{})
+		s.RegisterDataHandler("mock", func(ctx context.Context, d *ytfeed.Data) error {
+			close(started)
+			<-ctx.Done()
+
+			return nil
+		})
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(-time.Minute), newData(videoURL)))
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		require.NoError(t, s.work(ctx))
+		<-started
+
+		sch, err := s.Get(videoURL)
+		require.NoError(t, err)
+		require.Equal(t, ScheduleStateInProgress, sch.State)
+
+		// nothing is due while it is in progress
+		require.NoError(t, s.work(ctx))
+
+		// shutdown leaves it in progress
+		cancel()
+		require.NoError(t, s.CloseDatabase())
+
+		s, err = New(mock.NewMockLogger(ctrl), databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+
+		sch, err = s.Get(videoURL)
+		require.NoError(t, err)
+		require.Equal(t, ScheduleStateScheduled, sch.State)
+
+		ran := make(chan string, 1)
+		s.RegisterDataHandler("mock", func(ctx context.Context, d *ytfeed.Data) error {
+			ran <- d.Feed.Entry.Link.Href
+
+			return nil
+		})
+		require.NoError(t, s.work(context.TODO()))
+		require.Equal(t, videoURL, <-ran)
+		waitFinished(t, s, videoURL)
+	})
+
+	t.Run("Handlers that ask to be retried later are fired again without counting an attempt", func(t *testing.T) {
+		databasePath := filepath.Join(os.TempDir(), "database.db")
+		defer os.Remove(databasePath)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		logger := mock.NewMockLogger(ctrl)
+		s, err := New(logger, databasePath, time.Hour)
+		require.NoError(t, err)
+		defer s.CloseDatabase()
+		s.SetRetryPolicy(1, time.Hour, time.Hour)
+
+		var handlerLock sync.Mutex
+		okRuns := 0
+		laterRuns := 0
+		s.RegisterDataHandler("ok", func(ctx context.Context, d *ytfeed.Data) error {
+			handlerLock.Lock()
+			defer handlerLock.Unlock()
+			okRuns++
+
+			return nil
+		})
+		s.RegisterDataHandler("later", func(ctx context.Context, d *ytfeed.Data) error {
+			handlerLock.Lock()
+			defer handlerLock.Unlock()
+			laterRuns++
+			require.True(t, ytfeed.RetriesLater(ctx))
+			if laterRuns < 3 {
+				return &ytfeed.RetryLaterError{RetryAt: time.Now().Add(-time.Second), Reason: "not yet"}
+			}
+
+			return nil
+		})
+
+		require.NoError(t, s.RegisterSchedule(time.Now().Add(-time.Minute), newData(videoURL)))
+
+		// asking twice doesn't dead letter it with one max attempt, only the handler that asked runs again
+		due := make(chan struct{}, 2)
+		logger.EXPECT().Infof(gomock.Any(), videoURL, gomock.Any()).Do(func(format string, args ...interface{}) {
+			due <- struct{}{}
+		}).Times(2)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, s.work(context.TODO()))
+			<-due
+
+			sch, err := s.Get(videoURL)
+			require.NoError(t, err)
+			require.Equal(t, ScheduleStateScheduled, sch.State)
+			require.Equal(t, 0, sch.Attempts)
+			require.Equal(t, []string{"later"}, sch.PendingHandlers)
+			require.Empty(t, sch.LastError)
+		}
+
+		require.NoError(t, s.work(context.TODO()))
+		waitFinished(t, s, videoURL)
+		handlerLock.Lock()
+		require.Equal(t, 1, okRuns)
+		require.Equal(t, 3, laterRuns)
+		handlerLock.Unlock()
+	})
 }
@@ -0,0 +1,332 @@
+package subscriptionimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionstore"
+	"github.com/worksinmagic/ytfeed/rss"
+)
+
+const (
+	DefaultOPMLTitle = "ytfeed subscriptions"
+
+	YoutubeFeedURLPrefix    = "https://www.youtube.com/feeds/videos.xml?channel_id="
+	YoutubeChannelURLPrefix = "https://www.youtube.com/channel/"
+)
+
+var (
+	ErrUnknownFormat = errors.New("input is neither OPML nor a takeout subscriptions CSV")
+)
+
+// Entry is a channel found in an import file, Input is a subscription topic or anything the topic resolver accepts
+type Entry struct {
+	Input string `json:"input"`
+	Label string `json:"label,omitempty"`
+}
+
+// Failure is an entry that could not be imported
+type Failure struct {
+	Input string `json:"input"`
+	Error string `json:"error"`
+}
+
+// Result lists the topics that were added, the ones that were already in the subscription set and the failures
+type Result struct {
+	Imported []string  `json:"imported"`
+	Existing []string  `json:"existing"`
+	Failed   []Failure `json:"failed,omitempty"`
+}
+
+type Store interface {
+	Get(topic string) (*subscriptionstore.Subscription, error)
+	Put(sub subscriptionstore.Subscription) (subscriptionstore.Subscription, error)
+}
+
+// ResolveTopicFunc returns the subscription topic of the channel input refers to
+type ResolveTopicFunc func(ctx context.Context, input string) (topic string, err error)
+
+// Importer adds the entries of import files to the subscription store
+type Importer struct {
+	store        Store
+	resolveTopic ResolveTopicFunc
+}
+
+// Import adds every entry as an enabled subscription, existing subscriptions are left untouched
+func (i *Importer) Import(ctx context.Context, entries []Entry) (result Result) {
+	result.Imported = make([]string, 0, len(entries))
+	result.Existing = make([]string, 0)
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		topic, err := i.resolveTopic(ctx, entry.Input)
+		if err != nil {
+			result.Failed = append(result.Failed, Failure{Input: entry.Input, Error: err.Error()})
+			continue
+		}
+		if seen[topic] {
+			continue
+		}
+		seen[topic] = true
+
+		_, err = i.store.Get(topic)
+		if err == nil {
+			result.Existing = append(result.Existing, topic)
+			continue
+		}
+		if err != subscriptionstore.ErrSubscriptionNotFound {
+			result.Failed = append(result.Failed, Failure{Input: entry.Input, Error: err.Error()})
+			continue
+		}
+
+		_, err = i.store.Put(subscriptionstore.Subscription{Topic: topic, Label: entry.Label, Enabled: true})
+		if err != nil {
+			result.Failed = append(result.Failed, Failure{Input: entry.Input, Error: err.Error()})
+			continue
+		}
+		result.Imported = append(result.Imported, topic)
+	}
+
+	return
+}
+
+func New(store Store, resolveTopic ResolveTopicFunc) (i *Importer) {
+	i = &Importer{}
+	i.store = store
+	i.resolveTopic = resolveTopic
+
+	return
+}
+
+// Parse reads OPML or a takeout subscriptions CSV depending on what r starts with
+func Parse(r io.Reader) (entries []Entry, err error) {
+	br := bufio.NewReader(r)
+	for {
+		var b []byte
+		b, err = br.Peek(1)
+		if err == io.EOF {
+			err = ErrUnknownFormat
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		switch {
+		case b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n':
+			_, _ = br.ReadByte()
+			continue
+		case bytes.HasPrefix(b, []byte("<")):
+			return ParseOPML(br)
+		case bytes.HasPrefix(b, []byte("\xef")):
+			// byte order mark of takeout files
+			_, _, _ = br.ReadRune()
+			continue
+		default:
+			return ParseTakeoutCSV(br)
+		}
+	}
+}
+
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title string `xml:"title"`
+}
+
+type opmlBody struct {
+	Outlines []outline `xml:"outline"`
+}
+
+type outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	Outlines []outline `xml:"outline,omitempty"`
+}
+
+// ParseOPML returns the YouTube channels of the OPML outlines including nested ones, feeds of other sites are skipped
+func ParseOPML(r io.Reader) (entries []Entry, err error) {
+	doc := opml{}
+	err = xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode OPML")
+		return
+	}
+
+	entries = make([]Entry, 0, len(doc.Body.Outlines))
+	var walk func(outlines []outline)
+	walk = func(outlines []outline) {
+		for _, o := range outlines {
+			walk(o.Outlines)
+
+			input := feedInput(o.XMLURL)
+			if input == "" && isYoutubeURL(o.HTMLURL) {
+				input = o.HTMLURL
+			}
+			if input == "" {
+				continue
+			}
+
+			label := o.Title
+			if label == "" {
+				label = o.Text
+			}
+			entries = append(entries, Entry{Input: input, Label: label})
+		}
+	}
+	walk(doc.Body.Outlines)
+
+	return
+}
+
+// ParseTakeoutCSV returns the channels of the subscriptions.csv of Google Takeout, its columns are channel ID, channel URL and title
+func ParseTakeoutCSV(r io.Reader) (entries []Entry, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		err = errors.Wrap(err, "failed to read takeout CSV")
+		return
+	}
+
+	entries = make([]Entry, 0, len(records))
+	for i, record := range records {
+		if len(record) < 1 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		// the header is localized so it is recognized by not being a channel
+		channelID := strings.TrimSpace(record[0])
+		if i == 0 && !strings.HasPrefix(channelID, "UC") {
+			continue
+		}
+
+		entry := Entry{Input: rss.YoutubeSubscriptionTopicPrefix + channelID}
+		if len(record) > 2 {
+			entry.Label = strings.TrimSpace(record[2])
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// WriteOPML writes the subscriptions as OPML that RSS readers can import
+func WriteOPML(w io.Writer, subs []subscriptionstore.Subscription) (err error) {
+	doc := opml{Version: "2.0"}
+	doc.Head.Title = DefaultOPMLTitle
+	doc.Body.Outlines = make([]outline, 0, len(subs))
+	for _, sub := range subs {
+		channelID := strings.TrimPrefix(sub.Topic, rss.YoutubeSubscriptionTopicPrefix)
+		label := sub.Label
+		if label == "" {
+			label = channelID
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, outline{
+			Text:    label,
+			Title:   label,
+			Type:    "rss",
+			XMLURL:  YoutubeFeedURLPrefix + channelID,
+			HTMLURL: YoutubeChannelURLPrefix + channelID,
+		})
+	}
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		err = errors.Wrap(err, "failed to encode OPML")
+		return
+	}
+
+	return
+}
+
+// SubscriptionSet returns the subscriptions the subscriber subscribes to, the static topics are first and
+// use the metadata of their stored subscription if there is one
+func SubscriptionSet(static []string, stored []subscriptionstore.Subscription) (subs []subscriptionstore.Subscription) {
+	byTopic := make(map[string]subscriptionstore.Subscription, len(stored))
+	for _, sub := range stored {
+		byTopic[sub.Topic] = sub
+	}
+
+	subs = make([]subscriptionstore.Subscription, 0, len(static)+len(stored))
+	seen := make(map[string]bool, len(static)+len(stored))
+	for _, topic := range static {
+		sub, ok := byTopic[topic]
+		if seen[topic] || (ok && !sub.Enabled) {
+			continue
+		}
+		seen[topic] = true
+		if !ok {
+			sub = subscriptionstore.Subscription{Topic: topic, Enabled: true}
+		}
+		subs = append(subs, sub)
+	}
+	for _, sub := range stored {
+		if seen[sub.Topic] || !sub.Enabled {
+			continue
+		}
+		seen[sub.Topic] = true
+		subs = append(subs, sub)
+	}
+
+	return
+}
+
+// feedInput returns what to resolve for a YouTube feed URL, it is empty for other feeds
+func feedInput(feedURL string) (input string) {
+	if rss.IsYoutubeSubscriptionTopic(feedURL) {
+		return feedURL
+	}
+	if !isYoutubeURL(feedURL) {
+		return
+	}
+	u, err := url.Parse(feedURL)
+	if err != nil || !strings.HasSuffix(u.Path, "/feeds/videos.xml") {
+		return
+	}
+
+	query := u.Query()
+	switch {
+	case query.Get("channel_id") != "":
+		input = rss.YoutubeSubscriptionTopicPrefix + query.Get("channel_id")
+	case query.Get("user") != "":
+		input = "https://www.youtube.com/user/" + query.Get("user")
+	case query.Get("playlist_id") != "":
+		input = "https://www.youtube.com/playlist?list=" + query.Get("playlist_id")
+	}
+
+	return
+}
+
+func isYoutubeURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	switch strings.TrimPrefix(u.Hostname(), "www.") {
+	case "youtube.com", "m.youtube.com", "youtu.be":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,146 @@
+package subscriptionimport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/plugin/subscriptionstore"
+	"github.com/worksinmagic/ytfeed/rss"
+)
+
+const (
+	channelID      = "UCuAXFkgsw1L7xaCfnd5JJOw"
+	otherChannelID = "UC38IQsAvIsxxjztdMZQtwHA"
+	topic          = rss.YoutubeSubscriptionTopicPrefix + channelID
+	otherTopic     = rss.YoutubeSubscriptionTopicPrefix + otherChannelID
+
+	sampleOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.1">
+  <head><title>Feeds</title></head>
+  <body>
+    <outline text="YouTube Subscriptions" title="YouTube Subscriptions">
+      <outline text="My Channel" title="My Channel" type="rss" xmlUrl="https://www.youtube.com/feeds/videos.xml?channel_id=UCuAXFkgsw1L7xaCfnd5JJOw"/>
+      <outline text="My User" type="rss" xmlUrl="https://www.youtube.com/feeds/videos.xml?user=myuser"/>
+    </outline>
+    <outline text="My Handle" type="link" htmlUrl="https://www.youtube.com/@myhandle"/>
+    <outline text="Blog" type="rss" xmlUrl="https://example.com/feed.xml" htmlUrl="https://example.com"/>
+  </body>
+</opml>`
+
+	sampleTakeoutCSV = "\xef\xbb\xbfChannel Id,Channel Url,Channel Title\n" +
+		"UCuAXFkgsw1L7xaCfnd5JJOw,http://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw,My Channel\n" +
+		"UC38IQsAvIsxxjztdMZQtwHA,http://www.youtube.com/channel/UC38IQsAvIsxxjztdMZQtwHA,\"Other, Channel\"\n" +
+		"\n"
+)
+
+type fakeStore struct {
+	subs map[string]subscriptionstore.Subscription
+	err  error
+}
+
+func (f *fakeStore) Get(topic string) (*subscriptionstore.Subscription, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	sub, ok := f.subs[topic]
+	if !ok {
+		return nil, subscriptionstore.ErrSubscriptionNotFound
+	}
+	return &sub, nil
+}
+
+func (f *fakeStore) Put(sub subscriptionstore.Subscription) (subscriptionstore.Subscription, error) {
+	f.subs[sub.Topic] = sub
+	return sub, nil
+}
+
+func TestSubscriptionImport(t *testing.T) {
+	t.Run("Parse OPML", func(t *testing.T) {
+		entries, err := Parse(strings.NewReader("\n  " + sampleOPML))
+		require.NoError(t, err)
+		require.Equal(t, []Entry{
+			{Input: topic, Label: "My Channel"},
+			{Input: "https://www.youtube.com/user/myuser", Label: "My User"},
+			{Input: "https://www.youtube.com/@myhandle", Label: "My Handle"},
+		}, entries)
+
+		_, err = Parse(strings.NewReader("<opml><body>"))
+		require.Error(t, err)
+	})
+
+	t.Run("Parse takeout CSV", func(t *testing.T) {
+		entries, err := Parse(strings.NewReader(sampleTakeoutCSV))
+		require.NoError(t, err)
+		require.Equal(t, []Entry{
+			{Input: topic, Label: "My Channel"},
+			{Input: otherTopic, Label: "Other, Channel"},
+		}, entries)
+
+		_, err = Parse(strings.NewReader("  \n"))
+		require.Equal(t, ErrUnknownFormat, err)
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		store := &fakeStore{subs: map[string]subscriptionstore.Subscription{
+			otherTopic: {Topic: otherTopic, Label: "kept", StoragePath: "kept"},
+		}}
+		resolveTopic := func(ctx context.Context, input string) (string, error) {
+			if input == "https://www.youtube.com/@myhandle" {
+				return topic, nil
+			}
+			if rss.IsYoutubeSubscriptionTopic(input) {
+				return input, nil
+			}
+			return "", errors.New("expected error")
+		}
+		i := New(store, resolveTopic)
+		require.NotNil(t, i)
+
+		result := i.Import(context.TODO(), []Entry{
+			{Input: topic, Label: "My Channel"},
+			{Input: "https://www.youtube.com/@myhandle", Label: "Duplicate"},
+			{Input: otherTopic, Label: "Other"},
+			{Input: "https://www.youtube.com/user/unknown"},
+		})
+		require.Equal(t, []string{topic}, result.Imported)
+		require.Equal(t, []string{otherTopic}, result.Existing)
+		require.Len(t, result.Failed, 1)
+		require.Equal(t, "https://www.youtube.com/user/unknown", result.Failed[0].Input)
+
+		require.True(t, store.subs[topic].Enabled)
+		require.Equal(t, "My Channel", store.subs[topic].Label)
+		// existing subscriptions are left untouched
+		require.Equal(t, "kept", store.subs[otherTopic].Label)
+
+		store.err = errors.New("expected error")
+		result = i.Import(context.TODO(), []Entry{{Input: topic}})
+		require.Empty(t, result.Imported)
+		require.Len(t, result.Failed, 1)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		subs := SubscriptionSet([]string{topic, otherTopic}, []subscriptionstore.Subscription{
+			{Topic: topic, Label: "My Channel", Enabled: true},
+			{Topic: otherTopic, Enabled: false},
+			{Topic: rss.YoutubeSubscriptionTopicPrefix + "UCstored", Enabled: true},
+		})
+		require.Len(t, subs, 2)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteOPML(buf, subs))
+		require.Contains(t, buf.String(), `xmlUrl="https://www.youtube.com/feeds/videos.xml?channel_id=UCuAXFkgsw1L7xaCfnd5JJOw"`)
+		require.Contains(t, buf.String(), `text="UCstored"`)
+
+		// the export can be imported again
+		entries, err := Parse(buf)
+		require.NoError(t, err)
+		require.Equal(t, []Entry{
+			{Input: topic, Label: "My Channel"},
+			{Input: rss.YoutubeSubscriptionTopicPrefix + "UCstored", Label: "UCstored"},
+		}, entries)
+	})
+}
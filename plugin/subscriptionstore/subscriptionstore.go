@@ -0,0 +1,289 @@
+package subscriptionstore
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed/rss"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	DefaultFilePermission      = 0666
+	DefaultDatabaseOpenTimeout = time.Second
+	DefaultBucketName          = "ytfeed-subscriptions"
+	// DefaultSubscribedBucketName holds the topics the hub was asked to push to us
+	DefaultSubscribedBucketName = "ytfeed-subscribed-topics"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvalidTopic         = errors.New("topic is not a youtube subscription topic")
+	ErrInvalidStoragePath   = errors.New("storage path must be relative and stay inside the storage root")
+)
+
+type Databaser interface {
+	Close() error
+	Update(func(tx *bbolt.Tx) error) error
+	View(func(tx *bbolt.Tx) error) error
+}
+
+// Subscription is a hub topic and its metadata, disabled subscriptions are not subscribed even if the topic is configured statically
+type Subscription struct {
+	Topic string `json:"topic"`
+	Label string `json:"label,omitempty"`
+	// StoragePath is prepended to the file name of videos of the channel, it is relative to the storage root
+	StoragePath string    `json:"storage_path,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// cleanStoragePath cleans the storage path and rejects absolute ones and ones that climb out of the storage root with ..
+func cleanStoragePath(storagePath string) (cleaned string, err error) {
+	if storagePath == "" {
+		return
+	}
+
+	cleaned = path.Clean(storagePath)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		cleaned = ""
+		err = ErrInvalidStoragePath
+		return
+	}
+	if cleaned == "." {
+		cleaned = ""
+	}
+
+	return
+}
+
+type SubscriptionStore struct {
+	database Databaser
+}
+
+// Put adds the subscription or updates the existing one of the same topic, the storage path is saved cleaned
+func (s *SubscriptionStore) Put(sub Subscription) (saved Subscription, err error) {
+	if !rss.IsYoutubeSubscriptionTopic(sub.Topic) {
+		err = ErrInvalidTopic
+		return
+	}
+	sub.StoragePath, err = cleanStoragePath(sub.StoragePath)
+	if err != nil {
+		return
+	}
+
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+
+		now := time.Now()
+		sub.CreatedAt = now
+		sub.UpdatedAt = now
+		if v := b.Get([]byte(sub.Topic)); v != nil {
+			existing := Subscription{}
+			err = json.Unmarshal(v, &existing)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal subscription json with key %s", sub.Topic)
+				return
+			}
+			sub.CreatedAt = existing.CreatedAt
+		}
+
+		var rawData []byte
+		rawData, err = json.Marshal(sub)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to json marshal subscription of topic %s", sub.Topic)
+			return
+		}
+
+		err = b.Put([]byte(sub.Topic), rawData)
+		return
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to save subscription of topic %s", sub.Topic)
+		return
+	}
+	saved = sub
+
+	return
+}
+
+// Get returns the subscription of the topic, err is ErrSubscriptionNotFound if there is none
+func (s *SubscriptionStore) Get(topic string) (sub *Subscription, err error) {
+	err = s.database.View(func(tx *bbolt.Tx) (err error) {
+		v := tx.Bucket([]byte(DefaultBucketName)).Get([]byte(topic))
+		if v == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		sub = &Subscription{}
+		err = json.Unmarshal(v, sub)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal subscription json with key %s", topic)
+		}
+
+		return
+	})
+	if err != nil {
+		sub = nil
+	}
+
+	return
+}
+
+// Delete removes the subscription of the topic, err is ErrSubscriptionNotFound if there is none
+func (s *SubscriptionStore) Delete(topic string) (err error) {
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket([]byte(DefaultBucketName))
+		if b.Get([]byte(topic)) == nil {
+			return ErrSubscriptionNotFound
+		}
+		err = b.Delete([]byte(topic))
+
+		return
+	})
+
+	return
+}
+
+// List returns every subscription ordered by topic
+func (s *SubscriptionStore) List() (subs []Subscription, err error) {
+	subs = make([]Subscription, 0, 16)
+	err = s.database.View(func(tx *bbolt.Tx) (err error) {
+		return tx.Bucket([]byte(DefaultBucketName)).ForEach(func(k, v []byte) (err error) {
+			sub := Subscription{}
+			err = json.Unmarshal(v, &sub)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal subscription json with key %s", string(k))
+				return
+			}
+			subs = append(subs, sub)
+
+			return
+		})
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to list subscriptions")
+		return
+	}
+
+	return
+}
+
+// Topics returns the enabled and disabled topics for the subscriber
+func (s *SubscriptionStore) Topics() (enabled, disabled []string, err error) {
+	subs, err := s.List()
+	if err != nil {
+		return
+	}
+
+	enabled = make([]string, 0, len(subs))
+	disabled = make([]string, 0, len(subs))
+	for _, sub := range subs {
+		if sub.Enabled {
+			enabled = append(enabled, sub.Topic)
+		} else {
+			disabled = append(disabled, sub.Topic)
+		}
+	}
+
+	return
+}
+
+// StoragePath returns the storage path override of the channel, it is empty if there is none
+func (s *SubscriptionStore) StoragePath(ctx context.Context, channelID string) (storagePath string, err error) {
+	sub, err := s.Get(rss.YoutubeSubscriptionTopicPrefix + channelID)
+	if err == ErrSubscriptionNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	storagePath = sub.StoragePath
+
+	return
+}
+
+// SubscribedTopics returns the topics the hub was asked to push to us and when they were subscribed
+func (s *SubscriptionStore) SubscribedTopics() (topics map[string]time.Time, err error) {
+	topics = make(map[string]time.Time)
+	err = s.database.View(func(tx *bbolt.Tx) (err error) {
+		return tx.Bucket([]byte(DefaultSubscribedBucketName)).ForEach(func(k, v []byte) (err error) {
+			var subscribedAt time.Time
+			err = subscribedAt.UnmarshalText(v)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to unmarshal subscribed time with key %s", string(k))
+				return
+			}
+			topics[string(k)] = subscribedAt
+
+			return
+		})
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to list subscribed topics")
+		return
+	}
+
+	return
+}
+
+// PutSubscribedTopic records that the hub was asked to push the topic to us
+func (s *SubscriptionStore) PutSubscribedTopic(topic string, subscribedAt time.Time) (err error) {
+	rawData, err := subscribedAt.MarshalText()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to marshal subscribed time of topic %s", topic)
+		return
+	}
+
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		return tx.Bucket([]byte(DefaultSubscribedBucketName)).Put([]byte(topic), rawData)
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to save subscribed topic %s", topic)
+		return
+	}
+
+	return
+}
+
+// DeleteSubscribedTopic forgets the topic once the hub stopped pushing it to us
+func (s *SubscriptionStore) DeleteSubscribedTopic(topic string) (err error) {
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		return tx.Bucket([]byte(DefaultSubscribedBucketName)).Delete([]byte(topic))
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to delete subscribed topic %s", topic)
+		return
+	}
+
+	return
+}
+
+func (s *SubscriptionStore) CloseDatabase() (err error) {
+	return s.database.Close()
+}
+
+func New(databasePath string) (s *SubscriptionStore, err error) {
+	s = &SubscriptionStore{}
+	s.database, err = bbolt.Open(databasePath, DefaultFilePermission, &bbolt.Options{Timeout: DefaultDatabaseOpenTimeout})
+	if err != nil {
+		return
+	}
+
+	err = s.database.Update(func(tx *bbolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists([]byte(DefaultBucketName))
+		if err != nil {
+			return
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(DefaultSubscribedBucketName))
+		return
+	})
+
+	return
+}
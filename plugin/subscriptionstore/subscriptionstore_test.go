@@ -0,0 +1,112 @@
+package subscriptionstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed/rss"
+)
+
+const (
+	channelID  = "UCuAXFkgsw1L7xaCfnd5JJOw"
+	topic      = rss.YoutubeSubscriptionTopicPrefix + channelID
+	otherTopic = rss.YoutubeSubscriptionTopicPrefix + "UC38IQsAvIsxxjztdMZQtwHA"
+)
+
+func TestSubscriptionStore(t *testing.T) {
+	databasePath := filepath.Join(os.TempDir(), "subscriptions.db")
+	defer os.Remove(databasePath)
+
+	s, err := New(databasePath)
+	require.NoError(t, err)
+	defer s.CloseDatabase()
+
+	t.Run("Put", func(t *testing.T) {
+		_, err := s.Put(Subscription{Topic: "https://example.com/feed"})
+		require.Equal(t, ErrInvalidTopic, err)
+
+		saved, err := s.Put(Subscription{Topic: topic, Label: "label", StoragePath: "channel", Enabled: true})
+		require.NoError(t, err)
+		require.False(t, saved.CreatedAt.IsZero())
+
+		// updating keeps the creation time
+		updated, err := s.Put(Subscription{Topic: topic, Label: "new label", StoragePath: "channel", Enabled: true})
+		require.NoError(t, err)
+		require.True(t, saved.CreatedAt.Equal(updated.CreatedAt))
+
+		_, err = s.Put(Subscription{Topic: otherTopic})
+		require.NoError(t, err)
+	})
+
+	t.Run("Put cleans storage path", func(t *testing.T) {
+		for _, storagePath := range []string{"/absolute", "..", "../parent", "channel/../../parent"} {
+			_, err := s.Put(Subscription{Topic: topic, StoragePath: storagePath})
+			require.Equal(t, ErrInvalidStoragePath, err, storagePath)
+		}
+
+		saved, err := s.Put(Subscription{Topic: topic, Label: "new label", StoragePath: "./channel//videos/../", Enabled: true})
+		require.NoError(t, err)
+		require.Equal(t, "channel", saved.StoragePath)
+	})
+
+	t.Run("Get and List", func(t *testing.T) {
+		sub, err := s.Get(topic)
+		require.NoError(t, err)
+		require.Equal(t, "new label", sub.Label)
+
+		_, err = s.Get(rss.YoutubeSubscriptionTopicPrefix + "unknown")
+		require.Equal(t, ErrSubscriptionNotFound, err)
+
+		subs, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, subs, 2)
+		require.Equal(t, otherTopic, subs[0].Topic)
+		require.Equal(t, topic, subs[1].Topic)
+	})
+
+	t.Run("Topics", func(t *testing.T) {
+		enabled, disabled, err := s.Topics()
+		require.NoError(t, err)
+		require.Equal(t, []string{topic}, enabled)
+		require.Equal(t, []string{otherTopic}, disabled)
+	})
+
+	t.Run("StoragePath", func(t *testing.T) {
+		storagePath, err := s.StoragePath(context.TODO(), channelID)
+		require.NoError(t, err)
+		require.Equal(t, "channel", storagePath)
+
+		storagePath, err = s.StoragePath(context.TODO(), "unknown")
+		require.NoError(t, err)
+		require.Empty(t, storagePath)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, s.Delete(topic))
+		require.Equal(t, ErrSubscriptionNotFound, s.Delete(topic))
+
+		subs, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, subs, 1)
+	})
+	t.Run("Subscribed topics", func(t *testing.T) {
+		subscribedAt := time.Now()
+		require.NoError(t, s.PutSubscribedTopic(topic, subscribedAt))
+		require.NoError(t, s.PutSubscribedTopic(otherTopic, subscribedAt))
+
+		topics, err := s.SubscribedTopics()
+		require.NoError(t, err)
+		require.Len(t, topics, 2)
+		require.True(t, subscribedAt.Equal(topics[topic]))
+
+		require.NoError(t, s.DeleteSubscribedTopic(topic))
+		topics, err = s.SubscribedTopics()
+		require.NoError(t, err)
+		require.Len(t, topics, 1)
+		require.Contains(t, topics, otherTopic)
+	})
+}
@@ -0,0 +1,29 @@
+package ytfeed
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DownloadStatusDownloading = "downloading"
+	DownloadStatusFinished    = "finished"
+	DownloadStatusFailed      = "failed"
+)
+
+type DownloadProgressHandlerFunc func(ctx context.Context, p *DownloadProgress)
+
+type DownloadProgress struct {
+	VideoName           string    `json:"video_name"`
+	URL                 string    `json:"url"`
+	Status              string    `json:"status"`
+	Percent             float64   `json:"percent"`
+	TotalBytes          int64     `json:"total_bytes"`
+	DownloadedBytes     int64     `json:"downloaded_bytes"`
+	SpeedBytesPerSecond float64   `json:"speed_bytes_per_second"`
+	ETASeconds          int64     `json:"eta_seconds"`
+	StartedAt           time.Time `json:"started_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	SubtitleLanguages   []string  `json:"subtitle_languages,omitempty"`
+	Error               string    `json:"error,omitempty"`
+}
@@ -0,0 +1,207 @@
+package rss
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/worksinmagic/ytfeed"
+)
+
+const (
+	FeedElement         = "feed"
+	EntryElement        = "entry"
+	DeletedEntryElement = "deleted-entry"
+	// YoutubeHubURL is the hub link of the notifications YouTube pushes
+	YoutubeHubURL = "https://pubsubhubbub.appspot.com"
+
+	ErrUnexpectedRootElementFormat = "unexpected root element %s"
+)
+
+// elements are matched by local name only, so payloads missing the yt: or at: namespace declaration still decode
+type atomFeed struct {
+	YT             string             `xml:"xmlns yt,attr"`
+	XMLNS          string             `xml:"xmlns,attr"`
+	At             string             `xml:"xmlns at,attr"`
+	Title          string             `xml:"title"`
+	Updated        string             `xml:"updated"`
+	Links          []atomLink         `xml:"link"`
+	Entries        []atomEntry        `xml:"entry"`
+	DeletedEntries []atomDeletedEntry `xml:"deleted-entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	VideoID   string     `xml:"videoId"`
+	ChannelID string     `xml:"channelId"`
+	Title     string     `xml:"title"`
+	Link      atomLink   `xml:"link"`
+	Author    atomAuthor `xml:"author"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+type atomDeletedEntry struct {
+	Ref  string     `xml:"ref,attr"`
+	When string     `xml:"when,attr"`
+	Link atomLink   `xml:"link"`
+	By   atomAuthor `xml:"by"`
+}
+
+// ParseFeed decodes a hub notification into one ytfeed.Data per entry and deleted entry,
+// every returned data carries the feed level fields and the whole original message.
+// A bare entry or deleted entry root element is accepted as if it was wrapped in a feed.
+func ParseFeed(raw []byte) (data []*ytfeed.Data, err error) {
+	feed := atomFeed{}
+	dec := xml.NewDecoder(bytes.NewReader(raw))
+	for {
+		var tok xml.Token
+		tok, err = dec.Token()
+		if err == io.EOF {
+			err = errors.New("no root element found")
+			return
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to read XML token")
+			return
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case FeedElement:
+			err = dec.DecodeElement(&feed, &start)
+		case EntryElement:
+			entry := atomEntry{}
+			err = dec.DecodeElement(&entry, &start)
+			feed.Entries = append(feed.Entries, entry)
+		case DeletedEntryElement:
+			deletedEntry := atomDeletedEntry{}
+			err = dec.DecodeElement(&deletedEntry, &start)
+			feed.DeletedEntries = append(feed.DeletedEntries, deletedEntry)
+		default:
+			err = fmt.Errorf(ErrUnexpectedRootElementFormat, start.Name.Local)
+			return
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "failed to decode %s element", start.Name.Local)
+			return
+		}
+
+		break
+	}
+
+	data = make([]*ytfeed.Data, 0, len(feed.Entries)+len(feed.DeletedEntries))
+	for _, e := range feed.Entries {
+		d := feed.newData(raw)
+		d.Feed.Entry = ytfeed.Entry{
+			Title:     e.Title,
+			Link:      ytfeed.Link(e.Link),
+			Author:    ytfeed.Author(e.Author),
+			Published: e.Published,
+			Updated:   e.Updated,
+			ID:        e.ID,
+			VideoID:   e.VideoID,
+			ChannelID: e.ChannelID,
+		}
+		data = append(data, d)
+	}
+	for _, e := range feed.DeletedEntries {
+		d := feed.newData(raw)
+		d.Feed.DeletedEntry = ytfeed.DeletedEntry{
+			Ref:  e.Ref,
+			When: e.When,
+			Link: ytfeed.Link(e.Link),
+			By:   ytfeed.Author(e.By),
+		}
+		data = append(data, d)
+	}
+
+	return
+}
+
+func (f *atomFeed) newData(raw []byte) (d *ytfeed.Data) {
+	d = &ytfeed.Data{}
+	d.OriginalXMLMessage = string(raw)
+	d.Feed.YT = f.YT
+	d.Feed.XMLNS = f.XMLNS
+	d.Feed.At = f.At
+	d.Feed.Title = f.Title
+	d.Feed.Updated = f.Updated
+	if len(f.Links) > 0 {
+		d.Feed.Link = make([]ytfeed.Link, 0, len(f.Links))
+		for _, l := range f.Links {
+			d.Feed.Link = append(d.Feed.Link, ytfeed.Link(l))
+		}
+	}
+
+	return
+}
+
+// NewEntryData renders the entry as the hub would notify it and parses it back,
+// so the data is identical to a hub notification of the same video
+func NewEntryData(entry ytfeed.Entry) (d *ytfeed.Data, err error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(`<?xml version='1.0' encoding='UTF-8'?>` + "\n")
+	buf.WriteString(`<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">`)
+	buf.WriteString(`<link rel="hub" href="` + YoutubeHubURL + `"/>`)
+	writeLink(buf, "self", YoutubeSubscriptionTopicPrefix+entry.ChannelID)
+	writeElement(buf, "title", "YouTube video feed")
+	writeElement(buf, "updated", entry.Updated)
+	buf.WriteString("<entry>\n  ")
+	writeElement(buf, "id", entry.ID)
+	buf.WriteString("\n  ")
+	writeElement(buf, "yt:videoId", entry.VideoID)
+	buf.WriteString("\n  ")
+	writeElement(buf, "yt:channelId", entry.ChannelID)
+	buf.WriteString("\n  ")
+	writeElement(buf, "title", entry.Title)
+	buf.WriteString("\n  ")
+	writeLink(buf, entry.Link.Rel, entry.Link.Href)
+	buf.WriteString("\n  <author>\n   ")
+	writeElement(buf, "name", entry.Author.Name)
+	buf.WriteString("\n   ")
+	writeElement(buf, "uri", entry.Author.URI)
+	buf.WriteString("\n  </author>\n  ")
+	writeElement(buf, "published", entry.Published)
+	buf.WriteString("\n  ")
+	writeElement(buf, "updated", entry.Updated)
+	buf.WriteString("\n </entry></feed>")
+
+	data, err := ParseFeed(buf.Bytes())
+	if err != nil {
+		return
+	}
+	d = data[0]
+
+	return
+}
+
+func writeElement(buf *bytes.Buffer, name, text string) {
+	buf.WriteString("<" + name + ">")
+	_ = xml.EscapeText(buf, []byte(text))
+	buf.WriteString("</" + name + ">")
+}
+
+func writeLink(buf *bytes.Buffer, rel, href string) {
+	buf.WriteString(`<link rel="`)
+	_ = xml.EscapeText(buf, []byte(rel))
+	buf.WriteString(`" href="`)
+	_ = xml.EscapeText(buf, []byte(href))
+	buf.WriteString(`"/>`)
+}
@@ -0,0 +1,137 @@
+package rss
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/worksinmagic/ytfeed"
+)
+
+const (
+	corpusDir = "testdata/corpus"
+)
+
+func TestParseFeed(t *testing.T) {
+	t.Run("video", func(t *testing.T) {
+		raw := readCorpus(t, "video.xml")
+
+		data, err := ParseFeed(raw)
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+
+		d := data[0]
+		require.Equal(t, string(raw), d.OriginalXMLMessage)
+		require.Equal(t, "http://www.youtube.com/xml/schemas/2015", d.Feed.YT)
+		require.Equal(t, "http://www.w3.org/2005/Atom", d.Feed.XMLNS)
+		require.Equal(t, "YouTube video feed", d.Feed.Title)
+		require.Len(t, d.Feed.Link, 2)
+		require.Equal(t, "hub", d.Feed.Link[0].Rel)
+		require.Equal(t, "https://www.youtube.com/xml/feeds/videos.xml?channel_id=UCAzsiozXvl0GfoAodNpDSQw", d.Feed.Link[1].Href)
+		require.Equal(t, "yt:video:nivpuSG09_E", d.Feed.Entry.ID)
+		require.Equal(t, "nivpuSG09_E", d.Feed.Entry.VideoID)
+		require.Equal(t, "UCAzsiozXvl0GfoAodNpDSQw", d.Feed.Entry.ChannelID)
+		require.Equal(t, "Up- Shania Twain", d.Feed.Entry.Title)
+		require.Equal(t, "https://www.youtube.com/watch?v=nivpuSG09_E", d.Feed.Entry.Link.Href)
+		require.Equal(t, "Komari PackoftheFallen", d.Feed.Entry.Author.Name)
+		require.Equal(t, "2012-08-26T01:51:49+00:00", d.Feed.Entry.Published)
+		require.Equal(t, "2020-07-29T10:12:08.794405158+00:00", d.Feed.Entry.Updated)
+		require.Empty(t, d.Feed.DeletedEntry.Ref)
+	})
+
+	t.Run("deleted entry", func(t *testing.T) {
+		data, err := ParseFeed(readCorpus(t, "deleted_entry.xml"))
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+
+		d := data[0]
+		require.Equal(t, "http://purl.org/atompub/tombstones/1.0", d.Feed.At)
+		require.Equal(t, "yt:video:nivpuSG09_E", d.Feed.DeletedEntry.Ref)
+		require.Equal(t, "2020-07-29T16:46:32+00:00", d.Feed.DeletedEntry.When)
+		require.Equal(t, "https://www.youtube.com/watch?v=nivpuSG09_E", d.Feed.DeletedEntry.Link.Href)
+		require.Equal(t, "Komari PackoftheFallen", d.Feed.DeletedEntry.By.Name)
+		require.Equal(t, "https://www.youtube.com/channel/UCAzsiozXvl0GfoAodNpDSQw", d.Feed.DeletedEntry.By.URI)
+		require.Empty(t, d.Feed.Entry.VideoID)
+	})
+
+	t.Run("multiple entries", func(t *testing.T) {
+		data, err := ParseFeed(readCorpus(t, "multiple_entries.xml"))
+		require.NoError(t, err)
+		require.Len(t, data, 2)
+		require.Equal(t, "nivpuSG09_E", data[0].Feed.Entry.VideoID)
+		require.Equal(t, "PCicKydX5GE", data[1].Feed.Entry.VideoID)
+		require.Equal(t, data[0].Feed.Link, data[1].Feed.Link)
+	})
+
+	t.Run("bare entry", func(t *testing.T) {
+		data, err := ParseFeed(readCorpus(t, "entry.xml"))
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+		require.Equal(t, "nivpuSG09_E", data[0].Feed.Entry.VideoID)
+	})
+
+	t.Run("every corpus file parses", func(t *testing.T) {
+		files, err := filepath.Glob(filepath.Join(corpusDir, "*"))
+		require.NoError(t, err)
+		require.NotEmpty(t, files)
+
+		for _, f := range files {
+			raw, err := ioutil.ReadFile(f)
+			require.NoError(t, err)
+
+			data, err := ParseFeed(raw)
+			require.NoError(t, err, f)
+			require.NotEmpty(t, data, f)
+		}
+	})
+
+	t.Run("failed not XML", func(t *testing.T) {
+		data, err := ParseFeed([]byte(`{"data":"data"}`))
+		require.Error(t, err)
+		require.Nil(t, data)
+	})
+
+	t.Run("failed unexpected root element", func(t *testing.T) {
+		data, err := ParseFeed([]byte(`<rss><channel></channel></rss>`))
+		require.Error(t, err)
+		require.Nil(t, data)
+	})
+
+	t.Run("failed malformed XML", func(t *testing.T) {
+		data, err := ParseFeed([]byte(`<feed><entry><id>yt:video:id</entry></feed>`))
+		require.Error(t, err)
+		require.Nil(t, data)
+	})
+}
+
+func TestNewEntryData(t *testing.T) {
+	t.Run("success identical to hub notification", func(t *testing.T) {
+		want, err := ParseFeed(readCorpus(t, "video.xml"))
+		require.NoError(t, err)
+		require.Len(t, want, 1)
+
+		d, err := NewEntryData(want[0].Feed.Entry)
+		require.NoError(t, err)
+		require.Equal(t, want[0].Feed, d.Feed)
+		require.Equal(t, string(readCorpus(t, "video.xml")), d.OriginalXMLMessage+"\n")
+	})
+
+	t.Run("success escaped title", func(t *testing.T) {
+		d, err := NewEntryData(ytfeed.Entry{
+			Title:     `Tom & Jerry <"live">`,
+			VideoID:   "id",
+			ChannelID: "channel",
+		})
+		require.NoError(t, err)
+		require.Equal(t, `Tom & Jerry <"live">`, d.Feed.Entry.Title)
+		require.Equal(t, "channel", d.Feed.Entry.ChannelID)
+	})
+}
+
+func readCorpus(t *testing.T, name string) []byte {
+	raw, err := ioutil.ReadFile(filepath.Join(corpusDir, name))
+	require.NoError(t, err)
+
+	return raw
+}
@@ -0,0 +1,25 @@
+//go:build gofuzz
+// +build gofuzz
+
+package rss
+
+// Fuzz is the go-fuzz entry point, build it with `go-fuzz-build` and run it with `go-fuzz -workdir testdata`
+// so the hub payloads in testdata/corpus are used as the initial corpus.
+func Fuzz(raw []byte) int {
+	data, err := ParseFeed(raw)
+	if err != nil {
+		return 0
+	}
+
+	for _, d := range data {
+		if d == nil {
+			panic("nil data parsed without error")
+		}
+		if d.OriginalXMLMessage != string(raw) {
+			panic("original XML message differs from input")
+		}
+		_ = d.String()
+	}
+
+	return 1
+}
@@ -1,20 +1,18 @@
 package rss
 
 import (
-	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
-	xj "github.com/basgys/goxml2json"
 	"github.com/worksinmagic/ytfeed"
 )
 
@@ -22,7 +20,44 @@ const (
 	YoutubeSubscriptionTopicPrefix = "https://www.youtube.com/xml/feeds/videos.xml?channel_id="
 )
 
-func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacSecret string, dataHandlers ...ytfeed.DataHandlerFunc) func(w http.ResponseWriter, req *http.Request) {
+type Enqueuer interface {
+	// EnqueueBatch records either all or none of the entries of a notification
+	EnqueueBatch(ctx context.Context, data []*ytfeed.Data) error
+}
+
+// Verifier correlates the verifications of intent the hub made to the requests that were sent,
+// leaseSeconds is 0 if the hub did not send one
+type Verifier interface {
+	// Verify returns false if the mode of the topic was not requested, the verification is rejected then
+	Verify(mode, topic string, leaseSeconds int) (expected bool)
+	// Denied is told that the hub or the publisher refused the subscription of the topic,
+	// it returns false if no subscription of the topic was requested, the denial is rejected then
+	Denied(topic, reason string) (expected bool)
+}
+
+// Handler hands every notification to the data handlers directly, a notification in flight is lost if the process stops.
+// verifier can be nil.
+func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacSecret string, verifier Verifier, dataHandlers ...ytfeed.DataHandlerFunc) func(w http.ResponseWriter, req *http.Request) {
+	return handler(logger, verificationToken, hmacSecret, verifier, func(data []*ytfeed.Data) (err error) {
+		for _, dt := range data {
+			for _, d := range dataHandlers {
+				go d(ctx, dt)
+			}
+		}
+
+		return
+	})
+}
+
+// QueueHandler only acknowledges a notification to the hub once the queue has durably recorded it.
+// verifier can be nil.
+func QueueHandler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacSecret string, verifier Verifier, queue Enqueuer) func(w http.ResponseWriter, req *http.Request) {
+	return handler(logger, verificationToken, hmacSecret, verifier, func(data []*ytfeed.Data) (err error) {
+		return queue.EnqueueBatch(ctx, data)
+	})
+}
+
+func handler(logger ytfeed.Logger, verificationToken, hmacSecret string, verifier Verifier, dispatch func(data []*ytfeed.Data) error) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
 
@@ -32,6 +67,7 @@ func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacS
 			vtoken := req.URL.Query().Get("hub.verify_token")
 			topic := req.URL.Query().Get("hub.topic")
 			challenge := req.URL.Query().Get("hub.challenge")
+			leaseSeconds, _ := strconv.Atoi(req.URL.Query().Get("hub.lease_seconds"))
 
 			if mode == "unsubscribe" {
 				if vtoken != verificationToken {
@@ -46,6 +82,13 @@ func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacS
 					return
 				}
 
+				if verifier != nil && !verifier.Verify(mode, topic, 0) {
+					logger.Warnf("Rejected unexpected %s verification of topic %s", mode, topic)
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintln(w, "NOT FOUND")
+					return
+				}
+
 				logger.Infof("Unsubscribed to topic %s with challenge %s", topic, challenge)
 
 				fmt.Fprint(w, challenge)
@@ -64,11 +107,45 @@ func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacS
 					return
 				}
 
-				logger.Infof("Subscribed to topic %s with challenge %s", topic, challenge)
+				if verifier != nil && !verifier.Verify(mode, topic, leaseSeconds) {
+					logger.Warnf("Rejected unexpected %s verification of topic %s", mode, topic)
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintln(w, "NOT FOUND")
+					return
+				}
+
+				logger.Infof("Subscribed to topic %s with challenge %s and lease of %d seconds", topic, challenge, leaseSeconds)
 
 				fmt.Fprint(w, challenge)
 				return
 			}
+			if mode == "denied" {
+				// hubs don't have to send the verify token with a denial, one that is sent has to match
+				if vtoken != "" && vtoken != verificationToken {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintln(w, "UNAUTHORIZED")
+					return
+				}
+
+				if !IsYoutubeSubscriptionTopic(topic) {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprintln(w, "BAD REQUEST")
+					return
+				}
+
+				reason := req.URL.Query().Get("hub.reason")
+				if verifier != nil && !verifier.Denied(topic, reason) {
+					logger.Warnf("Rejected unexpected %s of topic %s", mode, topic)
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintln(w, "NOT FOUND")
+					return
+				}
+
+				logger.Warnf("Hub denied subscription to topic %s with reason '%s'", topic, reason)
+
+				fmt.Fprintln(w, "OK")
+				return
+			}
 
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintln(w, "BAD REQUEST")
@@ -81,7 +158,6 @@ func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacS
 				fmt.Fprintf(w, "INTERNAL SERVER ERROR: %v", err)
 				return
 			}
-			originalMessage := string(tmpRaw)
 
 			hmacHasher := hmac.New(sha1.New, []byte(hmacSecret))
 			var verified bool
@@ -97,27 +173,26 @@ func Handler(ctx context.Context, logger ytfeed.Logger, verificationToken, hmacS
 				return
 			}
 
-			jbuf, err := xj.Convert(bytes.NewReader(tmpRaw))
+			var data []*ytfeed.Data
+			data, err = ParseFeed(tmpRaw)
 			if err != nil {
+				logger.Errorf("Failed to parse XML: %v", err)
 				w.WriteHeader(http.StatusBadRequest)
 				fmt.Fprintf(w, "INVALID XML INPUT: %v", err)
 				return
 			}
 
-			data := &ytfeed.Data{}
-			err = json.Unmarshal(jbuf.Bytes(), data)
-			if err != nil {
-				logger.Errorf("Failed to unmarshal JSON: %v", err)
-				w.WriteHeader(http.StatusBadRequest)
-				fmt.Fprintf(w, "FAILED TO UNMARSHAL JSON: %v", err)
-				return
+			for _, d := range data {
+				logger.Infof("Got subscription data: %s", d)
 			}
-			data.OriginalXMLMessage = originalMessage
-
-			logger.Infof("Got subscription data: %s", data)
 
-			for _, d := range dataHandlers {
-				go d(ctx, data)
+			// the entries are dispatched together so a retry of the hub doesn't deliver some of them twice
+			err = dispatch(data)
+			if err != nil {
+				logger.Errorf("Failed to dispatch subscription data: %v", err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "SERVICE UNAVAILABLE: %v", err)
+				return
 			}
 
 			w.WriteHeader(http.StatusCreated)
@@ -3,6 +3,10 @@ package rss
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -44,7 +48,8 @@ func TestRss(t *testing.T) {
 		require.NotNil(t, data)
 	}
 
-	handler := Handler(context.TODO(), logger, verificationToken, hmacSecret, dataHandler)
+	verifier := mock.NewMockVerifier(ctrl)
+	handler := Handler(context.TODO(), logger, verificationToken, hmacSecret, verifier, dataHandler)
 	require.NotNil(t, handler)
 
 	t.Run("method GET", func(t *testing.T) {
@@ -54,6 +59,7 @@ func TestRss(t *testing.T) {
 			urlVal.Set("hub.verify_token", verificationToken)
 			urlVal.Set("hub.topic", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=id")
 			urlVal.Set("hub.challenge", "mychallenge")
+			urlVal.Set("hub.lease_seconds", "432000")
 			addr := "http://localhost:8080/?" + urlVal.Encode()
 			req, err := http.NewRequest(http.MethodGet, addr, &bytes.Buffer{})
 			if err != nil {
@@ -65,7 +71,9 @@ func TestRss(t *testing.T) {
 				gomock.AssignableToTypeOf("subscribe"),
 				gomock.AssignableToTypeOf("topic"),
 				gomock.AssignableToTypeOf("challenge"),
+				432000,
 			)
+			verifier.EXPECT().Verify("subscribe", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=id", 432000).Return(true)
 
 			handler(rec, req)
 
@@ -127,6 +135,7 @@ func TestRss(t *testing.T) {
 				gomock.AssignableToTypeOf("topic"),
 				gomock.AssignableToTypeOf("challenge"),
 			)
+			verifier.EXPECT().Verify("unsubscribe", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=id", 0).Return(true)
 
 			handler(rec, req)
 
@@ -170,6 +179,86 @@ func TestRss(t *testing.T) {
 			require.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
 		})
 
+		t.Run("subscribe unexpected", func(t *testing.T) {
+			urlVal := url.Values{}
+			urlVal.Set("hub.mode", "subscribe")
+			urlVal.Set("hub.verify_token", verificationToken)
+			urlVal.Set("hub.topic", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=other")
+			urlVal.Set("hub.challenge", "mychallenge")
+			addr := "http://localhost:8080/?" + urlVal.Encode()
+			req, err := http.NewRequest(http.MethodGet, addr, &bytes.Buffer{})
+			if err != nil {
+				panic(err)
+			}
+			rec := httptest.NewRecorder()
+
+			verifier.EXPECT().Verify("subscribe", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=other", 0).Return(false)
+			logger.EXPECT().Warnf(gomock.Any(), "subscribe", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=other")
+
+			handler(rec, req)
+
+			require.Equal(t, http.StatusNotFound, rec.Result().StatusCode)
+			require.NotContains(t, rec.Body.String(), "mychallenge")
+		})
+
+		t.Run("denied", func(t *testing.T) {
+			urlVal := url.Values{}
+			urlVal.Set("hub.mode", "denied")
+			urlVal.Set("hub.topic", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=id")
+			urlVal.Set("hub.reason", "myreason")
+			addr := "http://localhost:8080/?" + urlVal.Encode()
+			req, err := http.NewRequest(http.MethodGet, addr, &bytes.Buffer{})
+			if err != nil {
+				panic(err)
+			}
+			rec := httptest.NewRecorder()
+
+			logger.EXPECT().Warnf(gomock.Any(), "https://www.youtube.com/xml/feeds/videos.xml?channel_id=id", "myreason")
+			verifier.EXPECT().Denied("https://www.youtube.com/xml/feeds/videos.xml?channel_id=id", "myreason").Return(true)
+
+			handler(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+		})
+
+		t.Run("denied unexpected", func(t *testing.T) {
+			urlVal := url.Values{}
+			urlVal.Set("hub.mode", "denied")
+			urlVal.Set("hub.topic", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=other")
+			urlVal.Set("hub.reason", "myreason")
+			addr := "http://localhost:8080/?" + urlVal.Encode()
+			req, err := http.NewRequest(http.MethodGet, addr, &bytes.Buffer{})
+			if err != nil {
+				panic(err)
+			}
+			rec := httptest.NewRecorder()
+
+			verifier.EXPECT().Denied("https://www.youtube.com/xml/feeds/videos.xml?channel_id=other", "myreason").Return(false)
+			logger.EXPECT().Warnf(gomock.Any(), "denied", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=other")
+
+			handler(rec, req)
+
+			require.Equal(t, http.StatusNotFound, rec.Result().StatusCode)
+		})
+
+		t.Run("denied failed verification token", func(t *testing.T) {
+			urlVal := url.Values{}
+			urlVal.Set("hub.mode", "denied")
+			urlVal.Set("hub.verify_token", "wrongtoken")
+			urlVal.Set("hub.topic", "https://www.youtube.com/xml/feeds/videos.xml?channel_id=id")
+			urlVal.Set("hub.reason", "myreason")
+			addr := "http://localhost:8080/?" + urlVal.Encode()
+			req, err := http.NewRequest(http.MethodGet, addr, &bytes.Buffer{})
+			if err != nil {
+				panic(err)
+			}
+			rec := httptest.NewRecorder()
+
+			handler(rec, req)
+
+			require.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
+		})
+
 		t.Run("wrong mode", func(t *testing.T) {
 			urlVal := url.Values{}
 			urlVal.Set("hub.mode", "wrongmode")
@@ -272,3 +361,94 @@ func TestRss(t *testing.T) {
 		require.Equal(t, http.StatusMethodNotAllowed, rec.Result().StatusCode)
 	})
 }
+
+func TestQueueHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := mock.NewMockLogger(ctrl)
+	queue := mock.NewMockEnqueuer(ctrl)
+	verificationToken := "token"
+
+	handler := QueueHandler(context.TODO(), logger, verificationToken, hmacSecret, nil, queue)
+	require.NotNil(t, handler)
+
+	t.Run("feed success", func(t *testing.T) {
+		body := bytes.NewBufferString(sampleData)
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/", body)
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("X-Hub-Signature", sampleDataHmacHex)
+		rec := httptest.NewRecorder()
+
+		logger.EXPECT().Infof(
+			gomock.AssignableToTypeOf("feed data"),
+			gomock.AssignableToTypeOf(&ytfeed.Data{}),
+		)
+		queue.EXPECT().EnqueueBatch(
+			gomock.Any(),
+			gomock.AssignableToTypeOf([]*ytfeed.Data{}),
+		).Return(nil)
+
+		handler(rec, req)
+
+		require.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	})
+
+	t.Run("feed success multiple entries enqueued together", func(t *testing.T) {
+		rawData := readCorpus(t, "multiple_entries.xml")
+		hmacHasher := hmac.New(sha1.New, []byte(hmacSecret))
+		hmacHasher.Write(rawData)
+
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/", bytes.NewReader(rawData))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(hmacHasher.Sum(nil)))
+		rec := httptest.NewRecorder()
+
+		logger.EXPECT().Infof(
+			gomock.AssignableToTypeOf("feed data"),
+			gomock.AssignableToTypeOf(&ytfeed.Data{}),
+		).Times(2)
+		queue.EXPECT().EnqueueBatch(
+			gomock.Any(),
+			gomock.AssignableToTypeOf([]*ytfeed.Data{}),
+		).DoAndReturn(func(ctx context.Context, data []*ytfeed.Data) error {
+			require.Len(t, data, 2)
+			return nil
+		})
+
+		handler(rec, req)
+
+		require.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	})
+
+	t.Run("feed failed enqueue", func(t *testing.T) {
+		body := bytes.NewBufferString(sampleData)
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/", body)
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("X-Hub-Signature", sampleDataHmacHex)
+		rec := httptest.NewRecorder()
+
+		logger.EXPECT().Infof(
+			gomock.AssignableToTypeOf("feed data"),
+			gomock.AssignableToTypeOf(&ytfeed.Data{}),
+		)
+		queue.EXPECT().EnqueueBatch(
+			gomock.Any(),
+			gomock.AssignableToTypeOf([]*ytfeed.Data{}),
+		).Return(errors.New("queue is full"))
+		logger.EXPECT().Errorf(
+			gomock.AssignableToTypeOf("failed to dispatch"),
+			gomock.Any(),
+		)
+
+		handler(rec, req)
+
+		require.Equal(t, http.StatusServiceUnavailable, rec.Result().StatusCode)
+	})
+}